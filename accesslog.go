@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type AccessLogEntry struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id"`
+	ClientIP  string    `json:"client_ip"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Proto     string    `json:"proto"`
+	Status    int       `json:"status"`
+	Size      int       `json:"size"`
+	Duration  float64   `json:"duration_ms"`
+	Provider  string    `json:"provider,omitempty"`
+}
+
+// AccessLogger writes one line per request, either in the Common Log
+// Format extended with the duration and the winning provider, or as JSON.
+type AccessLogger struct {
+	mu     sync.Mutex
+	out    io.Writer
+	format string
+}
+
+func NewAccessLogger(out io.Writer, format string) *AccessLogger {
+	return &AccessLogger{out: out, format: format}
+}
+
+func (l *AccessLogger) Log(entry AccessLogEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.format == "json" {
+		_ = json.NewEncoder(l.out).Encode(entry)
+		return
+	}
+
+	provider := entry.Provider
+	if provider == "" {
+		provider = "-"
+	}
+	fmt.Fprintf(l.out, "%s - - [%s] \"%s %s %s\" %d %d %.3fms %s %s\n",
+		entry.ClientIP,
+		entry.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		entry.Method, entry.Path, entry.Proto,
+		entry.Status, entry.Size, entry.Duration,
+		provider, entry.RequestID,
+	)
+}
+
+// accessLog is nil when access logging is off.
+var accessLog *AccessLogger
+
+type requestProviderKey struct{}
+
+// requestProvider lets a handler report the winning provider to the
+// access log, which only sees the request from the outside.
+type requestProvider struct {
+	mu   sync.Mutex
+	name string
+}
+
+func withRequestProvider(ctx context.Context) (context.Context, *requestProvider) {
+	holder := &requestProvider{}
+	return context.WithValue(ctx, requestProviderKey{}, holder), holder
+}
+
+func setRequestProvider(ctx context.Context, name string) {
+	if holder, ok := ctx.Value(requestProviderKey{}).(*requestProvider); ok {
+		holder.mu.Lock()
+		holder.name = name
+		holder.mu.Unlock()
+	}
+}
+
+func (p *requestProvider) get() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.name
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
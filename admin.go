@@ -0,0 +1,306 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// adminToken guards /admin, which is not served when it is empty.
+var adminToken string
+
+// adminMu serializes the admin changes, each made over the config left by
+// the previous one.
+var adminMu sync.Mutex
+
+const adminMaxBody = 1 << 20
+
+// AdminCacheEntry is a cached lookup as seen through /admin/cache/{cep}.
+type AdminCacheEntry struct {
+	Cep       string     `json:"cep"`
+	Address   *Address   `json:"address"`
+	FetchedAt *time.Time `json:"fetched_at,omitempty"`
+	Stale     bool       `json:"stale"`
+}
+
+// adminOnly rejects the requests without the admin token in
+// Authorization: Bearer with 401, and all of them when no token is set.
+func adminOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if adminToken == "" || !ok || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeJSONError(w, r, http.StatusUnauthorized, CodeUnauthorized, "missing or invalid admin token")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// AdminHandler serves the runtime controls under /admin. Their changes
+// last until the next restart or config reload.
+func AdminHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin"), "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "config":
+		adminConfig(w, r)
+	case len(parts) == 3 && parts[0] == "providers":
+		adminProvider(w, r, parts[1], parts[2])
+	case len(parts) == 3 && parts[0] == "breakers":
+		adminBreaker(w, r, parts[1], parts[2])
+	case parts[0] == "cache" && len(parts) <= 2:
+		adminCache(w, r, parts[1:])
+	case len(parts) == 1 && parts[0] == "usage":
+		adminUsage(w, r)
+	default:
+		writeJSONError(w, r, http.StatusNotFound, CodeNotFound, "unknown admin endpoint")
+	}
+}
+
+// adminConfig answers the effective config on GET. PATCH merges the
+// settings of a JSON or YAML body into it, which may only change those a
+// reload would.
+func adminConfig(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeEffectiveConfig(w, r, currentConfig())
+	case http.MethodPatch:
+		adminMu.Lock()
+		defer adminMu.Unlock()
+		current := currentConfig()
+		next, err := patchConfig(current, http.MaxBytesReader(w, r.Body, adminMaxBody))
+		if err != nil {
+			writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+			return
+		}
+		applyConfig(next)
+		slog.InfoContext(r.Context(), "config changed through the admin api")
+		writeEffectiveConfig(w, r, next)
+	default:
+		w.Header().Set("Allow", "GET, PATCH")
+		writeJSONError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed")
+	}
+}
+
+// patchConfig decodes body over a copy of current.
+func patchConfig(current Config, body io.Reader) (Config, error) {
+	// the YAML round trip copies the maps and pointers too, which the
+	// decoding would otherwise change under the running config
+	raw, err := yaml.Marshal(current)
+	if err != nil {
+		return Config{}, err
+	}
+	var next Config
+	err = yaml.Unmarshal(raw, &next)
+	if err != nil {
+		return Config{}, err
+	}
+
+	decoder := yaml.NewDecoder(body)
+	decoder.KnownFields(true)
+	err = decoder.Decode(&next)
+	if errors.Is(err, io.EOF) {
+		return Config{}, errors.New("empty body")
+	}
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid body: %w", err)
+	}
+	if next.ProviderSettings == nil {
+		next.ProviderSettings = map[string]ProviderConfig{}
+	}
+	if restartNeeded(current, next) {
+		return Config{}, errors.New("only the providers, timeouts, retries, breakers, rate limits and log level can be changed at runtime")
+	}
+	err = next.Validate()
+	if err != nil {
+		return Config{}, err
+	}
+	return next, nil
+}
+
+// writeEffectiveConfig answers cfg, secrets masked, with the field names
+// of the config file.
+func writeEffectiveConfig(w http.ResponseWriter, r *http.Request, cfg Config) {
+	raw, err := yaml.Marshal(cfg.Redacted())
+	var doc map[string]any
+	if err == nil {
+		err = yaml.Unmarshal(raw, &doc)
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error encoding config", "error", err)
+		writeJSONError(w, r, http.StatusInternalServerError, CodeUnavailable, "error encoding config")
+		return
+	}
+	writeJSON(w, r, http.StatusOK, doc)
+}
+
+// adminProvider serves POST /admin/providers/{name}/enable, disable and
+// promote, which moves a canary into the race. An enabled or promoted
+// provider comes last in priority.
+func adminProvider(w http.ResponseWriter, r *http.Request, name, action string) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSONError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	adminMu.Lock()
+	defer adminMu.Unlock()
+	next := currentConfig()
+	next.Providers = slices.DeleteFunc(slices.Clone(next.Providers), func(p string) bool { return p == name })
+	switch action {
+	case "enable":
+		next.Providers = append(next.Providers, name)
+	case "disable":
+	case "promote":
+		if !slices.Contains(next.Canary.Providers, name) {
+			writeJSONError(w, r, http.StatusNotFound, CodeNotFound, fmt.Sprintf("provider %q is not a canary", name))
+			return
+		}
+		next.Canary.Providers = slices.DeleteFunc(slices.Clone(next.Canary.Providers), func(p string) bool { return p == name })
+		next.Providers = append(next.Providers, name)
+	default:
+		writeJSONError(w, r, http.StatusNotFound, CodeNotFound, "unknown admin endpoint")
+		return
+	}
+	err := next.Validate()
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	applyConfig(next)
+	slog.InfoContext(r.Context(), "provider "+strings.TrimSuffix(action, "e")+"ed through the admin api", "provider", name, "providers", next.Providers)
+	writeEffectiveConfig(w, r, next)
+}
+
+// adminBreaker serves POST /admin/breakers/{name}/trip and reset.
+func adminBreaker(w http.ResponseWriter, r *http.Request, name, action string) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSONError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	// breakers are keyed by the providers' display names, such as ViaCep
+	// for viacep
+	var breaker *CircuitBreaker
+	reloadMu.RLock()
+	for key, b := range breakers {
+		if strings.EqualFold(key, name) {
+			breaker = b
+		}
+	}
+	reloadMu.RUnlock()
+	if breaker == nil {
+		writeJSONError(w, r, http.StatusNotFound, CodeNotFound, fmt.Sprintf("provider %q has no breaker", name))
+		return
+	}
+	switch action {
+	case "trip":
+		breaker.Trip()
+		slog.InfoContext(r.Context(), "breaker tripped through the admin api", "provider", name)
+	case "reset":
+		breaker.Reset()
+		slog.InfoContext(r.Context(), "breaker reset through the admin api", "provider", name)
+	default:
+		writeJSONError(w, r, http.StatusNotFound, CodeNotFound, "unknown admin endpoint")
+		return
+	}
+	writeJSON(w, r, http.StatusOK, breaker.Status())
+}
+
+// adminCache answers the cache stats or, with a CEP, its entry on GET, and
+// drops them on DELETE.
+func adminCache(w http.ResponseWriter, r *http.Request, rest []string) {
+	flushable, canFlush := cache.(FlushableCache)
+	var cep string
+	if len(rest) == 1 && rest[0] != "" {
+		normalized, err := NormalizeCep(rest[0])
+		if err != nil {
+			writeJSONError(w, r, http.StatusUnprocessableEntity, CodeInvalidCep, err.Error())
+			return
+		}
+		cep = normalized
+	}
+
+	switch {
+	case r.Method == http.MethodGet && cep == "":
+		stats, ok := cache.(interface{ Stats() CacheStats })
+		if !ok {
+			writeJSON(w, r, http.StatusOK, CacheStats{Entries: -1})
+			return
+		}
+		writeJSON(w, r, http.StatusOK, stats.Stats())
+	case r.Method == http.MethodGet:
+		entry, ok, err := adminCacheEntry(r, cep)
+		if err != nil {
+			writeJSONError(w, r, http.StatusBadGateway, CodeUpstreamFailure, err.Error())
+			return
+		}
+		if !ok {
+			writeJSONError(w, r, http.StatusNotFound, CodeNotFound, "cep not cached")
+			return
+		}
+		writeJSON(w, r, http.StatusOK, entry)
+	case r.Method == http.MethodDelete && canFlush:
+		var err error
+		// the not found answers go too, so that a flush always sends
+		// the CEP back to the providers
+		if cep == "" {
+			err = flushable.Flush(r.Context())
+			if negativeCache != nil {
+				_ = negativeCache.Flush(r.Context())
+			}
+		} else {
+			err = flushable.Delete(r.Context(), cep)
+			if negativeCache != nil {
+				_ = negativeCache.Delete(r.Context(), cep)
+			}
+		}
+		if err != nil {
+			writeJSONError(w, r, http.StatusBadGateway, CodeUpstreamFailure, err.Error())
+			return
+		}
+		slog.InfoContext(r.Context(), "cache flushed through the admin api", "cep", cep)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		writeJSONError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed")
+	}
+}
+
+func adminCacheEntry(r *http.Request, cep string) (*AdminCacheEntry, bool, error) {
+	var body []byte
+	var stale, ok bool
+	var err error
+	if staleCache, keepsStale := cache.(StaleCache); keepsStale {
+		body, stale, ok, err = staleCache.GetStale(r.Context(), cep)
+	} else {
+		body, ok, err = cache.Get(r.Context(), cep)
+	}
+	if err != nil || !ok {
+		return nil, false, err
+	}
+
+	cached := cacheEntry{Address: &Address{}}
+	err = json.Unmarshal(body, &cached)
+	if err != nil {
+		return nil, false, err
+	}
+	entry := &AdminCacheEntry{Cep: cep, Address: cached.Address, Stale: stale}
+	if !cached.FetchedAt.IsZero() {
+		entry.FetchedAt = &cached.FetchedAt
+	}
+	return entry, true, nil
+}
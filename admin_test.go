@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminOnly(t *testing.T) {
+	t.Cleanup(func() { adminToken = "" })
+	tests := []struct {
+		token         string
+		authorization string
+		want          int
+	}{
+		{"", "", http.StatusUnauthorized},
+		{"", "Bearer ", http.StatusUnauthorized},
+		{"secret", "", http.StatusUnauthorized},
+		{"secret", "secret", http.StatusUnauthorized},
+		{"secret", "Bearer other", http.StatusUnauthorized},
+		{"secret", "Bearer secret", http.StatusOK},
+	}
+	handler := adminOnly(func(w http.ResponseWriter, r *http.Request) {})
+	for _, test := range tests {
+		adminToken = test.token
+		r := httptest.NewRequest(http.MethodGet, "/admin/providers", nil)
+		if test.authorization != "" {
+			r.Header.Set("Authorization", test.authorization)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != test.want {
+			t.Errorf("token %q, Authorization %q: got %d, want %d", test.token, test.authorization, w.Code, test.want)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	errMissingAPIKey = errors.New("missing X-API-Key header")
+	errInvalidAPIKey = errors.New("invalid API key")
+)
+
+// quotaError rejects a key over its rate or, when daily or monthly, over
+// that quota, until RetryAfter has passed.
+type quotaError struct {
+	daily      bool
+	monthly    bool
+	RetryAfter time.Duration
+}
+
+func (e *quotaError) Error() string {
+	switch {
+	case e.daily:
+		return "daily quota exceeded"
+	case e.monthly:
+		return "monthly quota exceeded"
+	}
+	return "rate limit exceeded"
+}
+
+// apiKey tracks the usage of a key against its quotas.
+type apiKey struct {
+	name    string
+	quota   int
+	limiter *rate.Limiter // nil when the rate is unlimited
+	// monthlyQuota caps the lookups of the month, counted by countUsage
+	monthlyQuota int
+
+	mu   sync.Mutex
+	day  string
+	used int
+}
+
+// apiKeys are the accepted keys by their value, nil when auth is disabled.
+var apiKeys map[string]*apiKey
+
+func setupAuth(cfg AuthConfig) {
+	apiKeys = nil
+	keys := cfg.Keys
+	if cfg.KeysFile != "" {
+		fileKeys, err := loadAPIKeys(cfg.KeysFile)
+		if err != nil {
+			fatal("error loading api keys", err)
+		}
+		keys = slices.Concat(keys, fileKeys)
+	}
+	if len(keys) == 0 {
+		return
+	}
+	err := validateAPIKeys(keys)
+	if err != nil {
+		fatal("invalid api keys", err)
+	}
+
+	apiKeys = make(map[string]*apiKey, len(keys))
+	for _, key := range keys {
+		apiKeys[key.Key] = newAPIKey(cfg, key)
+	}
+	slog.Info("api key auth enabled", "keys", len(apiKeys))
+}
+
+func newAPIKey(cfg AuthConfig, key APIKeyConfig) *apiKey {
+	k := &apiKey{
+		name:         key.Name,
+		quota:        cmp.Or(key.DailyQuota, cfg.DailyQuota),
+		monthlyQuota: cmp.Or(key.MonthlyQuota, cfg.MonthlyQuota),
+	}
+	if rps := cmp.Or(key.RPS, cfg.RPS); rps > 0 {
+		k.limiter = rate.NewLimiter(rate.Limit(rps), cmp.Or(key.Burst, cfg.Burst))
+	}
+	return k
+}
+
+func loadAPIKeys(path string) ([]APIKeyConfig, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var keys []APIKeyConfig
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	err = decoder.Decode(&keys)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return keys, nil
+}
+
+func validateAPIKeys(keys []APIKeyConfig) error {
+	var errs []error
+	names := make(map[string]bool)
+	values := make(map[string]bool)
+	for i, key := range keys {
+		if key.Name == "" || key.Key == "" {
+			errs = append(errs, fmt.Errorf("key %d needs a name and a key", i+1))
+			continue
+		}
+		if names[key.Name] {
+			errs = append(errs, fmt.Errorf("key name %q used twice", key.Name))
+		}
+		if values[key.Key] {
+			errs = append(errs, fmt.Errorf("key %q has the value of another key", key.Name))
+		}
+		names[key.Name], values[key.Key] = true, true
+		if key.DailyQuota < 0 || key.MonthlyQuota < 0 || key.RPS < 0 || key.Burst < 0 {
+			errs = append(errs, fmt.Errorf("key %q quotas must not be negative", key.Name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// allow counts a request against the key's quotas. The daily quota resets
+// at midnight UTC, the monthly one on the first of the month; the latter
+// counts lookups rather than requests, so it only rejects the requests
+// coming after the one that used it up.
+func (k *apiKey) allow(now time.Time) error {
+	if k.limiter != nil {
+		reservation := k.limiter.ReserveN(now, 1)
+		if delay := reservation.DelayFrom(now); delay > 0 {
+			reservation.CancelAt(now)
+			return &quotaError{RetryAfter: delay}
+		}
+	}
+	if k.monthlyQuota > 0 && monthlyUsage(k.name, now) >= k.monthlyQuota {
+		utc := now.UTC()
+		next := time.Date(utc.Year(), utc.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		return &quotaError{monthly: true, RetryAfter: next.Sub(utc)}
+	}
+	if k.quota == 0 {
+		return nil
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	now = now.UTC()
+	if day := now.Format(time.DateOnly); day != k.day {
+		k.day, k.used = day, 0
+	}
+	if k.used >= k.quota {
+		midnight := now.Truncate(24 * time.Hour).Add(24 * time.Hour)
+		return &quotaError{daily: true, RetryAfter: midnight.Sub(now)}
+	}
+	k.used++
+	return nil
+}
+
+// authenticate checks value is a known key within its quotas, returning
+// the key's name.
+func authenticate(value string) (string, error) {
+	if value == "" {
+		return "", errMissingAPIKey
+	}
+	key, ok := apiKeys[value]
+	if !ok {
+		return "", errInvalidAPIKey
+	}
+	return key.name, key.allow(time.Now())
+}
+
+// authenticated rejects requests without a valid X-API-Key with 401, and
+// those over their key's quotas with 429, when auth is enabled.
+func authenticated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if apiKeys == nil {
+			next(w, r)
+			return
+		}
+
+		name, err := authenticate(r.Header.Get("X-API-Key"))
+		var quotaErr *quotaError
+		switch {
+		case errors.As(err, &quotaErr):
+			slog.InfoContext(r.Context(), "api key over its quota", "api_key", name, "error", err)
+			code := CodeRateLimited
+			if quotaErr.daily || quotaErr.monthly {
+				code = CodeQuotaExceeded
+			}
+			w.Header().Set("Retry-After", seconds(quotaErr.RetryAfter))
+			writeJSONError(w, r, http.StatusTooManyRequests, code, err.Error())
+		case err != nil:
+			writeJSONError(w, r, http.StatusUnauthorized, CodeUnauthorized, err.Error())
+		default:
+			next(w, r.WithContext(withAPIKeyName(r.Context(), name)))
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// withAPIKeys enables auth with keys for a test, restoring the keys and
+// the usage of the process when it ends.
+func withAPIKeys(t *testing.T, cfg AuthConfig) {
+	t.Helper()
+	savedKeys := apiKeys
+	usage.mu.Lock()
+	savedMonth, savedTotals := usage.month, usage.totals
+	usage.month, usage.totals = usageMonth(time.Now()), map[string]usageCount{}
+	usage.mu.Unlock()
+	t.Cleanup(func() {
+		apiKeys = savedKeys
+		usage.mu.Lock()
+		usage.month, usage.totals = savedMonth, savedTotals
+		usage.mu.Unlock()
+	})
+
+	apiKeys = map[string]*apiKey{}
+	for _, key := range cfg.Keys {
+		apiKeys[key.Key] = newAPIKey(cfg, key)
+	}
+}
+
+// authRequest runs a request with key through authenticated, returning
+// its status, error code and Retry-After.
+func authRequest(t *testing.T, key string) (int, ErrorCode, string) {
+	t.Helper()
+	handler := authenticated(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	r := httptest.NewRequest(http.MethodGet, "/?cep=01001000", nil)
+	if key != "" {
+		r.Header.Set("X-API-Key", key)
+	}
+	recorder := httptest.NewRecorder()
+	handler(recorder, r)
+
+	var body ErrorResponse
+	if recorder.Code != http.StatusOK {
+		if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+			t.Fatalf("error body %q: %v", recorder.Body, err)
+		}
+	}
+	return recorder.Code, body.Error.Code, recorder.Header().Get("Retry-After")
+}
+
+func TestAuthenticatedUnknownKeys(t *testing.T) {
+	withAPIKeys(t, AuthConfig{Keys: []APIKeyConfig{{Name: "app", Key: "secret"}}})
+
+	for _, key := range []string{"", "wrong", "secret "} {
+		status, code, _ := authRequest(t, key)
+		if status != http.StatusUnauthorized || code != CodeUnauthorized {
+			t.Errorf("key %q: got %d %s, want 401 %s", key, status, code, CodeUnauthorized)
+		}
+	}
+	if status, _, _ := authRequest(t, "secret"); status != http.StatusOK {
+		t.Errorf("known key: got %d, want 200", status)
+	}
+}
+
+func TestAuthenticatedDailyQuota(t *testing.T) {
+	withAPIKeys(t, AuthConfig{Keys: []APIKeyConfig{{Name: "app", Key: "secret", DailyQuota: 2}}})
+
+	for i := range 2 {
+		if status, _, _ := authRequest(t, "secret"); status != http.StatusOK {
+			t.Fatalf("request %d within the quota: got %d, want 200", i+1, status)
+		}
+	}
+	status, code, retryAfter := authRequest(t, "secret")
+	if status != http.StatusTooManyRequests || code != CodeQuotaExceeded {
+		t.Fatalf("request past the quota: got %d %s, want 429 %s", status, code, CodeQuotaExceeded)
+	}
+	if seconds, err := strconv.Atoi(retryAfter); err != nil || seconds < 1 || seconds > 24*60*60 {
+		t.Errorf("Retry-After %q, want the seconds to midnight UTC", retryAfter)
+	}
+}
+
+func TestAuthenticatedRateQuota(t *testing.T) {
+	withAPIKeys(t, AuthConfig{RPS: 0.5, Burst: 2, Keys: []APIKeyConfig{{Name: "app", Key: "secret"}}})
+
+	for i := range 2 {
+		if status, _, _ := authRequest(t, "secret"); status != http.StatusOK {
+			t.Fatalf("request %d within the burst: got %d, want 200", i+1, status)
+		}
+	}
+	status, code, retryAfter := authRequest(t, "secret")
+	if status != http.StatusTooManyRequests || code != CodeRateLimited {
+		t.Fatalf("request past the burst: got %d %s, want 429 %s", status, code, CodeRateLimited)
+	}
+	if retryAfter != "2" {
+		t.Errorf("Retry-After %q, want 2, the time to the next token", retryAfter)
+	}
+}
+
+func TestAuthenticatedMonthlyQuota(t *testing.T) {
+	withAPIKeys(t, AuthConfig{Keys: []APIKeyConfig{{Name: "app", Key: "secret", MonthlyQuota: 3}}})
+	setLookups := func(n int) {
+		usage.mu.Lock()
+		usage.totals["app"] = usageCount{lookups: n}
+		usage.mu.Unlock()
+	}
+
+	setLookups(2)
+	if status, _, _ := authRequest(t, "secret"); status != http.StatusOK {
+		t.Fatalf("one lookup left: got %d, want 200", status)
+	}
+	setLookups(3)
+	status, code, retryAfter := authRequest(t, "secret")
+	if status != http.StatusTooManyRequests || code != CodeQuotaExceeded {
+		t.Fatalf("quota used up: got %d %s, want 429 %s", status, code, CodeQuotaExceeded)
+	}
+	if seconds, err := strconv.Atoi(retryAfter); err != nil || seconds < 1 || seconds > 31*24*60*60 {
+		t.Errorf("Retry-After %q, want the seconds to the next month", retryAfter)
+	}
+}
+
+func TestAPIKeyQuotaBoundaries(t *testing.T) {
+	withAPIKeys(t, AuthConfig{})
+	tests := []struct {
+		name       string
+		key        APIKeyConfig
+		used       int // lookups of the month so far
+		now        time.Time
+		calls      int // allowed before now
+		err        string
+		retryAfter time.Duration
+	}{
+		{"daily quota left", APIKeyConfig{DailyQuota: 2}, 0, time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC), 1, "", 0},
+		{"daily quota used up", APIKeyConfig{DailyQuota: 2}, 0, time.Date(2026, 3, 10, 23, 59, 30, 0, time.UTC), 2, "daily quota exceeded", 30 * time.Second},
+		{"monthly quota left", APIKeyConfig{MonthlyQuota: 5}, 4, time.Date(2026, 3, 31, 23, 0, 0, 0, time.UTC), 0, "", 0},
+		{"monthly quota used up", APIKeyConfig{MonthlyQuota: 5}, 5, time.Date(2026, 3, 31, 23, 0, 0, 0, time.UTC), 0, "monthly quota exceeded", time.Hour},
+		{"monthly quota over December", APIKeyConfig{MonthlyQuota: 5}, 5, time.Date(2026, 12, 31, 0, 0, 0, 0, time.UTC), 0, "monthly quota exceeded", 24 * time.Hour},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.key.Name = "app"
+			key := newAPIKey(AuthConfig{}, test.key)
+			usage.mu.Lock()
+			usage.month, usage.totals = usageMonth(test.now), map[string]usageCount{"app": {lookups: test.used}}
+			usage.mu.Unlock()
+			for range test.calls {
+				if err := key.allow(test.now); err != nil {
+					t.Fatalf("allow before the boundary: %v", err)
+				}
+			}
+
+			err := key.allow(test.now)
+			if test.err == "" {
+				if err != nil {
+					t.Fatalf("got %v, want allowed", err)
+				}
+				return
+			}
+			quotaErr, ok := err.(*quotaError)
+			if !ok || err.Error() != test.err {
+				t.Fatalf("got %v, want %s", err, test.err)
+			}
+			if quotaErr.RetryAfter != test.retryAfter {
+				t.Errorf("retry after %s, want %s", quotaErr.RetryAfter, test.retryAfter)
+			}
+		})
+	}
+}
+
+func TestAPIKeyDailyQuotaResetsAtMidnight(t *testing.T) {
+	key := newAPIKey(AuthConfig{}, APIKeyConfig{Name: "app", DailyQuota: 1})
+	before := time.Date(2026, 3, 10, 23, 59, 59, 0, time.UTC)
+	if err := key.allow(before); err != nil {
+		t.Fatal(err)
+	}
+	if err := key.allow(before); err == nil {
+		t.Fatal("second request of the day allowed past a quota of 1")
+	}
+	if err := key.allow(before.Add(time.Second)); err != nil {
+		t.Errorf("first request of the next day: %v", err)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/liberopassadorneto/multi/pkg/cep"
+)
+
+// AutocompleteResponse lists the known CEPs starting with a prefix and,
+// when asked for, the ranges of the offline dataset holding it.
+type AutocompleteResponse struct {
+	Prefix  string               `json:"prefix"`
+	Results []AutocompleteResult `json:"results"`
+	Ranges  []cep.Range          `json:"ranges,omitempty"`
+}
+
+// AutocompleteResult is a known CEP with enough of its address to fill a
+// type-ahead form.
+type AutocompleteResult struct {
+	Cep          string `json:"cep"`
+	State        string `json:"state"`
+	City         string `json:"city"`
+	Neighborhood string `json:"neighborhood,omitempty"`
+	Street       string `json:"street,omitempty"`
+}
+
+// errAutocompleteFull stops seeding the index once it is full.
+var errAutocompleteFull = errors.New("autocomplete index is full")
+
+// autocomplete indexes the addresses the providers answered, nil when
+// disabled.
+var autocomplete *autocompleteIndex
+
+// autocompleteIndex keeps the addresses answered so far sorted by CEP, so
+// that the ones of a prefix are contiguous. Past maxEntries, new CEPs are
+// no longer indexed.
+type autocompleteIndex struct {
+	mu         sync.RWMutex
+	ceps       []string
+	results    map[string]AutocompleteResult
+	maxEntries int
+}
+
+func setupAutocomplete(cfg AutocompleteConfig) {
+	autocomplete = nil
+	if cfg.MaxEntries == 0 {
+		return
+	}
+	autocomplete = &autocompleteIndex{results: map[string]AutocompleteResult{}, maxEntries: cfg.MaxEntries}
+	if history != nil {
+		go autocomplete.seed(context.Background(), history)
+	}
+}
+
+// seed indexes the addresses of the lookups persisted to the history, so
+// that a restart does not empty the index.
+func (i *autocompleteIndex) seed(ctx context.Context, store HistoryStore) {
+	err := store.Export(ctx, time.Time{}, time.Now(), func(record HistoryRecord) error {
+		if !i.add(record.Address) {
+			return errAutocompleteFull
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errAutocompleteFull) {
+		slog.Error("error seeding autocomplete from the history", "error", err)
+	}
+}
+
+// add indexes address, reporting false when the index is full. Offline
+// answers are left out, as their CEP is only known to be in a range.
+func (i *autocompleteIndex) add(address *Address) bool {
+	if i == nil || address == nil || address.Source == "offline" {
+		return true
+	}
+	result := AutocompleteResult{
+		Cep:          address.Cep,
+		State:        address.State,
+		City:         address.City,
+		Neighborhood: address.Neighborhood,
+		Street:       address.Street,
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if _, ok := i.results[result.Cep]; !ok {
+		if len(i.ceps) >= i.maxEntries {
+			return false
+		}
+		at, _ := slices.BinarySearch(i.ceps, result.Cep)
+		i.ceps = slices.Insert(i.ceps, at, result.Cep)
+	}
+	i.results[result.Cep] = result
+	return true
+}
+
+// match returns up to limit indexed CEPs starting with prefix, in order.
+func (i *autocompleteIndex) match(prefix string, limit int) []AutocompleteResult {
+	results := []AutocompleteResult{}
+	if i == nil {
+		return results
+	}
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	at, _ := slices.BinarySearch(i.ceps, prefix)
+	for _, cep := range i.ceps[at:] {
+		if len(results) == limit || !strings.HasPrefix(cep, prefix) {
+			break
+		}
+		results = append(results, i.results[cep])
+	}
+	return results
+}
+
+const (
+	defaultAutocompleteLimit = 10
+	maxAutocompleteLimit     = 100
+)
+
+// AutocompleteHandler serves GET /autocomplete?prefix=, listing the ?limit=
+// known CEPs starting with the prefix and, with ?offline=true, the ranges
+// of the offline dataset holding it.
+func AutocompleteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSONError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed")
+		return
+	}
+	if autocomplete == nil {
+		writeJSONError(w, r, http.StatusServiceUnavailable, CodeUnavailable, "autocomplete is not enabled")
+		return
+	}
+
+	queryParams := r.URL.Query()
+	prefix := strings.ReplaceAll(strings.TrimSpace(queryParams.Get("prefix")), "-", "")
+	if !validPrefix(prefix) {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, "prefix must be 1 to 8 digits")
+		return
+	}
+	limit, err := intParam(queryParams.Get("limit"), defaultAutocompleteLimit)
+	if err != nil || limit < 1 || limit > maxAutocompleteLimit {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, "limit must be between 1 and "+strconv.Itoa(maxAutocompleteLimit))
+		return
+	}
+	withRanges, err := boolParam(queryParams.Get("offline"))
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, "offline must be a boolean")
+		return
+	}
+
+	response := AutocompleteResponse{Prefix: prefix, Results: autocomplete.match(prefix, limit)}
+	if withRanges && offline != nil {
+		response.Ranges = offline.Ranges(prefix, limit)
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+func validPrefix(prefix string) bool {
+	if len(prefix) < 1 || len(prefix) > 8 {
+		return false
+	}
+	for _, c := range prefix {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+type BatchItem struct {
+	Input   string    `json:"input" xml:"input"`
+	Cep     string    `json:"cep,omitempty" xml:"cep,omitempty"`
+	Address *Address  `json:"address,omitempty" xml:"address,omitempty"`
+	Error   string    `json:"error,omitempty" xml:"error,omitempty"`
+	Code    ErrorCode `json:"code,omitempty" xml:"code,omitempty"`
+	Status  int       `json:"status" xml:"status"`
+}
+
+var (
+	batchMax         int
+	batchConcurrency int
+)
+
+// batchItemBytes is the room in a batch body for each of its CEPs, quoted
+// and formatted in any way a client would.
+const batchItemBytes = 64
+
+// BatchHandler resolves a JSON array of CEPs, answering with one item per
+// input in the same order. Failed items carry their own error and status
+// so one bad CEP does not fail the whole batch.
+func BatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSONError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	// bounded so that a body of any size is not held before counting
+	// the CEPs in it
+	body := http.MaxBytesReader(w, r.Body, int64(batchMax)*batchItemBytes+1024)
+	var ceps []string
+	err := json.NewDecoder(body).Decode(&ceps)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		writeJSONError(w, r, http.StatusRequestEntityTooLarge, CodeTooLarge, fmt.Sprintf("batch accepts at most %d CEPs", batchMax))
+		return
+	}
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, "body must be a JSON array of CEPs")
+		return
+	}
+	if len(ceps) > batchMax {
+		writeJSONError(w, r, http.StatusRequestEntityTooLarge, CodeTooLarge, fmt.Sprintf("batch accepts at most %d CEPs", batchMax))
+		return
+	}
+
+	strategy, err := strategyFor(r.URL.Query().Get("strategy"))
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	ctx, err := withTimeoutParam(r)
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	r = r.WithContext(ctx)
+
+	if acceptsEventStream(r) {
+		streamBatch(w, r, ceps, strategy)
+		return
+	}
+	format, err := responseFormat(r)
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	if err := checkResponseShape(r, format); err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	writeFormatted(w, r, http.StatusOK, format, lookupBatch(r.Context(), ceps, strategy, nil))
+}
+
+// BatchItemEvent is an item of a streamed batch, with its index in the
+// input.
+type BatchItemEvent struct {
+	Index int `json:"index"`
+	BatchItem
+}
+
+type BatchSummary struct {
+	Total  int `json:"total"`
+	Failed int `json:"failed"`
+}
+
+// streamBatch answers with Server-Sent Events: an item event as each CEP
+// is resolved, in completion order, then a done event with the summary.
+func streamBatch(w http.ResponseWriter, r *http.Request, ceps []string, strategy string) {
+	stream := newEventStream(w)
+	summary := BatchSummary{Total: len(ceps)}
+	lookupBatch(r.Context(), ceps, strategy, func(index int, item BatchItem) {
+		if item.Error != "" {
+			summary.Failed++
+		}
+		_ = stream.Send(strconv.Itoa(index), "item", BatchItemEvent{Index: index, BatchItem: item})
+	})
+	_ = stream.Send("", "done", summary)
+}
+
+// lookupBatch resolves ceps with up to batchConcurrency lookups at a time,
+// returning one item per input in the same order. When set, done is
+// called with every item as soon as it is resolved, one call at a time.
+func lookupBatch(ctx context.Context, ceps []string, strategy string, done func(index int, item BatchItem)) []BatchItem {
+	var mu sync.Mutex
+	report := func(index int, item BatchItem) {
+		if done != nil {
+			mu.Lock()
+			defer mu.Unlock()
+			done(index, item)
+		}
+	}
+
+	items := make([]BatchItem, len(ceps))
+	sem := make(chan struct{}, batchConcurrency)
+	var wg sync.WaitGroup
+	for i, rawCep := range ceps {
+		items[i].Input = rawCep
+
+		cep, err := NormalizeCep(rawCep)
+		if err != nil {
+			items[i].Error = err.Error()
+			items[i].Status = lookupStatus(err)
+			items[i].Code = lookupCode(err)
+			report(i, items[i])
+			continue
+		}
+		items[i].Cep = cep
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(index int, item *BatchItem) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			address, _, err := Lookup(ctx, item.Cep, strategy)
+			if err != nil {
+				item.Error = err.Error()
+				item.Status = lookupStatus(err)
+				item.Code = lookupCode(err)
+			} else {
+				item.Address = address
+				item.Status = http.StatusOK
+			}
+			report(index, *item)
+		}(i, &items[i])
+	}
+	wg.Wait()
+
+	return items
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/liberopassadorneto/multi/pkg/cep"
+)
+
+// BenchReport is the outcome of `multi bench`.
+type BenchReport struct {
+	Target    string         `json:"target"`
+	Duration  float64        `json:"duration_s"`
+	Requests  int            `json:"requests"`
+	Dropped   int            `json:"dropped"`
+	Errors    int            `json:"errors"`
+	ErrorRate float64        `json:"error_rate"`
+	RPS       float64        `json:"rps"`
+	Latency   BenchLatency   `json:"latency_ms"`
+	Statuses  map[string]int `json:"statuses"`
+}
+
+// BenchLatency holds the latency percentiles of the answered requests, in
+// milliseconds.
+type BenchLatency struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P95 float64 `json:"p95"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+// runBench implements `multi bench [flags]`, driving a running server at a
+// fixed rate and reporting its latency and error rate. Requests are sent
+// on schedule whether or not the earlier ones were answered, up to
+// --concurrency in flight; the ones past it are dropped and reported. It
+// returns the process exit code: 1 if no request succeeded and 2 on usage
+// errors.
+func runBench(args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	target := fs.String("target", "http://localhost:8080", "base URL of the server")
+	rps := fs.Float64("rps", 50, "requests per second")
+	duration := fs.Duration("duration", 30*time.Second, "how long to send requests")
+	cepsFile := fs.String("ceps", "", "file of CEPs, one per line (the mock fixtures' CEPs by default)")
+	distribution := fs.String("distribution", "zipf", "how CEPs are picked: zipf, where the first lines are the hottest, or uniform")
+	concurrency := fs.Int("concurrency", 100, "maximum requests in flight")
+	timeout := fs.Duration("timeout", 5*time.Second, "deadline of each request")
+	format := fs.String("format", "table", "output format: table or json")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: multi bench [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *rps <= 0 || *duration <= 0 || *concurrency < 1 || *timeout <= 0 {
+		fmt.Fprintln(os.Stderr, "rps, duration, concurrency and timeout must be positive")
+		return 2
+	}
+	if *format != "table" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "unknown format %q\n", *format)
+		return 2
+	}
+
+	ceps, err := benchCeps(*cepsFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "reading ceps: %v\n", err)
+		return 2
+	}
+	pick, err := benchPicker(*distribution, len(ceps))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	bench := &benchRun{
+		target:  strings.TrimSuffix(*target, "/"),
+		client:  &http.Client{Timeout: *timeout},
+		slots:   make(chan struct{}, *concurrency),
+		results: map[string]int{},
+	}
+	fmt.Fprintf(os.Stderr, "sending %g requests per second to %s for %s\n", *rps, bench.target, *duration)
+	report := bench.run(ctx, *rps, *duration, func() string { return ceps[pick()] })
+
+	if *format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(report)
+	} else {
+		err = writeBenchTable(os.Stdout, report)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "writing output: %v\n", err)
+		return 1
+	}
+	if report.Requests == report.Errors {
+		return 1
+	}
+	return 0
+}
+
+// benchCeps reads the CEPs of path or, when empty, those of the embedded
+// fixtures, which the server answers with --mock.
+func benchCeps(path string) ([]string, error) {
+	var lines []string
+	if path == "" {
+		names, err := fs.Glob(cep.DefaultFixtures(), "*.json")
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			lines = append(lines, strings.TrimSuffix(name, ".json"))
+		}
+	} else {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		lines, err = readLines(file)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("no ceps in %s", path)
+	}
+	return lines, nil
+}
+
+// benchPicker returns a function picking an index below n. Under zipf the
+// first indexes are picked far more often than the last ones, as real
+// traffic concentrates on a few popular CEPs.
+func benchPicker(distribution string, n int) (func() int, error) {
+	switch distribution {
+	case "uniform":
+		return func() int { return rand.Intn(n) }, nil
+	case "zipf":
+		var mu sync.Mutex
+		zipf := rand.NewZipf(rand.New(rand.NewSource(time.Now().UnixNano())), 1.1, 1, uint64(n-1))
+		return func() int {
+			mu.Lock()
+			defer mu.Unlock()
+			return int(zipf.Uint64())
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown distribution %q", distribution)
+}
+
+type benchRun struct {
+	target string
+	client *http.Client
+	slots  chan struct{}
+
+	mu        sync.Mutex
+	latencies []time.Duration
+	results   map[string]int
+	errors    int
+	dropped   int
+}
+
+func (b *benchRun) run(ctx context.Context, rps float64, duration time.Duration, next func() string) BenchReport {
+	ctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / rps))
+	defer ticker.Stop()
+
+	var wg sync.WaitGroup
+	start := time.Now()
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-ticker.C:
+		}
+		select {
+		case b.slots <- struct{}{}:
+		default:
+			b.mu.Lock()
+			b.dropped++
+			b.mu.Unlock()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-b.slots }()
+			b.send(next())
+		}()
+	}
+	elapsed := time.Since(start)
+	wg.Wait()
+	return b.report(elapsed)
+}
+
+// send looks up cep, recording the latency of the answered requests and
+// the status, or "error" when there was no answer. Statuses of 500 and
+// above count as errors; not found is an answer like any other.
+func (b *benchRun) send(cep string) {
+	start := time.Now()
+	resp, err := b.client.Get(b.target + "/?cep=" + cep)
+	if err == nil {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	latency := time.Since(start)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err != nil {
+		b.results["error"]++
+		b.errors++
+		return
+	}
+	b.latencies = append(b.latencies, latency)
+	b.results[strconv.Itoa(resp.StatusCode)]++
+	if resp.StatusCode >= 500 {
+		b.errors++
+	}
+}
+
+func (b *benchRun) report(elapsed time.Duration) BenchReport {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	requests := 0
+	for _, n := range b.results {
+		requests += n
+	}
+	report := BenchReport{
+		Target:   b.target,
+		Duration: elapsed.Seconds(),
+		Requests: requests,
+		Dropped:  b.dropped,
+		Errors:   b.errors,
+		RPS:      float64(requests) / elapsed.Seconds(),
+		Statuses: b.results,
+	}
+	if requests > 0 {
+		report.ErrorRate = float64(b.errors) / float64(requests)
+	}
+	slices.Sort(b.latencies)
+	report.Latency = BenchLatency{
+		P50: latencyPercentile(b.latencies, 0.5),
+		P90: latencyPercentile(b.latencies, 0.9),
+		P95: latencyPercentile(b.latencies, 0.95),
+		P99: latencyPercentile(b.latencies, 0.99),
+		Max: latencyPercentile(b.latencies, 1),
+	}
+	return report
+}
+
+// latencyPercentile is the p-th percentile of sorted, in milliseconds.
+func latencyPercentile(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := min(int(float64(len(sorted))*p), len(sorted)-1)
+	return float64(sorted[i].Microseconds()) / 1000
+}
+
+func writeBenchTable(w io.Writer, report BenchReport) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "target\t%s\n", report.Target)
+	fmt.Fprintf(tw, "duration\t%.1fs\n", report.Duration)
+	fmt.Fprintf(tw, "requests\t%d (%.1f/s)\n", report.Requests, report.RPS)
+	fmt.Fprintf(tw, "dropped\t%d\n", report.Dropped)
+	fmt.Fprintf(tw, "errors\t%d (%.2f%%)\n", report.Errors, report.ErrorRate*100)
+	l := report.Latency
+	fmt.Fprintf(tw, "latency\tp50 %.1fms  p90 %.1fms  p95 %.1fms  p99 %.1fms  max %.1fms\n", l.P50, l.P90, l.P95, l.P99, l.Max)
+	for _, status := range slices.Sorted(maps.Keys(report.Statuses)) {
+		fmt.Fprintf(tw, "status %s\t%d\n", status, report.Statuses[status])
+	}
+	return tw.Flush()
+}
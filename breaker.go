@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrHeldBack is returned for the calls refused by the breaker or the
+// rate limit of a provider started by a race: activeProviders let it in,
+// but its last probe or token went to another lookup in the meantime.
+// The call is never made, so the breakers and provider stats ignore it.
+var ErrHeldBack = errors.New("provider held back")
+
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// CircuitBreaker opens after threshold consecutive failures, keeping the
+// provider out of the race for openFor. It then lets up to probes lookups
+// through and closes again once they all succeed.
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	openFor   time.Duration
+	probes    int
+
+	state     BreakerState
+	failures  int
+	successes int
+	inFlight  int
+	openedAt  time.Time
+}
+
+func NewCircuitBreaker(threshold int, openFor time.Duration, probes int) *CircuitBreaker {
+	return &CircuitBreaker{threshold: threshold, openFor: openFor, probes: probes, state: BreakerClosed}
+}
+
+// Allow reports whether the provider may be called. In the half-open
+// state a granted call reserves a probe, which must be given back through
+// Record, so only the calls actually made ask for it.
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.ready() {
+		return false
+	}
+	if b.state == BreakerHalfOpen {
+		b.inFlight++
+	}
+	return true
+}
+
+// Ready reports whether Allow would grant a call, without reserving a
+// probe, for choosing the providers of a race that may not start them all.
+func (b *CircuitBreaker) Ready() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.ready()
+}
+
+func (b *CircuitBreaker) ready() bool {
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.openFor {
+		b.state = BreakerHalfOpen
+		b.successes = 0
+		b.inFlight = 0
+	}
+
+	switch b.state {
+	case BreakerClosed:
+		return true
+	case BreakerHalfOpen:
+		return b.inFlight < b.probes-b.successes
+	}
+	return false
+}
+
+// Record reports the outcome of an allowed call. A nil err is a success;
+// context.Canceled means the call was abandoned and counts as neither, as
+// do the errors for which notCalled holds.
+func (b *CircuitBreaker) Record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerHalfOpen && b.inFlight > 0 {
+		b.inFlight--
+	}
+	if errors.Is(err, context.Canceled) || notCalled(err) {
+		return
+	}
+
+	switch b.state {
+	case BreakerClosed:
+		if err == nil {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.threshold {
+			b.open()
+		}
+	case BreakerHalfOpen:
+		if err != nil {
+			b.open()
+			return
+		}
+		b.successes++
+		if b.successes >= b.probes {
+			b.state = BreakerClosed
+			b.failures = 0
+		}
+	}
+}
+
+func (b *CircuitBreaker) open() {
+	b.state = BreakerOpen
+	b.openedAt = time.Now()
+	b.failures = 0
+}
+
+// Trip opens the breaker by hand, as if the provider had just failed
+// threshold times.
+func (b *CircuitBreaker) Trip() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.open()
+}
+
+// Reset closes the breaker by hand, forgetting the failures so far.
+func (b *CircuitBreaker) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.state = BreakerClosed
+	b.failures, b.successes, b.inFlight = 0, 0, 0
+}
+
+type BreakerStatus struct {
+	State    BreakerState `json:"state"`
+	Failures int          `json:"failures"`
+	OpenedAt *time.Time   `json:"opened_at,omitempty"`
+	RetryAt  *time.Time   `json:"retry_at,omitempty"`
+}
+
+func (b *CircuitBreaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := BreakerStatus{State: b.state, Failures: b.failures}
+	if b.state != BreakerClosed {
+		openedAt := b.openedAt
+		retryAt := openedAt.Add(b.openFor)
+		status.OpenedAt, status.RetryAt = &openedAt, &retryAt
+	}
+	return status
+}
+
+// breakerProvider asks its breaker before every lookup and feeds the
+// outcome into it. Not found is an answer, so it counts as a success.
+type breakerProvider struct {
+	Provider
+	breaker *CircuitBreaker
+}
+
+func (p *breakerProvider) Lookup(ctx context.Context, cep string) (*Address, error) {
+	if !p.breaker.Allow() {
+		return nil, fmt.Errorf("%s: %w: circuit breaker open", p.Name(), ErrHeldBack)
+	}
+	address, err := p.Provider.Lookup(ctx, cep)
+	if errors.Is(err, ErrCepNotFound) {
+		p.breaker.Record(nil)
+	} else {
+		p.breaker.Record(err)
+	}
+	return address, err
+}
+
+var breakers = map[string]*CircuitBreaker{}
+
+// notCalled reports whether err was returned for a call that was never
+// made, which says nothing of the provider.
+func notCalled(err error) bool {
+	return errors.Is(err, ErrBudgetExhausted) || errors.Is(err, ErrDailyCapReached) || errors.Is(err, ErrHeldBack)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/liberopassadorneto/multi/pkg/cep"
+	"golang.org/x/time/rate"
+)
+
+// stubProvider answers every lookup with a fixed address, counting them.
+type stubProvider struct {
+	name  string
+	calls atomic.Int32
+}
+
+func (p *stubProvider) Name() string { return p.name }
+
+func (p *stubProvider) Lookup(ctx context.Context, code string) (*Address, error) {
+	p.calls.Add(1)
+	return &Address{Cep: code, Provider: p.name}, nil
+}
+
+// withProviderState swaps in the breakers and limiters of a test,
+// restoring those of the process when it ends.
+func withProviderState(t *testing.T, b map[string]*CircuitBreaker, l map[string]*rate.Limiter) {
+	t.Helper()
+	savedBreakers, savedLimiters := breakers, limiters
+	breakers, limiters = b, l
+	t.Cleanup(func() { breakers, limiters = savedBreakers, savedLimiters })
+}
+
+// halfOpenBreaker returns a breaker waiting on a single probe.
+func halfOpenBreaker() *CircuitBreaker {
+	breaker := NewCircuitBreaker(1, 0, 1)
+	breaker.Trip()
+	return breaker
+}
+
+func TestHedgedKeepsProbesOfProvidersNotStarted(t *testing.T) {
+	primary := &stubProvider{name: "primary"}
+	secondary := &stubProvider{name: "secondary"}
+	breaker := halfOpenBreaker()
+	withProviderState(t, map[string]*CircuitBreaker{"secondary": breaker}, map[string]*rate.Limiter{})
+	providers := []Provider{primary, &breakerProvider{Provider: secondary, breaker: breaker}}
+
+	for i := range 3 {
+		active := activeProviders(providers)
+		if len(active) != 2 {
+			t.Fatalf("lookup %d: %d active providers, want 2", i, len(active))
+		}
+		result, err := cep.Hedged(time.Hour)(context.Background(), active, "01001000")
+		if err != nil || result.Provider != "primary" {
+			t.Fatalf("lookup %d: answered by %q, %v; want primary", i, result.Provider, err)
+		}
+	}
+	if calls := secondary.calls.Load(); calls != 0 {
+		t.Errorf("secondary called %d times, want 0", calls)
+	}
+	if !breaker.Allow() {
+		t.Error("the probe of the half-open breaker was not given back")
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// ErrBudgetExhausted is returned for the upstream calls an inbound request
+// makes past its outbound budget. Like context.Canceled, it says nothing
+// of the upstream, so the breakers and provider stats ignore it.
+var ErrBudgetExhausted = errors.New("outbound budget of the request exhausted")
+
+// outboundBudget is the upstream calls a single inbound request may make,
+// 0 for no limit.
+var outboundBudget int
+
+var budgetExhaustions = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: "multi",
+	Name:      "outbound_budget_exhausted_total",
+	Help:      "Inbound requests that used up their outbound budget and were answered partially.",
+})
+
+type budgetKey struct{}
+
+// requestBudget counts down the upstream calls left to an inbound request.
+// It is shared with the races and the asynchronous work the request
+// starts, which keep its context values.
+type requestBudget struct {
+	left      atomic.Int64
+	exhausted atomic.Bool
+}
+
+// withOutboundBudget gives the upstream calls made with the returned
+// context the configured outbound budget, when there is one.
+func withOutboundBudget(ctx context.Context) context.Context {
+	if outboundBudget <= 0 {
+		return ctx
+	}
+	budget := &requestBudget{}
+	budget.left.Store(int64(outboundBudget))
+	return context.WithValue(ctx, budgetKey{}, budget)
+}
+
+// spendBudget takes an upstream call from the budget of ctx, reporting
+// false when none is left. Contexts without a budget are never refused.
+func spendBudget(ctx context.Context) bool {
+	budget, ok := ctx.Value(budgetKey{}).(*requestBudget)
+	if !ok || budget.left.Add(-1) >= 0 {
+		return true
+	}
+	if budget.exhausted.CompareAndSwap(false, true) {
+		budgetExhaustions.Inc()
+	}
+	return false
+}
+
+// withoutBudget lifts the outbound budget of ctx off the calls made with
+// the returned context, for the background work its request does not wait
+// on.
+func withoutBudget(ctx context.Context) context.Context {
+	return context.WithValue(ctx, budgetKey{}, nil)
+}
+
+// budgetExhausted reports whether an upstream call made for ctx was
+// refused, leaving its answer possibly partial.
+func budgetExhausted(ctx context.Context) bool {
+	budget, ok := ctx.Value(budgetKey{}).(*requestBudget)
+	return ok && budget.exhausted.Load()
+}
+
+// budgeted gives each request the configured outbound budget.
+func budgeted(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(w, r.WithContext(withOutboundBudget(r.Context())))
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// canaries are the providers evaluated in the shadow of the race: asked
+// for a sample of the lookups, their answers compared with the race's but
+// never returned. A reload rebuilds them like the race providers.
+var canaries []Provider
+
+// canarySampleRate is the share of the lookups the canaries are asked.
+var canarySampleRate float64
+
+// canaryChecks bounds the shadow lookups running at once; lookups sampled
+// past it are not shadowed.
+var canaryChecks = make(chan struct{}, 4)
+
+// canaryResults keeps the comparisons of each canary, by name, across
+// reloads, until the restart.
+var canaryResults = struct {
+	mu    sync.Mutex
+	stats map[string]*canaryStats
+}{stats: map[string]*canaryStats{}}
+
+// NewCanaryProviders builds the canary providers of cfg, wrapped like
+// those of the race.
+func NewCanaryProviders(cfg Config, client *http.Client) []Provider {
+	providers := make([]Provider, 0, len(cfg.Canary.Providers))
+	for _, name := range cfg.Canary.Providers {
+		providerClient := recordingClient(cfg.Recording, name, providerClient(client, cfg.Provider(name)))
+		provider, err := newProvider(name, cfg.Provider(name), providerClient)
+		if err != nil {
+			// Validate rejects unknown providers, so this is a bug
+			panic(err)
+		}
+		if mockFixtures != nil {
+			provider = mockProvider(cfg, name, provider)
+		}
+		providers = append(providers, wrapProvider(cfg, name, provider))
+	}
+	return providers
+}
+
+func currentCanaries() []Provider {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	return canaries
+}
+
+// canaryStats counts how a canary's answers compared with the race's.
+// Agreed are the lookups both answered alike, found or not; Missed the
+// CEPs only the race found and Extra those only the canary did.
+type canaryStats struct {
+	mu        sync.Mutex
+	samples   int
+	agreed    int
+	divergent int
+	missed    int
+	extra     int
+	errors    int
+	fields    map[string]int
+	latencies *LatencyWindow
+	// incumbent are the latencies of the races it was compared with
+	incumbent *LatencyWindow
+}
+
+func canaryStatsOf(name string) *canaryStats {
+	canaryResults.mu.Lock()
+	defer canaryResults.mu.Unlock()
+	stats, ok := canaryResults.stats[name]
+	if !ok {
+		stats = &canaryStats{fields: map[string]int{}, latencies: NewLatencyWindow(statsWindow), incumbent: NewLatencyWindow(statsWindow)}
+		canaryResults.stats[name] = stats
+	}
+	return stats
+}
+
+// shadowCanaries asks every canary for cep in the background, for the
+// configured share of the lookups, comparing their answers with address,
+// the race's, or its not found. latency is that of the lookup, which only
+// counts as the race's when it did not come from the cache.
+func shadowCanaries(ctx context.Context, cep string, address *Address, info LookupInfo, latency time.Duration, err error) {
+	if err != nil && !errors.Is(err, ErrCepNotFound) {
+		return
+	}
+	shadowed := currentCanaries()
+	if len(shadowed) == 0 || rand.Float64() >= canarySampleRate {
+		return
+	}
+	select {
+	case canaryChecks <- struct{}{}:
+	default:
+		return
+	}
+
+	go func() {
+		defer func() { <-canaryChecks }()
+		// the shadow lookups are not the client's to pay for
+		ctx, cancel := context.WithTimeout(withoutBudget(context.WithoutCancel(ctx)), lookupTimeout())
+		defer cancel()
+		defer context.AfterFunc(lookupsCtx, cancel)()
+
+		var wg sync.WaitGroup
+		for _, provider := range shadowed {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				start := time.Now()
+				canaryAddress, canaryErr := provider.Lookup(ctx, cep)
+				stats := canaryStatsOf(provider.Name())
+				if !info.Cached {
+					stats.incumbent.Record(latency)
+				}
+				stats.record(address, canaryAddress, time.Since(start), canaryErr)
+				if canaryErr != nil && !errors.Is(canaryErr, ErrCepNotFound) {
+					slog.DebugContext(ctx, "canary lookup failed", "provider", provider.Name(), "error", canaryErr)
+				}
+			}()
+		}
+		wg.Wait()
+	}()
+}
+
+// record compares the answer of the canary with incumbent, the race's, nil
+// when it did not find the CEP.
+func (s *canaryStats) record(incumbent, canary *Address, latency time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.samples++
+	notFound := errors.Is(err, ErrCepNotFound)
+	if err != nil && !notFound {
+		s.errors++
+		return
+	}
+	s.latencies.Record(latency)
+	switch {
+	case incumbent == nil && notFound:
+		s.agreed++
+	case incumbent == nil:
+		s.extra++
+	case notFound:
+		s.missed++
+	default:
+		discrepancies := FindDiscrepancies([]Result{
+			{Provider: "incumbent", Address: incumbent},
+			{Provider: "canary", Address: canary},
+		})
+		if len(discrepancies) == 0 {
+			s.agreed++
+			return
+		}
+		s.divergent++
+		for _, discrepancy := range discrepancies {
+			s.fields[discrepancy.Field]++
+		}
+	}
+}
+
+// CanaryReport compares every canary with the race, in the order they are
+// configured.
+type CanaryReport struct {
+	SampleRate float64        `json:"sample_rate"`
+	Canaries   []CanaryResult `json:"canaries"`
+}
+
+// CanaryResult is how a canary compared with the race over the sampled
+// lookups. Accuracy is the share of those it answered, found or not, on
+// which it agreed with the race.
+type CanaryResult struct {
+	Provider  string         `json:"provider"`
+	Samples   int            `json:"samples"`
+	Agreed    int            `json:"agreed"`
+	Divergent int            `json:"divergent"`
+	Missed    int            `json:"missed"`
+	Extra     int            `json:"extra"`
+	Errors    int            `json:"errors"`
+	Accuracy  *float64       `json:"accuracy,omitempty"`
+	Fields    map[string]int `json:"fields"`
+
+	LatencyP50          *float64 `json:"latency_p50_ms,omitempty"`
+	LatencyP95          *float64 `json:"latency_p95_ms,omitempty"`
+	IncumbentLatencyP50 *float64 `json:"incumbent_latency_p50_ms,omitempty"`
+	IncumbentLatencyP95 *float64 `json:"incumbent_latency_p95_ms,omitempty"`
+}
+
+func (s *canaryStats) result(name string) CanaryResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := CanaryResult{
+		Provider:  name,
+		Samples:   s.samples,
+		Agreed:    s.agreed,
+		Divergent: s.divergent,
+		Missed:    s.missed,
+		Extra:     s.extra,
+		Errors:    s.errors,
+		Fields:    map[string]int{},
+	}
+	for field, n := range s.fields {
+		result.Fields[field] = n
+	}
+	if answered := s.samples - s.errors; answered > 0 {
+		accuracy := float64(s.agreed) / float64(answered)
+		result.Accuracy = &accuracy
+	}
+	result.LatencyP50 = percentileMs(s.latencies, 0.5)
+	result.LatencyP95 = percentileMs(s.latencies, 0.95)
+	result.IncumbentLatencyP50 = percentileMs(s.incumbent, 0.5)
+	result.IncumbentLatencyP95 = percentileMs(s.incumbent, 0.95)
+	return result
+}
+
+// CanaryReportHandler serves GET /canary/report, the accuracy and latency
+// of the canaries against the race since the start, as a basis to promote
+// them into it through POST /admin/providers/{name}/promote.
+func CanaryReportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSONError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed")
+		return
+	}
+	shadowed := currentCanaries()
+	report := CanaryReport{SampleRate: canarySampleRate, Canaries: make([]CanaryResult, 0, len(shadowed))}
+	for _, provider := range shadowed {
+		report.Canaries = append(report.Canaries, canaryStatsOf(provider.Name()).result(provider.Name()))
+	}
+	writeJSON(w, r, http.StatusOK, report)
+}
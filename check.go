@@ -0,0 +1,279 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// CheckReport is the outcome of `multi check`: OK when every check passed.
+type CheckReport struct {
+	OK     bool          `json:"ok"`
+	Checks []CheckResult `json:"checks"`
+}
+
+// CheckResult is one check of a Target, a provider, host or backend,
+// with what it found or why it failed.
+type CheckResult struct {
+	Check      string  `json:"check"`
+	Target     string  `json:"target,omitempty"`
+	OK         bool    `json:"ok"`
+	DurationMs float64 `json:"duration_ms"`
+	Detail     string  `json:"detail,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+func (r *CheckReport) add(check, target string, start time.Time, detail string, err error) {
+	result := CheckResult{Check: check, Target: target, OK: err == nil, Detail: detail,
+		DurationMs: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		result.Error = err.Error()
+		r.OK = false
+	}
+	r.Checks = append(r.Checks, result)
+}
+
+// runCheck implements `multi check [flags]`, the preflight of a deploy: it
+// validates the configuration, resolves the hosts of the providers, looks
+// a known CEP up in each of them and reaches the cache and the history.
+// It returns the process exit code: 1 if any check failed and 2 on usage
+// errors.
+func runCheck(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	configFlags := NewConfigFlags(fs)
+	knownCep := fs.String("cep", "", "CEP every provider must find (HEALTH_CHECK_CEP by default)")
+	format := fs.String("format", "table", "output format: table or json")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: multi check [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	write, ok := checkWriters[*format]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown format %q\n", *format)
+		return 2
+	}
+
+	// the report says it all
+	slog.SetDefault(newLogger(io.Discard, "error", "console"))
+
+	report := &CheckReport{OK: true, Checks: []CheckResult{}}
+	start := time.Now()
+	cfg, err := configFlags.Load()
+	report.add("config", configFlags.Path(), start, "", err)
+	if err == nil {
+		checkSetup(report, cfg, *knownCep)
+	}
+
+	err = write(os.Stdout, report)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "writing output: %v\n", err)
+		return 1
+	}
+	if !report.OK {
+		return 1
+	}
+	return 0
+}
+
+// checkSetup runs the checks of a valid cfg. Providers whose host does not
+// resolve are not looked up.
+func checkSetup(report *CheckReport, cfg Config, knownCep string) {
+	start := time.Now()
+	cep, err := NormalizeCep(cmp.Or(knownCep, cfg.HealthCheck.Cep))
+	if err != nil {
+		report.add("config", "cep", start, "", err)
+		return
+	}
+	client, err := NewHTTPClient(cfg.HTTPClient)
+	if err != nil {
+		report.add("config", "http_client", start, "", err)
+		return
+	}
+	setupMock(cfg.Mock)
+
+	unresolved := map[string]bool{}
+	if mockFixtures == nil {
+		resolved := map[string]bool{}
+		for _, name := range cfg.Providers {
+			for _, host := range upstreamHosts(cfg, name, cep) {
+				if resolved[host] || net.ParseIP(host) != nil {
+					continue
+				}
+				resolved[host] = true
+				start := time.Now()
+				ctx, cancel := context.WithTimeout(context.Background(), dnsTimeout)
+				addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+				cancel()
+				if err != nil {
+					unresolved[name] = true
+				}
+				report.add("dns", host, start, strings.Join(addrs, ", "), err)
+			}
+		}
+	}
+
+	// in the order of cfg.Providers
+	for i, provider := range NewProviders(cfg, client) {
+		if unresolved[cfg.Providers[i]] {
+			continue
+		}
+		start := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+		result := LookupResult(ctx, provider, cep)
+		cancel()
+		detail := ""
+		if result.Address != nil {
+			detail = result.Address.City + "/" + result.Address.State
+		}
+		report.add("provider", provider.Name(), start, detail, result.Err)
+	}
+
+	checkCache(report, cfg)
+	checkHistory(report, cfg.History, cfg.Timeout)
+}
+
+// upstreamHosts are the hosts provider name sends its lookups of cep to,
+// its mirrors' included, learnt by building it with a client that records
+// them instead of sending the requests.
+func upstreamHosts(cfg Config, name, cep string) []string {
+	var hosts []string
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		hosts = append(hosts, req.URL.Hostname())
+		return nil, errors.New("not sent")
+	})}
+	settings := cfg.Provider(name)
+	provider, err := newProvider(name, settings, client)
+	if err == nil {
+		_, _ = provider.Lookup(context.Background(), cep)
+	}
+	for _, mirror := range settings.Mirrors {
+		if u, err := url.Parse(mirror); err == nil {
+			hosts = append(hosts, u.Hostname())
+		}
+	}
+	return hosts
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// checkCache reaches the cache backend of cfg, opening the bolt file,
+// which only one process holds at a time, and closing it again.
+func checkCache(report *CheckReport, cfg Config) {
+	backend := cfg.Cache.Backend
+	start := time.Now()
+	switch backend {
+	case "redis":
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+		defer cancel()
+		err := NewRedisCache(cfg.Cache.Redis.Addr, cfg.Cache.Redis.Password, cfg.Cache.Redis.DB, cfg.Cache.TTL).Ping(ctx)
+		report.add("cache", backend, start, cfg.Cache.Redis.Addr, err)
+	case "bolt":
+		boltCache, err := NewBoltCache(cfg.Cache.Bolt.Path, cfg.Cache.Size, cfg.Cache.TTL)
+		if err == nil {
+			err = boltCache.Close()
+		}
+		report.add("cache", backend, start, cfg.Cache.Bolt.Path, err)
+	default:
+		report.add("cache", "memory", start, "", nil)
+	}
+}
+
+// checkHistory connects to the history database of cfg, if any, within
+// timeout and checks that its tables exist. Unlike OpenHistory, it creates
+// neither the tables nor the SQLite file, a preflight changing nothing.
+func checkHistory(report *CheckReport, cfg HistoryConfig, timeout time.Duration) {
+	if cfg.Driver == "" {
+		return
+	}
+	start := time.Now()
+	report.add("history", cfg.Driver, start, "", historySchemaReady(cfg, timeout))
+}
+
+func historySchemaReady(cfg HistoryConfig, timeout time.Duration) error {
+	if cfg.Driver == "sqlite" {
+		path, _, _ := strings.Cut(strings.TrimPrefix(cfg.DSN, "file:"), "?")
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("history database not created yet: %w", err)
+		}
+	}
+	db, err := openHistoryDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err = db.PingContext(ctx)
+	if err != nil {
+		return err
+	}
+	var missing []string
+	for _, table := range historyTables {
+		rows, err := db.QueryContext(ctx, "SELECT 1 FROM "+table+" LIMIT 1")
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			missing = append(missing, table)
+			continue
+		}
+		rows.Close()
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("history tables missing, created on the first start: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+var checkWriters = map[string]func(io.Writer, *CheckReport) error{
+	"table": writeCheckTable,
+	"json":  writeCheckJSON,
+}
+
+func writeCheckJSON(w io.Writer, report *CheckReport) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+func writeCheckTable(w io.Writer, report *CheckReport) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHECK\tTARGET\tRESULT\tDURATION\tDETAIL")
+	for _, check := range report.Checks {
+		result, detail := "ok", check.Detail
+		if !check.OK {
+			result, detail = "FAIL", check.Error
+		}
+		duration := strconv.FormatFloat(check.DurationMs, 'f', 1, 64) + "ms"
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", check.Check, check.Target, result, duration, detail)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if !report.OK {
+		_, err := fmt.Fprintln(w, "\nsome checks failed")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// runLookup implements `multi lookup [flags] [cep...]`. CEPs are read from
+// the arguments or, when there are none or the only one is "-", one per
+// line from stdin. It returns the process exit code: 1 if any lookup
+// failed and 2 on usage errors.
+func runLookup(args []string) int {
+	fs := flag.NewFlagSet("lookup", flag.ContinueOnError)
+	format := fs.String("format", "json", "output format: json, table or csv")
+	configFlags := NewConfigFlags(fs)
+	verbose := fs.Bool("verbose", false, "log provider activity to stderr")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: multi lookup [flags] [cep...]")
+		fs.PrintDefaults()
+	}
+
+	ceps, err := parseInterspersed(fs, args)
+	if err != nil {
+		return 2
+	}
+
+	write, ok := lookupWriters[*format]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown format %q\n", *format)
+		return 2
+	}
+
+	// logs stay out of the way of the output unless asked for
+	slog.SetDefault(newLogger(io.Discard, "error", "console"))
+
+	if len(ceps) == 0 || (len(ceps) == 1 && ceps[0] == "-") {
+		ceps, err = readLines(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "reading stdin: %v\n", err)
+			return 2
+		}
+	}
+
+	cfg, err := configFlags.Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if *verbose {
+		slog.SetDefault(newLogger(os.Stderr, "debug", cfg.LogFormat))
+	}
+	setup(cfg)
+	defer closeHistory()
+	defer closeEvents()
+	defer closeCache()
+
+	items := make([]BatchItem, 0, len(ceps))
+	exitCode := 0
+	for _, rawCep := range ceps {
+		item := BatchItem{Input: rawCep}
+		cep, err := NormalizeCep(rawCep)
+		if err == nil {
+			item.Cep = cep
+			item.Address, _, err = Lookup(context.Background(), cep, cfg.Strategy)
+		}
+		if err != nil {
+			item.Error = err.Error()
+			item.Status = lookupStatus(err)
+			item.Code = lookupCode(err)
+			exitCode = 1
+		} else {
+			item.Status = http.StatusOK
+		}
+		items = append(items, item)
+	}
+
+	err = write(os.Stdout, items)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "writing output: %v\n", err)
+		return 1
+	}
+	return exitCode
+}
+
+// parseInterspersed parses flags that may appear after positional
+// arguments, as in `multi lookup 01310100 --format=table`.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		err := fs.Parse(args)
+		if err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+func readLines(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+var lookupWriters = map[string]func(io.Writer, []BatchItem) error{
+	"json":  writeLookupJSON,
+	"table": writeLookupTable,
+	"csv":   writeLookupCSV,
+}
+
+var lookupColumns = []string{"input", "cep", "state", "city", "neighborhood", "street", "provider", "status", "error"}
+
+func lookupRow(item BatchItem) []string {
+	row := []string{item.Input, item.Cep, "", "", "", "", "", strconv.Itoa(item.Status), item.Error}
+	if a := item.Address; a != nil {
+		copy(row[2:], []string{a.State, a.City, a.Neighborhood, a.Street, a.Provider})
+	}
+	return row
+}
+
+func writeLookupJSON(w io.Writer, items []BatchItem) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(items)
+}
+
+func writeLookupTable(w io.Writer, items []BatchItem) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.ToUpper(strings.Join(lookupColumns, "\t")))
+	for _, item := range items {
+		fmt.Fprintln(tw, strings.Join(lookupRow(item), "\t"))
+	}
+	return tw.Flush()
+}
+
+func writeLookupCSV(w io.Writer, items []BatchItem) error {
+	out := csv.NewWriter(w)
+	err := out.Write(lookupColumns)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		err = out.Write(lookupRow(item))
+		if err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"golang.org/x/time/rate"
+
+	"github.com/liberopassadorneto/multi/pkg/cep"
+)
+
+var (
+	cnpjProviders []cnpjProvider
+	// cnpjCache is kept apart from the CEP cache, so that companies do not
+	// evict addresses
+	cnpjCache *cep.MemoryCache
+)
+
+// cnpjProvider is a CNPJ provider with its outbound limiter, nil when it
+// is not rate limited.
+type cnpjProvider struct {
+	cep.CnpjProvider
+	limiter *rate.Limiter
+}
+
+func setupCnpj(cfg Config, client *http.Client) {
+	cnpjProviders = nil
+	for _, name := range cfg.Cnpj.Providers {
+		settings := cfg.Cnpj.ProviderSettings[name]
+		var provider cep.CnpjProvider
+		switch name {
+		case "brasilapi":
+			p := cep.NewBrasilApiCnpjProvider()
+			p.Client = client
+			if settings.URL != "" {
+				p.BaseURL = settings.URL
+			}
+			provider = p
+		case "receitaws":
+			p := cep.NewReceitaWsProvider()
+			p.Client = client
+			p.Token = settings.Token
+			if settings.URL != "" {
+				p.BaseURL = settings.URL
+			}
+			provider = p
+		}
+		entry := cnpjProvider{CnpjProvider: provider}
+		if limit := settings.RateLimit; limit != nil && limit.RPS > 0 {
+			entry.limiter = rate.NewLimiter(rate.Limit(limit.RPS), limit.Burst)
+		}
+		cnpjProviders = append(cnpjProviders, entry)
+	}
+	cnpjCache = cep.NewMemoryCache(cfg.Cache.Size, cfg.Cache.TTL)
+}
+
+// LookupCnpj finds a company from the cache or, on a miss, from the
+// providers in priority order, bounded by the configured timeout. A
+// provider whose rate limit is exhausted is skipped, and one that does not
+// know the CNPJ or fails hands over to the next, as their datasets differ.
+func LookupCnpj(ctx context.Context, raw string) (*cep.Company, error) {
+	cnpj, err := cep.NormalizeCnpj(raw)
+	if err != nil {
+		return nil, err
+	}
+	ctx = withLogAttrs(ctx, "cnpj", cnpj)
+
+	if body, ok, _ := cnpjCache.Get(ctx, cnpj); ok {
+		var company cep.Company
+		if json.Unmarshal(body, &company) == nil {
+			return &company, nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, lookupTimeout())
+	defer cancel()
+
+	var errs []error
+	for _, provider := range cnpjProviders {
+		if provider.limiter != nil && !provider.limiter.Allow() {
+			continue
+		}
+		company, err := provider.Lookup(ctx, cnpj)
+		if err == nil {
+			if body, err := json.Marshal(company); err == nil {
+				_ = cnpjCache.Set(ctx, cnpj, body)
+			}
+			return company, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ErrTimeout
+		}
+		if !errors.Is(err, ErrCnpjNotFound) {
+			slog.WarnContext(ctx, "cnpj lookup failed", "provider", provider.Name(), "error", err)
+		}
+		errs = append(errs, &cep.ProviderError{Provider: provider.Name(), Err: err})
+	}
+
+	switch {
+	case len(errs) == 0:
+		return nil, ErrNoProviders
+	case errors.Is(errors.Join(errs...), ErrCnpjNotFound):
+		// a provider knowing the CNPJ does not exist outweighs the others
+		// failing
+		return nil, ErrCnpjNotFound
+	default:
+		return nil, errors.Join(errs...)
+	}
+}
+
+// CnpjHandler serves GET /cnpj/{cnpj}.
+func CnpjHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSONError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	company, err := LookupCnpj(r.Context(), r.PathValue("cnpj"))
+	if err != nil {
+		writeLookupError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, company)
+}
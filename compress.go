@@ -0,0 +1,188 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var compressionConfig CompressionConfig
+
+// compress encodes the responses of clients accepting gzip or deflate,
+// once they reach the configured size: smaller ones gain too little to
+// be worth it. Already encoded and streamed bodies are left alone.
+func compress(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !compressionConfig.Enabled || r.Header.Get("Upgrade") != "" {
+			next(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" {
+			next(w, r)
+			return
+		}
+
+		writer := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer writer.Close()
+		next(writer, r)
+	}
+}
+
+// negotiateEncoding picks gzip or deflate, whichever Accept-Encoding
+// prefers, gzip on ties. It is empty when neither is acceptable.
+func negotiateEncoding(accept string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "*" {
+			name = "gzip"
+		}
+		if (name != "gzip" && name != "deflate") || q <= 0 {
+			continue
+		}
+		if q > bestQ || (q == bestQ && name == "gzip") {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// compressible reports whether a response of these headers is text worth
+// compressing. Event streams are not: every event must reach the client
+// as soon as it is flushed.
+func compressible(h http.Header) bool {
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	switch {
+	case mediaType == "text/event-stream":
+		return false
+	case strings.HasPrefix(mediaType, "text/"):
+		return true
+	default:
+		return strings.HasSuffix(mediaType, "json") || strings.HasSuffix(mediaType, "xml")
+	}
+}
+
+// compressWriter holds the status and the first bytes of the body until
+// they reach the minimum size, then commits to compressing them or not.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+
+	status  int
+	buf     []byte
+	decided bool
+	encoder io.WriteCloser
+}
+
+func (c *compressWriter) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+	}
+}
+
+func (c *compressWriter) Write(b []byte) (int, error) {
+	if c.decided {
+		if c.encoder != nil {
+			return c.encoder.Write(b)
+		}
+		return c.ResponseWriter.Write(b)
+	}
+	if !compressible(c.Header()) {
+		err := c.decide(false)
+		if err != nil {
+			return 0, err
+		}
+		return c.ResponseWriter.Write(b)
+	}
+
+	c.buf = append(c.buf, b...)
+	if len(c.buf) >= compressionConfig.MinSize {
+		err := c.decide(true)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// decide writes the held status, with the encoding headers when
+// compressing, and the buffered body.
+func (c *compressWriter) decide(compressing bool) error {
+	c.decided = true
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	if compressing {
+		c.Header().Set("Content-Encoding", c.encoding)
+		c.Header().Del("Content-Length")
+		if c.encoding == "gzip" {
+			c.encoder, _ = gzip.NewWriterLevel(c.ResponseWriter, compressionConfig.Level)
+		} else {
+			c.encoder, _ = zlib.NewWriterLevel(c.ResponseWriter, compressionConfig.Level)
+		}
+	}
+	c.ResponseWriter.WriteHeader(c.status)
+
+	buf := c.buf
+	c.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if c.encoder != nil {
+		_, err = c.encoder.Write(buf)
+	} else {
+		_, err = c.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// Flush sends what was written so far, compressed if the body is
+// compressible whatever its size, since more is coming.
+func (c *compressWriter) Flush() {
+	if !c.decided {
+		c.decide(compressible(c.Header()))
+	}
+	if flusher, ok := c.encoder.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+	http.NewResponseController(c.ResponseWriter).Flush()
+}
+
+// Close writes a body left under the minimum size as is, or ends the
+// compressed stream.
+func (c *compressWriter) Close() error {
+	if !c.decided {
+		if c.status == 0 && len(c.buf) == 0 {
+			// nothing was written at all
+			return nil
+		}
+		return c.decide(false)
+	}
+	if c.encoder != nil {
+		return c.encoder.Close()
+	}
+	return nil
+}
+
+func (c *compressWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
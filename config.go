@@ -0,0 +1,1596 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the effective configuration. It is built from the defaults,
+// then the optional YAML file, then the environment and finally the
+// command-line flags, each overriding the previous one.
+type Config struct {
+	// Listen is a comma separated list of host:port addresses and
+	// unix:/path/to.sock sockets.
+	Listen    string        `yaml:"listen"`
+	Timeout   time.Duration `yaml:"timeout"`
+	LogLevel  string        `yaml:"log_level"`
+	LogFormat string        `yaml:"log_format"`
+	AccessLog string        `yaml:"access_log"`
+	Strategy  string        `yaml:"strategy"`
+	Quorum    int           `yaml:"quorum"`
+	// HedgeDelay is how long the hedged strategy waits on a provider
+	// before also asking the next one.
+	HedgeDelay time.Duration `yaml:"hedge_delay"`
+	// MaxTimeout caps the deadline a request asks for with ?timeout_ms=.
+	MaxTimeout time.Duration `yaml:"max_timeout"`
+
+	// GRPCListen enables the gRPC API on its own addresses when set, in
+	// the format of Listen.
+	GRPCListen string `yaml:"grpc_listen"`
+	// DebugAddr serves pprof and expvar on their own addresses when set,
+	// in the format of Listen.
+	DebugAddr string `yaml:"debug_addr"`
+	// ShutdownTimeout is how long requests in flight get to finish once
+	// SIGINT or SIGTERM is received.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// ReloadInterval is how often the config file is checked for changes,
+	// never when zero. SIGHUP reloads it regardless.
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+
+	// Providers lists the enabled providers in priority order.
+	Providers        []string                  `yaml:"providers"`
+	ProviderSettings map[string]ProviderConfig `yaml:"provider_settings,omitempty"`
+	AdaptiveTimeout  AdaptiveTimeoutConfig     `yaml:"adaptive_timeout"`
+	Retry            RetryConfig               `yaml:"retry"`
+	CircuitBreaker   CircuitBreakerConfig      `yaml:"circuit_breaker"`
+	HealthCheck      HealthCheckConfig         `yaml:"health_check"`
+	HTTPClient       HTTPClientConfig          `yaml:"http_client"`
+	RateLimit        RateLimitConfig           `yaml:"rate_limit"`
+	ClientRateLimit  ClientRateLimitConfig     `yaml:"client_rate_limit"`
+	Outbound         OutboundConfig            `yaml:"outbound"`
+	Canary           CanaryConfig              `yaml:"canary"`
+
+	Tracing      TracingConfig      `yaml:"tracing"`
+	SLO          SLOConfig          `yaml:"slo"`
+	Geocoder     GeocoderConfig     `yaml:"geocoder"`
+	Offline      OfflineConfig      `yaml:"offline"`
+	Zones        ZonesConfig        `yaml:"zones"`
+	Mock         MockConfig         `yaml:"mock"`
+	Recording    RecordingConfig    `yaml:"recording"`
+	History      HistoryConfig      `yaml:"history"`
+	Quality      QualityConfig      `yaml:"quality"`
+	Autocomplete AutocompleteConfig `yaml:"autocomplete"`
+	Events       EventsConfig       `yaml:"events"`
+	Cnpj         CnpjConfig         `yaml:"cnpj"`
+	Modules      ModulesConfig      `yaml:"modules"`
+
+	Cache        CacheConfig        `yaml:"cache"`
+	Warmup       WarmupConfig       `yaml:"warmup"`
+	Revalidation RevalidationConfig `yaml:"revalidation"`
+	Batch        BatchConfig        `yaml:"batch"`
+	Jobs         JobsConfig         `yaml:"jobs"`
+
+	Webhook   WebhookConfig   `yaml:"webhook"`
+	WebSocket WebSocketConfig `yaml:"websocket"`
+
+	Auth        AuthConfig        `yaml:"auth"`
+	CORS        CORSConfig        `yaml:"cors"`
+	Compression CompressionConfig `yaml:"compression"`
+	Signing     SigningConfig     `yaml:"signing"`
+	TLS         TLSConfig         `yaml:"tls"`
+	Admin       AdminConfig       `yaml:"admin"`
+}
+
+type ProviderConfig struct {
+	Timeout   time.Duration    `yaml:"timeout,omitempty"`
+	Retry     *RetryConfig     `yaml:"retry,omitempty"`
+	RateLimit *RateLimitConfig `yaml:"rate_limit,omitempty"`
+	URL       string           `yaml:"url,omitempty"`
+	Username  string           `yaml:"username,omitempty"`
+	Password  string           `yaml:"password,omitempty"`
+	// Mirrors are base URLs serving the same API as URL, the requests
+	// under which go to the next mirror when they fail to connect.
+	Mirrors []string `yaml:"mirrors,omitempty"`
+	// Proxy overrides http_client.proxy for this provider.
+	Proxy string `yaml:"proxy,omitempty"`
+	// Weight is the provider's share of the lookups of the weighted and
+	// adaptive strategies, 1 when unset.
+	Weight float64 `yaml:"weight,omitempty"`
+	// DailyCap is the upstream calls the provider may take a day, retries
+	// and hedges included, past which it sits out of the lookups until
+	// midnight UTC; 0 is no cap.
+	DailyCap int `yaml:"daily_cap,omitempty"`
+	// Mock overrides mock's latency and failure rate for this provider.
+	Mock *MockBehavior `yaml:"mock,omitempty"`
+	// Options are handed as they are to the factory of a custom provider.
+	Options map[string]string `yaml:"options,omitempty"`
+	// Headers are added to every request to the provider, and Token as a
+	// bearer Authorization header; Username and Password go as basic auth
+	// when there is no Token.
+	Headers map[string]string `yaml:"headers,omitempty"`
+	Token   string            `yaml:"token,omitempty"`
+}
+
+// AdaptiveTimeoutConfig derives each provider's deadline from the given
+// percentile of its last Window latencies times Multiplier, never below
+// Min. Until MinSamples are collected the static timeouts apply.
+type AdaptiveTimeoutConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	Percentile float64       `yaml:"percentile"`
+	Multiplier float64       `yaml:"multiplier"`
+	Min        time.Duration `yaml:"min"`
+	Window     int           `yaml:"window"`
+	MinSamples int           `yaml:"min_samples"`
+}
+
+// RetryConfig retries a provider up to Attempts more times on connection
+// errors and the listed status codes.
+type RetryConfig struct {
+	Attempts  int           `yaml:"attempts"`
+	BaseDelay time.Duration `yaml:"base_delay"`
+	MaxDelay  time.Duration `yaml:"max_delay"`
+	Jitter    float64       `yaml:"jitter"`
+	Statuses  []int         `yaml:"statuses,flow"`
+}
+
+func (r RetryConfig) validate() error {
+	if r.Attempts < 0 || r.BaseDelay < 0 || r.MaxDelay < 0 {
+		return errors.New("attempts, base_delay and max_delay must not be negative")
+	}
+	if r.Jitter < 0 || r.Jitter > 1 {
+		return errors.New("jitter must be between 0 and 1")
+	}
+	return nil
+}
+
+// CircuitBreakerConfig opens a provider's breaker after FailureThreshold
+// consecutive failures for OpenDuration, then closes it once
+// HalfOpenProbes lookups in a row succeed.
+type CircuitBreakerConfig struct {
+	Enabled          bool          `yaml:"enabled"`
+	FailureThreshold int           `yaml:"failure_threshold"`
+	OpenDuration     time.Duration `yaml:"open_duration"`
+	HalfOpenProbes   int           `yaml:"half_open_probes"`
+}
+
+// HealthCheckConfig probes every provider with a known CEP each Interval.
+// A provider is left out of the races after UnhealthyThreshold failed
+// probes in a row and let back in after HealthyThreshold successful ones.
+type HealthCheckConfig struct {
+	Enabled            bool          `yaml:"enabled"`
+	Interval           time.Duration `yaml:"interval"`
+	Timeout            time.Duration `yaml:"timeout"`
+	Cep                string        `yaml:"cep"`
+	UnhealthyThreshold int           `yaml:"unhealthy_threshold"`
+	HealthyThreshold   int           `yaml:"healthy_threshold"`
+}
+
+// RateLimitConfig is a token bucket allowing RPS calls per second with
+// bursts of up to Burst. A zero RPS disables the limit.
+type RateLimitConfig struct {
+	RPS   float64 `yaml:"rps"`
+	Burst int     `yaml:"burst"`
+}
+
+// ClientRateLimitConfig limits the requests of each client, identified by
+// its API key when auth is enabled and by its IP otherwise, to RPS per
+// second with bursts of up to Burst. A zero RPS disables the limit.
+// Clients from the Bypass IPs or CIDRs are never limited.
+type ClientRateLimitConfig struct {
+	RPS    float64  `yaml:"rps"`
+	Burst  int      `yaml:"burst"`
+	Bypass []string `yaml:"bypass,omitempty"`
+}
+
+// OutboundConfig caps the provider calls in flight across every lookup
+// at MaxInFlight, zero for no cap. Past it, up to MaxQueue calls wait for
+// a slot for at most QueueTimeout, and lookups beyond that are answered
+// 503 at once. Budget caps the upstream calls of a single inbound request,
+// retries, hedges and enrichments included, zero for no cap.
+type OutboundConfig struct {
+	MaxInFlight  int           `yaml:"max_in_flight"`
+	MaxQueue     int           `yaml:"max_queue"`
+	QueueTimeout time.Duration `yaml:"queue_timeout"`
+	Budget       int           `yaml:"budget"`
+}
+
+// HTTPClientConfig tunes the transport shared by the providers.
+type HTTPClientConfig struct {
+	MaxIdleConns          int           `yaml:"max_idle_conns"`
+	MaxIdleConnsPerHost   int           `yaml:"max_idle_conns_per_host"`
+	MaxConnsPerHost       int           `yaml:"max_conns_per_host"`
+	IdleConnTimeout       time.Duration `yaml:"idle_conn_timeout"`
+	DialTimeout           time.Duration `yaml:"dial_timeout"`
+	KeepAlive             time.Duration `yaml:"keep_alive"`
+	TLSHandshakeTimeout   time.Duration `yaml:"tls_handshake_timeout"`
+	ExpectContinueTimeout time.Duration `yaml:"expect_continue_timeout"`
+	DisableKeepAlives     bool          `yaml:"disable_keep_alives"`
+	HTTP2                 bool          `yaml:"http2"`
+	// MaxBodySize bounds the provider responses read, in bytes.
+	MaxBodySize int64 `yaml:"max_body_size"`
+	// Proxy is the URL of the outbound proxy, "direct" for none. When
+	// empty, HTTP_PROXY, HTTPS_PROXY and NO_PROXY are honored.
+	Proxy string `yaml:"proxy,omitempty"`
+	// CAFiles are PEM certificates trusted on top of the system roots.
+	CAFiles []string `yaml:"ca_files,omitempty"`
+	// DNSCacheTTL is how long the addresses of an upstream host are
+	// dialed before it is resolved again, zero for the resolver's own
+	// caching.
+	DNSCacheTTL time.Duration `yaml:"dns_cache_ttl"`
+}
+
+// TracingConfig exports spans over OTLP/HTTP. An empty Endpoint defers to
+// the standard OTEL_EXPORTER_OTLP_* variables.
+type TracingConfig struct {
+	Enabled     bool    `yaml:"enabled"`
+	Endpoint    string  `yaml:"endpoint,omitempty"`
+	Insecure    bool    `yaml:"insecure"`
+	ServiceName string  `yaml:"service_name"`
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+// GeocoderConfig picks the geocoder behind ?enrich=geo: brasilapi or
+// nominatim. URL overrides its endpoint and UserAgent identifies the
+// service to Nominatim, as its usage policy requires.
+type GeocoderConfig struct {
+	Provider  string `yaml:"provider"`
+	URL       string `yaml:"url,omitempty"`
+	UserAgent string `yaml:"user_agent"`
+}
+
+// OfflineConfig enables answering from a local dataset of CEP ranges when
+// every provider fails. Dataset is a CSV (optionally gzipped) replacing
+// the embedded one.
+type OfflineConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Dataset string `yaml:"dataset,omitempty"`
+}
+
+// ZonesConfig classifies CEPs into shipping zones for GET /zone. File is a
+// CSV of start,end,zone rows (optionally gzipped) replacing the embedded
+// capital, interior and remote zones, and Ranges are added over either.
+// The CEPs no range holds are in Default, or in no zone when it is empty.
+type ZonesConfig struct {
+	Enabled bool        `yaml:"enabled"`
+	File    string      `yaml:"file,omitempty"`
+	Ranges  []ZoneRange `yaml:"ranges,omitempty"`
+	Default string      `yaml:"default,omitempty"`
+}
+
+// MockConfig makes the providers answer from fixtures instead of their
+// upstreams, for tests and demos. Fixtures is a directory of <cep>.json
+// addresses, with a subdirectory per provider overriding them; the
+// embedded fixtures are used when it is empty.
+type MockConfig struct {
+	Enabled      bool   `yaml:"enabled"`
+	Fixtures     string `yaml:"fixtures,omitempty"`
+	MockBehavior `yaml:",inline"`
+}
+
+// MockBehavior is how a mock provider answers: after Latency plus a random
+// share of Jitter, failing FailureRate of the lookups with a 503.
+type MockBehavior struct {
+	Latency     time.Duration `yaml:"latency"`
+	Jitter      time.Duration `yaml:"jitter"`
+	FailureRate float64       `yaml:"failure_rate"`
+}
+
+func (b MockBehavior) validate() error {
+	if b.Latency < 0 || b.Jitter < 0 {
+		return errors.New("latency and jitter must not be negative")
+	}
+	if b.FailureRate < 0 || b.FailureRate > 1 {
+		return errors.New("failure_rate must be between 0 and 1")
+	}
+	return nil
+}
+
+// AutocompleteConfig keeps up to MaxEntries answered CEPs in memory for
+// prefix queries, seeded from the history when it is enabled. 0 disables
+// it.
+type AutocompleteConfig struct {
+	MaxEntries int `yaml:"max_entries"`
+}
+
+// CnpjConfig sets up GET /cnpj/{cnpj}, answered by Providers in priority
+// order, brasilapi and receitaws.
+type CnpjConfig struct {
+	Providers        []string                      `yaml:"providers"`
+	ProviderSettings map[string]CnpjProviderConfig `yaml:"provider_settings,omitempty"`
+}
+
+// CnpjProviderConfig overrides the URL of a CNPJ provider and limits how
+// often it is called. Token is ReceitaWS's, for its paid plans.
+type CnpjProviderConfig struct {
+	URL       string           `yaml:"url,omitempty"`
+	Token     string           `yaml:"token,omitempty"`
+	RateLimit *RateLimitConfig `yaml:"rate_limit,omitempty"`
+}
+
+var cnpjProviderNames = []string{"brasilapi", "receitaws"}
+
+// WarmupConfig preloads the cache at startup, and every Interval when set,
+// with Ceps, the CEPs of File, one per line, and the Top CEPs looked up in
+// the history over the last Window. Lookups are paced to RPS a second.
+type WarmupConfig struct {
+	Ceps     []string      `yaml:"ceps,omitempty"`
+	File     string        `yaml:"file,omitempty"`
+	Top      int           `yaml:"top"`
+	Window   time.Duration `yaml:"window"`
+	Interval time.Duration `yaml:"interval"`
+	RPS      float64       `yaml:"rps"`
+}
+
+func (w WarmupConfig) enabled() bool {
+	return len(w.Ceps) > 0 || w.File != "" || w.Top > 0
+}
+
+// RevalidationConfig looks the CEPs of the history up again every
+// Interval, never when zero: Ceps, the CEPs of File and the Top found over
+// the last Window, paced to RPS a second as in the warm-up. The addresses
+// changed since stored go to the change log of the history and, when set,
+// are POSTed to WebhookURL and, with Events, published to the event bus.
+type RevalidationConfig struct {
+	Interval   time.Duration `yaml:"interval"`
+	Ceps       []string      `yaml:"ceps,omitempty"`
+	File       string        `yaml:"file,omitempty"`
+	Top        int           `yaml:"top"`
+	Window     time.Duration `yaml:"window"`
+	RPS        float64       `yaml:"rps"`
+	WebhookURL string        `yaml:"webhook_url,omitempty"`
+	Events     bool          `yaml:"events"`
+}
+
+// ModulesConfig enables the endpoints serving BrasilAPI's reference
+// datasets other than CEPs, off by default.
+type ModulesConfig struct {
+	Banks ModuleConfig `yaml:"banks"`
+	Fipe  ModuleConfig `yaml:"fipe"`
+}
+
+// ModuleConfig enables a reference module. URL overrides the base URL of
+// its upstream.
+type ModuleConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	URL     string `yaml:"url,omitempty"`
+}
+
+// RecordingConfig saves the responses of the providers to Dir, as
+// <cep>/<provider>.json, in record mode, and answers from them instead of
+// the upstreams in replay mode, for deterministic regression tests.
+type RecordingConfig struct {
+	Mode string `yaml:"mode,omitempty"`
+	Dir  string `yaml:"dir"`
+}
+
+// HistoryConfig records every lookup to a database, sqlite or postgres,
+// when Driver is set. DSN is the file name for sqlite and the connection
+// string for postgres.
+type HistoryConfig struct {
+	Driver string `yaml:"driver,omitempty"`
+	DSN    string `yaml:"dsn,omitempty"`
+}
+
+// QualityConfig compares every provider's answer for a SampleRate share of
+// the lookups in the background, recording the fields they disagree on to
+// the history for GET /quality/report.
+type QualityConfig struct {
+	SampleRate float64 `yaml:"sample_rate"`
+}
+
+// CanaryConfig evaluates Providers in the shadow of the race: they are
+// asked for a SampleRate share of the lookups and their answers compared
+// with the race's for GET /canary/report, but never returned. Their
+// settings are those of provider_settings, like any provider's.
+type CanaryConfig struct {
+	Providers  []string `yaml:"providers"`
+	SampleRate float64  `yaml:"sample_rate"`
+}
+
+// SLOConfig tracks the Objectives of the routes over each of Windows, an
+// objective alerting while all of them burn its error budget at
+// AlertBurnRate or faster.
+type SLOConfig struct {
+	Objectives    []SLOObjective  `yaml:"objectives"`
+	Windows       []time.Duration `yaml:"windows,flow"`
+	AlertBurnRate float64         `yaml:"alert_burn_rate"`
+}
+
+// SLOObjective promises that Target of the requests to Route, a route
+// label of the metrics, are answered within Latency without a 5xx.
+type SLOObjective struct {
+	Route   string        `yaml:"route"`
+	Target  float64       `yaml:"target"`
+	Latency time.Duration `yaml:"latency"`
+}
+
+// EventsConfig publishes every lookup to Topic on an event bus, nats or
+// kafka, when Backend is set. URL is the NATS server URL or the comma
+// separated Kafka brokers.
+type EventsConfig struct {
+	Backend string `yaml:"backend,omitempty"`
+	URL     string `yaml:"url,omitempty"`
+	Topic   string `yaml:"topic"`
+}
+
+// CacheConfig stores lookups for TTL. With a StaleWindow, expired entries
+// are still served for that long while a refresh runs in the background.
+// MaxAge is how long clients and CDNs may cache a lookup response.
+// NotFoundTTL is how long a CEP no provider knows is answered as not found
+// without asking them again, 0 to always ask.
+type CacheConfig struct {
+	Backend     string        `yaml:"backend"`
+	Size        int           `yaml:"size"`
+	TTL         time.Duration `yaml:"ttl"`
+	StaleWindow time.Duration `yaml:"stale_window"`
+	NotFoundTTL time.Duration `yaml:"not_found_ttl"`
+	MaxAge      time.Duration `yaml:"max_age"`
+	Redis       RedisConfig   `yaml:"redis"`
+	Bolt        BoltConfig    `yaml:"bolt"`
+}
+
+// BoltConfig keeps the cache in the bbolt file at Path, holding up to the
+// cache size entries, which survives restarts. Every CompactInterval, the
+// expired entries are dropped and the file shrunk; 0 never compacts it.
+type BoltConfig struct {
+	Path            string        `yaml:"path"`
+	CompactInterval time.Duration `yaml:"compact_interval"`
+}
+
+type RedisConfig struct {
+	Addr     string `yaml:"addr"`
+	Password string `yaml:"password,omitempty"`
+	DB       int    `yaml:"db"`
+}
+
+type BatchConfig struct {
+	Max         int `yaml:"max"`
+	Concurrency int `yaml:"concurrency"`
+}
+
+type JobsConfig struct {
+	Workers       int           `yaml:"workers"`
+	ProviderRPS   float64       `yaml:"provider_rps"`
+	ProviderBurst int           `yaml:"provider_burst"`
+	Retention     time.Duration `yaml:"retention"`
+	MaxUpload     int64         `yaml:"max_upload"`
+}
+
+// WebhookConfig governs the callbacks of POST /lookup/async. Bodies are
+// signed with Secret when set, each delivery attempt gets Timeout, and
+// callbacks may only go to AllowedHosts when the list is not empty. Only
+// AllowedHosts may resolve to addresses that are not public.
+type WebhookConfig struct {
+	Secret       string        `yaml:"secret,omitempty"`
+	Timeout      time.Duration `yaml:"timeout"`
+	Attempts     int           `yaml:"attempts"`
+	AllowedHosts []string      `yaml:"allowed_hosts,omitempty"`
+}
+
+// WebSocketConfig bounds the lookups each /ws connection runs at once and
+// the size of the messages it accepts.
+type WebSocketConfig struct {
+	Concurrency int   `yaml:"concurrency"`
+	MaxMessage  int64 `yaml:"max_message"`
+}
+
+// AuthConfig requires an X-API-Key on the lookup endpoints once any key
+// is listed in Keys or in KeysFile, a YAML list of keys. DailyQuota,
+// MonthlyQuota, RPS and Burst apply to the keys that do not set their own;
+// zero leaves the quota or the rate unlimited.
+type AuthConfig struct {
+	Keys         []APIKeyConfig `yaml:"keys,omitempty"`
+	KeysFile     string         `yaml:"keys_file,omitempty"`
+	DailyQuota   int            `yaml:"daily_quota"`
+	MonthlyQuota int            `yaml:"monthly_quota"`
+	RPS          float64        `yaml:"rps"`
+	Burst        int            `yaml:"burst"`
+}
+
+// APIKeyConfig is a client's key. Name identifies the client in the logs
+// without exposing the key.
+type APIKeyConfig struct {
+	Name       string `yaml:"name"`
+	Key        string `yaml:"key"`
+	DailyQuota int    `yaml:"daily_quota,omitempty"`
+	// MonthlyQuota caps the CEPs looked up in a calendar month (UTC).
+	MonthlyQuota int     `yaml:"monthly_quota,omitempty"`
+	RPS          float64 `yaml:"rps,omitempty"`
+	Burst        int     `yaml:"burst,omitempty"`
+}
+
+// CORSConfig lets browser apps on AllowedOrigins ("*" for any) call the
+// lookup endpoints. Preflights are answered with the allowed methods and
+// headers, cached by the browser for MaxAge.
+type CORSConfig struct {
+	AllowedOrigins []string      `yaml:"allowed_origins,omitempty"`
+	AllowedMethods []string      `yaml:"allowed_methods,flow"`
+	AllowedHeaders []string      `yaml:"allowed_headers,flow"`
+	MaxAge         time.Duration `yaml:"max_age"`
+}
+
+// CompressionConfig gzips or deflates, at Level, the responses of at
+// least MinSize bytes sent to clients accepting it.
+type CompressionConfig struct {
+	Enabled bool `yaml:"enabled"`
+	MinSize int  `yaml:"min_size"`
+	Level   int  `yaml:"level"`
+}
+
+// TLSConfig serves HTTPS, and gRPC over TLS, with the certificate in
+// CertFile and KeyFile, re-read every ReloadInterval they have changed
+// (never when zero). ClientAuth request or require verifies the client
+// certificates against the CAs in ClientCAFile, for mutual TLS.
+type TLSConfig struct {
+	CertFile       string        `yaml:"cert_file,omitempty"`
+	KeyFile        string        `yaml:"key_file,omitempty"`
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+	MinVersion     string        `yaml:"min_version"`
+	ClientAuth     string        `yaml:"client_auth"`
+	ClientCAFile   string        `yaml:"client_ca_file,omitempty"`
+}
+
+// SigningConfig signs the response bodies and the webhook payloads with
+// Secret when set, so that consumers can tell they were not tampered with.
+type SigningConfig struct {
+	Secret string `yaml:"secret,omitempty"`
+}
+
+// AdminConfig enables /admin for the requests bearing Token.
+type AdminConfig struct {
+	Token string `yaml:"token,omitempty"`
+}
+
+var logLevels = []string{"debug", "info", "warn", "error"}
+
+func DefaultConfig() Config {
+	return Config{
+		Listen:           ":8080",
+		Timeout:          1 * time.Second,
+		MaxTimeout:       10 * time.Second,
+		ShutdownTimeout:  10 * time.Second,
+		LogLevel:         "info",
+		LogFormat:        "console",
+		AccessLog:        "common",
+		Strategy:         "fastest",
+		Quorum:           2,
+		HedgeDelay:       200 * time.Millisecond,
+		Providers:        []string{"viacep", "brasilapi", "opencep", "apicep"},
+		ProviderSettings: map[string]ProviderConfig{},
+		AdaptiveTimeout: AdaptiveTimeoutConfig{
+			Percentile: 0.95,
+			Multiplier: 1.5,
+			Min:        100 * time.Millisecond,
+			Window:     100,
+			MinSamples: 20,
+		},
+		Retry: RetryConfig{
+			Attempts:  1,
+			BaseDelay: 50 * time.Millisecond,
+			MaxDelay:  400 * time.Millisecond,
+			Jitter:    0.2,
+			Statuses:  []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout},
+		},
+		CircuitBreaker: CircuitBreakerConfig{
+			Enabled:          true,
+			FailureThreshold: 5,
+			OpenDuration:     30 * time.Second,
+			HalfOpenProbes:   1,
+		},
+		HealthCheck: HealthCheckConfig{
+			Interval:           30 * time.Second,
+			Timeout:            2 * time.Second,
+			Cep:                "01001000",
+			UnhealthyThreshold: 3,
+			HealthyThreshold:   2,
+		},
+		HTTPClient: HTTPClientConfig{
+			MaxIdleConns:          100,
+			MaxIdleConnsPerHost:   32,
+			IdleConnTimeout:       90 * time.Second,
+			DialTimeout:           500 * time.Millisecond,
+			KeepAlive:             30 * time.Second,
+			TLSHandshakeTimeout:   500 * time.Millisecond,
+			ExpectContinueTimeout: time.Second,
+			HTTP2:                 true,
+			MaxBodySize:           64 << 10,
+		},
+		RateLimit:       RateLimitConfig{RPS: 0, Burst: 10},
+		ClientRateLimit: ClientRateLimitConfig{RPS: 0, Burst: 20},
+		Outbound:        OutboundConfig{MaxQueue: 100, QueueTimeout: 100 * time.Millisecond},
+		Canary:          CanaryConfig{SampleRate: 0.1},
+		SLO: SLOConfig{
+			Objectives:    []SLOObjective{{Route: "/", Target: 0.99, Latency: 300 * time.Millisecond}},
+			Windows:       []time.Duration{5 * time.Minute, time.Hour},
+			AlertBurnRate: 14.4,
+		},
+		Tracing: TracingConfig{
+			Insecure:    true,
+			ServiceName: "multi",
+			SampleRatio: 1,
+		},
+		Geocoder: GeocoderConfig{
+			Provider:  "brasilapi",
+			UserAgent: "multi (https://github.com/liberopassadorneto/multi)",
+		},
+		Recording:    RecordingConfig{Dir: "testdata/recordings"},
+		Autocomplete: AutocompleteConfig{MaxEntries: 100000},
+		Cnpj: CnpjConfig{
+			Providers: []string{"brasilapi", "receitaws"},
+			// the free plan of ReceitaWS allows 3 lookups a minute
+			ProviderSettings: map[string]CnpjProviderConfig{
+				"receitaws": {RateLimit: &RateLimitConfig{RPS: 0.05, Burst: 3}},
+			},
+		},
+		Cache: CacheConfig{
+			Backend:     "memory",
+			Size:        10000,
+			TTL:         24 * time.Hour,
+			NotFoundTTL: 10 * time.Minute,
+			MaxAge:      time.Hour,
+			Redis:       RedisConfig{Addr: "localhost:6379"},
+			Bolt:        BoltConfig{Path: "multi-cache.db", CompactInterval: 24 * time.Hour},
+		},
+		Warmup:       WarmupConfig{Window: 7 * 24 * time.Hour, RPS: 5},
+		Revalidation: RevalidationConfig{Window: 7 * 24 * time.Hour, RPS: 1},
+		Batch:        BatchConfig{Max: 100, Concurrency: 10},
+		Jobs: JobsConfig{
+			Workers:       4,
+			ProviderRPS:   5,
+			ProviderBurst: 5,
+			Retention:     time.Hour,
+			MaxUpload:     10 << 20,
+		},
+		Events:    EventsConfig{Topic: "multi.lookups"},
+		Webhook:   WebhookConfig{Timeout: 5 * time.Second, Attempts: 3},
+		WebSocket: WebSocketConfig{Concurrency: 8, MaxMessage: 4 << 10},
+		Auth:      AuthConfig{Burst: 10},
+		CORS: CORSConfig{
+			AllowedMethods: []string{http.MethodGet, http.MethodPost},
+			AllowedHeaders: []string{"Content-Type", "X-API-Key", "X-Request-ID", "traceparent", "Last-Event-ID"},
+			MaxAge:         10 * time.Minute,
+		},
+		Compression: CompressionConfig{Enabled: true, MinSize: 1 << 10, Level: gzip.DefaultCompression},
+		TLS:         TLSConfig{ReloadInterval: time.Minute, MinVersion: "1.2", ClientAuth: "none"},
+	}
+}
+
+// Provider returns the settings of the named provider, which are empty
+// when it has none.
+func (c Config) Provider(name string) ProviderConfig {
+	return c.ProviderSettings[name]
+}
+
+// ProviderRateLimit returns the outbound rate limit of the named
+// provider, falling back to the global one.
+func (c Config) ProviderRateLimit(name string) RateLimitConfig {
+	if limit := c.Provider(name).RateLimit; limit != nil {
+		return *limit
+	}
+	return c.RateLimit
+}
+
+// ProviderRetry returns the retry policy of the named provider, falling
+// back to the global one.
+func (c Config) ProviderRetry(name string) RetryConfig {
+	if retry := c.Provider(name).Retry; retry != nil {
+		return *retry
+	}
+	return c.Retry
+}
+
+// ProviderMock returns how the named provider answers in mock mode,
+// falling back to the global behavior.
+func (c Config) ProviderMock(name string) MockBehavior {
+	if mock := c.Provider(name).Mock; mock != nil {
+		return *mock
+	}
+	return c.Mock.MockBehavior
+}
+
+func (c Config) Validate() error {
+	var errs []error
+	if err := validateListen("listen", c.Listen); err != nil {
+		errs = append(errs, err)
+	}
+	if c.GRPCListen != "" {
+		if err := validateListen("grpc_listen", c.GRPCListen); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.DebugAddr != "" {
+		if err := validateListen("debug_addr", c.DebugAddr); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.Timeout <= 0 {
+		errs = append(errs, errors.New("timeout must be positive"))
+	}
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("shutdown_timeout must be positive"))
+	}
+	if c.ReloadInterval < 0 {
+		errs = append(errs, errors.New("reload_interval must not be negative"))
+	}
+	if !slices.Contains(logLevels, c.LogLevel) {
+		errs = append(errs, fmt.Errorf("log_level must be one of %s", strings.Join(logLevels, ", ")))
+	}
+	if c.LogFormat != "console" && c.LogFormat != "json" {
+		errs = append(errs, errors.New("log_format must be console or json"))
+	}
+	if !slices.Contains([]string{"common", "json", "off"}, c.AccessLog) {
+		errs = append(errs, errors.New("access_log must be common, json or off"))
+	}
+	if _, ok := allStrategies(c)[c.Strategy]; !ok {
+		errs = append(errs, fmt.Errorf("unknown strategy %q", c.Strategy))
+	}
+	if c.Quorum < 1 {
+		errs = append(errs, errors.New("quorum must be at least 1"))
+	} else if c.Strategy == "quorum" && c.Quorum > len(c.Providers) {
+		errs = append(errs, fmt.Errorf("quorum must be between 1 and the %d enabled providers", len(c.Providers)))
+	}
+	if c.MaxTimeout <= 0 {
+		errs = append(errs, errors.New("max_timeout must be positive"))
+	}
+	if c.HedgeDelay < 0 {
+		errs = append(errs, errors.New("hedge_delay must not be negative"))
+	}
+
+	if len(c.Providers) == 0 {
+		errs = append(errs, errors.New("at least one provider must be enabled"))
+	}
+	known := providerNames()
+	seen := make(map[string]bool)
+	for _, name := range c.Providers {
+		if !slices.Contains(known, name) {
+			errs = append(errs, fmt.Errorf("unknown provider %q", name))
+		}
+		if seen[name] {
+			errs = append(errs, fmt.Errorf("provider %q enabled twice", name))
+		}
+		seen[name] = true
+	}
+	for _, name := range c.Canary.Providers {
+		if !slices.Contains(known, name) {
+			errs = append(errs, fmt.Errorf("unknown canary provider %q", name))
+		}
+		if seen[name] {
+			errs = append(errs, fmt.Errorf("provider %q is both in the race and a canary, or a canary twice", name))
+		}
+		seen[name] = true
+	}
+	if s := c.Canary.SampleRate; s < 0 || s > 1 {
+		errs = append(errs, errors.New("canary sample_rate must be between 0 and 1"))
+	}
+	if len(c.SLO.Windows) == 0 || slices.ContainsFunc(c.SLO.Windows, func(w time.Duration) bool { return w < time.Minute }) {
+		errs = append(errs, errors.New("slo needs windows of a minute or more"))
+	}
+	if c.SLO.AlertBurnRate <= 0 {
+		errs = append(errs, errors.New("slo alert_burn_rate must be positive"))
+	}
+	sloRoutes := map[string]bool{}
+	for _, objective := range c.SLO.Objectives {
+		if objective.Route == "" || sloRoutes[objective.Route] {
+			errs = append(errs, fmt.Errorf("slo objectives need distinct routes, got %q", objective.Route))
+		}
+		sloRoutes[objective.Route] = true
+		if objective.Target <= 0 || objective.Target >= 1 || objective.Latency <= 0 {
+			errs = append(errs, fmt.Errorf("slo objective of %q needs a target between 0 and 1 and a positive latency", objective.Route))
+		}
+	}
+	for name, settings := range c.ProviderSettings {
+		if !slices.Contains(known, name) && !slices.Contains(internationalNames, name) {
+			errs = append(errs, fmt.Errorf("settings for unknown provider %q", name))
+		}
+		if settings.Timeout < 0 {
+			errs = append(errs, fmt.Errorf("provider %q timeout must not be negative", name))
+		}
+		if settings.Weight < 0 {
+			errs = append(errs, fmt.Errorf("provider %q weight must not be negative", name))
+		}
+		if settings.DailyCap < 0 {
+			errs = append(errs, fmt.Errorf("provider %q daily_cap must not be negative", name))
+		}
+		if settings.RateLimit != nil && (settings.RateLimit.RPS < 0 || settings.RateLimit.Burst < 1) {
+			errs = append(errs, fmt.Errorf("provider %q rate_limit needs a non-negative rps and a positive burst", name))
+		}
+		if settings.Retry != nil {
+			if err := settings.Retry.validate(); err != nil {
+				errs = append(errs, fmt.Errorf("provider %q retry: %w", name, err))
+			}
+		}
+		if settings.Mock != nil {
+			if err := settings.Mock.validate(); err != nil {
+				errs = append(errs, fmt.Errorf("provider %q mock: %w", name, err))
+			}
+		}
+		if _, err := proxyFunc(settings.Proxy); err != nil {
+			errs = append(errs, fmt.Errorf("provider %q: %w", name, err))
+		}
+		if len(settings.Mirrors) > 0 && settings.URL == "" {
+			errs = append(errs, fmt.Errorf("provider %q mirrors need its url", name))
+		}
+		for _, mirror := range settings.Mirrors {
+			if u, err := url.Parse(mirror); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+				errs = append(errs, fmt.Errorf("provider %q mirror %q must be an absolute http or https URL", name, mirror))
+			}
+		}
+		if settings.Token != "" && settings.Username != "" {
+			errs = append(errs, fmt.Errorf("provider %q token and username are exclusive", name))
+		}
+		for header := range settings.Headers {
+			if header == "" || strings.ContainsAny(header, " :\r\n") {
+				errs = append(errs, fmt.Errorf("provider %q header %q is not a valid header name", name, header))
+			}
+		}
+	}
+	if _, err := proxyFunc(c.HTTPClient.Proxy); err != nil {
+		errs = append(errs, fmt.Errorf("http_client: %w", err))
+	}
+
+	if a := c.AdaptiveTimeout; a.Enabled {
+		if a.Percentile <= 0 || a.Percentile > 1 {
+			errs = append(errs, errors.New("adaptive_timeout percentile must be in (0, 1]"))
+		}
+		if a.Multiplier < 1 {
+			errs = append(errs, errors.New("adaptive_timeout multiplier must be at least 1"))
+		}
+		if a.Window < 1 || a.MinSamples < 1 || a.MinSamples > a.Window {
+			errs = append(errs, errors.New("adaptive_timeout needs 1 <= min_samples <= window"))
+		}
+	}
+
+	if err := c.Retry.validate(); err != nil {
+		errs = append(errs, fmt.Errorf("retry: %w", err))
+	}
+
+	if b := c.CircuitBreaker; b.Enabled && (b.FailureThreshold < 1 || b.OpenDuration <= 0 || b.HalfOpenProbes < 1) {
+		errs = append(errs, errors.New("circuit_breaker failure_threshold, open_duration and half_open_probes must be positive"))
+	}
+
+	if h := c.HealthCheck; h.Enabled {
+		if h.Interval <= 0 || h.Timeout <= 0 || h.UnhealthyThreshold < 1 || h.HealthyThreshold < 1 {
+			errs = append(errs, errors.New("health_check interval, timeout and thresholds must be positive"))
+		}
+		if _, err := NormalizeCep(h.Cep); err != nil {
+			errs = append(errs, fmt.Errorf("health_check cep: %w", err))
+		}
+	}
+
+	if h := c.HTTPClient; h.MaxIdleConns < 0 || h.MaxIdleConnsPerHost < 0 || h.MaxConnsPerHost < 0 {
+		errs = append(errs, errors.New("http_client connection limits must not be negative"))
+	}
+	if c.HTTPClient.DNSCacheTTL < 0 {
+		errs = append(errs, errors.New("http_client.dns_cache_ttl must not be negative"))
+	}
+	if c.HTTPClient.MaxBodySize < 1 {
+		errs = append(errs, errors.New("http_client.max_body_size must be at least 1"))
+	}
+
+	if c.RateLimit.RPS < 0 || c.RateLimit.Burst < 1 {
+		errs = append(errs, errors.New("rate_limit needs a non-negative rps and a positive burst"))
+	}
+	if c.ClientRateLimit.RPS < 0 || c.ClientRateLimit.Burst < 1 {
+		errs = append(errs, errors.New("client_rate_limit needs a non-negative rps and a positive burst"))
+	}
+	if n := c.Outbound; n.MaxInFlight < 0 || n.MaxQueue < 0 || n.QueueTimeout < 0 || n.Budget < 0 {
+		errs = append(errs, errors.New("outbound max_in_flight, max_queue, queue_timeout and budget must not be negative"))
+	}
+	if _, err := parseNetworks(c.ClientRateLimit.Bypass); err != nil {
+		errs = append(errs, fmt.Errorf("client_rate_limit bypass: %w", err))
+	}
+
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, errors.New("tracing sample_ratio must be between 0 and 1"))
+	}
+
+	if c.Geocoder.Provider != "brasilapi" && c.Geocoder.Provider != "nominatim" {
+		errs = append(errs, fmt.Errorf("geocoder provider must be brasilapi or nominatim, got %q", c.Geocoder.Provider))
+	}
+
+	if c.Cache.Backend != "memory" && c.Cache.Backend != "redis" && c.Cache.Backend != "bolt" {
+		errs = append(errs, fmt.Errorf("cache backend must be memory, redis or bolt, got %q", c.Cache.Backend))
+	}
+	if c.Cache.Backend == "bolt" && c.Cache.Bolt.Path == "" {
+		errs = append(errs, errors.New("cache bolt path must not be empty"))
+	}
+	if c.Cache.Bolt.CompactInterval < 0 {
+		errs = append(errs, errors.New("cache bolt compact_interval must not be negative"))
+	}
+	if c.Cache.TTL <= 0 {
+		errs = append(errs, errors.New("cache ttl must be positive"))
+	}
+	if err := c.Mock.validate(); err != nil {
+		errs = append(errs, fmt.Errorf("mock: %w", err))
+	}
+	if r := c.Recording; r.Mode != "" {
+		if r.Mode != "record" && r.Mode != "replay" {
+			errs = append(errs, fmt.Errorf("recording mode must be record or replay, got %q", r.Mode))
+		}
+		if r.Dir == "" {
+			errs = append(errs, errors.New("recording dir must not be empty"))
+		}
+		if c.Mock.Enabled {
+			errs = append(errs, errors.New("recording does not apply to mock providers"))
+		}
+	}
+	if c.Mock.Enabled && c.Mock.Fixtures != "" {
+		if info, err := os.Stat(c.Mock.Fixtures); err != nil || !info.IsDir() {
+			errs = append(errs, fmt.Errorf("mock fixtures %q is not a directory", c.Mock.Fixtures))
+		}
+	}
+	if h := c.History; h.Driver != "" {
+		if h.Driver != "sqlite" && h.Driver != "postgres" {
+			errs = append(errs, fmt.Errorf("history driver must be sqlite or postgres, got %q", h.Driver))
+		}
+		if h.DSN == "" {
+			errs = append(errs, errors.New("history dsn must not be empty"))
+		}
+	}
+
+	if q := c.Quality; q.SampleRate < 0 || q.SampleRate > 1 {
+		errs = append(errs, errors.New("quality sample_rate must be between 0 and 1"))
+	} else if q.SampleRate > 0 && c.History.Driver == "" {
+		errs = append(errs, errors.New("quality sampling needs the history enabled"))
+	}
+
+	var zoneTable ZoneTable
+	for _, r := range c.Zones.Ranges {
+		if err := zoneTable.Add(r); err != nil {
+			errs = append(errs, fmt.Errorf("zones: %w", err))
+		}
+	}
+
+	if c.Autocomplete.MaxEntries < 0 {
+		errs = append(errs, errors.New("autocomplete max_entries must not be negative"))
+	}
+
+	for _, name := range c.Cnpj.Providers {
+		if !slices.Contains(cnpjProviderNames, name) {
+			errs = append(errs, fmt.Errorf("unknown cnpj provider %q", name))
+		}
+	}
+	for name, settings := range c.Cnpj.ProviderSettings {
+		if !slices.Contains(cnpjProviderNames, name) {
+			errs = append(errs, fmt.Errorf("settings for unknown cnpj provider %q", name))
+		}
+		if settings.RateLimit != nil && (settings.RateLimit.RPS < 0 || settings.RateLimit.Burst < 1) {
+			errs = append(errs, fmt.Errorf("cnpj provider %q rate_limit needs a non-negative rps and a positive burst", name))
+		}
+	}
+
+	if e := c.Events; e.Backend != "" {
+		if e.Backend != "nats" && e.Backend != "kafka" {
+			errs = append(errs, fmt.Errorf("events backend must be nats or kafka, got %q", e.Backend))
+		}
+		if e.URL == "" || e.Topic == "" {
+			errs = append(errs, errors.New("events url and topic must not be empty"))
+		}
+	}
+
+	if c.Cache.StaleWindow < 0 || c.Cache.NotFoundTTL < 0 || c.Cache.MaxAge < 0 {
+		errs = append(errs, errors.New("cache stale_window, not_found_ttl and max_age must not be negative"))
+	}
+	if c.Cache.Size < 0 {
+		errs = append(errs, errors.New("cache size must not be negative"))
+	}
+	if w := c.Warmup; w.Top < 0 || w.Window < 0 || w.Interval < 0 || w.RPS <= 0 {
+		errs = append(errs, errors.New("warmup needs a non-negative top, window and interval and a positive rps"))
+	} else if w.Top > 0 && c.History.Driver == "" {
+		errs = append(errs, errors.New("warmup top needs the history enabled"))
+	}
+	if f := c.Warmup.File; f != "" {
+		if _, err := os.Stat(f); err != nil {
+			errs = append(errs, fmt.Errorf("warmup file: %w", err))
+		}
+	}
+	if v := c.Revalidation; v.Interval < 0 || v.Top < 0 || v.Window < 0 || v.RPS <= 0 {
+		errs = append(errs, errors.New("revalidation needs a non-negative interval, top and window and a positive rps"))
+	} else if v.Interval > 0 {
+		if c.History.Driver == "" {
+			errs = append(errs, errors.New("revalidation needs the history enabled"))
+		}
+		if len(v.Ceps) == 0 && v.File == "" && v.Top == 0 {
+			errs = append(errs, errors.New("revalidation needs ceps, a file or a top"))
+		}
+		if v.File != "" {
+			if _, err := os.Stat(v.File); err != nil {
+				errs = append(errs, fmt.Errorf("revalidation file: %w", err))
+			}
+		}
+		if v.WebhookURL != "" {
+			if u, err := url.Parse(v.WebhookURL); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+				errs = append(errs, errors.New("revalidation webhook_url must be an absolute http or https URL"))
+			}
+		}
+		if v.Events && c.Events.Backend == "" {
+			errs = append(errs, errors.New("revalidation events need an events backend"))
+		}
+	}
+	if c.Batch.Max < 1 || c.Batch.Concurrency < 1 {
+		errs = append(errs, errors.New("batch max and concurrency must be positive"))
+	}
+	if c.Jobs.Workers < 1 || c.Jobs.ProviderBurst < 1 || c.Jobs.MaxUpload < 1 {
+		errs = append(errs, errors.New("jobs workers, provider_burst and max_upload must be positive"))
+	}
+	if c.Webhook.Timeout <= 0 || c.Webhook.Attempts < 1 {
+		errs = append(errs, errors.New("webhook timeout and attempts must be positive"))
+	}
+	if c.WebSocket.Concurrency < 1 || c.WebSocket.MaxMessage < 1 {
+		errs = append(errs, errors.New("websocket concurrency and max_message must be positive"))
+	}
+	if a := c.Auth; a.DailyQuota < 0 || a.MonthlyQuota < 0 || a.RPS < 0 || a.Burst < 1 {
+		errs = append(errs, errors.New("auth needs a non-negative daily_quota, monthly_quota and rps and a positive burst"))
+	}
+	if err := validateAPIKeys(c.Auth.Keys); err != nil {
+		errs = append(errs, fmt.Errorf("auth keys: %w", err))
+	}
+	if c.CORS.MaxAge < 0 {
+		errs = append(errs, errors.New("cors max_age must not be negative"))
+	}
+	if c.Compression.MinSize < 0 {
+		errs = append(errs, errors.New("compression min_size must not be negative"))
+	}
+	if c.Compression.Level < gzip.DefaultCompression || c.Compression.Level > gzip.BestCompression {
+		errs = append(errs, fmt.Errorf("compression level must be between %d and %d", gzip.DefaultCompression, gzip.BestCompression))
+	}
+	if t := c.TLS; (t.CertFile == "") != (t.KeyFile == "") {
+		errs = append(errs, errors.New("tls cert_file and key_file must be set together"))
+	}
+	if _, ok := tlsVersions[c.TLS.MinVersion]; !ok {
+		errs = append(errs, errors.New("tls min_version must be 1.2 or 1.3"))
+	}
+	if _, ok := clientAuths[c.TLS.ClientAuth]; !ok {
+		errs = append(errs, errors.New("tls client_auth must be none, request or require"))
+	}
+	if t := c.TLS; t.ClientAuth != "none" && (t.ClientCAFile == "" || t.CertFile == "") {
+		errs = append(errs, errors.New("tls client_auth needs a cert_file and a client_ca_file"))
+	}
+	if c.TLS.ReloadInterval < 0 {
+		errs = append(errs, errors.New("tls reload_interval must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+// Redacted returns a copy safe to print, with every secret masked.
+func (c Config) Redacted() Config {
+	mask := func(s string) string {
+		if s == "" {
+			return ""
+		}
+		return "******"
+	}
+
+	c.Cache.Redis.Password = mask(c.Cache.Redis.Password)
+	c.History.DSN = maskURL(c.History.DSN, mask)
+	c.Events.URL = maskURL(c.Events.URL, mask)
+	c.HTTPClient.Proxy = maskURL(c.HTTPClient.Proxy, mask)
+	c.Revalidation.WebhookURL = maskURL(c.Revalidation.WebhookURL, mask)
+	c.Webhook.Secret = mask(c.Webhook.Secret)
+	c.Signing.Secret = mask(c.Signing.Secret)
+	c.Admin.Token = mask(c.Admin.Token)
+	keys := make([]APIKeyConfig, len(c.Auth.Keys))
+	for i, key := range c.Auth.Keys {
+		key.Key = mask(key.Key)
+		keys[i] = key
+	}
+	c.Auth.Keys = keys
+	settings := make(map[string]ProviderConfig, len(c.ProviderSettings))
+	for name, provider := range c.ProviderSettings {
+		provider.Password = mask(provider.Password)
+		provider.Token = mask(provider.Token)
+		provider.URL = maskURL(provider.URL, mask)
+		provider.Proxy = maskURL(provider.Proxy, mask)
+		mirrors := make([]string, len(provider.Mirrors))
+		for i, mirror := range provider.Mirrors {
+			mirrors[i] = maskURL(mirror, mask)
+		}
+		provider.Mirrors = mirrors
+		provider.Options = maskSecrets(provider.Options, mask)
+		provider.Headers = maskSecrets(provider.Headers, mask)
+		settings[name] = provider
+	}
+	c.ProviderSettings = settings
+	cnpjSettings := make(map[string]CnpjProviderConfig, len(c.Cnpj.ProviderSettings))
+	for name, provider := range c.Cnpj.ProviderSettings {
+		provider.Token = mask(provider.Token)
+		provider.URL = maskURL(provider.URL, mask)
+		cnpjSettings[name] = provider
+	}
+	c.Cnpj.ProviderSettings = cnpjSettings
+	return c
+}
+
+// maskURL applies mask to the password in the userinfo or the query of
+// raw, a URL or a comma separated list of them, such as the Kafka brokers. Connection
+// strings in the key=value form are masked whole when they hold a
+// password.
+func maskURL(raw string, mask func(string) string) string {
+	if raw == "" {
+		return ""
+	}
+	if !strings.Contains(raw, "://") {
+		if strings.Contains(strings.ToLower(raw), "password") {
+			return mask(raw)
+		}
+		return raw
+	}
+	parts := strings.Split(raw, ",")
+	for i, part := range parts {
+		u, err := url.Parse(strings.TrimSpace(part))
+		if err != nil {
+			// unparsable, it may still hold the password
+			parts[i] = mask(part)
+			continue
+		}
+		if query := u.Query(); query.Has("password") {
+			query.Set("password", mask("x"))
+			// the mask reads better unescaped, which a query allows
+			u.RawQuery = strings.ReplaceAll(query.Encode(), url.QueryEscape(mask("x")), mask("x"))
+		}
+		if u.User == nil {
+			parts[i] = u.String()
+			continue
+		}
+		// a lone user may be a token, as in https://token@host
+		userinfo := mask("x")
+		if _, ok := u.User.Password(); ok {
+			userinfo = url.User(u.User.Username()).String() + ":" + mask("x")
+		}
+		u.User = nil
+		parts[i] = strings.Replace(u.String(), "://", "://"+userinfo+"@", 1)
+	}
+	return strings.Join(parts, ",")
+}
+
+// maskSecrets returns a copy of values with mask applied to the ones whose
+// name tells they hold a secret, such as an api_key option or an
+// Authorization header.
+func maskSecrets(values map[string]string, mask func(string) string) map[string]string {
+	if values == nil {
+		return nil
+	}
+	masked := make(map[string]string, len(values))
+	for key, value := range values {
+		name := strings.ToLower(key)
+		for _, word := range []string{"key", "token", "secret", "password", "auth"} {
+			if strings.Contains(name, word) {
+				value = mask(value)
+				break
+			}
+		}
+		masked[key] = value
+	}
+	return masked
+}
+
+func (c *Config) loadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	err = decoder.Decode(c)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// applyEnv overrides the configuration with the environment. Every
+// variable keeps the name it had before the config file existed.
+func (c *Config) applyEnv() {
+	c.Listen = envString("LISTEN", c.Listen)
+	c.GRPCListen = envString("GRPC_LISTEN", c.GRPCListen)
+	c.DebugAddr = envString("DEBUG_ADDR", c.DebugAddr)
+	c.Timeout = envDuration("TIMEOUT", c.Timeout)
+	c.MaxTimeout = envDuration("MAX_TIMEOUT", c.MaxTimeout)
+	c.ShutdownTimeout = envDuration("SHUTDOWN_TIMEOUT", c.ShutdownTimeout)
+	c.ReloadInterval = envDuration("CONFIG_RELOAD_INTERVAL", c.ReloadInterval)
+	c.LogLevel = envString("LOG_LEVEL", c.LogLevel)
+	c.LogFormat = envString("LOG_FORMAT", c.LogFormat)
+	c.AccessLog = envString("ACCESS_LOG", c.AccessLog)
+	c.Strategy = envString("STRATEGY", c.Strategy)
+	c.Quorum = envInt("QUORUM", c.Quorum)
+	c.HedgeDelay = envDuration("HEDGE_DELAY", c.HedgeDelay)
+
+	if value := envString("PROVIDERS", ""); value != "" {
+		c.Providers = splitList(value)
+	}
+	if envBool("CORREIOS_ENABLED", false) && !slices.Contains(c.Providers, "correios") {
+		c.Providers = append(c.Providers, "correios")
+	}
+	c.RateLimit.RPS = envFloat("RATE_LIMIT_RPS", c.RateLimit.RPS)
+	c.RateLimit.Burst = envInt("RATE_LIMIT_BURST", c.RateLimit.Burst)
+	c.ClientRateLimit.RPS = envFloat("CLIENT_RATE_LIMIT_RPS", c.ClientRateLimit.RPS)
+	c.ClientRateLimit.Burst = envInt("CLIENT_RATE_LIMIT_BURST", c.ClientRateLimit.Burst)
+	if value := envString("CLIENT_RATE_LIMIT_BYPASS", ""); value != "" {
+		c.ClientRateLimit.Bypass = splitList(value)
+	}
+	c.Outbound.MaxInFlight = envInt("OUTBOUND_MAX_IN_FLIGHT", c.Outbound.MaxInFlight)
+	c.Outbound.MaxQueue = envInt("OUTBOUND_MAX_QUEUE", c.Outbound.MaxQueue)
+	c.Outbound.QueueTimeout = envDuration("OUTBOUND_QUEUE_TIMEOUT", c.Outbound.QueueTimeout)
+	c.Outbound.Budget = envInt("OUTBOUND_BUDGET", c.Outbound.Budget)
+	// before the providers', which fall back to it
+	c.Mock.Enabled = envBool("MOCK_PROVIDERS", c.Mock.Enabled)
+	c.Mock.Fixtures = envString("MOCK_FIXTURES", c.Mock.Fixtures)
+	c.Mock.Latency = envDuration("MOCK_LATENCY", c.Mock.Latency)
+	c.Mock.Jitter = envDuration("MOCK_JITTER", c.Mock.Jitter)
+	c.Mock.FailureRate = envFloat("MOCK_FAILURE_RATE", c.Mock.FailureRate)
+	c.Recording.Mode = envString("RECORDING_MODE", c.Recording.Mode)
+	c.Recording.Dir = envString("RECORDING_DIR", c.Recording.Dir)
+
+	for _, name := range slices.Concat(providerNames(), internationalNames) {
+		prefix := "PROVIDER_" + strings.ToUpper(name) + "_"
+		settings := c.Provider(name)
+		settings.Timeout = envDuration(prefix+"TIMEOUT", settings.Timeout)
+		settings.URL = envString(prefix+"URL", settings.URL)
+		settings.Username = envString(prefix+"USERNAME", settings.Username)
+		settings.Password = envString(prefix+"PASSWORD", settings.Password)
+		settings.Proxy = envString(prefix+"PROXY", settings.Proxy)
+		if value := envString(prefix+"MIRRORS", ""); value != "" {
+			settings.Mirrors = splitList(value)
+		}
+		settings.Token = envString(prefix+"TOKEN", settings.Token)
+		settings.Weight = envFloat(prefix+"WEIGHT", settings.Weight)
+		settings.DailyCap = envInt(prefix+"DAILY_CAP", settings.DailyCap)
+		if name == "correios" {
+			settings.URL = envString("CORREIOS_URL", settings.URL)
+			settings.Username = envString("CORREIOS_USERNAME", settings.Username)
+			settings.Password = envString("CORREIOS_PASSWORD", settings.Password)
+		}
+		// PROVIDER_<NAME>_OPTIONS holds key=value pairs, e.g. "table=ceps,region=sp"
+		if value := envString(prefix+"OPTIONS", ""); value != "" {
+			settings.Options = splitPairs(value)
+		}
+		// and PROVIDER_<NAME>_HEADERS as well, e.g. "X-Api-Key=abc123"
+		if value := envString(prefix+"HEADERS", ""); value != "" {
+			settings.Headers = splitPairs(value)
+		}
+		if envString(prefix+"MOCK_LATENCY", "") != "" || envString(prefix+"MOCK_FAILURE_RATE", "") != "" {
+			mock := c.ProviderMock(name)
+			mock.Latency = envDuration(prefix+"MOCK_LATENCY", mock.Latency)
+			mock.FailureRate = envFloat(prefix+"MOCK_FAILURE_RATE", mock.FailureRate)
+			settings.Mock = &mock
+		}
+		if rps := envFloat(prefix+"RPS", -1); rps >= 0 {
+			settings.RateLimit = &RateLimitConfig{RPS: rps, Burst: envInt(prefix+"BURST", c.RateLimit.Burst)}
+		}
+		if settings.Timeout != 0 || settings.URL != "" || settings.Username != "" || settings.Password != "" || settings.Mirrors != nil || settings.Proxy != "" || settings.Weight != 0 || settings.DailyCap != 0 || settings.Retry != nil || settings.RateLimit != nil || settings.Mock != nil || settings.Options != nil || settings.Headers != nil || settings.Token != "" {
+			c.ProviderSettings[name] = settings
+		}
+	}
+
+	c.AdaptiveTimeout.Enabled = envBool("ADAPTIVE_TIMEOUT", c.AdaptiveTimeout.Enabled)
+	c.AdaptiveTimeout.Percentile = envFloat("ADAPTIVE_TIMEOUT_PERCENTILE", c.AdaptiveTimeout.Percentile)
+	c.AdaptiveTimeout.Multiplier = envFloat("ADAPTIVE_TIMEOUT_MULTIPLIER", c.AdaptiveTimeout.Multiplier)
+	c.AdaptiveTimeout.Min = envDuration("ADAPTIVE_TIMEOUT_MIN", c.AdaptiveTimeout.Min)
+
+	c.Retry.Attempts = envInt("RETRY_ATTEMPTS", c.Retry.Attempts)
+	c.Retry.BaseDelay = envDuration("RETRY_BASE_DELAY", c.Retry.BaseDelay)
+	c.Retry.MaxDelay = envDuration("RETRY_MAX_DELAY", c.Retry.MaxDelay)
+	c.Retry.Jitter = envFloat("RETRY_JITTER", c.Retry.Jitter)
+
+	c.CircuitBreaker.Enabled = envBool("BREAKER_ENABLED", c.CircuitBreaker.Enabled)
+	c.CircuitBreaker.FailureThreshold = envInt("BREAKER_FAILURE_THRESHOLD", c.CircuitBreaker.FailureThreshold)
+	c.CircuitBreaker.OpenDuration = envDuration("BREAKER_OPEN_DURATION", c.CircuitBreaker.OpenDuration)
+	c.CircuitBreaker.HalfOpenProbes = envInt("BREAKER_HALF_OPEN_PROBES", c.CircuitBreaker.HalfOpenProbes)
+
+	c.HealthCheck.Enabled = envBool("HEALTH_CHECK_ENABLED", c.HealthCheck.Enabled)
+	c.HealthCheck.Interval = envDuration("HEALTH_CHECK_INTERVAL", c.HealthCheck.Interval)
+	c.HealthCheck.Timeout = envDuration("HEALTH_CHECK_TIMEOUT", c.HealthCheck.Timeout)
+	c.HealthCheck.Cep = envString("HEALTH_CHECK_CEP", c.HealthCheck.Cep)
+	c.HealthCheck.UnhealthyThreshold = envInt("HEALTH_CHECK_UNHEALTHY_THRESHOLD", c.HealthCheck.UnhealthyThreshold)
+	c.HealthCheck.HealthyThreshold = envInt("HEALTH_CHECK_HEALTHY_THRESHOLD", c.HealthCheck.HealthyThreshold)
+
+	c.HTTPClient.MaxIdleConns = envInt("HTTP_MAX_IDLE_CONNS", c.HTTPClient.MaxIdleConns)
+	c.HTTPClient.MaxIdleConnsPerHost = envInt("HTTP_MAX_IDLE_CONNS_PER_HOST", c.HTTPClient.MaxIdleConnsPerHost)
+	c.HTTPClient.MaxConnsPerHost = envInt("HTTP_MAX_CONNS_PER_HOST", c.HTTPClient.MaxConnsPerHost)
+	c.HTTPClient.IdleConnTimeout = envDuration("HTTP_IDLE_CONN_TIMEOUT", c.HTTPClient.IdleConnTimeout)
+	c.HTTPClient.DialTimeout = envDuration("HTTP_DIAL_TIMEOUT", c.HTTPClient.DialTimeout)
+	c.HTTPClient.TLSHandshakeTimeout = envDuration("HTTP_TLS_HANDSHAKE_TIMEOUT", c.HTTPClient.TLSHandshakeTimeout)
+	c.HTTPClient.DisableKeepAlives = envBool("HTTP_DISABLE_KEEP_ALIVES", c.HTTPClient.DisableKeepAlives)
+	c.HTTPClient.HTTP2 = envBool("HTTP_HTTP2", c.HTTPClient.HTTP2)
+	c.HTTPClient.MaxBodySize = int64(envInt("HTTP_MAX_BODY_SIZE", int(c.HTTPClient.MaxBodySize)))
+	c.HTTPClient.Proxy = envString("HTTP_PROXY_URL", c.HTTPClient.Proxy)
+	c.HTTPClient.DNSCacheTTL = envDuration("HTTP_DNS_CACHE_TTL", c.HTTPClient.DNSCacheTTL)
+	if value := envString("HTTP_CA_FILES", ""); value != "" {
+		c.HTTPClient.CAFiles = splitList(value)
+	}
+
+	c.Tracing.Enabled = envBool("TRACING_ENABLED", c.Tracing.Enabled)
+	c.Tracing.Endpoint = envString("TRACING_ENDPOINT", c.Tracing.Endpoint)
+	c.Tracing.Insecure = envBool("TRACING_INSECURE", c.Tracing.Insecure)
+	c.Tracing.ServiceName = envString("TRACING_SERVICE_NAME", c.Tracing.ServiceName)
+	c.Tracing.SampleRatio = envFloat("TRACING_SAMPLE_RATIO", c.Tracing.SampleRatio)
+
+	c.Geocoder.Provider = envString("GEOCODER", c.Geocoder.Provider)
+	c.Geocoder.URL = envString("GEOCODER_URL", c.Geocoder.URL)
+	c.Geocoder.UserAgent = envString("GEOCODER_USER_AGENT", c.Geocoder.UserAgent)
+
+	c.Offline.Enabled = envBool("OFFLINE_ENABLED", c.Offline.Enabled)
+	c.Offline.Dataset = envString("OFFLINE_DATASET", c.Offline.Dataset)
+	c.Zones.Enabled = envBool("ZONES_ENABLED", c.Zones.Enabled)
+	c.Zones.File = envString("ZONES_FILE", c.Zones.File)
+	c.Zones.Default = envString("ZONES_DEFAULT", c.Zones.Default)
+
+	c.History.Driver = envString("HISTORY_DRIVER", c.History.Driver)
+	c.History.DSN = envString("HISTORY_DSN", c.History.DSN)
+	c.Quality.SampleRate = envFloat("QUALITY_SAMPLE_RATE", c.Quality.SampleRate)
+	if value := envString("CANARY_PROVIDERS", ""); value != "" {
+		c.Canary.Providers = splitList(value)
+	}
+	c.Canary.SampleRate = envFloat("CANARY_SAMPLE_RATE", c.Canary.SampleRate)
+	// SLO_OBJECTIVES holds route=target@latency pairs, e.g. "/=0.99@300ms"
+	c.SLO.Objectives = envSLOObjectives("SLO_OBJECTIVES", c.SLO.Objectives)
+	c.SLO.Windows = envDurations("SLO_WINDOWS", c.SLO.Windows)
+	c.SLO.AlertBurnRate = envFloat("SLO_ALERT_BURN_RATE", c.SLO.AlertBurnRate)
+	c.Autocomplete.MaxEntries = envInt("AUTOCOMPLETE_MAX_ENTRIES", c.Autocomplete.MaxEntries)
+
+	if value := envString("CNPJ_PROVIDERS", ""); value != "" {
+		c.Cnpj.Providers = splitList(value)
+	}
+	if c.Cnpj.ProviderSettings == nil {
+		c.Cnpj.ProviderSettings = map[string]CnpjProviderConfig{}
+	}
+	for _, name := range cnpjProviderNames {
+		prefix := "CNPJ_" + strings.ToUpper(name) + "_"
+		settings := c.Cnpj.ProviderSettings[name]
+		settings.URL = envString(prefix+"URL", settings.URL)
+		settings.Token = envString(prefix+"TOKEN", settings.Token)
+		if rps := envFloat(prefix+"RPS", -1); rps >= 0 {
+			settings.RateLimit = &RateLimitConfig{RPS: rps, Burst: envInt(prefix+"BURST", c.RateLimit.Burst)}
+		}
+		if settings.URL != "" || settings.Token != "" || settings.RateLimit != nil {
+			c.Cnpj.ProviderSettings[name] = settings
+		}
+	}
+	c.Modules.Banks.Enabled = envBool("BANKS_ENABLED", c.Modules.Banks.Enabled)
+	c.Modules.Banks.URL = envString("BANKS_URL", c.Modules.Banks.URL)
+	c.Modules.Fipe.Enabled = envBool("FIPE_ENABLED", c.Modules.Fipe.Enabled)
+	c.Modules.Fipe.URL = envString("FIPE_URL", c.Modules.Fipe.URL)
+
+	c.Events.Backend = envString("EVENTS_BACKEND", c.Events.Backend)
+	c.Events.URL = envString("EVENTS_URL", c.Events.URL)
+	c.Events.Topic = envString("EVENTS_TOPIC", c.Events.Topic)
+
+	c.Cache.Backend = envString("CACHE_BACKEND", c.Cache.Backend)
+	c.Cache.Size = envInt("CACHE_SIZE", c.Cache.Size)
+	c.Cache.TTL = envDuration("CACHE_TTL", c.Cache.TTL)
+	c.Cache.StaleWindow = envDuration("CACHE_STALE_WINDOW", c.Cache.StaleWindow)
+	c.Cache.NotFoundTTL = envDuration("CACHE_NOT_FOUND_TTL", c.Cache.NotFoundTTL)
+	if value := envString("WARMUP_CEPS", ""); value != "" {
+		c.Warmup.Ceps = splitList(value)
+	}
+	c.Warmup.File = envString("WARMUP_FILE", c.Warmup.File)
+	c.Warmup.Top = envInt("WARMUP_TOP", c.Warmup.Top)
+	c.Warmup.Window = envDuration("WARMUP_WINDOW", c.Warmup.Window)
+	c.Warmup.Interval = envDuration("WARMUP_INTERVAL", c.Warmup.Interval)
+	c.Warmup.RPS = envFloat("WARMUP_RPS", c.Warmup.RPS)
+	c.Revalidation.Interval = envDuration("REVALIDATION_INTERVAL", c.Revalidation.Interval)
+	if value := envString("REVALIDATION_CEPS", ""); value != "" {
+		c.Revalidation.Ceps = splitList(value)
+	}
+	c.Revalidation.File = envString("REVALIDATION_FILE", c.Revalidation.File)
+	c.Revalidation.Top = envInt("REVALIDATION_TOP", c.Revalidation.Top)
+	c.Revalidation.Window = envDuration("REVALIDATION_WINDOW", c.Revalidation.Window)
+	c.Revalidation.RPS = envFloat("REVALIDATION_RPS", c.Revalidation.RPS)
+	c.Revalidation.WebhookURL = envString("REVALIDATION_WEBHOOK_URL", c.Revalidation.WebhookURL)
+	c.Revalidation.Events = envBool("REVALIDATION_EVENTS", c.Revalidation.Events)
+	c.Cache.MaxAge = envDuration("CACHE_MAX_AGE", c.Cache.MaxAge)
+	c.Cache.Redis.Addr = envString("REDIS_ADDR", c.Cache.Redis.Addr)
+	c.Cache.Redis.Password = envString("REDIS_PASSWORD", c.Cache.Redis.Password)
+	c.Cache.Redis.DB = envInt("REDIS_DB", c.Cache.Redis.DB)
+	c.Cache.Bolt.Path = envString("BOLT_PATH", c.Cache.Bolt.Path)
+	c.Cache.Bolt.CompactInterval = envDuration("BOLT_COMPACT_INTERVAL", c.Cache.Bolt.CompactInterval)
+
+	c.Batch.Max = envInt("BATCH_MAX", c.Batch.Max)
+	c.Batch.Concurrency = envInt("BATCH_CONCURRENCY", c.Batch.Concurrency)
+
+	c.Jobs.Workers = envInt("JOBS_WORKERS", c.Jobs.Workers)
+	c.Jobs.ProviderRPS = envFloat("JOBS_PROVIDER_RPS", c.Jobs.ProviderRPS)
+	c.Jobs.ProviderBurst = envInt("JOBS_PROVIDER_BURST", c.Jobs.ProviderBurst)
+	c.Jobs.Retention = envDuration("JOBS_RETENTION", c.Jobs.Retention)
+	c.Jobs.MaxUpload = int64(envInt("JOBS_MAX_UPLOAD", int(c.Jobs.MaxUpload)))
+
+	c.Webhook.Secret = envString("WEBHOOK_SECRET", c.Webhook.Secret)
+	c.Webhook.Timeout = envDuration("WEBHOOK_TIMEOUT", c.Webhook.Timeout)
+	c.Webhook.Attempts = envInt("WEBHOOK_ATTEMPTS", c.Webhook.Attempts)
+	if value := envString("WEBHOOK_ALLOWED_HOSTS", ""); value != "" {
+		c.Webhook.AllowedHosts = splitList(value)
+	}
+
+	c.WebSocket.Concurrency = envInt("WS_CONCURRENCY", c.WebSocket.Concurrency)
+	c.WebSocket.MaxMessage = int64(envInt("WS_MAX_MESSAGE", int(c.WebSocket.MaxMessage)))
+
+	// API_KEYS holds name:key pairs, e.g. "web:abc123,batch:def456"
+	if value := envString("API_KEYS", ""); value != "" {
+		c.Auth.Keys = nil
+		for _, pair := range splitList(value) {
+			name, key, _ := strings.Cut(pair, ":")
+			c.Auth.Keys = append(c.Auth.Keys, APIKeyConfig{Name: name, Key: key})
+		}
+	}
+	c.Auth.KeysFile = envString("API_KEYS_FILE", c.Auth.KeysFile)
+	c.Auth.DailyQuota = envInt("API_KEY_DAILY_QUOTA", c.Auth.DailyQuota)
+	c.Auth.MonthlyQuota = envInt("API_KEY_MONTHLY_QUOTA", c.Auth.MonthlyQuota)
+	c.Auth.RPS = envFloat("API_KEY_RPS", c.Auth.RPS)
+	c.Auth.Burst = envInt("API_KEY_BURST", c.Auth.Burst)
+
+	if value := envString("CORS_ALLOWED_ORIGINS", ""); value != "" {
+		c.CORS.AllowedOrigins = splitList(value)
+	}
+	if value := envString("CORS_ALLOWED_METHODS", ""); value != "" {
+		c.CORS.AllowedMethods = splitList(value)
+	}
+	if value := envString("CORS_ALLOWED_HEADERS", ""); value != "" {
+		c.CORS.AllowedHeaders = splitList(value)
+	}
+	c.CORS.MaxAge = envDuration("CORS_MAX_AGE", c.CORS.MaxAge)
+
+	c.Compression.Enabled = envBool("COMPRESSION_ENABLED", c.Compression.Enabled)
+	c.Compression.MinSize = envInt("COMPRESSION_MIN_SIZE", c.Compression.MinSize)
+	c.Compression.Level = envInt("COMPRESSION_LEVEL", c.Compression.Level)
+
+	c.TLS.CertFile = envString("TLS_CERT_FILE", c.TLS.CertFile)
+	c.TLS.KeyFile = envString("TLS_KEY_FILE", c.TLS.KeyFile)
+	c.TLS.ReloadInterval = envDuration("TLS_RELOAD_INTERVAL", c.TLS.ReloadInterval)
+	c.TLS.MinVersion = envString("TLS_MIN_VERSION", c.TLS.MinVersion)
+	c.TLS.ClientAuth = envString("TLS_CLIENT_AUTH", c.TLS.ClientAuth)
+	c.TLS.ClientCAFile = envString("TLS_CLIENT_CA_FILE", c.TLS.ClientCAFile)
+
+	c.Signing.Secret = envString("SIGNING_SECRET", c.Signing.Secret)
+	c.Admin.Token = envString("ADMIN_TOKEN", c.Admin.Token)
+}
+
+// splitPairs parses a comma separated list of key=value pairs.
+func splitPairs(value string) map[string]string {
+	pairs := map[string]string{}
+	for _, pair := range splitList(value) {
+		key, value, _ := strings.Cut(pair, "=")
+		pairs[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return pairs
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// ConfigFlags registers the configuration flags on a flag set, so the
+// server and the CLI subcommands accept the same ones.
+type ConfigFlags struct {
+	fs           *flag.FlagSet
+	file         *string
+	listen       *string
+	grpcListen   *string
+	debugAddr    *string
+	timeout      *time.Duration
+	logLevel     *string
+	logFormat    *string
+	accessLog    *string
+	strategy     *string
+	providers    *string
+	adaptive     *bool
+	mock         *bool
+	cacheBackend *string
+	cacheSize    *int
+	cacheTTL     *time.Duration
+}
+
+func NewConfigFlags(fs *flag.FlagSet) *ConfigFlags {
+	defaults := DefaultConfig()
+	return &ConfigFlags{
+		fs:           fs,
+		file:         fs.String("config", "", "path to a YAML config file (or CONFIG_FILE)"),
+		listen:       fs.String("listen", defaults.Listen, "comma separated addresses to listen on, host:port or unix:/path.sock"),
+		grpcListen:   fs.String("grpc-listen", defaults.GRPCListen, "address to serve the gRPC API on (disabled when empty)"),
+		debugAddr:    fs.String("debug-addr", defaults.DebugAddr, "address to serve pprof and expvar on (disabled when empty)"),
+		timeout:      fs.Duration("timeout", defaults.Timeout, "deadline for a lookup"),
+		logLevel:     fs.String("log-level", defaults.LogLevel, "log level: "+strings.Join(logLevels, ", ")),
+		logFormat:    fs.String("log-format", defaults.LogFormat, "log format: console or json"),
+		accessLog:    fs.String("access-log", defaults.AccessLog, "access log format: common, json or off"),
+		strategy:     fs.String("strategy", defaults.Strategy, "default race strategy"),
+		providers:    fs.String("providers", strings.Join(defaults.Providers, ","), "comma separated providers, in priority order"),
+		adaptive:     fs.Bool("adaptive-timeout", defaults.AdaptiveTimeout.Enabled, "derive provider deadlines from their latency"),
+		mock:         fs.Bool("mock", defaults.Mock.Enabled, "answer from fixtures instead of the live providers (or MOCK_PROVIDERS)"),
+		cacheBackend: fs.String("cache-backend", defaults.Cache.Backend, "cache backend: memory, redis or bolt"),
+		cacheSize:    fs.Int("cache-size", defaults.Cache.Size, "maximum entries of the memory cache"),
+		cacheTTL:     fs.Duration("cache-ttl", defaults.Cache.TTL, "how long lookups are cached"),
+	}
+}
+
+// Path is the config file, empty when there is none.
+func (f *ConfigFlags) Path() string {
+	if *f.file != "" {
+		return *f.file
+	}
+	return envString("CONFIG_FILE", "")
+}
+
+// Load builds and validates the configuration. It must be called after
+// the flag set has been parsed.
+func (f *ConfigFlags) Load() (Config, error) {
+	cfg := DefaultConfig()
+
+	path := f.Path()
+	if path != "" {
+		err := cfg.loadFile(path)
+		if err != nil {
+			return Config{}, err
+		}
+	}
+	if cfg.ProviderSettings == nil {
+		cfg.ProviderSettings = map[string]ProviderConfig{}
+	}
+
+	cfg.applyEnv()
+
+	f.fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "listen":
+			cfg.Listen = *f.listen
+		case "grpc-listen":
+			cfg.GRPCListen = *f.grpcListen
+		case "debug-addr":
+			cfg.DebugAddr = *f.debugAddr
+		case "timeout":
+			cfg.Timeout = *f.timeout
+		case "log-level":
+			cfg.LogLevel = *f.logLevel
+		case "log-format":
+			cfg.LogFormat = *f.logFormat
+		case "access-log":
+			cfg.AccessLog = *f.accessLog
+		case "strategy":
+			cfg.Strategy = *f.strategy
+		case "providers":
+			cfg.Providers = splitList(*f.providers)
+		case "adaptive-timeout":
+			cfg.AdaptiveTimeout.Enabled = *f.adaptive
+		case "mock":
+			cfg.Mock.Enabled = *f.mock
+		case "cache-backend":
+			cfg.Cache.Backend = *f.cacheBackend
+		case "cache-size":
+			cfg.Cache.Size = *f.cacheSize
+		case "cache-ttl":
+			cfg.Cache.TTL = *f.cacheTTL
+		}
+	})
+
+	err := cfg.Validate()
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid config: %w", err)
+	}
+	return cfg, nil
+}
+
+func printConfig(cfg Config) error {
+	out, err := yaml.Marshal(cfg.Redacted())
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(out)
+	return err
+}
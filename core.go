@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/liberopassadorneto/multi/pkg/cep"
+)
+
+// The lookup core lives in pkg/cep so that other services can embed it.
+// These aliases keep the server, whose variables are commonly named cep,
+// clear of the package name.
+type (
+	Address          = cep.Address
+	Provider         = cep.Provider
+	StatusError      = cep.StatusError
+	ProviderError    = cep.ProviderError
+	ProviderFailures = cep.ProviderFailures
+	Strategy         = cep.Strategy
+	Result           = cep.Result
+	Discrepancy      = cep.Discrepancy
+	Cache            = cep.Cache
+	StaleCache       = cep.StaleCache
+	FlushableCache   = cep.FlushableCache
+	CacheStats       = cep.CacheStats
+	MemoryCache      = cep.MemoryCache
+	Searcher         = cep.Searcher
+	ZoneRange        = cep.ZoneRange
+	ZoneTable        = cep.ZoneTable
+)
+
+var (
+	ErrInvalidCep         = cep.ErrInvalid
+	ErrCepNotFound        = cep.ErrNotFound
+	ErrTimeout            = cep.ErrTimeout
+	ErrNoProviders        = cep.ErrNoProviders
+	ErrInvalidSearch      = cep.ErrInvalidSearch
+	ErrInvalidAddress     = cep.ErrInvalidAddress
+	ErrInvalidDdd         = cep.ErrInvalidDdd
+	ErrDddNotFound        = cep.ErrDddNotFound
+	ErrInvalidCnpj        = cep.ErrInvalidCnpj
+	ErrCnpjNotFound       = cep.ErrCnpjNotFound
+	ErrInvalidBankCode    = cep.ErrInvalidBankCode
+	ErrBankNotFound       = cep.ErrBankNotFound
+	ErrInvalidVehicleType = cep.ErrInvalidVehicleType
+	ErrInvalidFipeCode    = cep.ErrInvalidFipeCode
+	ErrFipeNotFound       = cep.ErrFipeNotFound
+	ErrNoCoordinates      = cep.ErrNoCoordinates
+	ErrEmptyAnswer        = cep.ErrEmptyAnswer
+
+	NormalizeCep         = cep.Normalize
+	Strategies           = cep.Strategies
+	Quorum               = cep.Quorum
+	LookupResult         = cep.LookupResult
+	All                  = cep.All
+	NewAllResponse       = cep.NewAllResponse
+	FindDiscrepancies    = cep.FindDiscrepancies
+	NormalizeAddressText = cep.NormalizeAddressText
+	NewMemoryCache       = cep.NewMemoryCache
+	NewRedisCache        = cep.NewRedisCache
+	NewBoltCache         = cep.NewBoltCache
+	providerNames        = cep.ProviderNames
+	Distance             = cep.Distance
+)
+
+// newProvider builds a registered provider from its configuration.
+func newProvider(name string, settings ProviderConfig, client *http.Client) (Provider, error) {
+	return cep.NewProvider(name, cep.Settings{
+		URL:      settings.URL,
+		Username: settings.Username,
+		Password: settings.Password,
+		Options:  settings.Options,
+	}, client)
+}
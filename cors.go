@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// corsExposed are the response headers browser apps may read.
+const corsExposed = "X-Request-ID, traceresponse, X-Cache, X-Signature, X-Lookup-Shared, X-UF-Mismatch, X-Total-Count, Retry-After, X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset"
+
+var corsConfig CORSConfig
+
+func (c CORSConfig) allows(origin string) bool {
+	return slices.ContainsFunc(c.AllowedOrigins, func(allowed string) bool {
+		return allowed == "*" || strings.EqualFold(allowed, origin)
+	})
+}
+
+// cors answers the preflights of the allowed origins and lets them read
+// the responses, when CORS is configured. Requests without an Origin are
+// not browser cross-origin calls and pass through untouched.
+func cors(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || len(corsConfig.AllowedOrigins) == 0 {
+			next(w, r)
+			return
+		}
+
+		allowed := corsConfig.allows(origin)
+		w.Header().Add("Vary", "Origin")
+		if allowed {
+			allowOrigin := origin
+			if slices.Contains(corsConfig.AllowedOrigins, "*") {
+				allowOrigin = "*"
+			}
+			w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			// a refused preflight simply lacks the headers, which the
+			// browser reports to the app as a CORS failure
+			if allowed {
+				w.Header().Set("Access-Control-Allow-Methods", strings.Join(corsConfig.AllowedMethods, ", "))
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(corsConfig.AllowedHeaders, ", "))
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(corsConfig.MaxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if allowed {
+			w.Header().Set("Access-Control-Expose-Headers", corsExposed)
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/liberopassadorneto/multi/pkg/cep"
+)
+
+var (
+	dddProvider *cep.DddProvider
+	// dddCache is kept apart from the CEP cache: there are only a few
+	// dozen DDDs and they must not evict addresses
+	dddCache *cep.MemoryCache
+)
+
+func setupDdd(cfg Config, client *http.Client) {
+	dddProvider = cep.NewDddProvider()
+	dddProvider.Client = client
+	dddCache = cep.NewMemoryCache(0, cfg.Cache.TTL)
+}
+
+// LookupDdd lists the cities of a DDD from the cache or, on a miss, from
+// the upstream bounded by the configured timeout.
+func LookupDdd(ctx context.Context, raw string) (*cep.DddInfo, error) {
+	ddd, err := cep.NormalizeDdd(raw)
+	if err != nil {
+		return nil, err
+	}
+	ctx = withLogAttrs(ctx, "ddd", ddd)
+
+	if body, ok, _ := dddCache.Get(ctx, ddd); ok {
+		var info cep.DddInfo
+		if json.Unmarshal(body, &info) == nil {
+			return &info, nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, lookupTimeout())
+	defer cancel()
+
+	info, err := dddProvider.Lookup(ctx, ddd)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ErrTimeout
+		}
+		slog.WarnContext(ctx, "ddd lookup failed", "error", err)
+		return nil, err
+	}
+
+	if body, err := json.Marshal(info); err == nil {
+		_ = dddCache.Set(ctx, ddd, body)
+	}
+	return info, nil
+}
+
+// DddHandler serves GET /ddd/{code}.
+func DddHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSONError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	info, err := LookupDdd(r.Context(), r.PathValue("code"))
+	if err != nil {
+		writeLookupError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, info)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+)
+
+func init() {
+	expvar.Publish("goroutines", expvar.Func(func() any { return runtime.NumGoroutine() }))
+}
+
+// debugMux serves pprof and expvar. It is only ever served on the debug
+// address, apart from the API, so profiles are not exposed to its clients.
+func debugMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}
+
+// serveDebug runs the debug endpoints on every address until ctx is done.
+// Profiles being taken then are cut short rather than drained.
+func serveDebug(ctx context.Context, addrs []string) error {
+	listeners, err := listenAll(addrs)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: debugMux()}
+	errc := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		go func() {
+			slog.Info("debug listening", "addr", listener.Addr().String())
+			errc <- server.Serve(listener)
+		}()
+	}
+
+	select {
+	case err := <-errc:
+		server.Close()
+		return err
+	case <-ctx.Done():
+	}
+	return server.Close()
+}
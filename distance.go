@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+
+	"golang.org/x/sync/errgroup"
+)
+
+type DistanceResponse struct {
+	From       *Address `json:"from"`
+	To         *Address `json:"to"`
+	DistanceKm float64  `json:"distance_km"`
+}
+
+// locate resolves a CEP and makes sure it has coordinates, geocoding it
+// when the winning provider had none.
+func locate(ctx context.Context, cep string) (*Address, error) {
+	address, _, err := Lookup(ctx, cep, defaultStrategy)
+	if err != nil {
+		return nil, err
+	}
+	address = enrich(ctx, address, []string{"geo"})
+	if address.Location == nil {
+		return nil, ErrNoCoordinates
+	}
+	return address, nil
+}
+
+// DistanceHandler serves GET /distance?from=&to= with the straight-line
+// distance between two CEPs.
+func DistanceHandler(w http.ResponseWriter, r *http.Request) {
+	queryParams := r.URL.Query()
+	var ceps [2]string
+	for i, param := range []string{"from", "to"} {
+		raw := queryParams.Get(param)
+		if raw == "" {
+			writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("missing '%s' query parameter", param))
+			return
+		}
+		normalized, err := NormalizeCep(raw)
+		if err != nil {
+			writeJSONError(w, r, http.StatusUnprocessableEntity, CodeInvalidCep, fmt.Sprintf("%s: %v", param, err))
+			return
+		}
+		ceps[i] = normalized
+	}
+
+	var addresses [2]*Address
+	g, ctx := errgroup.WithContext(r.Context())
+	for i, cep := range ceps {
+		g.Go(func() error {
+			address, err := locate(ctx, cep)
+			if err != nil {
+				return fmt.Errorf("%s: %w", cep, err)
+			}
+			addresses[i] = address
+			return nil
+		})
+	}
+	err := g.Wait()
+	if err != nil {
+		writeLookupError(w, r, err)
+		return
+	}
+
+	distance := Distance(addresses[0].Location.Coordinates, addresses[1].Location.Coordinates)
+	writeJSON(w, r, http.StatusOK, DistanceResponse{
+		From:       addresses[0],
+		To:         addresses[1],
+		DistanceKm: math.Round(distance*100) / 100,
+	})
+}
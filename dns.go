@@ -0,0 +1,104 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/sync/singleflight"
+)
+
+var dnsLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "multi",
+	Name:      "dns_cache_lookups_total",
+	Help:      "Upstream host resolutions by result: hit, miss or stale.",
+}, []string{"result"})
+
+// dnsTimeout bounds the resolutions of a dialer without a timeout.
+const dnsTimeout = 5 * time.Second
+
+// dnsCache resolves the upstream hosts once every ttl instead of on every
+// new connection, and keeps dialing the addresses it last resolved while
+// the resolver fails, so a flaky DNS does not take the providers down
+// with it.
+type dnsCache struct {
+	ttl      time.Duration
+	dialer   *net.Dialer
+	resolver *net.Resolver
+
+	mu        sync.Mutex
+	entries   map[string]dnsEntry
+	resolving singleflight.Group
+}
+
+type dnsEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+func newDNSCache(ttl time.Duration, dialer *net.Dialer) *dnsCache {
+	return &dnsCache{ttl: ttl, dialer: dialer, resolver: net.DefaultResolver, entries: map[string]dnsEntry{}}
+}
+
+// DialContext dials the addresses of the host of address in turn, until
+// one connects.
+func (c *dnsCache) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || net.ParseIP(host) != nil {
+		return c.dialer.DialContext(ctx, network, address)
+	}
+	addrs, err := c.resolve(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for _, addr := range addrs {
+		conn, err := c.dialer.DialContext(ctx, network, net.JoinHostPort(addr, port))
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+func (c *dnsCache) resolve(ctx context.Context, host string) ([]string, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[host]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		dnsLookups.WithLabelValues("hit").Inc()
+		return entry.addrs, nil
+	}
+
+	resolved, err, _ := c.resolving.Do(host, func() (any, error) {
+		// shared by the dials waiting on it, so not cancelled with the first
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cmp.Or(c.dialer.Timeout, dnsTimeout))
+		defer cancel()
+		return c.resolver.LookupHost(ctx, host)
+	})
+	if err != nil {
+		if ok {
+			dnsLookups.WithLabelValues("stale").Inc()
+			slog.WarnContext(ctx, "error resolving upstream host, dialing its last addresses", "host", host, "error", err)
+			return entry.addrs, nil
+		}
+		return nil, err
+	}
+	dnsLookups.WithLabelValues("miss").Inc()
+	addrs := resolved.([]string)
+	c.mu.Lock()
+	c.entries[host] = dnsEntry{addrs: addrs, expires: time.Now().Add(c.ttl)}
+	c.mu.Unlock()
+	return addrs, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/liberopassadorneto/multi/pkg/cep"
+)
+
+var (
+	ibgeProvider *cep.IbgeProvider
+	// ibgeCache holds the municipalities apart from the CEP cache, since
+	// many CEPs share each of them
+	ibgeCache *cep.MemoryCache
+
+	geocoder cep.Geocoder
+	geoCache *cep.MemoryCache
+)
+
+// enrichments are the optional steps selectable with ?enrich=, each
+// filling more fields of an address.
+var enrichments = map[string]func(ctx context.Context, address *Address) error{
+	"ibge": enrichIbge,
+	"geo":  enrichGeo,
+}
+
+func setupEnrichment(cfg Config, client *http.Client) {
+	ibgeProvider = cep.NewIbgeProvider()
+	ibgeProvider.Client = client
+	ibgeCache = cep.NewMemoryCache(0, cfg.Cache.TTL)
+
+	geocoder = newGeocoder(cfg.Geocoder, client)
+	geoCache = cep.NewMemoryCache(cfg.Cache.Size, cfg.Cache.TTL)
+}
+
+func newGeocoder(cfg GeocoderConfig, client *http.Client) cep.Geocoder {
+	if cfg.Provider == "nominatim" {
+		nominatim := cep.NewNominatimGeocoder(cfg.UserAgent)
+		nominatim.Client = client
+		if cfg.URL != "" {
+			nominatim.BaseURL = cfg.URL
+		}
+		return nominatim
+	}
+	brasilApi := cep.NewBrasilApiProvider()
+	brasilApi.Client = client
+	if cfg.URL != "" {
+		brasilApi.BaseURL = cfg.URL
+	}
+	return &cep.BrasilApiGeocoder{Provider: brasilApi}
+}
+
+// parseEnrich validates the comma separated enrichments of ?enrich=.
+func parseEnrich(raw string) ([]string, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	names := strings.Split(raw, ",")
+	for _, name := range names {
+		if _, ok := enrichments[name]; !ok {
+			return nil, fmt.Errorf("unknown enrichment %q", name)
+		}
+	}
+	return names, nil
+}
+
+// enrich returns a copy of address with the given enrichments applied.
+// A failed enrichment is logged and left out rather than failing the
+// lookup, whose address is still good.
+func enrich(ctx context.Context, address *Address, names []string) *Address {
+	if len(names) == 0 {
+		return address
+	}
+	// the address may be shared with concurrent lookups of the same CEP
+	enriched := *address
+	for _, name := range names {
+		enrichCtx, cancel := context.WithTimeout(ctx, lookupTimeout())
+		err := enrichments[name](enrichCtx, &enriched)
+		cancel()
+		if err != nil {
+			slog.WarnContext(ctx, "enrichment failed", "enrichment", name, "error", err)
+		}
+	}
+	return &enriched
+}
+
+func enrichIbge(ctx context.Context, address *Address) error {
+	if address.Ibge == "" {
+		return nil
+	}
+
+	if body, ok, _ := ibgeCache.Get(ctx, address.Ibge); ok {
+		var municipality cep.Municipality
+		if json.Unmarshal(body, &municipality) == nil {
+			address.Municipality = &municipality
+			return nil
+		}
+	}
+
+	municipality, err := ibgeProvider.Lookup(ctx, address.Ibge)
+	if err != nil {
+		return err
+	}
+	if body, err := json.Marshal(municipality); err == nil {
+		_ = ibgeCache.Set(ctx, address.Ibge, body)
+	}
+	address.Municipality = municipality
+	return nil
+}
+
+// enrichGeo fills the coordinates of addresses whose provider had none.
+func enrichGeo(ctx context.Context, address *Address) error {
+	if address.Location != nil {
+		return nil
+	}
+
+	if body, ok, _ := geoCache.Get(ctx, address.Cep); ok {
+		var location cep.Location
+		if json.Unmarshal(body, &location) == nil {
+			address.Location = &location
+			return nil
+		}
+	}
+
+	location, err := geocoder.Geocode(ctx, address)
+	if err != nil {
+		return err
+	}
+	if body, err := json.Marshal(location); err == nil {
+		_ = geoCache.Set(ctx, address.Cep, body)
+	}
+	address.Location = location
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+)
+
+func envString(name string, fallback string) string {
+	if value, ok := os.LookupEnv(name); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+func envInt(name string, fallback int) int {
+	value := envString(name, "")
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		slog.Warn("invalid environment variable, using the fallback", "name", name, "value", value, "fallback", fallback, "error", err)
+		return fallback
+	}
+	return n
+}
+
+func envDuration(name string, fallback time.Duration) time.Duration {
+	value := envString(name, "")
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		slog.Warn("invalid environment variable, using the fallback", "name", name, "value", value, "fallback", fallback, "error", err)
+		return fallback
+	}
+	return d
+}
+
+func envBool(name string, fallback bool) bool {
+	value := envString(name, "")
+	if value == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		slog.Warn("invalid environment variable, using the fallback", "name", name, "value", value, "fallback", fallback, "error", err)
+		return fallback
+	}
+	return b
+}
+
+func envFloat(name string, fallback float64) float64 {
+	value := envString(name, "")
+	if value == "" {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		slog.Warn("invalid environment variable, using the fallback", "name", name, "value", value, "fallback", fallback, "error", err)
+		return fallback
+	}
+	return f
+}
+
+// envDurations reads a comma separated list of durations, falling back
+// when any of them is invalid.
+func envDurations(name string, fallback []time.Duration) []time.Duration {
+	value := envString(name, "")
+	if value == "" {
+		return fallback
+	}
+	var durations []time.Duration
+	for _, item := range splitList(value) {
+		d, err := time.ParseDuration(item)
+		if err != nil {
+			slog.Warn("invalid environment variable, using the fallback", "name", name, "value", value, "fallback", fallback, "error", err)
+			return fallback
+		}
+		durations = append(durations, d)
+	}
+	return durations
+}
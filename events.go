@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+)
+
+// EventPublisher sends a message for every completed lookup to an event
+// bus. Publish must not block the lookup on the bus.
+type EventPublisher interface {
+	Publish(ctx context.Context, record HistoryRecord) error
+	// PublishChange sends an address change found by the re-validation to
+	// the topic of the lookups suffixed with .changes.
+	PublishChange(ctx context.Context, change AddressChange) error
+	Close() error
+}
+
+// changesTopic is where the address changes of topic are published.
+func changesTopic(topic string) string {
+	return topic + ".changes"
+}
+
+// events is nil unless an event bus is configured.
+var events EventPublisher
+
+// eventsMu guards the publishing against closeEvents, as lookups of jobs
+// and webhooks may still finish after the server stopped.
+var (
+	eventsMu     sync.RWMutex
+	eventsClosed bool
+)
+
+func setupEvents(cfg EventsConfig) {
+	switch cfg.Backend {
+	case "nats":
+		publisher, err := NewNatsPublisher(cfg.URL, cfg.Topic)
+		if err != nil {
+			fatal("error connecting to nats", err)
+		}
+		events = publisher
+	case "kafka":
+		events = NewKafkaPublisher(splitList(cfg.URL), cfg.Topic)
+	}
+}
+
+func publishEvent(ctx context.Context, record HistoryRecord) {
+	if events == nil {
+		return
+	}
+	eventsMu.RLock()
+	defer eventsMu.RUnlock()
+	if eventsClosed {
+		slog.WarnContext(ctx, "event bus closed, lookup event not published")
+		return
+	}
+	err := events.Publish(ctx, record)
+	if err != nil {
+		slog.WarnContext(ctx, "error publishing lookup event", "error", err)
+	}
+}
+
+func publishChange(ctx context.Context, change AddressChange) {
+	if events == nil {
+		return
+	}
+	eventsMu.RLock()
+	defer eventsMu.RUnlock()
+	if eventsClosed {
+		slog.WarnContext(ctx, "event bus closed, address change not published")
+		return
+	}
+	err := events.PublishChange(ctx, change)
+	if err != nil {
+		slog.WarnContext(ctx, "error publishing address change", "error", err)
+	}
+}
+
+// closeEvents flushes the events still buffered and disconnects.
+func closeEvents() {
+	if events == nil {
+		return
+	}
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	eventsClosed = true
+	err := events.Close()
+	if err != nil {
+		slog.Error("error closing event bus", "error", err)
+	}
+}
+
+// NatsPublisher publishes to a NATS subject. The connection is retried in
+// the background, so an unreachable server at startup is not fatal;
+// messages are buffered by the client meanwhile.
+type NatsPublisher struct {
+	conn    *nats.Conn
+	subject string
+}
+
+func NewNatsPublisher(url string, subject string) (*NatsPublisher, error) {
+	conn, err := nats.Connect(url,
+		nats.Name("multi"),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &NatsPublisher{conn: conn, subject: subject}, nil
+}
+
+func (p *NatsPublisher) Publish(_ context.Context, record HistoryRecord) error {
+	body, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return p.conn.Publish(p.subject, body)
+}
+
+func (p *NatsPublisher) PublishChange(_ context.Context, change AddressChange) error {
+	body, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	return p.conn.Publish(changesTopic(p.subject), body)
+}
+
+func (p *NatsPublisher) Close() error {
+	return p.conn.Drain()
+}
+
+// KafkaPublisher writes to a Kafka topic in the background, keyed by CEP
+// so that the events of a CEP stay in order within their partition. The
+// writer is shared by the topics of the lookups and the changes, so each
+// message names its own.
+type KafkaPublisher struct {
+	writer *kafka.Writer
+	topic  string
+}
+
+func NewKafkaPublisher(brokers []string, topic string) *KafkaPublisher {
+	return &KafkaPublisher{topic: topic, writer: &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Balancer:     &kafka.Hash{},
+		Async:        true,
+		BatchTimeout: 100 * time.Millisecond,
+		Completion: func(messages []kafka.Message, err error) {
+			if err != nil {
+				slog.Warn("error publishing events", "messages", len(messages), "error", err)
+			}
+		},
+	}}
+}
+
+func (p *KafkaPublisher) Publish(ctx context.Context, record HistoryRecord) error {
+	body, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	// asynchronous writes return right away, so the lookup's context
+	// would only cancel the message
+	return p.writer.WriteMessages(context.WithoutCancel(ctx), kafka.Message{
+		Topic: p.topic,
+		Key:   []byte(record.Cep),
+		Value: body,
+	})
+}
+
+func (p *KafkaPublisher) PublishChange(ctx context.Context, change AddressChange) error {
+	body, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	return p.writer.WriteMessages(context.WithoutCancel(ctx), kafka.Message{
+		Topic: changesTopic(p.topic),
+		Key:   []byte(change.Cep),
+		Value: body,
+	})
+}
+
+func (p *KafkaPublisher) Close() error {
+	return p.writer.Close()
+}
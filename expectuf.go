@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// UFMismatch reports a CEP resolved to another state than the caller
+// expected, as an anti-fraud check of checkout addresses.
+type UFMismatch struct {
+	Cep      string `json:"cep"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+func (e *UFMismatch) Error() string {
+	return fmt.Sprintf("cep %s is in %s, not %s", e.Cep, e.Actual, e.Expected)
+}
+
+// ufExpectation is the state a caller expects a CEP in, with ?expect_uf=.
+// A mismatch is rejected unless ?expect_uf_mode=flag, which only flags it.
+type ufExpectation struct {
+	uf   string
+	flag bool
+}
+
+func expectUFParam(queryParams url.Values) (ufExpectation, error) {
+	expectation := ufExpectation{uf: strings.ToUpper(strings.TrimSpace(queryParams.Get("expect_uf")))}
+	if expectation.uf != "" && (len(expectation.uf) != 2 || strings.Trim(expectation.uf, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "") {
+		return ufExpectation{}, errors.New("expect_uf must be a 2 letter state")
+	}
+	switch mode := queryParams.Get("expect_uf_mode"); mode {
+	case "", "reject":
+	case "flag":
+		expectation.flag = true
+	default:
+		return ufExpectation{}, fmt.Errorf("unknown expect_uf_mode %q", mode)
+	}
+	return expectation, nil
+}
+
+// check returns the mismatch of address, nil when it is in the expected
+// state or none is expected.
+func (e ufExpectation) check(address *Address) *UFMismatch {
+	if e.uf == "" || strings.EqualFold(address.State, e.uf) {
+		return nil
+	}
+	return &UFMismatch{Cep: address.Cep, Expected: e.uf, Actual: address.State}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// addressFields are the JSON names of the fields of Address, which
+// ?fields= picks from.
+var addressFields = jsonFields[Address]()
+
+// jsonFields are the JSON names of the fields of the struct T.
+func jsonFields[T any]() []string {
+	var names []string
+	t := reflect.TypeFor[T]()
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// responseFields parses ?fields=cep,city,state, the fields of the
+// addresses to answer with, nil when every field is. Only the JSON, CSV and
+// GeoJSON encodings can leave fields out; in JSON, they are those of the
+// schema of the response.
+func responseFields(r *http.Request, format string) ([]string, error) {
+	raw := r.URL.Query().Get("fields")
+	if raw == "" {
+		return nil, nil
+	}
+	if format != "json" && format != "csv" && format != "geojson" {
+		return nil, errors.New("fields is only supported with the json, csv and geojson formats")
+	}
+	known := addressFields
+	if schema, _ := responseSchema(r); format == "json" && schema == "v2" {
+		known = addressV2Fields
+	}
+	fields := splitList(raw)
+	for _, field := range fields {
+		if !slices.Contains(known, field) {
+			return nil, fmt.Errorf("unknown field %q, expected some of %s", field, strings.Join(known, ", "))
+		}
+	}
+	if len(fields) == 0 {
+		return nil, errors.New("fields must name at least one field")
+	}
+	return fields, nil
+}
+
+// shapedBatchItem is a batch item with its address reshaped.
+type shapedBatchItem struct {
+	BatchItem
+	Address map[string]json.RawMessage `json:"address,omitempty"`
+}
+
+// shapedSearchResponse is a search with its addresses reshaped.
+type shapedSearchResponse struct {
+	SearchResponse
+	Results []map[string]json.RawMessage `json:"results"`
+}
+
+// shapeBody returns the JSON body of v with its addresses in the shape of
+// schema, with only fields of them, or all of them when nil, named in lang;
+// v is an address, a batch or a search.
+func shapeBody(v any, fields []string, lang, schema string) any {
+	switch v := v.(type) {
+	case *Address:
+		return shapeAddress(v, fields, lang, schema)
+	case []BatchItem:
+		items := make([]shapedBatchItem, len(v))
+		for i, item := range v {
+			items[i] = shapedBatchItem{BatchItem: item, Address: shapeAddress(item.Address, fields, lang, schema)}
+		}
+		return items
+	case SearchResponse:
+		results := make([]map[string]json.RawMessage, len(v.Results))
+		for i := range v.Results {
+			results[i] = shapeAddress(&v.Results[i], fields, lang, schema)
+		}
+		return shapedSearchResponse{SearchResponse: v, Results: results}
+	default:
+		return v
+	}
+}
+
+// shapeAddress is the JSON object of address in schema with only fields,
+// nil for a nil address. A requested field the address leaves empty stays
+// out, as it would in the full answer.
+func shapeAddress(address *Address, fields []string, lang, schema string) map[string]json.RawMessage {
+	if address == nil {
+		return nil
+	}
+	var object map[string]json.RawMessage
+	body, _ := json.Marshal(schemaAddress(address, schema))
+	_ = json.Unmarshal(body, &object)
+	for name := range object {
+		if fields != nil && !slices.Contains(fields, name) {
+			delete(object, name)
+		}
+	}
+	if lang == "pt" {
+		object = localizeObject(object)
+	}
+	return object
+}
+
+// checkResponseShape validates the ?fields=, ?lang= and Accept profile of
+// r, answered in format.
+func checkResponseShape(r *http.Request, format string) error {
+	if _, err := responseSchema(r); err != nil {
+		return err
+	}
+	if _, err := responseFields(r, format); err != nil {
+		return err
+	}
+	_, err := responseLang(r)
+	return err
+}
+
+// sparseRecords drops the address columns of records not in fields,
+// keeping the batch columns. The coordinates columns go with location.
+func sparseRecords(records [][]string, fields []string) [][]string {
+	var keep []int
+	for i, column := range records[0] {
+		field := column
+		if column == "latitude" || column == "longitude" {
+			field = "location"
+		}
+		if !slices.Contains(addressColumns, column) || slices.Contains(fields, field) {
+			keep = append(keep, i)
+		}
+	}
+	for i, record := range records {
+		sparse := make([]string, len(keep))
+		for j, k := range keep {
+			sparse[j] = record[k]
+		}
+		records[i] = sparse
+	}
+	return records
+}
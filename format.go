@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+
+	cepv1 "github.com/liberopassadorneto/multi/proto/cep/v1"
+)
+
+// formats are the response encodings by the media types asking for them.
+var formats = map[string]string{
+	"application/json": "json",
+	"application/xml":  "xml",
+	"text/xml":         "xml",
+	"text/csv":         "csv",
+
+	"application/geo+json": "geojson",
+
+	"application/x-protobuf": "protobuf",
+	"application/protobuf":   "protobuf",
+}
+
+// responseFormat picks the encoding of the response to r: ?format=json,
+// xml, csv, geojson or protobuf, else the preferred of the Accept media
+// types, else JSON.
+func responseFormat(r *http.Request) (string, error) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		if !slices.Contains([]string{"json", "xml", "csv", "geojson", "protobuf"}, format) {
+			return "", fmt.Errorf("unknown format %q", format)
+		}
+		return format, nil
+	}
+
+	best, bestQ := "json", 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		format, ok := formats[mediaType]
+		if !ok {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+		}
+		if q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	return best, nil
+}
+
+// writeFormatted answers v in format, which is either json or one of the
+// XML, CSV and GeoJSON encodings of addresses, batches and searches. The
+// fields are the same in every format, unless ?fields= leaves some out of
+// the JSON, CSV and GeoJSON ones, which name them in the language of
+// ?lang=. Protobuf answers take the messages of the gRPC API, v being one
+// already or a batch. JSON addresses take the shape of the schema of
+// responseSchema.
+func writeFormatted(w http.ResponseWriter, r *http.Request, status int, format string, v any) {
+	w.Header().Add("Vary", "Accept")
+	// validated by the handlers along with the format
+	fields, _ := responseFields(r, format)
+	lang, _ := responseLang(r)
+	var body bytes.Buffer
+	var err error
+	switch format {
+	case "protobuf":
+		var encoded []byte
+		encoded, err = proto.Marshal(protoBody(v))
+		body.Write(encoded)
+		w.Header().Set("Content-Type", "application/x-protobuf")
+	case "xml":
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		body.WriteString(xml.Header)
+		err = xml.NewEncoder(&body).Encode(xmlBody(v))
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		if search, ok := v.(SearchResponse); ok {
+			w.Header().Set("X-Total-Count", strconv.Itoa(search.Total))
+		}
+		w.Header().Add("Vary", "Accept-Language")
+		records := csvRecords(v)
+		if fields != nil {
+			records = sparseRecords(records, fields)
+		}
+		if lang == "pt" {
+			records = localizeRecords(records)
+		}
+		err = csv.NewWriter(&body).WriteAll(records)
+	case "geojson":
+		w.Header().Set("Content-Type", "application/geo+json")
+		w.Header().Add("Vary", "Accept-Language")
+		err = json.NewEncoder(&body).Encode(geojsonBody(v, fields, lang))
+	default:
+		w.Header().Add("Vary", "Accept-Language")
+		schema, _ := responseSchema(r)
+		if fields != nil || lang == "pt" || schema != schemaVersions[0] {
+			v = shapeBody(v, fields, lang, schema)
+		}
+		if schema == schemaVersions[0] {
+			writeJSON(w, r, status, v)
+			return
+		}
+		w.Header().Set("Content-Type", fmt.Sprintf("application/json; profile=%q", schema))
+		err = json.NewEncoder(&body).Encode(v)
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error encoding response", "format", format, "error", err)
+	}
+	writeBody(w, r, status, body.Bytes())
+}
+
+type xmlBatch struct {
+	XMLName xml.Name    `xml:"batch"`
+	Items   []BatchItem `xml:"item"`
+}
+
+type xmlAddress struct {
+	XMLName xml.Name `xml:"address"`
+	*Address
+}
+
+func xmlBody(v any) any {
+	switch v := v.(type) {
+	case *Address:
+		return xmlAddress{Address: v}
+	case []BatchItem:
+		return xmlBatch{Items: v}
+	default:
+		return v
+	}
+}
+
+func protoBody(v any) proto.Message {
+	switch v := v.(type) {
+	case proto.Message:
+		return v
+	case []BatchItem:
+		batch := &cepv1.BatchResponse{Items: make([]*cepv1.BatchLookupResponse, len(v))}
+		for i, item := range v {
+			result := &cepv1.BatchLookupResponse{Index: int32(i), Input: item.Input, Error: item.Error,
+				Code: string(item.Code), Status: int32(item.Status)}
+			if item.Address != nil {
+				result.Address = addressResponse(item.Address, LookupInfo{})
+			}
+			batch.Items[i] = result
+		}
+		return batch
+	default:
+		panic(fmt.Sprintf("no protobuf encoding for %T", v))
+	}
+}
+
+var addressColumns = []string{"cep", "state", "city", "neighborhood", "street", "complement", "ibge", "ddd",
+	"latitude", "longitude", "provider"}
+
+func addressRecord(a *Address) []string {
+	if a == nil {
+		return make([]string, len(addressColumns))
+	}
+	latitude, longitude := "", ""
+	if a.Location != nil {
+		latitude = strconv.FormatFloat(a.Location.Coordinates.Latitude, 'f', -1, 64)
+		longitude = strconv.FormatFloat(a.Location.Coordinates.Longitude, 'f', -1, 64)
+	}
+	return []string{a.Cep, a.State, a.City, a.Neighborhood, a.Street, a.Complement, a.Ibge, a.Ddd,
+		latitude, longitude, a.Provider}
+}
+
+// csvRecords flattens v into a header and one row per address or item.
+func csvRecords(v any) [][]string {
+	switch v := v.(type) {
+	case *Address:
+		return [][]string{addressColumns, addressRecord(v)}
+	case []BatchItem:
+		records := [][]string{append([]string{"input", "status", "code", "error"}, addressColumns...)}
+		for _, item := range v {
+			row := []string{item.Input, strconv.Itoa(item.Status), string(item.Code), item.Error}
+			records = append(records, append(row, addressRecord(item.Address)...))
+		}
+		return records
+	case SearchResponse:
+		records := [][]string{addressColumns}
+		for i := range v.Results {
+			records = append(records, addressRecord(&v.Results[i]))
+		}
+		return records
+	default:
+		panic(fmt.Sprintf("no csv encoding for %T", v))
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// GeoJSONFeature is an address as a GeoJSON Feature (RFC 7946): a Point
+// at its coordinates, or a null geometry when it has none, with the other
+// fields of the address as its properties.
+type GeoJSONFeature struct {
+	Type       string                     `json:"type"`
+	Geometry   *GeoJSONPoint              `json:"geometry"`
+	Properties map[string]json.RawMessage `json:"properties"`
+}
+
+// GeoJSONPoint is a position as longitude then latitude, the order GeoJSON
+// takes, unlike most of the APIs.
+type GeoJSONPoint struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+// GeoJSONFeatureCollection holds the features of a batch or a search.
+type GeoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []GeoJSONFeature `json:"features"`
+}
+
+// geojsonBody is v, an address, a batch or a search, as GeoJSON: a Feature
+// for an address, a FeatureCollection for the others. The properties are
+// the address fields of ?fields= in the language of ?lang=, as in JSON.
+func geojsonBody(v any, fields []string, lang string) any {
+	switch v := v.(type) {
+	case *Address:
+		return addressFeature(v, fields, lang)
+	case []BatchItem:
+		features := make([]GeoJSONFeature, len(v))
+		for i, item := range v {
+			feature := addressFeature(item.Address, fields, lang)
+			feature.Properties["input"] = rawJSON(item.Input)
+			feature.Properties["status"] = json.RawMessage(strconv.Itoa(item.Status))
+			if item.Error != "" {
+				feature.Properties["code"] = rawJSON(item.Code)
+				feature.Properties["error"] = rawJSON(item.Error)
+			}
+			features[i] = feature
+		}
+		return GeoJSONFeatureCollection{Type: "FeatureCollection", Features: features}
+	case SearchResponse:
+		features := make([]GeoJSONFeature, len(v.Results))
+		for i := range v.Results {
+			features[i] = addressFeature(&v.Results[i], fields, lang)
+		}
+		return GeoJSONFeatureCollection{Type: "FeatureCollection", Features: features}
+	default:
+		panic(fmt.Sprintf("no geojson encoding for %T", v))
+	}
+}
+
+// addressFeature is the Feature of address, without a geometry or
+// properties for a nil one, such as that of a failed batch item.
+func addressFeature(address *Address, fields []string, lang string) GeoJSONFeature {
+	feature := GeoJSONFeature{Type: "Feature", Properties: map[string]json.RawMessage{}}
+	if address == nil {
+		return feature
+	}
+	if location := address.Location; location != nil {
+		feature.Geometry = &GeoJSONPoint{
+			Type:        "Point",
+			Coordinates: [2]float64{location.Coordinates.Longitude, location.Coordinates.Latitude},
+		}
+	}
+	properties := shapeAddress(address, fields, lang, "v1")
+	// the geometry already carries it
+	delete(properties, "location")
+	delete(properties, "localizacao")
+	feature.Properties = properties
+	return feature
+}
+
+func rawJSON(v any) json.RawMessage {
+	body, _ := json.Marshal(v)
+	return body
+}
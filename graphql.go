@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/relay"
+)
+
+const graphqlSchema = `
+schema {
+	query: Query
+}
+
+type Query {
+	"Resolves a single CEP, as GET /?cep= does."
+	address(cep: String!, strategy: String): Address
+	"Resolves many CEPs at once, as POST /batch does."
+	addresses(ceps: [String!]!, strategy: String): [BatchItem!]!
+	"Lists the addresses of a street."
+	searchByStreet(uf: String!, city: String!, street: String!): [Address!]!
+}
+
+type Address {
+	cep: String!
+	state: String!
+	city: String!
+	neighborhood: String!
+	street: String!
+	complement: String!
+	ibge: String!
+	ddd: String!
+	location: Location
+	provider: String!
+}
+
+type Location {
+	type: String!
+	coordinates: Coordinates!
+}
+
+type Coordinates {
+	longitude: Float!
+	latitude: Float!
+}
+
+type BatchItem {
+	input: String!
+	cep: String!
+	address: Address
+	error: String!
+	code: String
+	status: Int!
+}
+`
+
+// graphqlResolver resolves the queries over the same lookup core as the
+// HTTP API. Object fields are resolved straight from the structs.
+type graphqlResolver struct{}
+
+// graphqlError carries the code of the equivalent HTTP error response in
+// the GraphQL error extensions.
+type graphqlError struct {
+	err error
+}
+
+func (e graphqlError) Error() string {
+	return e.err.Error()
+}
+
+func (e graphqlError) Extensions() map[string]interface{} {
+	return map[string]interface{}{"code": lookupCode(e.err)}
+}
+
+func (*graphqlResolver) Address(ctx context.Context, args struct {
+	Cep      string
+	Strategy *string
+}) (*Address, error) {
+	cep, err := NormalizeCep(args.Cep)
+	if err != nil {
+		return nil, graphqlError{err}
+	}
+	strategy, err := strategyFor(deref(args.Strategy))
+	if err != nil {
+		return nil, err
+	}
+
+	address, _, err := Lookup(ctx, cep, strategy)
+	if err != nil {
+		return nil, graphqlError{err}
+	}
+	return address, nil
+}
+
+func (*graphqlResolver) Addresses(ctx context.Context, args struct {
+	Ceps     []string
+	Strategy *string
+}) ([]graphqlBatchItem, error) {
+	if len(args.Ceps) > batchMax {
+		return nil, fmt.Errorf("addresses accepts at most %d CEPs", batchMax)
+	}
+	strategy, err := strategyFor(deref(args.Strategy))
+	if err != nil {
+		return nil, err
+	}
+	items := lookupBatch(ctx, args.Ceps, strategy, nil)
+	results := make([]graphqlBatchItem, len(items))
+	for i, item := range items {
+		results[i] = graphqlBatchItem{item}
+	}
+	return results, nil
+}
+
+// graphqlBatchItem adapts BatchItem to the GraphQL types: its code as a
+// null-able string and its status as an Int.
+type graphqlBatchItem struct {
+	BatchItem
+}
+
+func (i graphqlBatchItem) Code() *string {
+	if i.BatchItem.Code == "" {
+		return nil
+	}
+	code := string(i.BatchItem.Code)
+	return &code
+}
+
+func (i graphqlBatchItem) Status() int32 {
+	return int32(i.BatchItem.Status)
+}
+
+func (*graphqlResolver) SearchByStreet(ctx context.Context, args struct {
+	Uf     string
+	City   string
+	Street string
+}) ([]Address, error) {
+	addresses, err := Search(ctx, args.Uf, args.City, args.Street)
+	if err != nil {
+		return nil, graphqlError{err}
+	}
+	return addresses, nil
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// GraphQLHandler serves POST /graphql. The schema is parsed once, at
+// startup, so a broken schema fails fast.
+func GraphQLHandler() *relay.Handler {
+	schema := graphql.MustParseSchema(graphqlSchema, &graphqlResolver{},
+		graphql.UseFieldResolvers(),
+		graphql.MaxDepth(8),
+		graphql.MaxParallelism(batchConcurrency),
+	)
+	return &relay.Handler{Schema: schema}
+}
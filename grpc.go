@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	cepv1 "github.com/liberopassadorneto/multi/proto/cep/v1"
+)
+
+// grpcServer serves CepService over the same lookup core as the HTTP API.
+type grpcServer struct {
+	cepv1.UnimplementedCepServiceServer
+}
+
+func (grpcServer) Lookup(ctx context.Context, req *cepv1.LookupRequest) (*cepv1.AddressResponse, error) {
+	cep, err := NormalizeCep(req.GetCep())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	strategy, err := strategyFor(req.GetStrategy())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	address, info, err := Lookup(ctx, cep, strategy)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return addressResponse(address, info), nil
+}
+
+func (grpcServer) BatchLookup(req *cepv1.BatchLookupRequest, stream grpc.ServerStreamingServer[cepv1.BatchLookupResponse]) error {
+	if len(req.GetCeps()) > batchMax {
+		return status.Errorf(codes.InvalidArgument, "batch accepts at most %d CEPs", batchMax)
+	}
+	strategy, err := strategyFor(req.GetStrategy())
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	ctx := stream.Context()
+	results := make(chan *cepv1.BatchLookupResponse)
+	sem := make(chan struct{}, batchConcurrency)
+	var wg sync.WaitGroup
+	for i, rawCep := range req.GetCeps() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			result := &cepv1.BatchLookupResponse{Index: int32(i), Input: rawCep, Status: http.StatusOK}
+			cep, err := NormalizeCep(rawCep)
+			var address *Address
+			var info LookupInfo
+			if err == nil {
+				address, info, err = Lookup(ctx, cep, strategy)
+			}
+			if err != nil {
+				result.Error, result.Code, result.Status = err.Error(), string(lookupCode(err)), int32(lookupStatus(err))
+			} else {
+				result.Address = addressResponse(address, info)
+			}
+
+			select {
+			case results <- result:
+			case <-ctx.Done():
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	for result := range results {
+		err := stream.Send(result)
+		if err != nil {
+			// drain so the lookups still running can exit
+			for range results {
+			}
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+func addressResponse(address *Address, info LookupInfo) *cepv1.AddressResponse {
+	response := &cepv1.AddressResponse{
+		Cep:          address.Cep,
+		State:        address.State,
+		City:         address.City,
+		Neighborhood: address.Neighborhood,
+		Street:       address.Street,
+		Complement:   address.Complement,
+		Ibge:         address.Ibge,
+		Ddd:          address.Ddd,
+		Provider:     address.Provider,
+		Cached:       info.Cached,
+		Country:      address.Country,
+		Source:       address.Source,
+		Stale:        address.Stale,
+	}
+	if location := address.Location; location != nil {
+		response.Location = &cepv1.Location{
+			Type: location.Type,
+			Coordinates: &cepv1.Coordinates{
+				Longitude: location.Coordinates.Longitude,
+				Latitude:  location.Coordinates.Latitude,
+			},
+		}
+	}
+	return response
+}
+
+// grpcError maps a Lookup error to the gRPC status matching its HTTP one.
+func grpcError(err error) error {
+	code := codes.Unavailable
+	switch {
+	case errors.Is(err, ErrInvalidCep):
+		code = codes.InvalidArgument
+	case errors.Is(err, ErrCepNotFound):
+		code = codes.NotFound
+	case errors.Is(err, ErrTimeout):
+		code = codes.DeadlineExceeded
+	case errors.Is(err, ErrOverloaded):
+		code = codes.ResourceExhausted
+	}
+	return status.Error(code, err.Error())
+}
+
+// grpcRequestID tags every call with the caller's x-request-id metadata or
+// a new one, like instrument does for HTTP requests.
+func grpcRequestID(ctx context.Context) context.Context {
+	id := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("x-request-id"); len(values) > 0 {
+			id = values[0]
+		}
+	}
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+	return withRequestID(ctx, id)
+}
+
+// grpcAuthenticate checks the x-api-key metadata of a call like
+// authenticated does the X-API-Key header, when auth is enabled, returning
+// the context of the call with the key's name.
+func grpcAuthenticate(ctx context.Context) (context.Context, error) {
+	if apiKeys == nil {
+		return ctx, nil
+	}
+	key := ""
+	if values := metadata.ValueFromIncomingContext(ctx, "x-api-key"); len(values) > 0 {
+		key = values[0]
+	}
+	name, err := authenticate(key)
+	var quotaErr *quotaError
+	switch {
+	case errors.As(err, &quotaErr):
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	case err != nil:
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return withAPIKeyName(ctx, name), nil
+}
+
+func newGRPCServer() *grpc.Server {
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			ctx, err := grpcAuthenticate(withOutboundBudget(grpcRequestID(ctx)))
+			if err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv any, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			ctx, err := grpcAuthenticate(withOutboundBudget(grpcRequestID(stream.Context())))
+			if err != nil {
+				return err
+			}
+			return handler(srv, &requestIDStream{ServerStream: stream, ctx: ctx})
+		}),
+	}
+	if serverTLS != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(serverTLS)))
+	}
+	server := grpc.NewServer(opts...)
+	cepv1.RegisterCepServiceServer(server, grpcServer{})
+	return server
+}
+
+type requestIDStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *requestIDStream) Context() context.Context {
+	return s.ctx
+}
+
+// serveGRPC runs the gRPC API on every address until ctx is done, then lets
+// the calls in flight finish for up to drain.
+func serveGRPC(ctx context.Context, addrs []string, drain time.Duration) error {
+	listeners, err := listenAll(addrs)
+	if err != nil {
+		return err
+	}
+	server := newGRPCServer()
+
+	errc := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		go func() {
+			slog.Info("grpc listening", "addr", listener.Addr().String())
+			errc <- server.Serve(listener)
+		}()
+	}
+
+	select {
+	case err := <-errc:
+		server.Stop()
+		return err
+	case <-ctx.Done():
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(drain):
+		server.Stop()
+	}
+	return nil
+}
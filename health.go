@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// statsWindow is how many recent lookups of each provider are kept for
+// the success rate and latency percentiles.
+const statsWindow = 100
+
+// ProviderStats keeps the outcome and latency of a provider's most recent
+// lookups. Not found is an answer, so it counts as a success; lookups
+// cancelled because another provider won the race are not counted.
+type ProviderStats struct {
+	mu        sync.Mutex
+	outcomes  []bool
+	next      int
+	full      bool
+	latencies *LatencyWindow
+}
+
+func NewProviderStats(size int) *ProviderStats {
+	return &ProviderStats{outcomes: make([]bool, size), latencies: NewLatencyWindow(size)}
+}
+
+func (s *ProviderStats) Record(latency time.Duration, err error) {
+	if errors.Is(err, context.Canceled) || notCalled(err) {
+		return
+	}
+	success := err == nil || errors.Is(err, ErrCepNotFound)
+
+	s.mu.Lock()
+	s.outcomes[s.next] = success
+	s.next = (s.next + 1) % len(s.outcomes)
+	if s.next == 0 {
+		s.full = true
+	}
+	s.mu.Unlock()
+
+	if success {
+		s.latencies.Record(latency)
+	}
+}
+
+// SuccessRate returns the share of successful lookups in the window and
+// how many lookups it holds.
+func (s *ProviderStats) SuccessRate() (float64, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := s.next
+	if s.full {
+		n = len(s.outcomes)
+	}
+	if n == 0 {
+		return 0, 0
+	}
+	successes := 0
+	for _, ok := range s.outcomes[:n] {
+		if ok {
+			successes++
+		}
+	}
+	return float64(successes) / float64(n), n
+}
+
+var providerStats = map[string]*ProviderStats{}
+
+type ProviderStatus struct {
+	Name        string             `json:"name"`
+	Samples     int                `json:"samples"`
+	SuccessRate *float64           `json:"success_rate,omitempty"`
+	LatencyP50  *float64           `json:"latency_p50_ms,omitempty"`
+	LatencyP95  *float64           `json:"latency_p95_ms,omitempty"`
+	HealthCheck *HealthCheckStatus `json:"health_check,omitempty"`
+	Breaker     *BreakerStatus     `json:"breaker,omitempty"`
+}
+
+// ProvidersStatusHandler reports the recent success rate, latency, health
+// check and breaker state of every enabled provider.
+func ProvidersStatusHandler(w http.ResponseWriter, r *http.Request) {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	all := slices.Concat(providers, international)
+	statuses := make([]ProviderStatus, 0, len(all))
+	for _, provider := range all {
+		status := ProviderStatus{Name: provider.Name()}
+		if stats, ok := providerStats[provider.Name()]; ok {
+			rate, samples := stats.SuccessRate()
+			status.Samples = samples
+			if samples > 0 {
+				status.SuccessRate = &rate
+			}
+			status.LatencyP50 = percentileMs(stats.latencies, 0.5)
+			status.LatencyP95 = percentileMs(stats.latencies, 0.95)
+		}
+		if health, ok := providerHealth[provider.Name()]; ok {
+			healthStatus := health.Status()
+			status.HealthCheck = &healthStatus
+		}
+		if breaker, ok := breakers[provider.Name()]; ok {
+			breakerStatus := breaker.Status()
+			status.Breaker = &breakerStatus
+		}
+		statuses = append(statuses, status)
+	}
+
+	writeJSON(w, r, http.StatusOK, statuses)
+}
+
+func percentileMs(window *LatencyWindow, p float64) *float64 {
+	d, ok := window.Percentile(p)
+	if !ok {
+		return nil
+	}
+	ms := float64(d.Microseconds()) / 1000
+	return &ms
+}
+
+type HealthStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// HealthzHandler reports that the process is up and serving.
+func HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, HealthStatus{Status: "ok"})
+}
+
+// ReadyzHandler reports whether the cache backend is reachable. The
+// in-memory cache is always ready.
+func ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	if pinger, ok := cache.(interface{ Ping(context.Context) error }); ok {
+		err := pinger.Ping(r.Context())
+		if err != nil {
+			writeJSON(w, r, http.StatusServiceUnavailable, HealthStatus{Status: "unavailable", Error: err.Error()})
+			return
+		}
+	}
+	writeJSON(w, r, http.StatusOK, HealthStatus{Status: "ready"})
+}
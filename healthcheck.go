@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ProviderHealth tracks the active health checks of a provider. It
+// becomes unhealthy after unhealthyAfter failed probes in a row and
+// healthy again after healthyAfter successful ones.
+type ProviderHealth struct {
+	provider       Provider
+	unhealthyAfter int
+	healthyAfter   int
+
+	mu        sync.Mutex
+	healthy   bool
+	failures  int
+	successes int
+	lastCheck time.Time
+	lastError string
+}
+
+func NewProviderHealth(provider Provider, unhealthyAfter, healthyAfter int) *ProviderHealth {
+	return &ProviderHealth{provider: provider, unhealthyAfter: unhealthyAfter, healthyAfter: healthyAfter, healthy: true}
+}
+
+// inherit takes over the state of old, the health of the provider h was
+// rebuilt from.
+func (h *ProviderHealth) inherit(old *ProviderHealth) {
+	old.mu.Lock()
+	defer old.mu.Unlock()
+	h.healthy, h.failures, h.successes = old.healthy, old.failures, old.successes
+	h.lastCheck, h.lastError = old.lastCheck, old.lastError
+}
+
+func (h *ProviderHealth) Healthy() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.healthy
+}
+
+// Record reports the outcome of a probe.
+func (h *ProviderHealth) Record(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastCheck = time.Now()
+	if err == nil {
+		h.lastError = ""
+		h.failures = 0
+		h.successes++
+		if !h.healthy && h.successes >= h.healthyAfter {
+			h.healthy = true
+			slog.Info("provider healthy again", "provider", h.provider.Name())
+		}
+		return
+	}
+
+	h.lastError = err.Error()
+	h.successes = 0
+	h.failures++
+	if h.healthy && h.failures >= h.unhealthyAfter {
+		h.healthy = false
+		slog.Warn("provider unhealthy, leaving it out of the races", "provider", h.provider.Name(), "error", err)
+	}
+}
+
+type HealthCheckStatus struct {
+	Healthy   bool       `json:"healthy"`
+	Failures  int        `json:"failures"`
+	LastCheck *time.Time `json:"last_check,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+func (h *ProviderHealth) Status() HealthCheckStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	status := HealthCheckStatus{Healthy: h.healthy, Failures: h.failures, LastError: h.lastError}
+	if !h.lastCheck.IsZero() {
+		lastCheck := h.lastCheck
+		status.LastCheck = &lastCheck
+	}
+	return status
+}
+
+var providerHealth = map[string]*ProviderHealth{}
+
+// runHealthChecks probes every provider with cfg.Cep each cfg.Interval
+// until ctx is done. The CEP is known to exist, so not found is a failure.
+func runHealthChecks(ctx context.Context, cfg HealthCheckConfig) {
+	cep, _ := NormalizeCep(cfg.Cep)
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		reloadMu.RLock()
+		checks := slices.Collect(maps.Values(providerHealth))
+		reloadMu.RUnlock()
+
+		var wg sync.WaitGroup
+		for _, health := range checks {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				probeCtx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+				defer cancel()
+				_, err := health.provider.Lookup(probeCtx, cep)
+				// a probe past the daily cap or the rate limit never
+				// went out
+				if ctx.Err() != nil || notCalled(err) {
+					return
+				}
+				health.Record(err)
+			}()
+		}
+		wg.Wait()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,786 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+type HistoryResult string
+
+const (
+	HistoryFound    HistoryResult = "found"
+	HistoryNotFound HistoryResult = "not_found"
+	HistoryError    HistoryResult = "error"
+)
+
+// HistoryRecord is one lookup as kept by a HistoryStore.
+type HistoryRecord struct {
+	Cep       string        `json:"cep"`
+	Provider  string        `json:"provider,omitempty"`
+	Strategy  string        `json:"strategy"`
+	LatencyMs float64       `json:"latency_ms"`
+	Result    HistoryResult `json:"result"`
+	Error     string        `json:"error,omitempty"`
+	Cached    bool          `json:"cached"`
+	Address   *Address      `json:"address,omitempty"`
+	At        time.Time     `json:"at"`
+}
+
+// HistoryStore persists lookups so they can be audited later.
+type HistoryStore interface {
+	Record(ctx context.Context, record HistoryRecord) error
+	// Query returns up to limit records of cep, newest first.
+	Query(ctx context.Context, cep string, limit int) ([]HistoryRecord, error)
+	// Export calls fn with every record from from (inclusive) to to
+	// (exclusive), oldest first, stopping at the first error of fn.
+	Export(ctx context.Context, from, to time.Time, fn func(HistoryRecord) error) error
+	// Stats summarizes the lookups from from (inclusive) to to (exclusive).
+	Stats(ctx context.Context, from, to time.Time) (*HistoryStats, error)
+	// RecordSample keeps a comparison of the providers' answers.
+	RecordSample(ctx context.Context, sample QualitySample) error
+	// Samples calls fn with every comparison from from (inclusive) to to
+	// (exclusive), stopping at the first error of fn.
+	Samples(ctx context.Context, from, to time.Time, fn func(QualitySample) error) error
+	// AddUsage adds lookups, of which cached were answered from the
+	// cache, to the usage of the API key named key in month.
+	AddUsage(ctx context.Context, key, month string, lookups, cached int) error
+	// Usage returns the usage of the API keys in month, as 2006-01.
+	Usage(ctx context.Context, month string) ([]KeyUsage, error)
+	// AddProviderCalls adds calls to the upstream calls of provider on
+	// day, as 2006-01-02.
+	AddProviderCalls(ctx context.Context, provider, day string, calls int) error
+	// ProviderCalls returns the upstream calls of the providers on day.
+	ProviderCalls(ctx context.Context, day string) (map[string]int, error)
+	// RecordChange adds a change found by the re-validation to the change
+	// log.
+	RecordChange(ctx context.Context, change AddressChange) error
+	// Changes calls fn with every change of cep, or of every CEP when
+	// empty, from from (inclusive) to to (exclusive), oldest first,
+	// stopping at the first error of fn.
+	Changes(ctx context.Context, cep string, from, to time.Time, fn func(AddressChange) error) error
+	Close() error
+}
+
+// HistoryStats summarizes the lookups of a time window. Cached lookups
+// count towards Lookups only; the providers are judged by their races.
+type HistoryStats struct {
+	From      time.Time              `json:"from"`
+	To        time.Time              `json:"to"`
+	Lookups   int                    `json:"lookups"`
+	Cached    int                    `json:"cached"`
+	Races     int                    `json:"races"`
+	NotFound  int                    `json:"not_found"`
+	Providers []ProviderHistoryStats `json:"providers"`
+}
+
+type ProviderHistoryStats struct {
+	Provider string  `json:"provider"`
+	Wins     int     `json:"wins"`
+	WinRate  float64 `json:"win_rate"`
+	Errors   int     `json:"errors"`
+	// AvgLatencyMs is the mean latency of the lookups the provider won.
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+}
+
+// sqlHistory stores the history in SQLite or Postgres, whose only
+// difference here is the placeholders and the id column.
+type sqlHistory struct {
+	db       *sql.DB
+	postgres bool
+}
+
+// historyTables are the tables migrate creates.
+var historyTables = []string{"lookups", "quality_samples", "api_key_usage", "provider_calls", "address_changes"}
+
+func OpenHistory(cfg HistoryConfig) (HistoryStore, error) {
+	db, err := openHistoryDB(cfg)
+	if err != nil {
+		return nil, err
+	}
+	h := &sqlHistory{db: db, postgres: cfg.Driver == "postgres"}
+	err = h.migrate(context.Background())
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating history schema: %w", err)
+	}
+	return h, nil
+}
+
+// openHistoryDB opens the database of cfg, without connecting yet.
+func openHistoryDB(cfg HistoryConfig) (*sql.DB, error) {
+	driver := "sqlite"
+	if cfg.Driver == "postgres" {
+		driver = "pgx"
+	}
+	db, err := sql.Open(driver, cfg.DSN)
+	if err != nil {
+		return nil, err
+	}
+	if driver == "sqlite" {
+		// SQLite takes one writer at a time
+		db.SetMaxOpenConns(1)
+	}
+	return db, nil
+}
+
+func (h *sqlHistory) migrate(ctx context.Context) error {
+	id := "INTEGER PRIMARY KEY AUTOINCREMENT"
+	if h.postgres {
+		id = "BIGSERIAL PRIMARY KEY"
+	}
+	_, err := h.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS lookups (
+		id `+id+`,
+		cep TEXT NOT NULL,
+		provider TEXT NOT NULL,
+		strategy TEXT NOT NULL,
+		latency_ms DOUBLE PRECISION NOT NULL,
+		result TEXT NOT NULL,
+		error TEXT NOT NULL,
+		cached BOOLEAN NOT NULL,
+		address TEXT,
+		created_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+	_, err = h.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS lookups_cep_created_at ON lookups (cep, created_at)`)
+	if err != nil {
+		return err
+	}
+	_, err = h.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS quality_samples (
+		id `+id+`,
+		cep TEXT NOT NULL,
+		providers TEXT NOT NULL,
+		discrepancies TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+	_, err = h.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS quality_samples_created_at ON quality_samples (created_at)`)
+	if err != nil {
+		return err
+	}
+	_, err = h.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS api_key_usage (
+		api_key TEXT NOT NULL,
+		month TEXT NOT NULL,
+		lookups BIGINT NOT NULL,
+		cached BIGINT NOT NULL,
+		PRIMARY KEY (api_key, month)
+	)`)
+	if err != nil {
+		return err
+	}
+	_, err = h.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS provider_calls (
+		provider TEXT NOT NULL,
+		day TEXT NOT NULL,
+		calls BIGINT NOT NULL,
+		PRIMARY KEY (provider, day)
+	)`)
+	if err != nil {
+		return err
+	}
+	_, err = h.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS address_changes (
+		id `+id+`,
+		cep TEXT NOT NULL,
+		fields TEXT NOT NULL,
+		previous TEXT,
+		current TEXT,
+		created_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+	_, err = h.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS address_changes_cep_created_at ON address_changes (cep, created_at)`)
+	if err != nil {
+		return err
+	}
+	_, err = h.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS address_changes_created_at ON address_changes (created_at)`)
+	return err
+}
+
+// query rewrites the $n placeholders into the ?n of SQLite.
+func (h *sqlHistory) query(q string) string {
+	if h.postgres {
+		return q
+	}
+	return strings.ReplaceAll(q, "$", "?")
+}
+
+func (h *sqlHistory) Record(ctx context.Context, record HistoryRecord) error {
+	var address sql.NullString
+	if record.Address != nil {
+		body, err := json.Marshal(record.Address)
+		if err != nil {
+			return err
+		}
+		address = sql.NullString{String: string(body), Valid: true}
+	}
+	_, err := h.db.ExecContext(ctx, h.query(`INSERT INTO lookups
+		(cep, provider, strategy, latency_ms, result, error, cached, address, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`),
+		record.Cep, record.Provider, record.Strategy, record.LatencyMs, record.Result,
+		record.Error, record.Cached, address, record.At.UTC())
+	return err
+}
+
+func (h *sqlHistory) Query(ctx context.Context, cep string, limit int) ([]HistoryRecord, error) {
+	rows, err := h.db.QueryContext(ctx, h.query(`SELECT
+		cep, provider, strategy, latency_ms, result, error, cached, address, created_at
+		FROM lookups WHERE cep = $1 ORDER BY created_at DESC, id DESC LIMIT $2`), cep, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []HistoryRecord{}
+	for rows.Next() {
+		record, err := scanHistory(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+func (h *sqlHistory) Export(ctx context.Context, from, to time.Time, fn func(HistoryRecord) error) error {
+	rows, err := h.db.QueryContext(ctx, h.query(`SELECT
+		cep, provider, strategy, latency_ms, result, error, cached, address, created_at
+		FROM lookups WHERE created_at >= $1 AND created_at < $2 ORDER BY created_at, id`), from.UTC(), to.UTC())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		record, err := scanHistory(rows)
+		if err != nil {
+			return err
+		}
+		err = fn(record)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (h *sqlHistory) Stats(ctx context.Context, from, to time.Time) (*HistoryStats, error) {
+	stats := &HistoryStats{From: from, To: to, Providers: []ProviderHistoryStats{}}
+	err := h.db.QueryRowContext(ctx, h.query(`SELECT
+		COUNT(*),
+		COALESCE(SUM(CASE WHEN cached THEN 1 ELSE 0 END), 0),
+		COALESCE(SUM(CASE WHEN result = $3 THEN 1 ELSE 0 END), 0)
+		FROM lookups WHERE created_at >= $1 AND created_at < $2`), from.UTC(), to.UTC(), HistoryNotFound,
+	).Scan(&stats.Lookups, &stats.Cached, &stats.NotFound)
+	if err != nil {
+		return nil, err
+	}
+	stats.Races = stats.Lookups - stats.Cached
+
+	rows, err := h.db.QueryContext(ctx, h.query(`SELECT
+		provider,
+		SUM(CASE WHEN result = $3 THEN 1 ELSE 0 END),
+		SUM(CASE WHEN result = $4 THEN 1 ELSE 0 END),
+		COALESCE(AVG(CASE WHEN result = $3 THEN latency_ms END), 0)
+		FROM lookups WHERE created_at >= $1 AND created_at < $2 AND NOT cached AND provider <> ''
+		GROUP BY provider ORDER BY provider`), from.UTC(), to.UTC(), HistoryFound, HistoryError)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var provider ProviderHistoryStats
+		err := rows.Scan(&provider.Provider, &provider.Wins, &provider.Errors, &provider.AvgLatencyMs)
+		if err != nil {
+			return nil, err
+		}
+		if stats.Races > 0 {
+			provider.WinRate = float64(provider.Wins) / float64(stats.Races)
+		}
+		stats.Providers = append(stats.Providers, provider)
+	}
+	return stats, rows.Err()
+}
+
+func (h *sqlHistory) RecordSample(ctx context.Context, sample QualitySample) error {
+	discrepancies, err := json.Marshal(sample.Discrepancies)
+	if err != nil {
+		return err
+	}
+	_, err = h.db.ExecContext(ctx, h.query(`INSERT INTO quality_samples
+		(cep, providers, discrepancies, created_at) VALUES ($1, $2, $3, $4)`),
+		sample.Cep, strings.Join(sample.Providers, ","), string(discrepancies), sample.At.UTC())
+	return err
+}
+
+func (h *sqlHistory) Samples(ctx context.Context, from, to time.Time, fn func(QualitySample) error) error {
+	rows, err := h.db.QueryContext(ctx, h.query(`SELECT cep, providers, discrepancies, created_at
+		FROM quality_samples WHERE created_at >= $1 AND created_at < $2 ORDER BY created_at, id`), from.UTC(), to.UTC())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sample QualitySample
+		var providers, discrepancies string
+		err := rows.Scan(&sample.Cep, &providers, &discrepancies, &sample.At)
+		if err != nil {
+			return err
+		}
+		sample.Providers = strings.Split(providers, ",")
+		err = json.Unmarshal([]byte(discrepancies), &sample.Discrepancies)
+		if err != nil {
+			return err
+		}
+		err = fn(sample)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (h *sqlHistory) AddUsage(ctx context.Context, key, month string, lookups, cached int) error {
+	_, err := h.db.ExecContext(ctx, h.query(`INSERT INTO api_key_usage (api_key, month, lookups, cached)
+		VALUES ($1, $2, $3, $4) ON CONFLICT (api_key, month) DO UPDATE SET
+		lookups = api_key_usage.lookups + excluded.lookups, cached = api_key_usage.cached + excluded.cached`),
+		key, month, lookups, cached)
+	return err
+}
+
+func (h *sqlHistory) Usage(ctx context.Context, month string) ([]KeyUsage, error) {
+	rows, err := h.db.QueryContext(ctx, h.query(`SELECT api_key, lookups, cached
+		FROM api_key_usage WHERE month = $1 ORDER BY api_key`), month)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usage := []KeyUsage{}
+	for rows.Next() {
+		record := KeyUsage{Month: month}
+		err := rows.Scan(&record.Key, &record.Lookups, &record.Cached)
+		if err != nil {
+			return nil, err
+		}
+		usage = append(usage, record)
+	}
+	return usage, rows.Err()
+}
+
+func (h *sqlHistory) AddProviderCalls(ctx context.Context, provider, day string, calls int) error {
+	_, err := h.db.ExecContext(ctx, h.query(`INSERT INTO provider_calls (provider, day, calls)
+		VALUES ($1, $2, $3) ON CONFLICT (provider, day) DO UPDATE SET
+		calls = provider_calls.calls + excluded.calls`),
+		provider, day, calls)
+	return err
+}
+
+func (h *sqlHistory) ProviderCalls(ctx context.Context, day string) (map[string]int, error) {
+	rows, err := h.db.QueryContext(ctx, h.query(`SELECT provider, calls FROM provider_calls WHERE day = $1`), day)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	calls := map[string]int{}
+	for rows.Next() {
+		var provider string
+		var count int
+		err := rows.Scan(&provider, &count)
+		if err != nil {
+			return nil, err
+		}
+		calls[provider] = count
+	}
+	return calls, rows.Err()
+}
+
+func (h *sqlHistory) RecordChange(ctx context.Context, change AddressChange) error {
+	fields, err := json.Marshal(change.Fields)
+	if err != nil {
+		return err
+	}
+	previous, err := nullJSON(change.Previous)
+	if err != nil {
+		return err
+	}
+	current, err := nullJSON(change.Current)
+	if err != nil {
+		return err
+	}
+	_, err = h.db.ExecContext(ctx, h.query(`INSERT INTO address_changes
+		(cep, fields, previous, current, created_at) VALUES ($1, $2, $3, $4, $5)`),
+		change.Cep, string(fields), previous, current, change.At.UTC())
+	return err
+}
+
+func (h *sqlHistory) Changes(ctx context.Context, cep string, from, to time.Time, fn func(AddressChange) error) error {
+	rows, err := h.db.QueryContext(ctx, h.query(`SELECT cep, fields, previous, current, created_at
+		FROM address_changes WHERE ($1 = '' OR cep = $1) AND created_at >= $2 AND created_at < $3
+		ORDER BY created_at, id`), cep, from.UTC(), to.UTC())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var change AddressChange
+		var fields string
+		var previous, current sql.NullString
+		err := rows.Scan(&change.Cep, &fields, &previous, &current, &change.At)
+		if err != nil {
+			return err
+		}
+		err = json.Unmarshal([]byte(fields), &change.Fields)
+		if err != nil {
+			return err
+		}
+		change.Previous, err = addressJSON(previous)
+		if err != nil {
+			return err
+		}
+		change.Current, err = addressJSON(current)
+		if err != nil {
+			return err
+		}
+		err = fn(change)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// nullJSON encodes address for a nullable column, NULL when nil.
+func nullJSON(address *Address) (sql.NullString, error) {
+	if address == nil {
+		return sql.NullString{}, nil
+	}
+	body, err := json.Marshal(address)
+	return sql.NullString{String: string(body), Valid: true}, err
+}
+
+func addressJSON(column sql.NullString) (*Address, error) {
+	if !column.Valid {
+		return nil, nil
+	}
+	address := &Address{}
+	return address, json.Unmarshal([]byte(column.String), address)
+}
+
+func scanHistory(rows *sql.Rows) (HistoryRecord, error) {
+	var record HistoryRecord
+	var address sql.NullString
+	err := rows.Scan(&record.Cep, &record.Provider, &record.Strategy, &record.LatencyMs, &record.Result,
+		&record.Error, &record.Cached, &address, &record.At)
+	if err != nil {
+		return record, err
+	}
+	if address.Valid {
+		record.Address = &Address{}
+		err = json.Unmarshal([]byte(address.String), record.Address)
+	}
+	return record, err
+}
+
+func (h *sqlHistory) Close() error {
+	return h.db.Close()
+}
+
+// historyQueue buffers the records so that a slow database never holds up
+// the lookups; records are dropped when it is full.
+const historyQueue = 1024
+
+var (
+	history        HistoryStore
+	historyRecords chan HistoryRecord
+	historyDone    chan struct{}
+
+	// historyMu guards the sends on historyRecords against closeHistory:
+	// job workers and webhook deliveries may still finish lookups after
+	// the server stopped.
+	historyMu     sync.RWMutex
+	historyClosed bool
+)
+
+func setupHistory(cfg HistoryConfig) {
+	if cfg.Driver == "" {
+		return
+	}
+	store, err := OpenHistory(cfg)
+	if err != nil {
+		fatal("error opening history", err)
+	}
+	history = store
+	historyRecords = make(chan HistoryRecord, historyQueue)
+	historyDone = make(chan struct{})
+	go func() {
+		defer close(historyDone)
+		for record := range historyRecords {
+			err := history.Record(context.Background(), record)
+			if err != nil {
+				slog.Error("error recording lookup history", "cep", record.Cep, "error", err)
+			}
+		}
+	}()
+}
+
+// closeHistory writes the records still queued and closes the store.
+func closeHistory() {
+	if history == nil {
+		return
+	}
+	historyMu.Lock()
+	historyClosed = true
+	close(historyRecords)
+	historyMu.Unlock()
+	<-historyDone
+	err := history.Close()
+	if err != nil {
+		slog.Error("error closing history", "error", err)
+	}
+}
+
+// newLookupRecord describes a completed lookup, for the history and the
+// event bus.
+func newLookupRecord(cep string, strategy string, address *Address, info LookupInfo, latency float64, err error) HistoryRecord {
+	record := HistoryRecord{
+		Cep:       cep,
+		Strategy:  strategy,
+		LatencyMs: latency,
+		Result:    HistoryFound,
+		Cached:    info.Cached,
+		Address:   address,
+		At:        time.Now(),
+	}
+	switch {
+	case address != nil:
+		record.Provider = address.Provider
+	case errors.Is(err, ErrCepNotFound):
+		record.Result = HistoryNotFound
+	default:
+		record.Result = HistoryError
+		record.Error = err.Error()
+		var providerErr *ProviderError
+		if errors.As(err, &providerErr) {
+			record.Provider = providerErr.Provider
+		}
+	}
+	return record
+}
+
+// recordHistory queues a lookup for the history, when enabled and not
+// closed yet.
+func recordHistory(ctx context.Context, record HistoryRecord) {
+	if history == nil {
+		return
+	}
+	historyMu.RLock()
+	defer historyMu.RUnlock()
+	if historyClosed {
+		slog.WarnContext(ctx, "history closed, lookup not recorded")
+		return
+	}
+	select {
+	case historyRecords <- record:
+	default:
+		slog.WarnContext(ctx, "history queue full, lookup not recorded")
+	}
+}
+
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 500
+)
+
+type HistoryResponse struct {
+	Cep     string          `json:"cep"`
+	Records []HistoryRecord `json:"records"`
+}
+
+// HistoryHandler serves GET /history?cep= with the past lookups of a CEP,
+// newest first.
+func HistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if history == nil {
+		writeJSONError(w, r, http.StatusServiceUnavailable, CodeUnavailable, "history is not enabled")
+		return
+	}
+
+	queryParams := r.URL.Query()
+	rawCep := queryParams.Get("cep")
+	if rawCep == "" {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, "missing 'cep' query parameter")
+		return
+	}
+	cep, err := NormalizeCep(rawCep)
+	if err != nil {
+		writeJSONError(w, r, http.StatusUnprocessableEntity, CodeInvalidCep, err.Error())
+		return
+	}
+	limit, err := intParam(queryParams.Get("limit"), defaultHistoryLimit)
+	if err != nil || limit < 1 || limit > maxHistoryLimit {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, "limit must be between 1 and "+strconv.Itoa(maxHistoryLimit))
+		return
+	}
+
+	records, err := history.Query(r.Context(), cep, limit)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error querying history", "error", err)
+		writeJSONError(w, r, http.StatusInternalServerError, CodeUnavailable, "error querying history")
+		return
+	}
+	writeJSON(w, r, http.StatusOK, HistoryResponse{Cep: cep, Records: records})
+}
+
+// defaultHistoryWindow is how far back exports and stats go without from.
+const defaultHistoryWindow = 24 * time.Hour
+
+// timeWindow reads the from and to query parameters, as RFC 3339 times or
+// dates, or to and a window duration. To defaults to now and from to a
+// day before to.
+func timeWindow(queryParams url.Values) (time.Time, time.Time, error) {
+	to := time.Now()
+	if raw := queryParams.Get("to"); raw != "" {
+		t, err := parseTime(raw)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid 'to': %w", err)
+		}
+		to = t
+	}
+
+	from := to.Add(-defaultHistoryWindow)
+	if raw := queryParams.Get("window"); raw != "" {
+		window, err := time.ParseDuration(raw)
+		if err != nil || window <= 0 {
+			return time.Time{}, time.Time{}, errors.New("window must be a positive duration, e.g. 1h")
+		}
+		from = to.Add(-window)
+	}
+	if raw := queryParams.Get("from"); raw != "" {
+		t, err := parseTime(raw)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid 'from': %w", err)
+		}
+		from = t
+	}
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, errors.New("'from' must be before 'to'")
+	}
+	return from, to, nil
+}
+
+func parseTime(raw string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, raw); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, raw)
+}
+
+var historyColumns = []string{"at", "cep", "provider", "strategy", "result", "error", "cached", "latency_ms",
+	"state", "city", "neighborhood", "street"}
+
+// HistoryExportHandler serves GET /history/export?from=&to=&format=, streaming
+// the records of the window as CSV or, with format=json, JSON lines.
+func HistoryExportHandler(w http.ResponseWriter, r *http.Request) {
+	if history == nil {
+		writeJSONError(w, r, http.StatusServiceUnavailable, CodeUnavailable, "history is not enabled")
+		return
+	}
+
+	queryParams := r.URL.Query()
+	from, to, err := timeWindow(queryParams)
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	var write func(HistoryRecord) error
+	var flush func() error
+	switch format := queryParams.Get("format"); format {
+	case "", "csv":
+		out := csv.NewWriter(w)
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="history.csv"`)
+		err = out.Write(historyColumns)
+		write = func(record HistoryRecord) error {
+			columns := []string{
+				record.At.UTC().Format(time.RFC3339Nano), record.Cep, record.Provider, record.Strategy,
+				string(record.Result), record.Error, strconv.FormatBool(record.Cached),
+				strconv.FormatFloat(record.LatencyMs, 'f', -1, 64),
+			}
+			if a := record.Address; a != nil {
+				columns = append(columns, a.State, a.City, a.Neighborhood, a.Street)
+			} else {
+				columns = append(columns, "", "", "", "")
+			}
+			return out.Write(columns)
+		}
+		flush = func() error {
+			out.Flush()
+			return out.Error()
+		}
+	case "json":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		encoder := json.NewEncoder(w)
+		write = func(record HistoryRecord) error {
+			return encoder.Encode(record)
+		}
+		flush = func() error { return nil }
+	default:
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("unknown format %q", format))
+		return
+	}
+
+	// the status is sent with the first row, so failures past it can
+	// only be logged
+	if err == nil {
+		err = history.Export(r.Context(), from, to, write)
+	}
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error exporting history", "error", err)
+	}
+}
+
+// StatsHandler serves GET /stats?from=&to= (or ?window=1h) with the win
+// rate, average latency and errors of every provider over the window.
+func StatsHandler(w http.ResponseWriter, r *http.Request) {
+	if history == nil {
+		writeJSONError(w, r, http.StatusServiceUnavailable, CodeUnavailable, "history is not enabled")
+		return
+	}
+
+	from, to, err := timeWindow(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	stats, err := history.Stats(r.Context(), from, to)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error computing history stats", "error", err)
+		writeJSONError(w, r, http.StatusInternalServerError, CodeUnavailable, "error computing history stats")
+		return
+	}
+	writeJSON(w, r, http.StatusOK, stats)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// addressETag is a weak validator of the address itself in format:
+// answers from different providers, or stale ones, with the same content
+// share it.
+func addressETag(address *Address, format string) string {
+	normalized := *address
+	normalized.Provider, normalized.Stale = "", false
+	body, _ := json.Marshal(normalized)
+	sum := sha256.Sum256(append(body, format...))
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether the If-None-Match list holds etag, compared
+// weakly as RFC 9110 requires for it.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// writeAddress answers a lookup with the headers letting CDNs and browsers
+// cache it, or with 304 when the client already has it. Stale and offline
+// answers must be revalidated, as a fresher one may be available soon.
+func writeAddress(w http.ResponseWriter, r *http.Request, format string, address *Address, info LookupInfo) {
+	lang, _ := responseLang(r)
+	schema, _ := responseSchema(r)
+	etag := addressETag(address, format+lang+schema+r.URL.Query().Get("fields"))
+	w.Header().Set("ETag", etag)
+	if info.Stale || address.Source == "offline" || config.Cache.MaxAge == 0 {
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(config.Cache.MaxAge.Seconds())))
+	}
+	if !info.FetchedAt.IsZero() {
+		w.Header().Set("Last-Modified", info.FetchedAt.UTC().Format(http.TimeFormat))
+	}
+
+	if match := r.Header.Get("If-None-Match"); match != "" && etagMatches(match, etag) {
+		w.Header().Add("Vary", "Accept")
+		w.Header().Add("Vary", "Accept-Language")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if format == "protobuf" {
+		writeFormatted(w, r, http.StatusOK, format, addressResponse(address, info))
+		return
+	}
+	writeFormatted(w, r, http.StatusOK, format, address)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/liberopassadorneto/multi/pkg/cep"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// NewHTTPClient builds the client shared by every provider, so connections
+// to the upstreams are pooled and reused across lookups. Deadlines come
+// from the lookup context, so the client itself has no overall timeout.
+func NewHTTPClient(cfg HTTPClientConfig) (*http.Client, error) {
+	dialer := &net.Dialer{
+		Timeout:   cfg.DialTimeout,
+		KeepAlive: cfg.KeepAlive,
+	}
+
+	proxy, err := proxyFunc(cfg.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	transport := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.MaxConnsPerHost,
+		IdleConnTimeout:       cfg.IdleConnTimeout,
+		TLSHandshakeTimeout:   cfg.TLSHandshakeTimeout,
+		ExpectContinueTimeout: cfg.ExpectContinueTimeout,
+		DisableKeepAlives:     cfg.DisableKeepAlives,
+		ForceAttemptHTTP2:     cfg.HTTP2,
+	}
+	if cfg.DNSCacheTTL > 0 {
+		transport.DialContext = newDNSCache(cfg.DNSCacheTTL, dialer).DialContext
+	}
+	if len(cfg.CAFiles) > 0 {
+		roots, err := rootCAs(cfg.CAFiles)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+// proxyFunc resolves a proxy setting: empty honors HTTP_PROXY, HTTPS_PROXY
+// and NO_PROXY, "direct" bypasses any proxy and anything else is the URL
+// of the proxy every request goes through.
+func proxyFunc(value string) (func(*http.Request) (*url.URL, error), error) {
+	switch value {
+	case "":
+		return http.ProxyFromEnvironment, nil
+	case "direct":
+		return nil, nil
+	}
+	proxyURL, err := url.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("proxy %q must be an http, https or socks5 URL, or direct", value)
+	}
+	if proxyURL.Host == "" {
+		return nil, fmt.Errorf("proxy %q has no host", value)
+	}
+	return http.ProxyURL(proxyURL), nil
+}
+
+// rootCAs is the system pool with the PEM certificates of files added, for
+// upstreams behind an internal CA such as a TLS inspecting proxy.
+func rootCAs(files []string) (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	for _, file := range files {
+		pem, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no PEM certificate found", file)
+		}
+	}
+	return pool, nil
+}
+
+// providerClient is client going through the proxy of the provider's
+// settings, when it overrides the shared one, failing over to its mirrors
+// and adding the provider's headers and credentials and the correlation
+// headers to its requests. A provider with its own proxy gets a pool of
+// connections of its own.
+func providerClient(client *http.Client, settings ProviderConfig) *http.Client {
+	if transport, ok := client.Transport.(*http.Transport); settings.Proxy != "" && ok {
+		// Validate has checked the setting
+		proxy, _ := proxyFunc(settings.Proxy)
+		transport = transport.Clone()
+		transport.Proxy = proxy
+		client = &http.Client{Transport: transport}
+	}
+	if len(settings.Mirrors) > 0 {
+		bases := append([]string{settings.URL}, settings.Mirrors...)
+		client = &http.Client{Transport: &mirrorTransport{bases: bases, transport: client.Transport}}
+	}
+	if header := providerHeader(settings); len(header) > 0 {
+		client = &http.Client{Transport: &headerTransport{header: header, transport: client.Transport}}
+	}
+	return correlatedClient(client)
+}
+
+// correlatedClient is client tagging the requests it makes on behalf of
+// an inbound request, as correlationTransport does, and holding them to
+// the request's outbound budget.
+func correlatedClient(client *http.Client) *http.Client {
+	return &http.Client{Transport: &correlationTransport{transport: &budgetTransport{transport: client.Transport}}}
+}
+
+// budgetTransport refuses the requests past the outbound budget of the
+// inbound request they are made for, retries and hedges included.
+type budgetTransport struct {
+	transport http.RoundTripper
+}
+
+func (t *budgetTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !spendBudget(req.Context()) {
+		slog.DebugContext(req.Context(), "upstream request refused", "host", req.URL.Host, "path", req.URL.Path,
+			"error", ErrBudgetExhausted)
+		return nil, ErrBudgetExhausted
+	}
+	transport := t.transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return transport.RoundTrip(req)
+}
+
+// correlationTransport sends, on the upstream requests made for an inbound
+// request, its X-Request-ID and, when it is part of a trace, its W3C
+// traceparent, and logs them, so that a complaint about a request can be
+// matched with the exact upstream calls it made.
+type correlationTransport struct {
+	transport http.RoundTripper
+}
+
+func (t *correlationTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := t.transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	ctx := req.Context()
+	id := requestID(ctx)
+	if id == "" {
+		return transport.RoundTrip(req)
+	}
+
+	req = req.Clone(ctx)
+	if req.Header.Get("X-Request-ID") == "" {
+		req.Header.Set("X-Request-ID", id)
+	}
+	propagation.TraceContext{}.Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	start := time.Now()
+	response, err := transport.RoundTrip(req)
+	attrs := []any{"method", req.Method, "host", req.URL.Host, "path", req.URL.Path,
+		"latency_ms", float64(time.Since(start).Microseconds()) / 1000}
+	if traceparent := req.Header.Get("traceparent"); traceparent != "" {
+		attrs = append(attrs, "traceparent", traceparent)
+	}
+	if err != nil {
+		slog.DebugContext(ctx, "upstream request failed", append(attrs, "error", err)...)
+		return nil, err
+	}
+	slog.DebugContext(ctx, "upstream request", append(attrs, "status", response.StatusCode)...)
+	return response, nil
+}
+
+// providerHeader is the headers of settings with its bearer token or basic
+// auth credentials, if any, as their Authorization header.
+func providerHeader(settings ProviderConfig) http.Header {
+	header := http.Header{}
+	for name, value := range settings.Headers {
+		header.Set(name, value)
+	}
+	if settings.Token != "" {
+		header.Set("Authorization", "Bearer "+settings.Token)
+	} else if settings.Username != "" {
+		req := &http.Request{Header: http.Header{}}
+		req.SetBasicAuth(settings.Username, settings.Password)
+		header.Set("Authorization", req.Header.Get("Authorization"))
+	}
+	return header
+}
+
+// headerTransport adds header to the requests, leaving alone the headers a
+// provider set itself.
+type headerTransport struct {
+	header    http.Header
+	transport http.RoundTripper
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for name, values := range t.header {
+		if _, ok := req.Header[name]; !ok {
+			req.Header[name] = values
+		}
+	}
+	transport := t.transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return transport.RoundTrip(req)
+}
+
+// recordingClient records the responses of the named provider to the
+// recording directory, or answers its requests from them, as the
+// recording mode asks.
+func recordingClient(cfg RecordingConfig, name string, client *http.Client) *http.Client {
+	switch cfg.Mode {
+	case "record":
+		return &http.Client{Transport: &cep.RecordingTransport{Dir: cfg.Dir, Name: name, Transport: client.Transport}}
+	case "replay":
+		return &http.Client{Transport: &cep.ReplayTransport{Dir: cfg.Dir, Name: name}}
+	}
+	return client
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/liberopassadorneto/multi/pkg/cep"
+)
+
+// internationalNames are the providers of non-Brazilian postal codes.
+// They are configured like the CEP providers but cannot be enabled as one.
+var internationalNames = []string{"zippopotam"}
+
+// international serves the lookups of /lookup outside Brazil.
+var international []Provider
+
+// NewInternationalProviders builds the providers of non-Brazilian postal
+// codes with the same wrappers as the CEP ones. It must run after
+// NewProviders, which resets their registries.
+func NewInternationalProviders(cfg Config, client *http.Client) []Provider {
+	// the probes look up HEALTH_CHECK_CEP, which is no key of theirs
+	cfg.HealthCheck.Enabled = false
+
+	zippopotam := cep.NewZippopotamProvider()
+	settings := cfg.Provider("zippopotam")
+	zippopotam.Client = providerClient(client, settings)
+	if settings.URL != "" {
+		zippopotam.BaseURL = settings.URL
+	}
+	return []Provider{wrapProvider(cfg, "zippopotam", zippopotam)}
+}
+
+// LookupHandler serves GET /lookup?country=US&code=90210. Brazil, the
+// default country, is answered exactly like /?cep=.
+func LookupHandler(w http.ResponseWriter, r *http.Request) {
+	queryParams := r.URL.Query()
+	country, code := queryParams.Get("country"), queryParams.Get("code")
+	if code == "" {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, "missing 'code' query parameter")
+		return
+	}
+
+	if country == "" || strings.EqualFold(country, "BR") {
+		r = r.Clone(r.Context())
+		queryParams.Set("cep", code)
+		r.URL.RawQuery = queryParams.Encode()
+		FetchBothHandler(w, r)
+		return
+	}
+
+	key, err := cep.NormalizePostalCode(country, code)
+	if err != nil {
+		writeJSONError(w, r, http.StatusUnprocessableEntity, CodeInvalidCep, err.Error())
+		return
+	}
+	strategy := queryParams.Get("strategy")
+	if strategy == "" {
+		// quorum could never be reached with a single provider
+		strategy = "fastest"
+	}
+	strategy, err = strategyFor(strategy)
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	format, err := responseFormat(r)
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	if err := checkResponseShape(r, format); err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	ctx, err := withTimeoutParam(r)
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	address, info, err := lookupWith(ctx, currentInternational(), key, strategy)
+	setCacheHeader(w, info)
+	if err != nil {
+		writeLookupError(w, r, err)
+		return
+	}
+
+	setRequestProvider(r.Context(), address.Provider)
+	writeAddress(w, r, format, address, info)
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type JobStatus string
+
+const (
+	JobQueued  JobStatus = "queued"
+	JobRunning JobStatus = "running"
+	JobDone    JobStatus = "done"
+)
+
+var ErrJobNotFound = errors.New("job not found")
+
+type jobRow struct {
+	record  []string
+	address *Address
+	err     error
+}
+
+// Job is a CSV of CEPs being enriched in the background. Rows keep their
+// original columns and get the resolved address appended.
+type Job struct {
+	mu         sync.Mutex
+	id         string
+	status     JobStatus
+	header     []string
+	rows       []jobRow
+	processed  int
+	failed     int
+	createdAt  time.Time
+	finishedAt time.Time
+
+	// completed lists the rows in the order they finished, for the event
+	// streams, and changed is closed and replaced every time it grows
+	completed []int
+	changed   chan struct{}
+}
+
+// JobRowEvent reports a finished row of a job.
+type JobRowEvent struct {
+	Row     int      `json:"row"`
+	Cep     string   `json:"cep"`
+	Address *Address `json:"address,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+type JobProgress struct {
+	ID         string     `json:"id"`
+	Status     JobStatus  `json:"status"`
+	Total      int        `json:"total"`
+	Processed  int        `json:"processed"`
+	Failed     int        `json:"failed"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+func (j *Job) Progress() JobProgress {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	progress := JobProgress{
+		ID:        j.id,
+		Status:    j.status,
+		Total:     len(j.rows),
+		Processed: j.processed,
+		Failed:    j.failed,
+		CreatedAt: j.createdAt,
+	}
+	if j.status == JobDone {
+		finishedAt := j.finishedAt
+		progress.FinishedAt = &finishedAt
+	}
+	return progress
+}
+
+func (j *Job) complete(row int, address *Address, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.rows[row].address = address
+	j.rows[row].err = err
+	j.processed++
+	if err != nil {
+		j.failed++
+	}
+	if j.processed == len(j.rows) {
+		j.status = JobDone
+		j.finishedAt = time.Now()
+	}
+	j.completed = append(j.completed, row)
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// eventsSince returns the rows finished after the first cursor ones, the
+// new cursor, whether the job is done, and a channel closed when more rows
+// finish.
+func (j *Job) eventsSince(cursor int) ([]JobRowEvent, int, bool, <-chan struct{}) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	cursor = min(cursor, len(j.completed))
+	events := make([]JobRowEvent, 0, len(j.completed)-cursor)
+	for _, row := range j.completed[cursor:] {
+		event := JobRowEvent{Row: row, Cep: firstColumn(j.rows[row].record), Address: j.rows[row].address}
+		if err := j.rows[row].err; err != nil {
+			event.Error = err.Error()
+		}
+		events = append(events, event)
+	}
+	return events, len(j.completed), j.status == JobDone, j.changed
+}
+
+// WriteCSV writes the enriched CSV. It must only be called once the job
+// is done.
+func (j *Job) WriteCSV(w io.Writer) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	out := csv.NewWriter(w)
+	enriched := []string{"cep", "state", "city", "neighborhood", "street", "provider", "error"}
+	if j.header != nil {
+		err := out.Write(append(append([]string{}, j.header...), prefixed("multi_", enriched)...))
+		if err != nil {
+			return err
+		}
+	}
+	for _, row := range j.rows {
+		columns := make([]string, len(enriched))
+		if row.address != nil {
+			a := row.address
+			copy(columns, []string{a.Cep, a.State, a.City, a.Neighborhood, a.Street, a.Provider})
+		}
+		if row.err != nil {
+			columns[len(columns)-1] = row.err.Error()
+		}
+		err := out.Write(append(append([]string{}, row.record...), columns...))
+		if err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+func prefixed(prefix string, names []string) []string {
+	out := make([]string, len(names))
+	for i, name := range names {
+		out[i] = prefix + name
+	}
+	return out
+}
+
+type jobTask struct {
+	job *Job
+	row int
+}
+
+// JobManager runs jobs on a fixed pool of workers shared by every job,
+// with the providers throttled so large files do not hammer the upstreams.
+type JobManager struct {
+	mu        sync.Mutex
+	jobs      map[string]*Job
+	tasks     chan jobTask
+	providers []Provider
+	strategy  string
+	retention time.Duration
+}
+
+func NewJobManager(providers []Provider, strategy string, workers int, retention time.Duration) *JobManager {
+	m := &JobManager{
+		jobs:      make(map[string]*Job),
+		tasks:     make(chan jobTask),
+		providers: providers,
+		strategy:  strategy,
+		retention: retention,
+	}
+	for i := 0; i < workers; i++ {
+		go m.work()
+	}
+	return m
+}
+
+func (m *JobManager) work() {
+	for task := range m.tasks {
+		task.job.mu.Lock()
+		task.job.status = JobRunning
+		cep := firstColumn(task.job.rows[task.row].record)
+		task.job.mu.Unlock()
+
+		normalized, err := NormalizeCep(cep)
+		if err != nil {
+			task.job.complete(task.row, nil, err)
+			continue
+		}
+		m.mu.Lock()
+		providers := m.providers
+		m.mu.Unlock()
+		address, _, err := lookupWith(lookupsCtx, providers, normalized, m.strategy)
+		task.job.complete(task.row, address, err)
+	}
+}
+
+// SetProviders replaces the providers of the rows not started yet.
+func (m *JobManager) SetProviders(providers []Provider) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.providers = providers
+}
+
+// Submit parses a CSV whose first column holds the CEPs and queues a row
+// per CEP. A first row that does not start with a CEP is kept as header.
+func (m *JobManager) Submit(r io.Reader) (*Job, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid csv: %w", err)
+	}
+
+	job := &Job{id: newJobID(), status: JobQueued, createdAt: time.Now(), changed: make(chan struct{})}
+	if len(records) > 0 {
+		if _, err := NormalizeCep(firstColumn(records[0])); err != nil {
+			job.header, records = records[0], records[1:]
+		}
+	}
+	if len(records) == 0 {
+		return nil, errors.New("csv has no CEPs")
+	}
+	job.rows = make([]jobRow, len(records))
+	for i, record := range records {
+		job.rows[i].record = record
+	}
+
+	m.mu.Lock()
+	m.purge()
+	m.jobs[job.id] = job
+	m.mu.Unlock()
+
+	go func() {
+		for row := range job.rows {
+			m.tasks <- jobTask{job: job, row: row}
+		}
+	}()
+	return job, nil
+}
+
+func (m *JobManager) Get(id string) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	return job, nil
+}
+
+// purge forgets finished jobs older than the retention. m.mu must be held.
+func (m *JobManager) purge() {
+	for id, job := range m.jobs {
+		progress := job.Progress()
+		if progress.FinishedAt != nil && time.Since(*progress.FinishedAt) > m.retention {
+			delete(m.jobs, id)
+		}
+	}
+}
+
+func firstColumn(record []string) string {
+	if len(record) == 0 {
+		return ""
+	}
+	return record[0]
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+var (
+	jobs          *JobManager
+	jobsMaxUpload int64
+)
+
+// JobsHandler accepts a CSV upload, either as the "file" field of a
+// multipart form or as the raw request body, and starts a job for it.
+func JobsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSONError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, jobsMaxUpload)
+	var upload io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, "missing 'file' form field")
+			return
+		}
+		defer file.Close()
+		upload = file
+	}
+
+	job, err := jobs.Submit(upload)
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	w.Header().Set("Location", "/jobs/"+job.id)
+	writeJSON(w, r, http.StatusAccepted, job.Progress())
+}
+
+// JobHandler serves /jobs/{id} with the progress, /jobs/{id}/result with
+// the enriched CSV once the job is done and /jobs/{id}/events with its
+// progress as Server-Sent Events.
+func JobHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSONError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	id, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/jobs/"), "/")
+	job, err := jobs.Get(id)
+	if err != nil {
+		writeJSONError(w, r, http.StatusNotFound, CodeNotFound, err.Error())
+		return
+	}
+
+	switch rest {
+	case "":
+		writeJSON(w, r, http.StatusOK, job.Progress())
+	case "result":
+		if job.Progress().Status != JobDone {
+			writeJSONError(w, r, http.StatusConflict, CodeConflict, "job is not done yet")
+			return
+		}
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", job.id+".csv"))
+		err = job.WriteCSV(w)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "error writing job result", "job", job.id, "error", err)
+		}
+	case "events":
+		streamJobEvents(w, r, job)
+	default:
+		writeJSONError(w, r, http.StatusNotFound, CodeNotFound, "not found")
+	}
+}
+
+// streamJobEvents sends a row event for every finished row, starting with
+// the ones finished before the client connected, and a done event with
+// the final progress. Event ids count the rows sent, so a client resuming
+// with Last-Event-ID only gets the rows it missed.
+func streamJobEvents(w http.ResponseWriter, r *http.Request, job *Job) {
+	cursor, err := intParam(r.Header.Get("Last-Event-ID"), 0)
+	if err != nil || cursor < 0 {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, "Last-Event-ID must be a row count")
+		return
+	}
+
+	stream := newEventStream(w)
+	for {
+		events, next, done, changed := job.eventsSince(cursor)
+		for i, event := range events {
+			err := stream.Send(strconv.Itoa(cursor+i+1), "row", event)
+			if err != nil {
+				return
+			}
+		}
+		cursor = next
+		if done {
+			_ = stream.Send("", "done", job.Progress())
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
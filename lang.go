@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// portugueseKeys names the address fields, and those of its location and
+// municipality, as ViaCep and the other Brazilian APIs do. The keys left
+// out are the same in both languages.
+var portugueseKeys = map[string]string{
+	"country":      "pais",
+	"state":        "uf",
+	"city":         "localidade",
+	"neighborhood": "bairro",
+	"street":       "logradouro",
+	"complement":   "complemento",
+	"location":     "localizacao",
+	"type":         "tipo",
+	"coordinates":  "coordenadas",
+	"provider":     "provedor",
+	"source":       "fonte",
+	"stale":        "desatualizado",
+	"municipality": "municipio",
+	"name":         "nome",
+	"microregion":  "microrregiao",
+	"mesoregion":   "mesorregiao",
+	"region":       "regiao",
+}
+
+var langMatcher = language.NewMatcher([]language.Tag{language.English, language.Portuguese})
+
+// responseLang picks the language of the keys of the addresses answered
+// to r: ?lang=en or pt, else the preferred of the Accept-Language ones,
+// else English.
+func responseLang(r *http.Request) (string, error) {
+	if lang := r.URL.Query().Get("lang"); lang != "" {
+		switch strings.ToLower(lang) {
+		case "en":
+			return "en", nil
+		case "pt", "pt-br":
+			return "pt", nil
+		}
+		return "", fmt.Errorf("unknown lang %q, expected en or pt", lang)
+	}
+
+	tags, _, err := language.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	if err != nil || len(tags) == 0 {
+		return "en", nil
+	}
+	_, index, confidence := langMatcher.Match(tags...)
+	if confidence == language.No || index == 0 {
+		return "en", nil
+	}
+	return "pt", nil
+}
+
+// localizeObject renames the keys of object, and of the objects nested in
+// it, to Portuguese.
+func localizeObject(object map[string]json.RawMessage) map[string]json.RawMessage {
+	localized := make(map[string]json.RawMessage, len(object))
+	for key, value := range object {
+		var nested map[string]json.RawMessage
+		if len(value) > 0 && value[0] == '{' && json.Unmarshal(value, &nested) == nil {
+			value, _ = json.Marshal(localizeObject(nested))
+		}
+		if name, ok := portugueseKeys[key]; ok {
+			key = name
+		}
+		localized[key] = value
+	}
+	return localized
+}
+
+// localizeRecords renames the columns of the header of records to
+// Portuguese.
+func localizeRecords(records [][]string) [][]string {
+	for i, column := range records[0] {
+		if name, ok := portugueseKeys[column]; ok {
+			records[0][i] = name
+		}
+	}
+	return records
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// LatencyWindow keeps the most recent latencies of a provider to compute
+// rolling percentiles.
+type LatencyWindow struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+	full    bool
+}
+
+func NewLatencyWindow(size int) *LatencyWindow {
+	return &LatencyWindow{samples: make([]time.Duration, size)}
+}
+
+func (w *LatencyWindow) Record(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.samples[w.next] = d
+	w.next = (w.next + 1) % len(w.samples)
+	if w.next == 0 {
+		w.full = true
+	}
+}
+
+// Len returns how many samples the window currently holds.
+func (w *LatencyWindow) Len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.full {
+		return len(w.samples)
+	}
+	return w.next
+}
+
+// Percentile returns the p-th percentile (0 < p <= 1) of the window, or
+// false while it is empty.
+func (w *LatencyWindow) Percentile(p float64) (time.Duration, bool) {
+	w.mu.Lock()
+	n := w.next
+	if w.full {
+		n = len(w.samples)
+	}
+	sorted := slices.Clone(w.samples[:n])
+	w.mu.Unlock()
+
+	if n == 0 {
+		return 0, false
+	}
+	slices.Sort(sorted)
+	i := int(float64(n)*p+0.5) - 1
+	return sorted[max(0, min(i, n-1))], true
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+)
+
+// listenAddrs splits a listen setting, a comma separated list of host:port
+// addresses and unix:/path/to.sock sockets.
+func listenAddrs(value string) []string {
+	return splitList(value)
+}
+
+// listen opens the listener of addr. A socket file left behind by a
+// previous run that did not shut down cleanly is removed first; the
+// listener removes its own once closed.
+func listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	if info, err := os.Stat(path); err == nil && info.Mode().Type() == fs.ModeSocket {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s: socket in use by another process", path)
+		}
+		os.Remove(path)
+	}
+	return net.Listen("unix", path)
+}
+
+// listenAll opens the listeners of every address, closing those already
+// open when one fails.
+func listenAll(addrs []string) ([]net.Listener, error) {
+	listeners := make([]net.Listener, 0, len(addrs))
+	for _, addr := range addrs {
+		listener, err := listen(addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+func validateListen(name, value string) error {
+	addrs := listenAddrs(value)
+	if len(addrs) == 0 {
+		return fmt.Errorf("%s must not be empty", name)
+	}
+	var errs []error
+	for _, addr := range addrs {
+		if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+			if path == "" {
+				errs = append(errs, fmt.Errorf("%s %q needs a socket path", name, addr))
+			}
+			continue
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			errs = append(errs, fmt.Errorf("%s %q: %w", name, addr, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// LookupInfo describes how a lookup was answered.
+type LookupInfo struct {
+	// Cached is set when the address came from the cache.
+	Cached bool
+	// Shared is set when the answer came from a provider race started by
+	// a concurrent lookup of the same CEP.
+	Shared bool
+	// Stale is set when the cached address had expired and is being
+	// refreshed in the background.
+	Stale bool
+	// FetchedAt is when a provider answered the address, zero when the
+	// cache entry predates it being recorded.
+	FetchedAt time.Time
+}
+
+// cacheEntry is the cached form of an address: its JSON plus when it was
+// fetched, so entries written before FetchedAt existed still decode.
+type cacheEntry struct {
+	*Address
+	FetchedAt time.Time `json:"fetched_at,omitzero"`
+}
+
+// lookupsCtx bounds every provider race. It is cancelled at shutdown so
+// that lookups outliving the drain timeout do not keep their upstream
+// requests open.
+var lookupsCtx, cancelLookups = context.WithCancel(context.Background())
+
+// negativeCache remembers the CEPs no provider knows, for the shorter
+// not_found_ttl; nil when disabled. It is kept apart from the CEP cache so
+// that bogus CEPs do not evict addresses.
+var negativeCache *MemoryCache
+
+// inflight deduplicates concurrent races for the same CEP and strategy.
+var inflight singleflight.Group
+
+// Lookup resolves a normalized cep from the cache or, on a miss, through
+// the named strategy bounded by the configured timeout.
+func Lookup(ctx context.Context, cep string, strategy string) (*Address, LookupInfo, error) {
+	return lookupWith(ctx, currentProviders(), cep, strategy)
+}
+
+func lookupWith(ctx context.Context, providers []Provider, cep string, strategy string) (*Address, LookupInfo, error) {
+	ctx = withLogAttrs(ctx, "cep", cep)
+	start := time.Now()
+	address, info, err := lookupThrough(ctx, providers, cep, strategy)
+	if err != nil {
+		address, err = offlineFallback(ctx, cep, err)
+	}
+	elapsed := time.Since(start)
+	latency := float64(elapsed.Microseconds()) / 1000
+	countUsage(ctx, info, err)
+	shadowCanaries(ctx, cep, address, info, elapsed, err)
+	if history != nil || events != nil {
+		record := newLookupRecord(cep, strategy, address, info, latency, err)
+		recordHistory(ctx, record)
+		publishEvent(ctx, record)
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "lookup failed", "strategy", strategy, "latency_ms", latency, "error", err)
+		return nil, info, err
+	}
+	slog.InfoContext(ctx, "lookup completed", "provider", address.Provider, "strategy", strategy,
+		"latency_ms", latency, "cached", info.Cached, "stale", info.Stale, "shared", info.Shared)
+	sampleQuality(ctx, cep)
+	autocomplete.add(address)
+	return address, info, nil
+}
+
+func lookupThrough(ctx context.Context, providers []Provider, cep string, strategy string) (*Address, LookupInfo, error) {
+	if knownNotFound(ctx, cep) {
+		return nil, LookupInfo{Cached: true}, ErrCepNotFound
+	}
+	if address, info, ok := cachedAddress(ctx, cep); ok {
+		if info.Stale {
+			// nobody waits on the refresh: its answer only
+			// lands in the cache
+			slog.InfoContext(ctx, "serving stale address, refreshing")
+			racing(ctx, providers, cep, strategy)
+		}
+		return address, info, nil
+	}
+
+	ch := racing(ctx, providers, cep, strategy)
+	select {
+	case result := <-ch:
+		address, _ := result.Val.(*Address)
+		return address, LookupInfo{Shared: result.Shared, FetchedAt: time.Now()}, result.Err
+	case <-ctx.Done():
+		return nil, LookupInfo{}, ErrTimeout
+	}
+}
+
+// racing starts a race for cep, or joins the one already running for the
+// same strategy and timeout. The race is detached from the caller that
+// happened to start it, so its cancellation does not fail the others
+// waiting on it.
+func racing(ctx context.Context, providers []Provider, cep string, strategy string) <-chan singleflight.Result {
+	key := strategy + ":" + cep
+	if timeout, ok := ctx.Value(timeoutKey{}).(time.Duration); ok {
+		key += "@" + timeout.String()
+	}
+	return inflight.DoChan(key, func() (interface{}, error) {
+		return race(context.WithoutCancel(ctx), providers, cep, strategies[strategy])
+	})
+}
+
+// race runs strategy over the active providers and caches its answer.
+func race(ctx context.Context, providers []Provider, cep string, strategy Strategy) (*Address, error) {
+	if outbound.saturated() {
+		outboundRejections.Inc()
+		return nil, ErrOverloaded
+	}
+	providers = activeProviders(providers)
+	if len(providers) == 0 {
+		return nil, ErrNoProviders
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeoutOf(ctx))
+	defer cancel()
+	defer context.AfterFunc(lookupsCtx, cancel)()
+
+	result, err := strategy(ctx, providers, cep)
+	if err != nil {
+		slog.WarnContext(ctx, "timeout reached while fetching", "providers", len(providers))
+		lookupTimeouts.Inc()
+		return nil, ErrTimeout
+	}
+	if result.Err != nil {
+		slog.WarnContext(ctx, "provider failed", "provider", result.Provider, "error", result.Err)
+		if errors.Is(result.Err, ErrCepNotFound) {
+			if negativeCache != nil {
+				_ = negativeCache.Set(ctx, cep, nil)
+			}
+			return nil, ErrCepNotFound
+		}
+		if errors.Is(result.Err, ErrOverloaded) {
+			return nil, ErrOverloaded
+		}
+		return nil, &ProviderError{Provider: result.Provider, Err: result.Err}
+	}
+
+	raceWins.WithLabelValues(result.Provider).Inc()
+	slog.DebugContext(ctx, "provider response", "provider", result.Provider, "address", result.Address)
+
+	body, err := json.Marshal(cacheEntry{Address: result.Address, FetchedAt: time.Now()})
+	if err == nil {
+		err = cache.Set(ctx, cep, body)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "error writing cache", "error", err)
+	}
+	if negativeCache != nil {
+		_ = negativeCache.Delete(ctx, cep)
+	}
+
+	return result.Address, nil
+}
+
+// knownNotFound reports whether cep was not found by the providers within
+// the not_found_ttl, counting it as a negative hit.
+func knownNotFound(ctx context.Context, cep string) bool {
+	if negativeCache == nil {
+		return false
+	}
+	if _, ok, _ := negativeCache.Get(ctx, cep); !ok {
+		return false
+	}
+	cacheLookups.WithLabelValues("negative_hit").Inc()
+	return true
+}
+
+// cachedAddress reads cep from the cache, also reporting whether it is a
+// stale entry when the cache keeps them.
+func cachedAddress(ctx context.Context, cep string) (*Address, LookupInfo, bool) {
+	var body []byte
+	var stale, ok bool
+	var err error
+	if staleCache, keepsStale := cache.(StaleCache); keepsStale && config.Cache.StaleWindow > 0 {
+		body, stale, ok, err = staleCache.GetStale(ctx, cep)
+	} else {
+		body, ok, err = cache.Get(ctx, cep)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "error reading cache", "error", err)
+		return nil, LookupInfo{}, false
+	}
+	result := "hit"
+	if !ok {
+		result = "miss"
+	} else if stale {
+		result = "stale"
+	}
+	cacheLookups.WithLabelValues(result).Inc()
+	logCacheStats(ctx, result)
+	if !ok {
+		return nil, LookupInfo{}, false
+	}
+
+	entry := cacheEntry{Address: &Address{}}
+	err = json.Unmarshal(body, &entry)
+	if err != nil {
+		slog.ErrorContext(ctx, "error decoding cached address", "error", err)
+		return nil, LookupInfo{}, false
+	}
+	entry.Stale = stale
+	return entry.Address, LookupInfo{Cached: true, Stale: stale, FetchedAt: entry.FetchedAt}, true
+}
+
+func logCacheStats(ctx context.Context, result string) {
+	if stats, ok := cache.(interface{ Stats() CacheStats }); ok {
+		s := stats.Stats()
+		slog.DebugContext(ctx, "cache "+result, "hits", s.Hits, "misses", s.Misses, "entries", s.Entries)
+	}
+}
+
+// lookupStatus maps a Lookup error to the HTTP status returned for it.
+func lookupStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrInvalidSearch), errors.Is(err, ErrInvalidAddress), errors.Is(err, ErrInvalidDdd), errors.Is(err, ErrInvalidCnpj),
+		errors.Is(err, ErrInvalidBankCode), errors.Is(err, ErrInvalidVehicleType), errors.Is(err, ErrInvalidFipeCode):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrInvalidCep), errors.Is(err, ErrNoCoordinates), errors.As(err, new(*UFMismatch)):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, ErrCepNotFound), errors.Is(err, ErrDddNotFound), errors.Is(err, ErrCnpjNotFound),
+		errors.Is(err, ErrBankNotFound), errors.Is(err, ErrFipeNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrTimeout):
+		return http.StatusRequestTimeout
+	case errors.Is(err, ErrNoProviders), errors.Is(err, ErrOverloaded), errors.Is(err, ErrBudgetExhausted),
+		errors.Is(err, ErrDailyCapReached), errors.Is(err, ErrHeldBack):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// lookupCode maps a Lookup error to the code of its error response.
+func lookupCode(err error) ErrorCode {
+	switch {
+	case errors.Is(err, ErrInvalidSearch), errors.Is(err, ErrInvalidAddress), errors.Is(err, ErrInvalidDdd), errors.Is(err, ErrInvalidCnpj),
+		errors.Is(err, ErrInvalidBankCode), errors.Is(err, ErrInvalidVehicleType), errors.Is(err, ErrInvalidFipeCode):
+		return CodeInvalidRequest
+	case errors.Is(err, ErrInvalidCep):
+		return CodeInvalidCep
+	case errors.Is(err, ErrNoCoordinates):
+		return CodeNoCoordinates
+	case errors.As(err, new(*UFMismatch)):
+		return CodeUFMismatch
+	case errors.Is(err, ErrCepNotFound), errors.Is(err, ErrDddNotFound), errors.Is(err, ErrCnpjNotFound),
+		errors.Is(err, ErrBankNotFound), errors.Is(err, ErrFipeNotFound):
+		return CodeNotFound
+	case errors.Is(err, ErrTimeout):
+		return CodeTimeout
+	case errors.Is(err, ErrBudgetExhausted):
+		return CodeBudgetExhausted
+	case errors.Is(err, ErrNoProviders), errors.Is(err, ErrOverloaded), errors.Is(err, ErrDailyCapReached),
+		errors.Is(err, ErrHeldBack):
+		return CodeUnavailable
+	default:
+		return CodeUpstreamFailure
+	}
+}
+
+// strategyFor validates the strategy named by the request, falling back
+// to the default one.
+func strategyFor(name string) (string, error) {
+	if name == "" {
+		return defaultStrategy, nil
+	}
+	if _, ok := strategies[name]; !ok {
+		return "", fmt.Errorf("unknown strategy %q", name)
+	}
+	return name, nil
+}
+
+type timeoutKey struct{}
+
+// timeoutOf returns the deadline of the lookups made with ctx: the one the
+// request asked for, or else the configured one.
+func timeoutOf(ctx context.Context) time.Duration {
+	if timeout, ok := ctx.Value(timeoutKey{}).(time.Duration); ok {
+		return timeout
+	}
+	return lookupTimeout()
+}
+
+// withTimeoutParam applies the ?timeout_ms= of r, clamped to the configured
+// max_timeout, to the lookups made with the returned context.
+func withTimeoutParam(r *http.Request) (context.Context, error) {
+	raw := r.URL.Query().Get("timeout_ms")
+	if raw == "" {
+		return r.Context(), nil
+	}
+	ms, err := strconv.Atoi(raw)
+	if err != nil || ms < 1 {
+		return nil, errors.New("timeout_ms must be a positive number of milliseconds")
+	}
+	timeout := min(time.Duration(ms)*time.Millisecond, maxLookupTimeout())
+	return context.WithValue(r.Context(), timeoutKey{}, timeout), nil
+}
@@ -2,171 +2,422 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
+
+	"github.com/liberopassadorneto/multi/pkg/cep"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-type ViaCep struct {
-	Cep         string `json:"cep"`
-	Logradouro  string `json:"logradouro"`
-	Complemento string `json:"complemento"`
-	Bairro      string `json:"bairro"`
-	Localidade  string `json:"localidade"`
-	Uf          string `json:"uf"`
-	Unidade     string `json:"unidade"`
-	Ibge        string `json:"ibge"`
-	Gia         string `json:"gia"`
-	Ddd         string `json:"ddd"`
-	Siafi       string `json:"siafi"`
-}
+var (
+	config          Config
+	cache           Cache
+	providers       []Provider
+	strategies      map[string]Strategy
+	defaultStrategy string
+)
 
-type Coordinates struct {
-	Longitude string `json:"longitude"`
-	Latitude  string `json:"latitude"`
-}
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "lookup":
+			os.Exit(runLookup(os.Args[2:]))
+		case "bench":
+			os.Exit(runBench(os.Args[2:]))
+		case "check":
+			os.Exit(runCheck(os.Args[2:]))
+		}
+	}
 
-type Location struct {
-	Type        string      `json:"type"`
-	Coordinates Coordinates `json:"coordinates"`
-}
+	fs := flag.NewFlagSet("multi", flag.ExitOnError)
+	configFlags := NewConfigFlags(fs)
+	showConfig := fs.Bool("print-config", false, "print the effective configuration and exit")
+	fs.Parse(os.Args[1:])
 
-type BrasilApi struct {
-	Cep          string   `json:"cep"`
-	State        string   `json:"state"`
-	City         string   `json:"city"`
-	Neighborhood *string  `json:"neighborhood"` // Pointer to handle null
-	Street       *string  `json:"street"`       // Pointer to handle null
-	Service      string   `json:"service"`
-	Location     Location `json:"location"`
-}
+	cfg, err := configFlags.Load()
+	if err != nil {
+		fatal("error loading config", err)
+	}
+	if *showConfig {
+		err = printConfig(cfg)
+		if err != nil {
+			fatal("error printing config", err)
+		}
+		return
+	}
+	slog.SetDefault(newLogger(os.Stderr, cfg.LogLevel, cfg.LogFormat))
 
-func main() {
-	http.HandleFunc("/", FetchBothHandler)
-	err := http.ListenAndServe(":8080", nil)
+	setup(cfg)
+
+	shutdownTracing, err := initTracing(context.Background(), cfg.Tracing)
 	if err != nil {
-		panic(err)
+		fatal("error initializing tracing", err)
 	}
-}
+	defer shutdownTracing(context.Background())
+
+	if cfg.AccessLog != "off" {
+		accessLog = NewAccessLogger(os.Stdout, cfg.AccessLog)
+	}
+
+	setupAuth(cfg.Auth)
+	setupClientRateLimit(cfg.ClientRateLimit)
+	corsConfig = cfg.CORS
+	adminToken = cfg.Admin.Token
+	compressionConfig = cfg.Compression
+	signingSecret = cfg.Signing.Secret
+
+	batchMax = cfg.Batch.Max
+	batchConcurrency = cfg.Batch.Concurrency
+
+	jobsProviders := throttled(providers, cfg.Jobs.ProviderRPS, cfg.Jobs.ProviderBurst)
+	jobs = NewJobManager(jobsProviders, defaultStrategy, cfg.Jobs.Workers, cfg.Jobs.Retention)
+	jobsMaxUpload = cfg.Jobs.MaxUpload
+
+	// the API has a mux of its own, so what other packages register on
+	// http.DefaultServeMux, such as pprof, is not served with it
+	mux := http.NewServeMux()
+	apiRoutes := routes()
+	for _, route := range apiRoutes {
+		handler := chain(route.Handler, route.Middleware...)
+		if !route.Public {
+			handler = chain(handler, apiMiddleware...)
+		}
+		mux.HandleFunc(route.Pattern, instrument(route.Label, recovered(handler)))
+	}
+	// the other paths would otherwise get the mux's plain text 404
+	mux.HandleFunc("/", instrument("unmatched", recovered(NotFoundHandler)))
+	openAPI = openAPISpec(apiRoutes)
+	setupSLO(cfg.SLO, apiRoutes)
+	mux.Handle("/metrics", promhttp.Handler())
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-func ViaCepQueue(cep string, ch chan<- *ViaCep) {
-	viaCep, err := FetchViaCep(cep)
+	setupTLS(ctx, cfg.TLS)
+	go watchConfig(ctx, configFlags, cfg.ReloadInterval)
+	if cfg.HealthCheck.Enabled {
+		go runHealthChecks(ctx, cfg.HealthCheck)
+	}
+	go runWarmup(ctx, cfg.Warmup)
+	go runRevalidation(ctx, cfg.Revalidation)
+	setupUsage(ctx)
+	setupProviderUsage(ctx)
+	go runCompaction(ctx, cfg.Cache.Bolt.CompactInterval)
+
+	// any server failing brings the others down through stop
+	var wg sync.WaitGroup
+	if cfg.GRPCListen != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := serveGRPC(ctx, listenAddrs(cfg.GRPCListen), cfg.ShutdownTimeout)
+			if err != nil {
+				slog.Error("grpc server failed", "error", err)
+				stop()
+			}
+		}()
+	}
+
+	if cfg.DebugAddr != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := serveDebug(ctx, listenAddrs(cfg.DebugAddr))
+			if err != nil {
+				slog.Error("debug server failed", "error", err)
+				stop()
+			}
+		}()
+	}
+
+	server := &http.Server{Handler: mux, TLSConfig: serverTLS}
+	err = serve(ctx, server, listenAddrs(cfg.Listen), cfg.ShutdownTimeout)
 	if err != nil {
-		log.Printf("Error fetching ViaCep: %v", err)
-		ch <- nil
-		return
+		slog.Error("server failed", "error", err)
+		stop()
 	}
-	ch <- viaCep
+	wg.Wait()
+	cancelLookups()
+	flushUsage()
+	flushProviderCalls()
+	closeHistory()
+	closeEvents()
+	closeCache()
 }
 
-func FetchViaCep(cep string) (*ViaCep, error) {
-	req, err := http.NewRequest(http.MethodGet, "http://viacep.com.br/ws/"+cep+"/json/", nil)
+// serve runs server on every address until ctx is done, then stops
+// accepting connections and gives the requests in flight up to drain to
+// finish. Lookups still running after that are cancelled.
+func serve(ctx context.Context, server *http.Server, addrs []string, drain time.Duration) error {
+	listeners, err := listenAll(addrs)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	// read before serving, which sets a TLSConfig up for HTTP/2
+	useTLS := server.TLSConfig != nil
+	errc := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		go func() {
+			slog.Info("listening", "addr", listener.Addr().String(), "tls", useTLS)
+			if useTLS {
+				// the certificate comes from TLSConfig.GetCertificate
+				errc <- server.ServeTLS(listener, "", "")
+				return
+			}
+			errc <- server.Serve(listener)
+		}()
 	}
 
-	response, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
+	select {
+	case err := <-errc:
+		server.Close()
+		return err
+	case <-ctx.Done():
 	}
-	defer response.Body.Close()
 
-	body, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		return nil, err
+	slog.Info("shutting down", "drain_timeout", drain)
+	drainCtx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+	err = server.Shutdown(drainCtx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		slog.Warn("drain timeout reached, cancelling in-flight lookups")
+		cancelLookups()
+		return server.Close()
 	}
+	return err
+}
 
-	var viaCep ViaCep
-	err = json.Unmarshal(body, &viaCep)
+// setup builds the lookup core shared by the server and the CLI.
+func setup(cfg Config) {
+	config = cfg
+	cache = newCache(cfg.Cache)
+	negativeCache = nil
+	if cfg.Cache.NotFoundTTL > 0 {
+		negativeCache = NewMemoryCache(cfg.Cache.Size, cfg.Cache.NotFoundTTL)
+	}
+	client, err := NewHTTPClient(cfg.HTTPClient)
 	if err != nil {
-		return nil, err
+		fatal("error building the http client", err)
+	}
+	outboundClient = client
+	cep.MaxBodySize = cfg.HTTPClient.MaxBodySize
+	setupOutbound(cfg.Outbound)
+	setupMock(cfg.Mock)
+	if mode := cfg.Recording.Mode; mode != "" {
+		slog.Warn("provider responses are "+mode+"ed", "dir", cfg.Recording.Dir)
 	}
+	providers = NewProviders(cfg, client)
+	international = NewInternationalProviders(cfg, client)
+	canaries = NewCanaryProviders(cfg, client)
+	canarySampleRate = cfg.Canary.SampleRate
+	// the CEP providers also carry their own headers, see providerClient
+	upstreams := correlatedClient(client)
+	setupDdd(cfg, upstreams)
+	setupCnpj(cfg, upstreams)
+	setupReference(cfg, upstreams)
+	setupEnrichment(cfg, upstreams)
+	setupOffline(cfg.Offline)
+	setupZones(cfg.Zones)
+	setupHistory(cfg.History)
+	setupQuality(cfg.Quality)
+	setupAutocomplete(cfg.Autocomplete)
+	setupEvents(cfg.Events)
+	setupWebhook(cfg.Webhook, client)
+	webSocketConfig = cfg.WebSocket
+	strategies = allStrategies(cfg)
+	defaultStrategy = cfg.Strategy
+}
 
-	return &viaCep, nil
+// fatal logs err and exits, for errors that prevent starting at all.
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
 }
 
-func BrasilApiQueue(cep string, ch chan<- *BrasilApi) {
-	brasilApi, err := FetchBrasilApi(cep)
-	if err != nil {
-		log.Printf("Error fetching BrasilApi: %v", err)
-		ch <- nil
+func newCache(cfg CacheConfig) Cache {
+	if cfg.Backend == "redis" {
+		redisCache := NewRedisCache(cfg.Redis.Addr, cfg.Redis.Password, cfg.Redis.DB, cfg.TTL)
+		redisCache.SetStaleWindow(cfg.StaleWindow)
+		// an unreachable Redis is not fatal: lookups keep working
+		// and simply miss the cache until it comes back
+		err := redisCache.Ping(context.Background())
+		if err != nil {
+			slog.Warn("redis unreachable, lookups will bypass the cache", "addr", cfg.Redis.Addr, "error", err)
+		}
+		return redisCache
+	}
+	if cfg.Backend == "bolt" {
+		boltCache, err := NewBoltCache(cfg.Bolt.Path, cfg.Size, cfg.TTL)
+		if err != nil {
+			fatal("error opening the cache file", err)
+		}
+		boltCache.SetStaleWindow(cfg.StaleWindow)
+		return boltCache
+	}
+	memoryCache := NewMemoryCache(cfg.Size, cfg.TTL)
+	memoryCache.SetStaleWindow(cfg.StaleWindow)
+	return memoryCache
+}
+
+// runCompaction compacts the cache, when it can be, every interval until
+// ctx is done.
+func runCompaction(ctx context.Context, interval time.Duration) {
+	compactable, ok := cache.(interface{ Compact(context.Context) error })
+	if !ok || interval <= 0 {
 		return
 	}
-	ch <- brasilApi
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			start := time.Now()
+			err := compactable.Compact(ctx)
+			if err != nil {
+				slog.Error("error compacting the cache", "error", err)
+				continue
+			}
+			slog.Info("cache compacted", "duration", time.Since(start).Round(time.Millisecond))
+		}
+	}
 }
 
-func FetchBrasilApi(cep string) (*BrasilApi, error) {
-	req, err := http.NewRequest(http.MethodGet, "https://brasilapi.com.br/api/cep/v2/"+cep, nil)
+// closeCache closes the cache file, if any, so that the next process can
+// open it.
+func closeCache() {
+	closer, ok := cache.(io.Closer)
+	if !ok {
+		return
+	}
+	err := closer.Close()
 	if err != nil {
-		return nil, err
+		slog.Error("error closing the cache", "error", err)
 	}
+}
 
-	response, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
+func FetchBothHandler(w http.ResponseWriter, r *http.Request) {
+	queryParams := r.URL.Query()
+	rawCep := queryParams.Get("cep")
+	if rawCep == "" {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, "missing 'cep' query parameter")
+		return
 	}
-	defer response.Body.Close()
 
-	body, err := ioutil.ReadAll(response.Body)
+	cep, err := NormalizeCep(rawCep)
 	if err != nil {
-		return nil, err
+		writeJSONError(w, r, http.StatusUnprocessableEntity, CodeInvalidCep, err.Error())
+		return
 	}
 
-	var brasilApi BrasilApi
-	err = json.Unmarshal(body, &brasilApi)
+	switch mode := queryParams.Get("mode"); mode {
+	case "", "race":
+	case "all":
+		writeAll(w, r, cep)
+		return
+	default:
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("unknown mode %q", mode))
+		return
+	}
+
+	strategy, err := strategyFor(queryParams.Get("strategy"))
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	enrichments, err := parseEnrich(queryParams.Get("enrich"))
 	if err != nil {
-		return nil, err
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	format, err := responseFormat(r)
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	if err := checkResponseShape(r, format); err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
 	}
 
-	return &brasilApi, nil
-}
+	debug, err := boolParam(queryParams.Get("debug"))
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, "debug must be a boolean")
+		return
+	}
+	expectation, err := expectUFParam(queryParams)
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
 
-func printJSON(label string, v interface{}) {
-	jsonBytes, err := json.MarshalIndent(v, "", "  ")
+	ctx, err := withTimeoutParam(r)
 	if err != nil {
-		log.Printf("Error marshalling to JSON: %v", err)
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	var trace *attemptTrace
+	if debug {
+		ctx, trace = withAttemptTrace(ctx)
+	}
+	start := time.Now()
+	address, info, err := Lookup(ctx, cep, strategy)
+	elapsed := time.Since(start)
+	setCacheHeader(w, info)
+	if info.Shared {
+		w.Header().Set("X-Lookup-Shared", "true")
+	}
+	if err != nil {
+		writeLookupError(w, r, err)
 		return
 	}
-	fmt.Printf("%s response:\n%s\n", label, string(jsonBytes))
-}
 
-func FetchBothHandler(w http.ResponseWriter, r *http.Request) {
-	queryParams := r.URL.Query()
-	cep := queryParams.Get("cep")
-	if cep == "" {
-		http.Error(w, "Missing 'cep' query parameter", http.StatusBadRequest)
+	setRequestProvider(r.Context(), address.Provider)
+	if mismatch := expectation.check(address); mismatch != nil {
+		if !expectation.flag {
+			writeLookupError(w, r, mismatch)
+			return
+		}
+		w.Header().Set("X-UF-Mismatch", mismatch.Actual)
+	}
+	address = enrich(r.Context(), address, enrichments)
+	if debug {
+		writeDebugAddress(w, r, address, strategy, info, elapsed, trace)
 		return
 	}
+	writeAddress(w, r, format, address, info)
+}
 
-	// Set a timeout for the context
-	timeout := 1 * time.Second
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+// writeAll answers with every provider's result and where they disagree,
+// bypassing the cache so the report always reflects the upstreams.
+func writeAll(w http.ResponseWriter, r *http.Request, cep string) {
+	ctx, cancel := context.WithTimeout(r.Context(), lookupTimeout())
 	defer cancel()
 
-	viaCepCh := make(chan *ViaCep)
-	brasilApiCh := make(chan *BrasilApi)
-
-	go ViaCepQueue(cep, viaCepCh)
-	go BrasilApiQueue(cep, brasilApiCh)
+	response := NewAllResponse(cep, All(ctx, activeProviders(currentProviders()), cep))
+	slog.DebugContext(ctx, "all providers answered", "cep", cep, "response", response)
 
-	var viaCep *ViaCep
-	var brasilApi *BrasilApi
+	writeJSON(w, r, http.StatusOK, response)
+}
 
-	// select the faster response or timeout
-	select {
-	case viaCep = <-viaCepCh:
-		printJSON("ViaCep", viaCep)
-	case brasilApi = <-brasilApiCh:
-		printJSON("BrasilApi", brasilApi)
-	case <-ctx.Done():
-		log.Printf("Timeout reached while fetching data")
-		http.Error(w, "Timeout reached", http.StatusRequestTimeout)
-		return
+// setCacheHeader reports in X-Cache whether the answer came from the cache.
+func setCacheHeader(w http.ResponseWriter, info LookupInfo) {
+	switch {
+	case info.Stale:
+		w.Header().Set("X-Cache", "STALE")
+	case info.Cached:
+		w.Header().Set("X-Cache", "HIT")
+	default:
+		w.Header().Set("X-Cache", "MISS")
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
 }
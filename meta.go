@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DebugAddress is an address answered with ?debug=1, along with how the
+// lookup went.
+type DebugAddress struct {
+	*Address
+	Meta LookupMeta `json:"meta"`
+}
+
+// LookupMeta explains how a lookup was answered. Attempts lists the
+// provider calls that had finished when the answer was picked; a lookup
+// served from the cache or sharing another's race made none of its own.
+type LookupMeta struct {
+	Provider   string    `json:"provider"`
+	Strategy   string    `json:"strategy"`
+	DurationMs float64   `json:"duration_ms"`
+	Cached     bool      `json:"cached"`
+	Stale      bool      `json:"stale,omitempty"`
+	Shared     bool      `json:"shared,omitempty"`
+	Attempts   []Attempt `json:"attempts"`
+}
+
+// Attempt is one provider call, with the outcome it is counted under in
+// the metrics and, when the upstream answered an unexpected status, that
+// status.
+type Attempt struct {
+	Provider   string  `json:"provider"`
+	Status     string  `json:"status"`
+	HTTPStatus int     `json:"http_status,omitempty"`
+	DurationMs float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+type attemptTraceKey struct{}
+
+// attemptTrace collects the provider calls of a lookup. The race keeps the
+// values of the context that started it, so the calls land here even
+// though they outlive the request's cancellation.
+type attemptTrace struct {
+	mu       sync.Mutex
+	attempts []Attempt
+}
+
+func withAttemptTrace(ctx context.Context) (context.Context, *attemptTrace) {
+	trace := &attemptTrace{}
+	return context.WithValue(ctx, attemptTraceKey{}, trace), trace
+}
+
+// recordAttempt adds a provider call to the lookup's trace, if it has one.
+func recordAttempt(ctx context.Context, provider string, elapsed time.Duration, err error) {
+	trace, ok := ctx.Value(attemptTraceKey{}).(*attemptTrace)
+	if !ok {
+		return
+	}
+	attempt := Attempt{Provider: provider, Status: outcome(err), DurationMs: float64(elapsed.Microseconds()) / 1000}
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		attempt.HTTPStatus = statusErr.StatusCode
+	}
+	trace.mu.Lock()
+	trace.attempts = append(trace.attempts, attempt)
+	trace.mu.Unlock()
+}
+
+func (t *attemptTrace) get() []Attempt {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Attempt{}, t.attempts...)
+}
+
+// writeDebugAddress answers address with its LookupMeta, always in JSON
+// and never cached, since the meta describes this very lookup.
+func writeDebugAddress(w http.ResponseWriter, r *http.Request, address *Address, strategy string, info LookupInfo, elapsed time.Duration, trace *attemptTrace) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, r, http.StatusOK, DebugAddress{Address: address, Meta: LookupMeta{
+		Provider:   address.Provider,
+		Strategy:   strategy,
+		DurationMs: float64(elapsed.Microseconds()) / 1000,
+		Cached:     info.Cached,
+		Stale:      info.Stale,
+		Shared:     info.Shared,
+		Attempts:   trace.get(),
+	}})
+}
+
+// boolParam parses an optional boolean query parameter, false when empty.
+func boolParam(raw string) (bool, error) {
+	if raw == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(raw)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "multi",
+		Name:      "http_requests_total",
+		Help:      "HTTP requests served, by route and status code.",
+	}, []string{"route", "code"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "multi",
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of the HTTP requests served, by route.",
+		Buckets:   []float64{.01, .025, .05, .1, .2, .3, .5, 1, 2, 5},
+	}, []string{"route"})
+
+	providerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "multi",
+		Name:      "provider_request_duration_seconds",
+		Help:      "Latency of provider lookups, by provider and outcome.",
+		Buckets:   []float64{.025, .05, .1, .2, .3, .5, .75, 1, 2},
+	}, []string{"provider", "outcome"})
+
+	raceWins = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "multi",
+		Name:      "race_wins_total",
+		Help:      "Lookups answered by each provider.",
+	}, []string{"provider"})
+
+	cacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "multi",
+		Name:      "cache_lookups_total",
+		Help:      "Cache reads, by result (hit, stale, negative_hit or miss).",
+	}, []string{"result"})
+
+	offlineFallbacks = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "multi",
+		Name:      "offline_fallbacks_total",
+		Help:      "Lookups answered from the offline dataset after every provider failed.",
+	})
+
+	lookupTimeouts = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "multi",
+		Name:      "lookup_timeouts_total",
+		Help:      "Lookups that no provider answered before the deadline.",
+	})
+
+	handlerPanics = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "multi",
+		Name:      "http_handler_panics_total",
+		Help:      "Requests whose handler panicked, answered 500.",
+	})
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "multi",
+		Name:      "cache_hit_ratio",
+		Help:      "Share of cache reads that were hits since startup.",
+	}, cacheHitRatio)
+)
+
+func init() {
+	prometheus.MustRegister(breakerCollector{})
+}
+
+func cacheHitRatio() float64 {
+	stats, ok := cache.(interface{ Stats() CacheStats })
+	if !ok {
+		return 0
+	}
+	s := stats.Stats()
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+var breakerStateDesc = prometheus.NewDesc(
+	"multi_circuit_breaker_state",
+	"Circuit breaker state of each provider: 0 closed, 1 half-open, 2 open.",
+	[]string{"provider"}, nil,
+)
+
+// breakerCollector reads the breakers at scrape time, since their state
+// also changes by the passing of time rather than only on lookups.
+type breakerCollector struct{}
+
+func (breakerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- breakerStateDesc
+}
+
+func (breakerCollector) Collect(ch chan<- prometheus.Metric) {
+	values := map[BreakerState]float64{BreakerClosed: 0, BreakerHalfOpen: 1, BreakerOpen: 2}
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	for name, breaker := range breakers {
+		ch <- prometheus.MustNewConstMetric(breakerStateDesc, prometheus.GaugeValue, values[breaker.Status().State], name)
+	}
+}
+
+// metricsProvider records the latency and outcome of every lookup, for
+// Prometheus, for the rolling stats of /providers/status and for the
+// trace of a ?debug=1 lookup.
+type metricsProvider struct {
+	Provider
+	stats *ProviderStats
+}
+
+func (p *metricsProvider) Lookup(ctx context.Context, cep string) (*Address, error) {
+	start := time.Now()
+	address, err := p.Provider.Lookup(ctx, cep)
+	elapsed := time.Since(start)
+	providerDuration.WithLabelValues(p.Name(), outcome(err)).Observe(elapsed.Seconds())
+	p.stats.Record(elapsed, err)
+	recordAttempt(ctx, p.Name(), elapsed, err)
+	return address, err
+}
+
+func outcome(err error) string {
+	switch {
+	case err == nil:
+		return "success"
+	case errors.Is(err, ErrCepNotFound), errors.Is(err, ErrBankNotFound), errors.Is(err, ErrFipeNotFound):
+		return "not_found"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, ErrBudgetExhausted):
+		return "budget_exhausted"
+	case errors.Is(err, ErrDailyCapReached):
+		return "capped"
+	case errors.Is(err, ErrHeldBack):
+		return "held_back"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	default:
+		return "error"
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.size += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the Flusher of streaming
+// responses.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Hijack hands the connection over to WebSockets, which need it
+// as an http.Hijacker.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(r.ResponseWriter).Hijack()
+}
+
+// instrument counts, traces and access logs the requests handled by next
+// under route, tagging them with the caller's X-Request-ID or a new one
+// and, when they are part of a trace, with its trace id.
+func instrument(route string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		ctx, provider := withRequestProvider(withRequestID(r.Context(), id))
+		r = r.WithContext(ctx)
+
+		ctx, span := startRequestSpan(r, route)
+		if sc := span.SpanContext(); sc.IsValid() {
+			w.Header().Set("traceresponse", traceResponse(sc))
+			ctx = withLogAttrs(ctx, "trace_id", sc.TraceID().String())
+		}
+		recorder := &statusRecorder{ResponseWriter: w}
+		next(recorder, r.WithContext(ctx))
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		httpRequests.WithLabelValues(route, strconv.Itoa(recorder.status)).Inc()
+		latency := time.Since(start)
+		httpDuration.WithLabelValues(route).Observe(latency.Seconds())
+		recordSLO(route, recorder.status, latency)
+		endRequestSpan(span, recorder.status)
+
+		if accessLog != nil {
+			accessLog.Log(AccessLogEntry{
+				Time:      start,
+				RequestID: id,
+				ClientIP:  clientIP(r),
+				Method:    r.Method,
+				Path:      r.URL.RequestURI(),
+				Proto:     r.Proto,
+				Status:    recorder.status,
+				Size:      recorder.size,
+				Duration:  float64(time.Since(start).Microseconds()) / 1000,
+				Provider:  provider.get(),
+			})
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+// middleware wraps a handler with behaviour of its own, such as a check
+// that answers the request before next does.
+type middleware func(next http.HandlerFunc) http.HandlerFunc
+
+// chain wraps h with middlewares, the first one outermost, so that they
+// see the request in the order listed.
+func chain(h http.HandlerFunc, middlewares ...middleware) http.HandlerFunc {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// apiMiddleware guards the handlers of the lookup endpoints with CORS, the
+// client rate limit and the API keys, in that order so that preflights,
+// which carry no key, are answered first, then gives them their outbound
+// budget. Their responses are compressed.
+var apiMiddleware = []middleware{compress, cors, rateLimited, authenticated, budgeted}
+
+// recovered answers a request whose handler panics with 500 instead of
+// dropping its connection, logging the panic with its stack. Once the
+// handler has written headers, only the log and the metric are left.
+func recovered(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		recorder, _ := w.(*statusRecorder)
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			// http.ErrAbortHandler is how a handler aborts its response
+			// on purpose, which net/http handles quietly
+			if err, ok := v.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				panic(v)
+			}
+			handlerPanics.Inc()
+			slog.ErrorContext(r.Context(), "panic serving request", "method", r.Method, "path", r.URL.Path,
+				"panic", v, "stack", string(debug.Stack()))
+			if recorder != nil && recorder.status != 0 {
+				return
+			}
+			writeJSONError(w, r, http.StatusInternalServerError, CodeInternal, "internal error")
+		}()
+		next(w, r)
+	}
+}
+
+// NotFoundHandler answers the paths no route matches with 404 in the same
+// JSON as the other errors.
+func NotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSONError(w, r, http.StatusNotFound, CodeNotFound, "not found")
+}
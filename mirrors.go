@@ -0,0 +1,87 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// mirrorFailback is how long a provider keeps going to the mirror that
+// took over before trying its URL again.
+const mirrorFailback = time.Minute
+
+var mirrorFailovers = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "multi",
+	Name:      "mirror_failovers_total",
+	Help:      "Upstream requests sent again to the next mirror after a connection error, by base URL failed.",
+}, []string{"base_url"})
+
+// mirrorTransport sends the requests under the first of bases to the
+// others in turn when they fail to connect, with no HTTP answer. The last
+// base that answered is tried first until mirrorFailback has passed.
+type mirrorTransport struct {
+	bases     []string
+	transport http.RoundTripper
+
+	preferred atomic.Int64
+	// since is when, in Unix nanoseconds, a mirror became preferred
+	since atomic.Int64
+}
+
+func (t *mirrorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	path, ok := strings.CutPrefix(req.URL.String(), t.bases[0])
+	if !ok || (req.Body != nil && req.GetBody == nil) {
+		return t.transport.RoundTrip(req)
+	}
+
+	first := int(t.preferred.Load())
+	if first != 0 && time.Since(time.Unix(0, t.since.Load())) > mirrorFailback {
+		first = 0
+	}
+	var err error
+	for n := range t.bases {
+		i := (first + n) % len(t.bases)
+		var attempt *http.Request
+		attempt, err = mirrored(req, t.bases[i]+path)
+		if err != nil {
+			return nil, err
+		}
+		var resp *http.Response
+		resp, err = t.transport.RoundTrip(attempt)
+		if err == nil {
+			t.preferred.Store(int64(i))
+			if n > 0 {
+				t.since.Store(time.Now().UnixNano())
+			}
+			return resp, nil
+		}
+		if req.Context().Err() != nil {
+			return nil, err
+		}
+		mirrorFailovers.WithLabelValues(t.bases[i]).Inc()
+		slog.DebugContext(req.Context(), "upstream unreachable, trying the next mirror", "base_url", t.bases[i], "error", err)
+	}
+	return nil, err
+}
+
+// mirrored is req sent to target instead, with a fresh body.
+func mirrored(req *http.Request, target string) (*http.Request, error) {
+	attempt, err := http.NewRequestWithContext(req.Context(), req.Method, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	attempt.Header = req.Header.Clone()
+	if req.GetBody != nil {
+		attempt.Body, err = req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		attempt.GetBody, attempt.ContentLength = req.GetBody, req.ContentLength
+	}
+	return attempt, nil
+}
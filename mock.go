@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io/fs"
+	"log/slog"
+	"os"
+
+	"github.com/liberopassadorneto/multi/pkg/cep"
+)
+
+// mockFixtures holds the fixtures of every provider in mock mode, nil when
+// the providers reach their upstreams.
+var mockFixtures map[string]map[string]Address
+
+func setupMock(cfg MockConfig) {
+	mockFixtures = nil
+	if !cfg.Enabled {
+		return
+	}
+	fsys, source := cep.DefaultFixtures(), "embedded"
+	if cfg.Fixtures != "" {
+		fsys, source = os.DirFS(cfg.Fixtures), cfg.Fixtures
+	}
+	mockFixtures = loadMockFixtures(fsys)
+	slog.Warn("mock mode enabled, providers answer from fixtures", "fixtures", source)
+}
+
+func loadMockFixtures(fsys fs.FS) map[string]map[string]Address {
+	fixtures := map[string]map[string]Address{}
+	for _, name := range providerNames() {
+		provider, err := cep.LoadFixtures(fsys, name)
+		if err != nil {
+			fatal("error loading mock fixtures", err)
+		}
+		fixtures[name] = provider
+	}
+	return fixtures
+}
+
+// mockProvider stands in for provider in mock mode, under its name so that
+// the metrics, breakers and answers look the same.
+func mockProvider(cfg Config, name string, provider Provider) Provider {
+	behavior := cfg.ProviderMock(name)
+	mock := cep.NewMockProvider(provider.Name(), mockFixtures[name])
+	mock.Latency = behavior.Latency
+	mock.Jitter = behavior.Jitter
+	mock.FailureRate = behavior.FailureRate
+	return mock
+}
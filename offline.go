@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/liberopassadorneto/multi/pkg/cep"
+)
+
+// offline is the last resort of CEP lookups, nil unless enabled.
+var offline *cep.OfflineProvider
+
+func setupOffline(cfg OfflineConfig) {
+	offline = nil
+	if !cfg.Enabled {
+		return
+	}
+	if cfg.Dataset == "" {
+		offline = cep.NewOfflineProvider()
+		return
+	}
+	dataset, err := cep.OpenOfflineDataset(cfg.Dataset)
+	if err != nil {
+		fatal("error loading offline dataset", err)
+	}
+	offline = dataset
+}
+
+// offlineFallback answers a CEP the providers failed to resolve from the
+// offline dataset. Not found is a real answer and is kept, as is err when
+// the dataset has no range for the CEP either. Offline answers are never
+// cached, so that the providers are asked again next time.
+func offlineFallback(ctx context.Context, cep string, err error) (*Address, error) {
+	if offline == nil || errors.Is(err, ErrCepNotFound) || errors.Is(err, ErrInvalidCep) {
+		return nil, err
+	}
+	address, offlineErr := offline.Lookup(ctx, cep)
+	if offlineErr != nil {
+		return nil, err
+	}
+	slog.WarnContext(ctx, "providers failed, answering from the offline dataset", "error", err)
+	offlineFallbacks.Inc()
+	return address, nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"cmp"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// openAPI is the document served by /openapi.json, generated from the
+// routes when the server starts.
+var openAPI map[string]any
+
+// OpenAPIHandler serves GET /openapi.json.
+func OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, openAPI)
+}
+
+// openAPISpec describes routes as an OpenAPI 3 document. The schemas are
+// derived from the JSON fields of the types each operation exchanges, and
+// every operation may fail with the error envelope.
+func openAPISpec(routes []Route) map[string]any {
+	schemas := openAPISchemas{}
+	errorSchema := schemas.of(reflect.TypeFor[ErrorResponse]())
+
+	paths := make(map[string]map[string]any)
+	for _, route := range routes {
+		for _, op := range route.Operations {
+			operation := map[string]any{
+				"summary":   op.Summary,
+				"responses": operationResponses(schemas, op, errorSchema),
+			}
+			if len(op.Params) > 0 {
+				operation["parameters"] = operationParams(op.Params)
+			}
+			if op.Body != nil {
+				operation["requestBody"] = map[string]any{
+					"required": true,
+					"content":  map[string]any{cmp.Or(op.BodyType, "application/json"): map[string]any{"schema": schemas.of(reflect.TypeOf(op.Body))}},
+				}
+			}
+			// /usage is public only to check the key itself
+			if (!route.Public || route.Pattern == "/usage") && apiKeys != nil {
+				operation["security"] = []map[string][]string{{"apiKey": {}}}
+			}
+			if paths[op.Path] == nil {
+				paths[op.Path] = make(map[string]any)
+			}
+			paths[op.Path][strings.ToLower(op.Method)] = operation
+		}
+	}
+
+	components := map[string]any{"schemas": schemas}
+	if apiKeys != nil {
+		components["securitySchemes"] = map[string]any{
+			"apiKey": map[string]any{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+		}
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "multi",
+			"description": "Races several Brazilian CEP providers and answers with a normalized address.",
+			"version":     "1",
+		},
+		"paths":      paths,
+		"components": components,
+	}
+}
+
+func operationParams(params []Param) []map[string]any {
+	out := make([]map[string]any, 0, len(params))
+	for _, param := range params {
+		schema := map[string]any{"type": cmp.Or(param.Type, "string")}
+		if len(param.Enum) > 0 {
+			schema["enum"] = param.Enum
+		}
+		parameter := map[string]any{"name": param.Name, "in": param.In, "schema": schema}
+		if param.Description != "" {
+			parameter["description"] = param.Description
+		}
+		// path parameters are always required
+		if param.Required || param.In == "path" {
+			parameter["required"] = true
+		}
+		out = append(out, parameter)
+	}
+	return out
+}
+
+func operationResponses(schemas openAPISchemas, op Operation, errorSchema map[string]any) map[string]any {
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := map[string]any{"description": http.StatusText(status)}
+	if op.Response != nil {
+		schema := schemas.of(reflect.TypeOf(op.Response))
+		content := map[string]any{cmp.Or(op.ContentType, "application/json"): map[string]any{"schema": schema}}
+		if op.Formats {
+			content["application/xml"] = map[string]any{"schema": schema}
+			content["text/csv"] = map[string]any{"schema": map[string]any{"type": "string"}}
+			content["application/geo+json"] = map[string]any{"schema": map[string]any{"type": "object"}}
+			content["application/x-protobuf"] = map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}}
+		}
+		success["content"] = content
+	}
+	return map[string]any{
+		strconv.Itoa(status): success,
+		"default": map[string]any{
+			"description": "Error",
+			"content":     map[string]any{"application/json": map[string]any{"schema": errorSchema}},
+		},
+	}
+}
+
+// openAPISchemas collects the named types met while describing the
+// operations, which are referenced rather than inlined.
+type openAPISchemas map[string]any
+
+var timeType = reflect.TypeFor[time.Time]()
+
+func (s openAPISchemas) of(t reflect.Type) map[string]any {
+	if t == timeType {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return s.of(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": s.of(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": s.of(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		if _, ok := s[t.Name()]; !ok {
+			// registered before its fields so recursive types end
+			s[t.Name()] = nil
+			s[t.Name()] = s.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	default:
+		return map[string]any{}
+	}
+}
+
+func (s openAPISchemas) object(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	var required []string
+	s.fields(t, properties, &required)
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// fields adds the JSON fields of struct t, flattening the embedded ones
+// as encoding/json does. Fields that may be omitted are not required.
+func (s openAPISchemas) fields(t reflect.Type, properties map[string]any, required *[]string) {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				s.fields(embedded, properties, required)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = s.of(field.Type)
+		optional := strings.Contains(opts, "omitempty") || strings.Contains(opts, "omitzero")
+		if !optional && field.Type.Kind() != reflect.Pointer {
+			*required = append(*required, name)
+		}
+	}
+}
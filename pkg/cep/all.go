@@ -0,0 +1,99 @@
+package cep
+
+import "context"
+
+type ProviderAnswer struct {
+	Provider string   `json:"provider"`
+	Address  *Address `json:"address,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// Discrepancy lists the values each provider returned for a field they
+// disagree on.
+type Discrepancy struct {
+	Field  string            `json:"field"`
+	Values map[string]string `json:"values"`
+}
+
+type AllResponse struct {
+	Cep           string           `json:"cep"`
+	Results       []ProviderAnswer `json:"results"`
+	Discrepancies []Discrepancy    `json:"discrepancies"`
+}
+
+// All waits for every provider until ctx is done and returns their
+// results in configuration order. Providers that did not answer in time
+// carry the context error.
+func All(ctx context.Context, providers []Provider, cep string) []Result {
+	results := make([]Result, len(providers))
+	for i, provider := range providers {
+		results[i] = Result{Provider: provider.Name(), Err: context.DeadlineExceeded}
+	}
+
+	ch := fanOut(ctx, providers, cep)
+	for range providers {
+		select {
+		case result := <-ch:
+			results[result.Index] = result.Result
+		case <-ctx.Done():
+			return results
+		}
+	}
+	return results
+}
+
+func NewAllResponse(cep string, results []Result) AllResponse {
+	answers := make([]ProviderAnswer, 0, len(results))
+	for _, result := range results {
+		answer := ProviderAnswer{Provider: result.Provider, Address: result.Address}
+		if result.Err != nil {
+			answer.Error = result.Err.Error()
+		}
+		answers = append(answers, answer)
+	}
+
+	return AllResponse{
+		Cep:           cep,
+		Results:       answers,
+		Discrepancies: FindDiscrepancies(results),
+	}
+}
+
+// FindDiscrepancies compares the addresses of the successful results field
+// by field. Empty values are treated as missing rather than as a
+// disagreement, since not every provider fills every field.
+func FindDiscrepancies(results []Result) []Discrepancy {
+	fields := []struct {
+		name  string
+		value func(*Address) string
+	}{
+		{"cep", func(a *Address) string { return a.Cep }},
+		{"state", func(a *Address) string { return a.State }},
+		{"city", func(a *Address) string { return a.City }},
+		{"neighborhood", func(a *Address) string { return a.Neighborhood }},
+		{"street", func(a *Address) string { return a.Street }},
+		{"ibge", func(a *Address) string { return a.Ibge }},
+		{"ddd", func(a *Address) string { return a.Ddd }},
+	}
+
+	discrepancies := []Discrepancy{}
+	for _, field := range fields {
+		values := make(map[string]string)
+		distinct := make(map[string]bool)
+		for _, result := range results {
+			if result.Err != nil || result.Address == nil {
+				continue
+			}
+			value := field.value(result.Address)
+			if value == "" {
+				continue
+			}
+			values[result.Provider] = value
+			distinct[value] = true
+		}
+		if len(distinct) > 1 {
+			discrepancies = append(discrepancies, Discrepancy{Field: field.name, Values: values})
+		}
+	}
+	return discrepancies
+}
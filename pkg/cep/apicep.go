@@ -0,0 +1,69 @@
+package cep
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// ApiCep mirrors the static files served by cdn.apicep.com. The HTTP
+// status is not reliable: the outcome is carried in the body's status.
+type ApiCep struct {
+	Status     int    `json:"status"`
+	Ok         bool   `json:"ok"`
+	Code       string `json:"code"`
+	State      string `json:"state"`
+	City       string `json:"city"`
+	District   string `json:"district"`
+	Address    string `json:"address"`
+	StatusText string `json:"statusText"`
+	Message    string `json:"message"`
+}
+
+type ApiCepProvider struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+func NewApiCepProvider() *ApiCepProvider {
+	return &ApiCepProvider{BaseURL: "https://cdn.apicep.com/file/apicep/", Client: http.DefaultClient}
+}
+
+func (p *ApiCepProvider) Name() string {
+	return "ApiCep"
+}
+
+func (p *ApiCepProvider) Lookup(ctx context.Context, raw string) (*Address, error) {
+	// called directly, the provider gets the CEP as the caller had it
+	cep, err := Normalize(raw)
+	if err != nil {
+		return nil, err
+	}
+	// files are named after the formatted CEP, e.g. 01310-100.json
+	var apiCep ApiCep
+	status, err := fetchJSON(ctx, p.Client, p.BaseURL+cep[:5]+"-"+cep[5:]+".json", &apiCep)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if status != http.StatusOK {
+		return nil, &StatusError{Provider: "apicep", StatusCode: status}
+	}
+	if apiCep.Status == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if !apiCep.Ok {
+		return nil, fmt.Errorf("apicep: %s (status %d)", apiCep.Message, apiCep.Status)
+	}
+
+	return &Address{
+		Cep:          normalizedOr(apiCep.Code, cep),
+		State:        apiCep.State,
+		City:         apiCep.City,
+		Neighborhood: apiCep.District,
+		Street:       apiCep.Address,
+		Provider:     p.Name(),
+	}, nil
+}
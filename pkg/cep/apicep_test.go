@@ -0,0 +1,50 @@
+package cep
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestApiCepProviderLookup(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.Write([]byte(`{"status": 200, "ok": true, "code": "01310-100", "state": "SP", "city": "São Paulo"}`))
+	}))
+	defer server.Close()
+	provider := &ApiCepProvider{BaseURL: server.URL + "/", Client: server.Client()}
+
+	tests := []struct {
+		input string
+		err   error
+	}{
+		{"", ErrInvalid},
+		{"013", ErrInvalid},
+		{"0131x100", ErrInvalid},
+		{"01310100", nil},
+		{" 01310-100 ", nil},
+	}
+	for _, test := range tests {
+		paths = nil
+		address, err := provider.Lookup(context.Background(), test.input)
+		if !errors.Is(err, test.err) {
+			t.Errorf("Lookup(%q): got %v, want %v", test.input, err, test.err)
+			continue
+		}
+		if test.err != nil {
+			if len(paths) != 0 {
+				t.Errorf("Lookup(%q) called the upstream on an invalid CEP", test.input)
+			}
+			continue
+		}
+		if len(paths) != 1 || paths[0] != "/01310-100.json" {
+			t.Errorf("Lookup(%q) requested %v, want /01310-100.json", test.input, paths)
+		}
+		if address.Cep != "01310100" {
+			t.Errorf("Lookup(%q) answered CEP %q, want 01310100", test.input, address.Cep)
+		}
+	}
+}
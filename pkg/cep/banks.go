@@ -0,0 +1,99 @@
+package cep
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrInvalidBankCode = errors.New("bank code must have 1 to 3 digits")
+	ErrBankNotFound    = errors.New("bank not found")
+)
+
+// Bank is a participant of the Brazilian payment system. Code is its
+// three digit COMPE code, empty for the participants without one.
+type Bank struct {
+	Code     string `json:"code,omitempty"`
+	Ispb     string `json:"ispb"`
+	Name     string `json:"name"`
+	FullName string `json:"full_name"`
+}
+
+// NormalizeBankCode validates a raw COMPE code, padding it to three digits
+// as in 001.
+func NormalizeBankCode(raw string) (string, error) {
+	code := strings.TrimSpace(raw)
+	if len(code) < 1 || len(code) > 3 {
+		return "", ErrInvalidBankCode
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return "", ErrInvalidBankCode
+		}
+	}
+	return strings.Repeat("0", 3-len(code)) + code, nil
+}
+
+// BankProvider lists the banks through BrasilAPI.
+type BankProvider struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+type brasilApiBank struct {
+	Ispb     string `json:"ispb"`
+	Name     string `json:"name"`
+	Code     *int   `json:"code"`
+	FullName string `json:"fullName"`
+}
+
+func (b brasilApiBank) bank() Bank {
+	bank := Bank{Ispb: b.Ispb, Name: b.Name, FullName: b.FullName}
+	if b.Code != nil {
+		bank.Code = fmt.Sprintf("%03d", *b.Code)
+	}
+	return bank
+}
+
+func NewBankProvider() *BankProvider {
+	return &BankProvider{BaseURL: "https://brasilapi.com.br/api/banks/v1", Client: http.DefaultClient}
+}
+
+// List returns every bank, in the order of the upstream.
+func (p *BankProvider) List(ctx context.Context) ([]Bank, error) {
+	var response []brasilApiBank
+	status, err := fetchJSON(ctx, p.Client, p.BaseURL, &response)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, &StatusError{Provider: "brasilapi", StatusCode: status}
+	}
+
+	banks := make([]Bank, 0, len(response))
+	for _, bank := range response {
+		banks = append(banks, bank.bank())
+	}
+	return banks, nil
+}
+
+// Lookup finds the bank of a normalized code.
+func (p *BankProvider) Lookup(ctx context.Context, code string) (*Bank, error) {
+	var response brasilApiBank
+	status, err := fetchJSON(ctx, p.Client, p.BaseURL+"/"+code, &response)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNotFound {
+		return nil, ErrBankNotFound
+	}
+	if status != http.StatusOK {
+		return nil, &StatusError{Provider: "brasilapi", StatusCode: status}
+	}
+
+	bank := response.bank()
+	return &bank, nil
+}
@@ -0,0 +1,315 @@
+package cep
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+var (
+	boltEntries = []byte("entries")
+	// boltExpiry indexes the entries by expiry, its keys being the
+	// expiry followed by the CEP, so that the ones to evict come first
+	boltExpiry = []byte("expiry")
+)
+
+// BoltCache keeps the lookups in a bbolt file, so that a single instance
+// retains its cache across restarts without Redis. Once it holds
+// maxEntries, it evicts the entries closest to expiring.
+type BoltCache struct {
+	// mu is held for writing only while Compact swaps the file
+	mu         sync.RWMutex
+	db         *bbolt.DB
+	path       string
+	ttl        time.Duration
+	stale      time.Duration
+	maxEntries int
+
+	entries atomic.Int64
+	hits    atomic.Int64
+	misses  atomic.Int64
+}
+
+// NewBoltCache opens, or creates, the cache file at path. Only one process
+// can have it open at a time.
+func NewBoltCache(path string, maxEntries int, ttl time.Duration) (*BoltCache, error) {
+	c := &BoltCache{path: path, ttl: ttl, maxEntries: maxEntries}
+	err := c.open()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *BoltCache) open() error {
+	db, err := bbolt.Open(c.path, 0o600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		entries, err := tx.CreateBucketIfNotExists(boltEntries)
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists(boltExpiry)
+		c.entries.Store(int64(entries.Stats().KeyN))
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return err
+	}
+	c.db = db
+	return nil
+}
+
+// SetStaleWindow keeps expired entries for window more, for GetStale.
+// It must be called before the cache is used.
+func (c *BoltCache) SetStaleWindow(window time.Duration) {
+	c.stale = window
+}
+
+func (c *BoltCache) Get(_ context.Context, key string) ([]byte, bool, error) {
+	value, stale, ok, err := c.get(key)
+	if !ok || stale {
+		c.misses.Add(1)
+		return nil, false, err
+	}
+	c.hits.Add(1)
+	return value, true, nil
+}
+
+func (c *BoltCache) GetStale(_ context.Context, key string) ([]byte, bool, bool, error) {
+	value, stale, ok, err := c.get(key)
+	if !ok {
+		c.misses.Add(1)
+		return nil, false, false, err
+	}
+	c.hits.Add(1)
+	return value, stale, true, nil
+}
+
+// get leaves the entries past their stale window in place: Set evicts
+// them, and Compact sweeps them.
+func (c *BoltCache) get(key string) ([]byte, bool, bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var value []byte
+	var expiresAt time.Time
+	err := c.db.View(func(tx *bbolt.Tx) error {
+		record := tx.Bucket(boltEntries).Get([]byte(key))
+		if record != nil {
+			expiresAt = decodeExpiry(record)
+			value = append([]byte(nil), record[8:]...)
+		}
+		return nil
+	})
+	if err != nil || value == nil {
+		return nil, false, false, err
+	}
+	now := time.Now()
+	if now.After(expiresAt.Add(c.stale)) {
+		return nil, false, false, nil
+	}
+	return value, now.After(expiresAt), true, nil
+}
+
+func (c *BoltCache) Set(_ context.Context, key string, value []byte) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	expiresAt := time.Now().Add(c.ttl)
+	record := binary.BigEndian.AppendUint64(make([]byte, 0, 8+len(value)), uint64(expiresAt.UnixNano()))
+	record = append(record, value...)
+
+	var delta int64
+	err := c.db.Update(func(tx *bbolt.Tx) error {
+		entries, expiry := tx.Bucket(boltEntries), tx.Bucket(boltExpiry)
+		if previous := entries.Get([]byte(key)); previous != nil {
+			err := expiry.Delete(expiryKey(decodeExpiry(previous), key))
+			if err != nil {
+				return err
+			}
+		} else {
+			delta++
+		}
+		err := entries.Put([]byte(key), record)
+		if err == nil {
+			err = expiry.Put(expiryKey(expiresAt, key), nil)
+		}
+		if err != nil {
+			return err
+		}
+
+		count := c.entries.Load() + delta
+		cursor := expiry.Cursor()
+		for k, _ := cursor.First(); k != nil && c.maxEntries > 0 && count > int64(c.maxEntries); k, _ = cursor.First() {
+			err := evict(entries, expiry, k)
+			if err != nil {
+				return err
+			}
+			delta--
+			count--
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	c.entries.Add(delta)
+	return nil
+}
+
+func (c *BoltCache) Delete(_ context.Context, key string) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var removed bool
+	err := c.db.Update(func(tx *bbolt.Tx) error {
+		entries := tx.Bucket(boltEntries)
+		record := entries.Get([]byte(key))
+		if record == nil {
+			return nil
+		}
+		removed = true
+		return evict(entries, tx.Bucket(boltExpiry), expiryKey(decodeExpiry(record), key))
+	})
+	if err == nil && removed {
+		c.entries.Add(-1)
+	}
+	return err
+}
+
+func (c *BoltCache) Flush(context.Context) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	err := c.db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{boltEntries, boltExpiry} {
+			err := tx.DeleteBucket(name)
+			if err == nil {
+				_, err = tx.CreateBucket(name)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		c.entries.Store(0)
+	}
+	return err
+}
+
+// Compact drops the entries past their stale window, then rewrites the
+// file without the pages they freed, which bbolt would otherwise keep.
+// Lookups wait for it to finish.
+func (c *BoltCache) Compact(context.Context) error {
+	err := c.sweep()
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tmp := c.path + ".compact"
+	dst, err := bbolt.Open(tmp, 0o600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+	err = bbolt.Compact(dst, c.db, 0)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	err = c.db.Close()
+	if err == nil {
+		err = os.Rename(tmp, c.path)
+	}
+	// the cache is reopened either way, compacted or not
+	if openErr := c.open(); err == nil {
+		err = openErr
+	}
+	return err
+}
+
+// sweep drops the entries past their stale window.
+func (c *BoltCache) sweep() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	now := time.Now()
+	var removed int64
+	err := c.db.Update(func(tx *bbolt.Tx) error {
+		entries, expiry := tx.Bucket(boltEntries), tx.Bucket(boltExpiry)
+		cursor := expiry.Cursor()
+		for k, _ := cursor.First(); k != nil && now.After(decodeExpiry(k).Add(c.stale)); k, _ = cursor.First() {
+			err := evict(entries, expiry, k)
+			if err != nil {
+				return err
+			}
+			removed++
+		}
+		return nil
+	})
+	if err == nil {
+		c.entries.Add(-removed)
+	}
+	return err
+}
+
+// Ping reports whether the file is open, so that /readyz fails if a
+// compaction could not reopen it.
+func (c *BoltCache) Ping(context.Context) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.db.View(func(*bbolt.Tx) error { return nil })
+}
+
+func (c *BoltCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.db.Close()
+}
+
+func (c *BoltCache) Stats() CacheStats {
+	return CacheStats{
+		Hits:    c.hits.Load(),
+		Misses:  c.misses.Load(),
+		Entries: int(c.entries.Load()),
+	}
+}
+
+// evict removes the entry of the expiry index key k from both buckets.
+func evict(entries, expiry *bbolt.Bucket, k []byte) error {
+	// k points into a page the deletes may rewrite
+	k = append([]byte(nil), k...)
+	err := entries.Delete(k[8:])
+	if err == nil {
+		err = expiry.Delete(k)
+	}
+	return err
+}
+
+func expiryKey(expiresAt time.Time, key string) []byte {
+	return append(binary.BigEndian.AppendUint64(nil, uint64(expiresAt.UnixNano())), key...)
+}
+
+// decodeExpiry reads the expiry that both entry records and expiry index
+// keys start with.
+func decodeExpiry(b []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(b)))
+}
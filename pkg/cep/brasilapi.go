@@ -0,0 +1,74 @@
+package cep
+
+import (
+	"context"
+	"net/http"
+)
+
+type BrasilApi struct {
+	Cep          string  `json:"cep"`
+	State        string  `json:"state"`
+	City         string  `json:"city"`
+	Neighborhood *string `json:"neighborhood"` // Pointer to handle null
+	Street       *string `json:"street"`       // Pointer to handle null
+	Service      string  `json:"service"`
+	Location     struct {
+		Type        string `json:"type"`
+		Coordinates struct {
+			Longitude string `json:"longitude"`
+			Latitude  string `json:"latitude"`
+		} `json:"coordinates"`
+	} `json:"location"`
+}
+
+type BrasilApiProvider struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+func NewBrasilApiProvider() *BrasilApiProvider {
+	return &BrasilApiProvider{BaseURL: "https://brasilapi.com.br/api/cep/v2/", Client: http.DefaultClient}
+}
+
+func (p *BrasilApiProvider) Name() string {
+	return "BrasilApi"
+}
+
+func (p *BrasilApiProvider) Lookup(ctx context.Context, cep string) (*Address, error) {
+	brasilApi, err := p.fetch(ctx, cep)
+	if err != nil {
+		return nil, err
+	}
+
+	address := &Address{
+		Cep:          normalizedOr(brasilApi.Cep, cep),
+		State:        brasilApi.State,
+		City:         brasilApi.City,
+		Neighborhood: deref(brasilApi.Neighborhood),
+		Street:       deref(brasilApi.Street),
+		Provider:     p.Name(),
+	}
+	// BrasilApi sends an empty location object when it has no coordinates,
+	// and the coordinates it has as strings; invalid ones are left out
+	// rather than failing an otherwise good address
+	coordinates := brasilApi.Location.Coordinates
+	address.Location, _ = ParseLocation(coordinates.Latitude, coordinates.Longitude)
+
+	return address, nil
+}
+
+func (p *BrasilApiProvider) fetch(ctx context.Context, cep string) (*BrasilApi, error) {
+	var brasilApi BrasilApi
+	status, err := fetchJSON(ctx, p.Client, p.BaseURL+cep, &brasilApi)
+	if err != nil {
+		return nil, err
+	}
+	// BrasilApi answers unknown CEPs with 404 and an error body
+	if status == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if status != http.StatusOK {
+		return nil, &StatusError{Provider: "brasilapi", StatusCode: status}
+	}
+	return &brasilApi, nil
+}
@@ -0,0 +1,171 @@
+package cep
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Cache stores encoded lookup responses keyed by normalized CEP.
+type Cache interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte) error
+}
+
+// StaleCache is a Cache that can keep entries for a while after they
+// expire, so that they are still served while being refreshed.
+type StaleCache interface {
+	Cache
+	// GetStale is Get that also returns expired entries still within
+	// the stale window, reporting them as stale.
+	GetStale(ctx context.Context, key string) (value []byte, stale bool, ok bool, err error)
+}
+
+// FlushableCache is a Cache whose entries can be dropped before they
+// expire, to force fresh lookups.
+type FlushableCache interface {
+	Cache
+	Delete(ctx context.Context, key string) error
+	// Flush drops every entry.
+	Flush(ctx context.Context) error
+}
+
+type CacheStats struct {
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+	Entries int   `json:"entries"`
+}
+
+type memoryEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+// MemoryCache is an LRU cache with a fixed number of entries, each of which
+// expires after ttl and is dropped once the stale window is over too.
+type MemoryCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	stale      time.Duration
+	maxEntries int
+	order      *list.List
+	entries    map[string]*list.Element
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+func NewMemoryCache(maxEntries int, ttl time.Duration) *MemoryCache {
+	return &MemoryCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+// SetStaleWindow keeps expired entries for window more, for GetStale.
+func (c *MemoryCache) SetStaleWindow(window time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stale = window
+}
+
+func (c *MemoryCache) Get(_ context.Context, key string) ([]byte, bool, error) {
+	value, stale, ok := c.get(key)
+	if !ok || stale {
+		c.misses.Add(1)
+		return nil, false, nil
+	}
+	c.hits.Add(1)
+	return value, true, nil
+}
+
+func (c *MemoryCache) GetStale(_ context.Context, key string) ([]byte, bool, bool, error) {
+	value, stale, ok := c.get(key)
+	if !ok {
+		c.misses.Add(1)
+		return nil, false, false, nil
+	}
+	c.hits.Add(1)
+	return value, stale, true, nil
+}
+
+func (c *MemoryCache) get(key string) ([]byte, bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false, false
+	}
+
+	entry := elem.Value.(*memoryEntry)
+	now := time.Now()
+	if now.After(entry.expiresAt.Add(c.stale)) {
+		c.removeElement(elem)
+		return nil, false, false
+	}
+
+	c.order.MoveToFront(elem)
+	return entry.value, now.After(entry.expiresAt), true
+}
+
+func (c *MemoryCache) Set(_ context.Context, key string, value []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expiresAt := time.Now().Add(c.ttl)
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*memoryEntry)
+		entry.value = value
+		entry.expiresAt = expiresAt
+		c.order.MoveToFront(elem)
+		return nil
+	}
+
+	c.entries[key] = c.order.PushFront(&memoryEntry{key: key, value: value, expiresAt: expiresAt})
+	for c.maxEntries > 0 && c.order.Len() > c.maxEntries {
+		c.removeElement(c.order.Back())
+	}
+	return nil
+}
+
+func (c *MemoryCache) Delete(_ context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.removeElement(elem)
+	}
+	return nil
+}
+
+func (c *MemoryCache) Flush(context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.order.Init()
+	clear(c.entries)
+	return nil
+}
+
+func (c *MemoryCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return CacheStats{
+		Hits:    c.hits.Load(),
+		Misses:  c.misses.Load(),
+		Entries: c.order.Len(),
+	}
+}
+
+func (c *MemoryCache) removeElement(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*memoryEntry).key)
+}
@@ -0,0 +1,34 @@
+// Package cep resolves Brazilian postal codes (CEPs) by racing several
+// public providers and mapping their answers into a single Address schema.
+package cep
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrInvalid  = errors.New("cep must have exactly 8 digits, optionally formatted as 00000-000")
+	ErrNotFound = errors.New("cep not found")
+)
+
+// Normalize validates a raw CEP and returns it as 8 bare digits.
+// Surrounding spaces are ignored and a single hyphen is accepted between
+// the fifth and sixth digits, so both 01310100 and 01310-100 are valid.
+func Normalize(raw string) (string, error) {
+	cep := strings.TrimSpace(raw)
+	if len(cep) == 9 && cep[5] == '-' {
+		cep = cep[:5] + cep[6:]
+	}
+
+	if len(cep) != 8 {
+		return "", ErrInvalid
+	}
+	for _, c := range cep {
+		if c < '0' || c > '9' {
+			return "", ErrInvalid
+		}
+	}
+
+	return cep, nil
+}
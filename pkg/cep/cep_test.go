@@ -0,0 +1,35 @@
+package cep
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+		err  error
+	}{
+		{"01310100", "01310100", nil},
+		{"01310-100", "01310100", nil},
+		{"  01310-100\n", "01310100", nil},
+		{"00000000", "00000000", nil},
+		{"", "", ErrInvalid},
+		{"0131010", "", ErrInvalid},
+		{"013101000", "", ErrInvalid},
+		{"0131-0100", "", ErrInvalid},
+		{"01310--100", "", ErrInvalid},
+		{"01310.100", "", ErrInvalid},
+		{"01310 100", "", ErrInvalid},
+		{"0131a100", "", ErrInvalid},
+		{"-01310100", "", ErrInvalid},
+		{"０１３１０１００", "", ErrInvalid},
+	}
+	for _, test := range tests {
+		got, err := Normalize(test.raw)
+		if got != test.want || !errors.Is(err, test.err) || (test.err == nil && err != nil) {
+			t.Errorf("Normalize(%q) = %q, %v, want %q, %v", test.raw, got, err, test.want, test.err)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package cep
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// DefaultProviders are the providers a Client races when none are given.
+var DefaultProviders = []string{"viacep", "brasilapi", "opencep", "apicep"}
+
+// Client resolves CEPs through a set of providers, optionally caching the
+// answers. It is safe for concurrent use.
+type Client struct {
+	providers  []Provider
+	strategy   Strategy
+	timeout    time.Duration
+	cache      Cache
+	httpClient *http.Client
+
+	inflight singleflight.Group
+}
+
+type Option func(*Client)
+
+// WithProviders replaces the default providers. Use NewProvider to build
+// registered ones.
+func WithProviders(providers ...Provider) Option {
+	return func(c *Client) {
+		c.providers = providers
+	}
+}
+
+// WithTimeout bounds every lookup. The default is one second.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// WithCache stores the answers in cache, keyed by normalized CEP. Lookups
+// are not cached by default.
+func WithCache(cache Cache) Option {
+	return func(c *Client) {
+		c.cache = cache
+	}
+}
+
+// WithStrategy picks how the answer is chosen among the providers. The
+// default is Race.
+func WithStrategy(strategy Strategy) Option {
+	return func(c *Client) {
+		c.strategy = strategy
+	}
+}
+
+// WithHTTPClient sets the client the default providers send their requests
+// through. It has no effect together with WithProviders.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = client
+	}
+}
+
+func NewClient(opts ...Option) *Client {
+	c := &Client{strategy: Race, timeout: time.Second, httpClient: http.DefaultClient}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.providers == nil {
+		for _, name := range DefaultProviders {
+			provider, _ := NewProvider(name, Settings{}, c.httpClient)
+			c.providers = append(c.providers, provider)
+		}
+	}
+	return c
+}
+
+// Lookup validates raw and resolves it from the cache or, on a miss,
+// through the providers. Concurrent lookups of the same CEP share a
+// single race.
+func (c *Client) Lookup(ctx context.Context, raw string) (*Address, error) {
+	cep, err := Normalize(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.cache != nil {
+		body, ok, err := c.cache.Get(ctx, cep)
+		if err == nil && ok {
+			var address Address
+			if json.Unmarshal(body, &address) == nil {
+				return &address, nil
+			}
+		}
+	}
+
+	ch := c.inflight.DoChan(cep, func() (interface{}, error) {
+		return c.race(context.WithoutCancel(ctx), cep)
+	})
+	select {
+	case result := <-ch:
+		address, _ := result.Val.(*Address)
+		return address, result.Err
+	case <-ctx.Done():
+		return nil, ErrTimeout
+	}
+}
+
+func (c *Client) race(ctx context.Context, cep string) (*Address, error) {
+	if len(c.providers) == 0 {
+		return nil, ErrNoProviders
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	result, err := c.strategy(ctx, c.providers, cep)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil, ErrTimeout
+	}
+	if err != nil {
+		return nil, err
+	}
+	if result.Err != nil {
+		if errors.Is(result.Err, ErrNotFound) {
+			return nil, ErrNotFound
+		}
+		// already names the providers that failed
+		var failures ProviderFailures
+		if errors.As(result.Err, &failures) || errors.Is(result.Err, ErrNoQuorum) {
+			return nil, result.Err
+		}
+		return nil, &ProviderError{Provider: result.Provider, Err: result.Err}
+	}
+
+	if c.cache != nil {
+		if body, err := json.Marshal(result.Address); err == nil {
+			// a cache failure is not a lookup failure
+			_ = c.cache.Set(ctx, cep, body)
+		}
+	}
+	return result.Address, nil
+}
@@ -0,0 +1,49 @@
+package cep
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestClientLookupErrors(t *testing.T) {
+	errStrategy := errors.New("strategy broke")
+	tests := []struct {
+		name      string
+		strategy  Strategy
+		providers []Provider
+		check     func(error) bool
+	}{
+		{"deadline is a timeout", Race,
+			[]Provider{found("slow", time.Second, "São Paulo")},
+			func(err error) bool { return err == ErrTimeout }},
+		{"strategy error kept", func(context.Context, []Provider, string) (Result, error) {
+			return Result{}, errStrategy
+		}, []Provider{found("a", 0, "São Paulo")},
+			func(err error) bool { return err == errStrategy }},
+		{"not found", Race,
+			[]Provider{failing("a", 0, ErrNotFound)},
+			func(err error) bool { return err == ErrNotFound }},
+		{"failures returned as they are", Race,
+			[]Provider{failing("a", 0, errUpstream), failing("b", 0, errUpstream)},
+			func(err error) bool { _, ok := err.(ProviderFailures); return ok }},
+		{"no quorum returned as it is", Quorum(2),
+			[]Provider{found("a", 0, "São Paulo"), found("b", 0, "Campinas")},
+			func(err error) bool { return errors.Is(err, ErrNoQuorum) && !errors.As(err, new(*ProviderError)) }},
+		{"single failure names its provider", Priority,
+			[]Provider{failing("a", 0, errUpstream)},
+			func(err error) bool {
+				var providerErr *ProviderError
+				return errors.As(err, &providerErr) && providerErr.Provider == "a" && errors.Is(err, errUpstream)
+			}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := NewClient(WithProviders(test.providers...), WithStrategy(test.strategy), WithTimeout(20*time.Millisecond))
+			if _, err := client.Lookup(context.Background(), "01001-000"); !test.check(err) {
+				t.Errorf("got %#v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package cep
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidCnpj  = errors.New("cnpj must have 14 digits with valid check digits")
+	ErrCnpjNotFound = errors.New("cnpj not found")
+)
+
+// Company is the normalized schema of a CNPJ, a company's registration
+// with the Receita Federal.
+type Company struct {
+	Cnpj        string `json:"cnpj"`
+	Name        string `json:"name"`
+	TradeName   string `json:"trade_name,omitempty"`
+	Status      string `json:"status"`
+	OpenedAt    string `json:"opened_at,omitempty"`
+	LegalNature string `json:"legal_nature,omitempty"`
+	// MainActivity is the CNAE code and description of the main activity.
+	MainActivity *Activity      `json:"main_activity,omitempty"`
+	Address      CompanyAddress `json:"address"`
+	Phone        string         `json:"phone,omitempty"`
+	Email        string         `json:"email,omitempty"`
+	Provider     string         `json:"provider"`
+}
+
+type Activity struct {
+	Code        string `json:"code"`
+	Description string `json:"description"`
+}
+
+type CompanyAddress struct {
+	Street       string `json:"street"`
+	Number       string `json:"number,omitempty"`
+	Complement   string `json:"complement,omitempty"`
+	Neighborhood string `json:"neighborhood"`
+	City         string `json:"city"`
+	State        string `json:"state"`
+	Cep          string `json:"cep"`
+}
+
+// CnpjProvider looks up a normalized CNPJ in one upstream.
+// Implementations return ErrCnpjNotFound when the upstream knows the CNPJ
+// does not exist.
+type CnpjProvider interface {
+	Name() string
+	Lookup(ctx context.Context, cnpj string) (*Company, error)
+}
+
+// NormalizeCnpj validates a raw CNPJ, as 00000000000000 or
+// 00.000.000/0000-00, checking its two check digits.
+func NormalizeCnpj(raw string) (string, error) {
+	cnpj := strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '/', '-', ' ':
+			return -1
+		}
+		return r
+	}, raw)
+	if len(cnpj) != 14 || strings.Count(cnpj, cnpj[:1]) == 14 {
+		return "", ErrInvalidCnpj
+	}
+	for _, c := range cnpj {
+		if c < '0' || c > '9' {
+			return "", ErrInvalidCnpj
+		}
+	}
+	if cnpj[12] != cnpjCheckDigit(cnpj[:12]) || cnpj[13] != cnpjCheckDigit(cnpj[:13]) {
+		return "", ErrInvalidCnpj
+	}
+	return cnpj, nil
+}
+
+// cnpjCheckDigit is the mod 11 check digit of digits, weighted from 2 to 9
+// right to left.
+func cnpjCheckDigit(digits string) byte {
+	sum, weight := 0, 2
+	for i := len(digits) - 1; i >= 0; i-- {
+		sum += int(digits[i]-'0') * weight
+		if weight++; weight > 9 {
+			weight = 2
+		}
+	}
+	if rest := sum % 11; rest >= 2 {
+		return byte('0' + 11 - rest)
+	}
+	return '0'
+}
+
+// BrasilApiCnpjProvider looks CNPJs up in BrasilAPI's mirror of the
+// Receita Federal dataset.
+type BrasilApiCnpjProvider struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+type brasilApiCnpj struct {
+	RazaoSocial         string `json:"razao_social"`
+	NomeFantasia        string `json:"nome_fantasia"`
+	SituacaoCadastral   string `json:"descricao_situacao_cadastral"`
+	DataInicioAtividade string `json:"data_inicio_atividade"`
+	NaturezaJuridica    string `json:"natureza_juridica"`
+	CnaeFiscal          int    `json:"cnae_fiscal"`
+	CnaeFiscalDescricao string `json:"cnae_fiscal_descricao"`
+	TipoLogradouro      string `json:"descricao_tipo_de_logradouro"`
+	Logradouro          string `json:"logradouro"`
+	Numero              string `json:"numero"`
+	Complemento         string `json:"complemento"`
+	Bairro              string `json:"bairro"`
+	Municipio           string `json:"municipio"`
+	Uf                  string `json:"uf"`
+	Cep                 string `json:"cep"`
+	Telefone            string `json:"ddd_telefone_1"`
+	Email               string `json:"email"`
+}
+
+func NewBrasilApiCnpjProvider() *BrasilApiCnpjProvider {
+	return &BrasilApiCnpjProvider{BaseURL: "https://brasilapi.com.br/api/cnpj/v1/", Client: http.DefaultClient}
+}
+
+func (p *BrasilApiCnpjProvider) Name() string {
+	return "BrasilApi"
+}
+
+func (p *BrasilApiCnpjProvider) Lookup(ctx context.Context, cnpj string) (*Company, error) {
+	var response brasilApiCnpj
+	status, err := fetchJSON(ctx, p.Client, p.BaseURL+cnpj, &response)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNotFound {
+		return nil, ErrCnpjNotFound
+	}
+	if status != http.StatusOK {
+		return nil, &StatusError{Provider: "brasilapi", StatusCode: status}
+	}
+
+	company := &Company{
+		Cnpj:        cnpj,
+		Name:        response.RazaoSocial,
+		TradeName:   response.NomeFantasia,
+		Status:      response.SituacaoCadastral,
+		OpenedAt:    response.DataInicioAtividade,
+		LegalNature: response.NaturezaJuridica,
+		Address: CompanyAddress{
+			Street:       strings.TrimSpace(response.TipoLogradouro + " " + response.Logradouro),
+			Number:       response.Numero,
+			Complement:   response.Complemento,
+			Neighborhood: response.Bairro,
+			City:         response.Municipio,
+			State:        response.Uf,
+			Cep:          normalizedOr(response.Cep, response.Cep),
+		},
+		Phone:    response.Telefone,
+		Email:    response.Email,
+		Provider: p.Name(),
+	}
+	if response.CnaeFiscal != 0 {
+		company.MainActivity = &Activity{Code: fmt.Sprintf("%07d", response.CnaeFiscal), Description: response.CnaeFiscalDescricao}
+	}
+	return company, nil
+}
+
+// ReceitaWsProvider looks CNPJs up in ReceitaWS. Its free plan allows 3
+// lookups a minute; Token lifts it on the paid ones.
+type ReceitaWsProvider struct {
+	BaseURL string
+	Token   string
+	Client  *http.Client
+}
+
+type receitaWsCnpj struct {
+	Status             string `json:"status"`
+	Message            string `json:"message"`
+	Nome               string `json:"nome"`
+	Fantasia           string `json:"fantasia"`
+	Situacao           string `json:"situacao"`
+	Abertura           string `json:"abertura"`
+	NaturezaJuridica   string `json:"natureza_juridica"`
+	AtividadePrincipal []struct {
+		Code string `json:"code"`
+		Text string `json:"text"`
+	} `json:"atividade_principal"`
+	Logradouro  string `json:"logradouro"`
+	Numero      string `json:"numero"`
+	Complemento string `json:"complemento"`
+	Bairro      string `json:"bairro"`
+	Municipio   string `json:"municipio"`
+	Uf          string `json:"uf"`
+	Cep         string `json:"cep"`
+	Telefone    string `json:"telefone"`
+	Email       string `json:"email"`
+}
+
+func NewReceitaWsProvider() *ReceitaWsProvider {
+	return &ReceitaWsProvider{BaseURL: "https://receitaws.com.br/v1/cnpj/", Client: http.DefaultClient}
+}
+
+func (p *ReceitaWsProvider) Name() string {
+	return "ReceitaWs"
+}
+
+func (p *ReceitaWsProvider) Lookup(ctx context.Context, cnpj string) (*Company, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.BaseURL+cnpj, nil)
+	if err != nil {
+		return nil, err
+	}
+	if p.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.Token)
+	}
+
+	var response receitaWsCnpj
+	status, err := doJSON(p.Client, req, &response)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, &StatusError{Provider: "receitaws", StatusCode: status}
+	}
+	// errors come back with 200 and status ERROR
+	if response.Status == "ERROR" {
+		if message := strings.ToLower(response.Message); strings.Contains(message, "inválido") || strings.Contains(message, "não encontrado") {
+			return nil, ErrCnpjNotFound
+		}
+		return nil, errors.New("receitaws: " + response.Message)
+	}
+
+	company := &Company{
+		Cnpj:        cnpj,
+		Name:        response.Nome,
+		TradeName:   response.Fantasia,
+		Status:      response.Situacao,
+		OpenedAt:    isoDate(response.Abertura),
+		LegalNature: response.NaturezaJuridica,
+		Address: CompanyAddress{
+			Street:       response.Logradouro,
+			Number:       response.Numero,
+			Complement:   response.Complemento,
+			Neighborhood: response.Bairro,
+			City:         response.Municipio,
+			State:        response.Uf,
+			Cep:          normalizedOr(strings.ReplaceAll(response.Cep, ".", ""), response.Cep),
+		},
+		Phone:    response.Telefone,
+		Email:    response.Email,
+		Provider: p.Name(),
+	}
+	if len(response.AtividadePrincipal) > 0 {
+		activity := response.AtividadePrincipal[0]
+		company.MainActivity = &Activity{Code: strings.NewReplacer(".", "", "-", "").Replace(activity.Code), Description: activity.Text}
+	}
+	return company, nil
+}
+
+// isoDate turns ReceitaWS's 02/01/2006 dates into 2006-01-02, as BrasilAPI
+// answers them.
+func isoDate(date string) string {
+	parsed, err := time.Parse("02/01/2006", date)
+	if err != nil {
+		return date
+	}
+	return parsed.Format(time.DateOnly)
+}
@@ -0,0 +1,117 @@
+package cep
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const correiosEnvelope = `<?xml version="1.0" encoding="UTF-8"?>
+<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/" xmlns:cli="http://cliente.bean.master.sigep.bsb.correios.com.br/">
+  <soapenv:Header/>
+  <soapenv:Body>
+    <cli:consultaCEP>
+      <cep>%s</cep>
+    </cli:consultaCEP>
+  </soapenv:Body>
+</soapenv:Envelope>`
+
+// Correios is the consultaCEP return element of the SIGEP web service.
+type Correios struct {
+	Bairro       string `xml:"bairro"`
+	Cep          string `xml:"cep"`
+	Cidade       string `xml:"cidade"`
+	Complemento2 string `xml:"complemento2"`
+	End          string `xml:"end"`
+	Uf           string `xml:"uf"`
+}
+
+type correiosEnvelopeResponse struct {
+	Body struct {
+		Response struct {
+			Return *Correios `xml:"return"`
+		} `xml:"consultaCEPResponse"`
+		Fault *struct {
+			Code   string `xml:"faultcode"`
+			String string `xml:"faultstring"`
+		} `xml:"Fault"`
+	} `xml:"Body"`
+}
+
+// CorreiosProvider queries the official Correios SOAP service. Username
+// and Password are sent as basic auth when set.
+type CorreiosProvider struct {
+	URL      string
+	Username string
+	Password string
+	Client   *http.Client
+}
+
+func NewCorreiosProvider(username string, password string) *CorreiosProvider {
+	return &CorreiosProvider{
+		URL:      "https://apps.correios.com.br/SigepMasterJPA/AtendeClienteService/AtendeCliente",
+		Username: username,
+		Password: password,
+		Client:   http.DefaultClient,
+	}
+}
+
+func (p *CorreiosProvider) Name() string {
+	return "Correios"
+}
+
+func (p *CorreiosProvider) Lookup(ctx context.Context, cep string) (*Address, error) {
+	envelope := fmt.Sprintf(correiosEnvelope, cep)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.URL, bytes.NewBufferString(envelope))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/xml; charset=utf-8")
+	req.Header.Set("SOAPAction", "consultaCEP")
+	if p.Username != "" {
+		req.SetBasicAuth(p.Username, p.Password)
+	}
+
+	status, body, err := do(p.Client, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// faults come back with HTTP 500, so the body is parsed before the
+	// status is looked at
+	var envelopeResponse correiosEnvelopeResponse
+	err = xml.Unmarshal(body, &envelopeResponse)
+	if err != nil {
+		if status != http.StatusOK {
+			return nil, &StatusError{Provider: "correios", StatusCode: status}
+		}
+		return nil, fmt.Errorf("correios: %w", err)
+	}
+	if fault := envelopeResponse.Body.Fault; fault != nil {
+		if strings.Contains(strings.ToUpper(fault.String), "NAO ENCONTRADO") {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("correios: %s: %s", fault.Code, fault.String)
+	}
+	if status != http.StatusOK {
+		return nil, &StatusError{Provider: "correios", StatusCode: status}
+	}
+
+	correios := envelopeResponse.Body.Response.Return
+	if correios == nil {
+		return nil, ErrNotFound
+	}
+
+	return &Address{
+		Cep:          normalizedOr(correios.Cep, cep),
+		State:        correios.Uf,
+		City:         correios.Cidade,
+		Neighborhood: correios.Bairro,
+		Street:       correios.End,
+		Complement:   correios.Complemento2,
+		Provider:     p.Name(),
+	}, nil
+}
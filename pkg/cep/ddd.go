@@ -0,0 +1,61 @@
+package cep
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrInvalidDdd  = errors.New("ddd must have exactly 2 digits")
+	ErrDddNotFound = errors.New("ddd not found")
+)
+
+// DddInfo lists the cities covered by a DDD (area code).
+type DddInfo struct {
+	Ddd    string   `json:"ddd"`
+	State  string   `json:"state"`
+	Cities []string `json:"cities"`
+}
+
+// NormalizeDdd validates a raw DDD, ignoring surrounding spaces and a
+// leading zero as in 011.
+func NormalizeDdd(raw string) (string, error) {
+	ddd := strings.TrimSpace(raw)
+	if len(ddd) == 3 && ddd[0] == '0' {
+		ddd = ddd[1:]
+	}
+	if len(ddd) != 2 || ddd[0] < '1' || ddd[0] > '9' || ddd[1] < '0' || ddd[1] > '9' {
+		return "", ErrInvalidDdd
+	}
+	return ddd, nil
+}
+
+// DddProvider lists the cities of a DDD through BrasilAPI.
+type DddProvider struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+func NewDddProvider() *DddProvider {
+	return &DddProvider{BaseURL: "https://brasilapi.com.br/api/ddd/v1/", Client: http.DefaultClient}
+}
+
+// Lookup lists the cities of a normalized ddd.
+func (p *DddProvider) Lookup(ctx context.Context, ddd string) (*DddInfo, error) {
+	var info DddInfo
+	status, err := fetchJSON(ctx, p.Client, p.BaseURL+ddd, &info)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNotFound {
+		return nil, ErrDddNotFound
+	}
+	if status != http.StatusOK {
+		return nil, &StatusError{Provider: "brasilapi", StatusCode: status}
+	}
+
+	info.Ddd = ddd
+	return &info, nil
+}
@@ -0,0 +1,21 @@
+package cep
+
+import "math"
+
+// earthRadius is the mean radius of the Earth, in kilometers.
+const earthRadius = 6371.0
+
+// Distance is the great-circle distance between a and b in kilometers,
+// by the haversine formula.
+func Distance(a, b Coordinates) float64 {
+	lat1, lat2 := radians(a.Latitude), radians(b.Latitude)
+	dLat := lat2 - lat1
+	dLon := radians(b.Longitude - a.Longitude)
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+func radians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
@@ -0,0 +1,155 @@
+package cep
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidVehicleType = errors.New("vehicle type must be cars, motorcycles or trucks")
+	ErrInvalidFipeCode    = errors.New("fipe code must have 6 digits and a check digit, as in 001004-9")
+	ErrFipeNotFound       = errors.New("fipe code not found")
+)
+
+// vehicleTypes maps the vehicle types, in English or as the FIPE table
+// names them, to the latter.
+var vehicleTypes = map[string]string{
+	"cars": "carros", "motorcycles": "motos", "trucks": "caminhoes",
+	"carros": "carros", "motos": "motos", "caminhoes": "caminhoes",
+}
+
+// FipeBrand is a vehicle brand of the FIPE table, the reference prices of
+// used vehicles.
+type FipeBrand struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+}
+
+// FipePrice is the reference price of a vehicle model in a model year.
+type FipePrice struct {
+	FipeCode  string `json:"fipe_code"`
+	Brand     string `json:"brand"`
+	Model     string `json:"model"`
+	ModelYear int    `json:"model_year"`
+	Fuel      string `json:"fuel"`
+	// Price is in reais.
+	Price float64 `json:"price"`
+	// ReferenceMonth is the edition of the table, as in "junho de 2021".
+	ReferenceMonth string `json:"reference_month"`
+}
+
+// NormalizeVehicleType validates a vehicle type, returning the FIPE
+// table's name for it.
+func NormalizeVehicleType(raw string) (string, error) {
+	vehicleType, ok := vehicleTypes[strings.ToLower(strings.TrimSpace(raw))]
+	if !ok {
+		return "", ErrInvalidVehicleType
+	}
+	return vehicleType, nil
+}
+
+// NormalizeFipeCode validates a raw FIPE code, with or without the hyphen
+// before its check digit, returning it as 001004-9.
+func NormalizeFipeCode(raw string) (string, error) {
+	code := strings.ReplaceAll(strings.TrimSpace(raw), "-", "")
+	if len(code) != 7 {
+		return "", ErrInvalidFipeCode
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return "", ErrInvalidFipeCode
+		}
+	}
+	return code[:6] + "-" + code[6:], nil
+}
+
+// FipeProvider queries the FIPE table through BrasilAPI.
+type FipeProvider struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+type brasilApiFipeBrand struct {
+	Nome  string `json:"nome"`
+	Valor string `json:"valor"`
+}
+
+type brasilApiFipePrice struct {
+	Valor         string `json:"valor"`
+	Marca         string `json:"marca"`
+	Modelo        string `json:"modelo"`
+	AnoModelo     int    `json:"anoModelo"`
+	Combustivel   string `json:"combustivel"`
+	CodigoFipe    string `json:"codigoFipe"`
+	MesReferencia string `json:"mesReferencia"`
+}
+
+func NewFipeProvider() *FipeProvider {
+	return &FipeProvider{BaseURL: "https://brasilapi.com.br/api/fipe/", Client: http.DefaultClient}
+}
+
+// Brands lists the brands of a normalized vehicle type.
+func (p *FipeProvider) Brands(ctx context.Context, vehicleType string) ([]FipeBrand, error) {
+	var response []brasilApiFipeBrand
+	status, err := fetchJSON(ctx, p.Client, p.BaseURL+"marcas/v1/"+vehicleType, &response)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, &StatusError{Provider: "brasilapi", StatusCode: status}
+	}
+
+	brands := make([]FipeBrand, 0, len(response))
+	for _, brand := range response {
+		brands = append(brands, FipeBrand{Code: brand.Valor, Name: brand.Nome})
+	}
+	return brands, nil
+}
+
+// Prices lists the reference prices of a normalized FIPE code, one for
+// each model year.
+func (p *FipeProvider) Prices(ctx context.Context, code string) ([]FipePrice, error) {
+	var response []brasilApiFipePrice
+	status, err := fetchJSON(ctx, p.Client, p.BaseURL+"preco/v1/"+code, &response)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNotFound {
+		return nil, ErrFipeNotFound
+	}
+	if status != http.StatusOK {
+		return nil, &StatusError{Provider: "brasilapi", StatusCode: status}
+	}
+	if len(response) == 0 {
+		return nil, ErrFipeNotFound
+	}
+
+	prices := make([]FipePrice, 0, len(response))
+	for _, price := range response {
+		prices = append(prices, FipePrice{
+			FipeCode:       price.CodigoFipe,
+			Brand:          price.Marca,
+			Model:          price.Modelo,
+			ModelYear:      price.AnoModelo,
+			Fuel:           price.Combustivel,
+			Price:          parseReais(price.Valor),
+			ReferenceMonth: strings.TrimSpace(price.MesReferencia),
+		})
+	}
+	return prices, nil
+}
+
+// parseReais parses an amount as the FIPE table writes it, "R$ 6.022,00",
+// returning 0 when it is not one.
+func parseReais(amount string) float64 {
+	amount = strings.TrimSpace(strings.TrimPrefix(amount, "R$"))
+	amount = strings.ReplaceAll(strings.ReplaceAll(amount, ".", ""), ",", ".")
+	value, err := strconv.ParseFloat(amount, 64)
+	if err != nil {
+		return 0
+	}
+	return value
+}
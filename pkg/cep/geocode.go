@@ -0,0 +1,87 @@
+package cep
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+var ErrNoCoordinates = errors.New("no coordinates found for the address")
+
+// Geocoder finds the coordinates of an address.
+type Geocoder interface {
+	Geocode(ctx context.Context, address *Address) (*Location, error)
+}
+
+// BrasilApiGeocoder takes the coordinates BrasilAPI keeps for the CEP.
+type BrasilApiGeocoder struct {
+	Provider *BrasilApiProvider
+}
+
+func (g *BrasilApiGeocoder) Geocode(ctx context.Context, address *Address) (*Location, error) {
+	found, err := g.Provider.Lookup(ctx, address.Cep)
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrNoCoordinates
+	}
+	if err != nil {
+		return nil, err
+	}
+	if found.Location == nil {
+		return nil, ErrNoCoordinates
+	}
+	return found.Location, nil
+}
+
+// NominatimGeocoder searches the address in OpenStreetMap's Nominatim.
+// Its usage policy requires an identifying UserAgent.
+type NominatimGeocoder struct {
+	BaseURL   string
+	UserAgent string
+	Client    *http.Client
+}
+
+func NewNominatimGeocoder(userAgent string) *NominatimGeocoder {
+	return &NominatimGeocoder{BaseURL: "https://nominatim.openstreetmap.org/search", UserAgent: userAgent, Client: http.DefaultClient}
+}
+
+func (g *NominatimGeocoder) Geocode(ctx context.Context, address *Address) (*Location, error) {
+	query := url.Values{
+		"format":     {"json"},
+		"limit":      {"1"},
+		"country":    {"Brazil"},
+		"state":      {address.State},
+		"city":       {address.City},
+		"street":     {address.Street},
+		"postalcode": {address.Cep},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.BaseURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", g.UserAgent)
+
+	var places []struct {
+		Lat string `json:"lat"`
+		Lon string `json:"lon"`
+	}
+	status, err := doJSON(g.Client, req, &places)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, &StatusError{Provider: "nominatim", StatusCode: status}
+	}
+	if len(places) == 0 {
+		return nil, ErrNoCoordinates
+	}
+
+	location, err := ParseLocation(places[0].Lat, places[0].Lon)
+	if err != nil {
+		return nil, err
+	}
+	if location == nil {
+		return nil, ErrNoCoordinates
+	}
+	return location, nil
+}
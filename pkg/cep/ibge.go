@@ -0,0 +1,94 @@
+package cep
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+var ErrMunicipalityNotFound = errors.New("municipality not found")
+
+// Municipality is the official IBGE record of a municipality, with the
+// regions it belongs to.
+type Municipality struct {
+	Ibge        string `json:"ibge" xml:"ibge"`
+	Name        string `json:"name" xml:"name"`
+	Microregion string `json:"microregion,omitempty" xml:"microregion,omitempty"`
+	Mesoregion  string `json:"mesoregion,omitempty" xml:"mesoregion,omitempty"`
+	State       string `json:"state" xml:"state"`
+	Region      string `json:"region" xml:"region"`
+}
+
+type ibgeMunicipality struct {
+	ID           int    `json:"id"`
+	Nome         string `json:"nome"`
+	Microrregiao *struct {
+		Nome        string `json:"nome"`
+		Mesorregiao struct {
+			Nome string `json:"nome"`
+		} `json:"mesorregiao"`
+	} `json:"microrregiao"`
+	RegiaoImediata *struct {
+		RegiaoIntermediaria struct {
+			UF ibgeState `json:"UF"`
+		} `json:"regiao-intermediaria"`
+	} `json:"regiao-imediata"`
+}
+
+type ibgeState struct {
+	Sigla  string `json:"sigla"`
+	Regiao struct {
+		Nome string `json:"nome"`
+	} `json:"regiao"`
+}
+
+// IbgeProvider looks municipalities up in the IBGE Localidades API.
+type IbgeProvider struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+func NewIbgeProvider() *IbgeProvider {
+	return &IbgeProvider{BaseURL: "https://servicodados.ibge.gov.br/api/v1/localidades/municipios/", Client: http.DefaultClient}
+}
+
+// Lookup fetches the municipality with the 7 digit IBGE code.
+func (p *IbgeProvider) Lookup(ctx context.Context, code string) (*Municipality, error) {
+	if _, err := strconv.Atoi(code); err != nil || len(code) != 7 {
+		return nil, ErrMunicipalityNotFound
+	}
+
+	status, body, err := fetch(ctx, p.Client, p.BaseURL+code)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, &StatusError{Provider: "ibge", StatusCode: status}
+	}
+	// unknown codes are answered with an empty list
+	if len(body) > 0 && body[0] == '[' {
+		return nil, ErrMunicipalityNotFound
+	}
+
+	var m ibgeMunicipality
+	err = json.Unmarshal(body, &m)
+	if err != nil {
+		return nil, err
+	}
+
+	municipality := &Municipality{Ibge: strconv.Itoa(m.ID), Name: m.Nome}
+	if m.Microrregiao != nil {
+		municipality.Microregion = m.Microrregiao.Nome
+		municipality.Mesoregion = m.Microrregiao.Mesorregiao.Nome
+	}
+	// the state is read from the immediate region, which unlike the
+	// microregion is set for every municipality
+	if m.RegiaoImediata != nil {
+		uf := m.RegiaoImediata.RegiaoIntermediaria.UF
+		municipality.State = uf.Sigla
+		municipality.Region = uf.Regiao.Nome
+	}
+	return municipality, nil
+}
@@ -0,0 +1,42 @@
+package cep
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidCoordinates is returned for coordinates that are not numbers
+// or fall outside the globe.
+var ErrInvalidCoordinates = errors.New("invalid coordinates")
+
+// ParseLocation parses the decimal degrees upstreams send as strings into
+// a Point, nil when either is empty, as they are when the upstream has no
+// coordinates for the address.
+func ParseLocation(latitude, longitude string) (*Location, error) {
+	latitude, longitude = strings.TrimSpace(latitude), strings.TrimSpace(longitude)
+	if latitude == "" || longitude == "" {
+		return nil, nil
+	}
+	lat, errLat := strconv.ParseFloat(latitude, 64)
+	lon, errLon := strconv.ParseFloat(longitude, 64)
+	if errLat != nil || errLon != nil {
+		return nil, fmt.Errorf("%w: %q, %q", ErrInvalidCoordinates, latitude, longitude)
+	}
+	coordinates := Coordinates{Longitude: lon, Latitude: lat}
+	if err := coordinates.Validate(); err != nil {
+		return nil, err
+	}
+	return &Location{Type: "Point", Coordinates: coordinates}, nil
+}
+
+// Validate reports whether c is a point on the globe: finite, with the
+// latitude within ±90 and the longitude within ±180 degrees.
+func (c Coordinates) Validate() error {
+	if math.IsNaN(c.Latitude) || math.IsNaN(c.Longitude) || math.Abs(c.Latitude) > 90 || math.Abs(c.Longitude) > 180 {
+		return fmt.Errorf("%w: latitude %v, longitude %v", ErrInvalidCoordinates, c.Latitude, c.Longitude)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package cep
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// defaultFixtures holds a few well known CEPs, enough for a demo.
+//
+//go:embed fixtures/*.json
+var defaultFixtures embed.FS
+
+// MockProvider answers from fixtures instead of an upstream, after an
+// artificial latency and failing at a given rate, so that tests and demos
+// do not depend on the live providers.
+type MockProvider struct {
+	name     string
+	fixtures map[string]Address
+
+	// Latency delays every answer, plus a random share of Jitter.
+	Latency time.Duration
+	Jitter  time.Duration
+	// FailureRate is the share of the lookups failing as if the upstream
+	// answered 503.
+	FailureRate float64
+}
+
+// NewMockProvider builds a provider named name answering from fixtures.
+func NewMockProvider(name string, fixtures map[string]Address) *MockProvider {
+	return &MockProvider{name: name, fixtures: fixtures}
+}
+
+func (p *MockProvider) Name() string {
+	return p.name
+}
+
+func (p *MockProvider) Lookup(ctx context.Context, cep string) (*Address, error) {
+	delay := p.Latency
+	if p.Jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(p.Jitter)))
+	}
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	if p.FailureRate > 0 && rand.Float64() < p.FailureRate {
+		return nil, &StatusError{Provider: p.name, StatusCode: http.StatusServiceUnavailable}
+	}
+	address, ok := p.fixtures[cep]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	address.Cep = cep
+	address.Provider = p.name
+	return &address, nil
+}
+
+// DefaultFixtures returns the embedded fixtures.
+func DefaultFixtures() fs.FS {
+	fixtures, _ := fs.Sub(defaultFixtures, "fixtures")
+	return fixtures
+}
+
+// LoadFixtures reads the fixtures of fsys, one <cep>.json file holding an
+// Address per CEP. Fixtures in a directory named after provider override
+// the shared ones, so that providers can be made to disagree.
+func LoadFixtures(fsys fs.FS, provider string) (map[string]Address, error) {
+	fixtures := map[string]Address{}
+	for _, dir := range []string{".", provider} {
+		entries, err := fs.ReadDir(fsys, dir)
+		if err != nil {
+			if dir != "." && errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() || !strings.HasSuffix(name, ".json") {
+				continue
+			}
+			cep, err := Normalize(strings.TrimSuffix(name, ".json"))
+			if err != nil {
+				return nil, fmt.Errorf("fixture %s: %w", path.Join(dir, name), err)
+			}
+			data, err := fs.ReadFile(fsys, path.Join(dir, name))
+			if err != nil {
+				return nil, err
+			}
+			var address Address
+			err = json.Unmarshal(data, &address)
+			if err != nil {
+				return nil, fmt.Errorf("fixture %s: %w", path.Join(dir, name), err)
+			}
+			fixtures[cep] = address
+		}
+	}
+	return fixtures, nil
+}
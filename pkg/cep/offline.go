@@ -0,0 +1,143 @@
+package cep
+
+import (
+	"compress/gzip"
+	"context"
+	_ "embed"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// offlineDataset holds the CEP range of every state and of the state
+// capitals, enough to place any CEP in its state.
+//
+//go:embed offline.csv
+var offlineDataset string
+
+type cepRange struct {
+	start, end string
+	state      string
+	city       string
+}
+
+// OfflineProvider answers from a local dataset of CEP ranges, as a last
+// resort when every remote provider fails. Its addresses carry only the
+// state and, when the dataset has it, the city, and are marked with the
+// "offline" source.
+type OfflineProvider struct {
+	ranges []cepRange
+}
+
+// NewOfflineProvider loads the embedded dataset.
+func NewOfflineProvider() *OfflineProvider {
+	p, err := LoadOfflineDataset(strings.NewReader(offlineDataset))
+	if err != nil {
+		panic("cep: embedded offline dataset: " + err.Error())
+	}
+	return p
+}
+
+// OpenOfflineDataset loads a dataset file, gunzipping it when its name
+// ends in .gz.
+func OpenOfflineDataset(path string) (*OfflineProvider, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return LoadOfflineDataset(r)
+}
+
+// LoadOfflineDataset reads a CSV of start,end,state,city rows, the first
+// row being a header. Ranges may nest, as a city inside its state: a CEP
+// gets the narrowest range holding it.
+func LoadOfflineDataset(r io.Reader) (*OfflineProvider, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 4
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) < 2 {
+		return nil, fmt.Errorf("offline dataset has no ranges")
+	}
+
+	ranges := make([]cepRange, 0, len(records)-1)
+	for i, record := range records[1:] {
+		start, errStart := Normalize(record[0])
+		end, errEnd := Normalize(record[1])
+		if errStart != nil || errEnd != nil || start > end {
+			return nil, fmt.Errorf("offline dataset line %d: invalid range %q-%q", i+2, record[0], record[1])
+		}
+		ranges = append(ranges, cepRange{start: start, end: end, state: record[2], city: record[3]})
+	}
+	return &OfflineProvider{ranges: ranges}, nil
+}
+
+func (p *OfflineProvider) Name() string {
+	return "Offline"
+}
+
+func (p *OfflineProvider) Lookup(ctx context.Context, cep string) (*Address, error) {
+	var best *cepRange
+	for i := range p.ranges {
+		r := &p.ranges[i]
+		// fixed width CEPs compare as strings
+		if cep < r.start || cep > r.end {
+			continue
+		}
+		if best == nil || r.end <= best.end && r.start >= best.start {
+			best = r
+		}
+	}
+	if best == nil {
+		return nil, ErrNotFound
+	}
+	return &Address{
+		Cep:      cep,
+		State:    best.state,
+		City:     best.city,
+		Provider: p.Name(),
+		Source:   "offline",
+	}, nil
+}
+
+// Range is a range of CEPs of the offline dataset, from Start to End
+// inclusive.
+type Range struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+	State string `json:"state"`
+	City  string `json:"city,omitempty"`
+}
+
+// Ranges returns up to limit ranges holding CEPs starting with prefix, in
+// the order of the dataset.
+func (p *OfflineProvider) Ranges(prefix string, limit int) []Range {
+	low := prefix + strings.Repeat("0", 8-len(prefix))
+	high := prefix + strings.Repeat("9", 8-len(prefix))
+	var ranges []Range
+	for _, r := range p.ranges {
+		if len(ranges) == limit {
+			break
+		}
+		if r.end < low || r.start > high {
+			continue
+		}
+		ranges = append(ranges, Range{Start: r.start, End: r.end, State: r.state, City: r.city})
+	}
+	return ranges
+}
@@ -0,0 +1,53 @@
+package cep
+
+import (
+	"context"
+	"net/http"
+)
+
+type OpenCep struct {
+	Cep         string `json:"cep"`
+	Logradouro  string `json:"logradouro"`
+	Complemento string `json:"complemento"`
+	Bairro      string `json:"bairro"`
+	Localidade  string `json:"localidade"`
+	Uf          string `json:"uf"`
+	Ibge        string `json:"ibge"`
+}
+
+type OpenCepProvider struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+func NewOpenCepProvider() *OpenCepProvider {
+	return &OpenCepProvider{BaseURL: "https://opencep.com/v1/", Client: http.DefaultClient}
+}
+
+func (p *OpenCepProvider) Name() string {
+	return "OpenCep"
+}
+
+func (p *OpenCepProvider) Lookup(ctx context.Context, cep string) (*Address, error) {
+	var openCep OpenCep
+	status, err := fetchJSON(ctx, p.Client, p.BaseURL+cep, &openCep)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if status != http.StatusOK {
+		return nil, &StatusError{Provider: "opencep", StatusCode: status}
+	}
+	return &Address{
+		Cep:          normalizedOr(openCep.Cep, cep),
+		State:        openCep.Uf,
+		City:         openCep.Localidade,
+		Neighborhood: openCep.Bairro,
+		Street:       openCep.Logradouro,
+		Complement:   openCep.Complemento,
+		Ibge:         openCep.Ibge,
+		Provider:     p.Name(),
+	}, nil
+}
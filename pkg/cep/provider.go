@@ -0,0 +1,218 @@
+package cep
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	ErrTimeout      = errors.New("timeout reached")
+	ErrNoProviders  = errors.New("no provider is available")
+	ErrBodyTooLarge = errors.New("response body too large")
+)
+
+// MaxBodySize bounds what is read of an upstream response, so that a
+// misbehaving upstream cannot exhaust the memory. Answers past it fail
+// with ErrBodyTooLarge.
+var MaxBodySize int64 = 64 << 10
+
+// Address is the normalized schema every provider maps its response into.
+type Address struct {
+	Cep string `json:"cep" xml:"cep"`
+	// Country is only set by international lookups, whose postal code
+	// goes in Cep.
+	Country      string    `json:"country,omitempty" xml:"country,omitempty"`
+	State        string    `json:"state" xml:"state"`
+	City         string    `json:"city" xml:"city"`
+	Neighborhood string    `json:"neighborhood" xml:"neighborhood"`
+	Street       string    `json:"street" xml:"street"`
+	Complement   string    `json:"complement,omitempty" xml:"complement,omitempty"`
+	Ibge         string    `json:"ibge,omitempty" xml:"ibge,omitempty"`
+	Ddd          string    `json:"ddd,omitempty" xml:"ddd,omitempty"`
+	Location     *Location `json:"location,omitempty" xml:"location,omitempty"`
+	Provider     string    `json:"provider" xml:"provider"`
+
+	// Source is "offline" when the address only comes from the offline
+	// dataset.
+	Source string `json:"source,omitempty" xml:"source,omitempty"`
+	// Stale is set on expired cached addresses served while they are
+	// refreshed.
+	Stale bool `json:"stale,omitempty" xml:"stale,omitempty"`
+
+	// Municipality is only set when the address is enriched with ibge.
+	Municipality *Municipality `json:"municipality,omitempty" xml:"municipality,omitempty"`
+}
+
+type Coordinates struct {
+	Longitude float64 `json:"longitude" xml:"longitude"`
+	Latitude  float64 `json:"latitude" xml:"latitude"`
+}
+
+type Location struct {
+	Type        string      `json:"type" xml:"type"`
+	Coordinates Coordinates `json:"coordinates" xml:"coordinates"`
+}
+
+// Provider looks up a normalized CEP in one upstream. Implementations
+// return ErrNotFound when the upstream knows the CEP does not exist.
+type Provider interface {
+	Name() string
+	Lookup(ctx context.Context, cep string) (*Address, error)
+}
+
+// StatusError reports an upstream answering with an unexpected HTTP status.
+type StatusError struct {
+	Provider   string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: unexpected status %d", e.Provider, e.StatusCode)
+}
+
+// ProviderError is a failure reported by the provider whose answer the
+// strategy picked.
+type ProviderError struct {
+	Provider string
+	Err      error
+}
+
+func (e *ProviderError) Error() string {
+	if e.Provider == "" {
+		return e.Err.Error()
+	}
+	return e.Provider + ": " + e.Err.Error()
+}
+
+func (e *ProviderError) Unwrap() error {
+	return e.Err
+}
+
+// fetch performs a GET and returns the status code and body, leaving the
+// interpretation of the status to each provider.
+func fetch(ctx context.Context, client *http.Client, url string) (int, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return do(client, req)
+}
+
+// do sends req and reads the body, for providers that need to build their
+// own requests.
+func do(client *http.Client, req *http.Request) (int, []byte, error) {
+	response, err := client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer response.Body.Close()
+
+	body := newBoundedBody(response.Body)
+	data, err := io.ReadAll(body)
+	traceHTTPExchange(req.Context(), req, response.StatusCode, body.read)
+	if err == nil && body.exceeded() {
+		err = ErrBodyTooLarge
+	}
+	if err != nil {
+		return response.StatusCode, nil, err
+	}
+
+	return response.StatusCode, data, nil
+}
+
+// fetchJSON performs a GET and, when the upstream answers 200, decodes the
+// body into v as it streams in. Other statuses are left for each provider
+// to interpret.
+func fetchJSON(ctx context.Context, client *http.Client, url string, v any) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	return doJSON(client, req, v)
+}
+
+// doJSON is fetchJSON for providers that need to build their own requests.
+func doJSON(client *http.Client, req *http.Request, v any) (int, error) {
+	response, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	body := newBoundedBody(response.Body)
+	if response.StatusCode == http.StatusOK {
+		err = json.NewDecoder(body).Decode(v)
+	} else {
+		// drained so that the connection can be reused
+		io.Copy(io.Discard, body)
+	}
+	traceHTTPExchange(req.Context(), req, response.StatusCode, body.read)
+	if err != nil && body.exceeded() {
+		err = ErrBodyTooLarge
+	}
+	return response.StatusCode, err
+}
+
+// boundedBody reads up to one byte past MaxBodySize, which tells a body
+// over the limit apart from one of exactly its size.
+type boundedBody struct {
+	r    io.Reader
+	read int
+}
+
+func newBoundedBody(r io.Reader) *boundedBody {
+	return &boundedBody{r: io.LimitReader(r, MaxBodySize+1)}
+}
+
+func (b *boundedBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.read += n
+	return n, err
+}
+
+func (b *boundedBody) exceeded() bool {
+	return int64(b.read) > MaxBodySize
+}
+
+// traceHTTPExchange annotates the current span, if any, with an upstream
+// exchange.
+func traceHTTPExchange(ctx context.Context, req *http.Request, status int, responseBytes int) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+	span.SetAttributes(
+		semconv.HTTPRequestMethodKey.String(req.Method),
+		semconv.URLFull(req.URL.String()),
+		semconv.HTTPResponseStatusCode(status),
+		semconv.HTTPRequestBodySize(int(max(req.ContentLength, 0))),
+		semconv.HTTPResponseBodySize(responseBytes),
+	)
+}
+
+// normalizedOr returns the normalized form of cep, or fallback when the
+// upstream echoed something unexpected.
+func normalizedOr(cep string, fallback string) string {
+	normalized, err := Normalize(cep)
+	if err != nil {
+		return fallback
+	}
+	return normalized
+}
+
+// deref turns the null-able strings some providers send into plain ones.
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
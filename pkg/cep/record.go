@@ -0,0 +1,162 @@
+package cep
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// ErrNoRecording is returned by ReplayTransport for a request it has no
+// recorded response for.
+var ErrNoRecording = errors.New("no recorded response")
+
+// Recording is an upstream response saved by RecordingTransport.
+type Recording struct {
+	Method     string      `json:"method"`
+	URL        string      `json:"url"`
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body"`
+}
+
+// RecordingTransport sends the requests through Transport, or the default
+// transport when nil, and saves every response to Dir as
+// <cep>/<Name>.json, so that ReplayTransport can serve them back.
+// Requests without a CEP in their URL or body are not saved.
+type RecordingTransport struct {
+	Dir       string
+	Name      string
+	Transport http.RoundTripper
+}
+
+func (t *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	path, pathErr := recordingPath(t.Dir, t.Name, req)
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil || pathErr != nil {
+		return resp, err
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, MaxBodySize+1))
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	header := http.Header{}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	err = saveRecording(path, Recording{
+		Method:     req.Method,
+		URL:        req.URL.String(),
+		StatusCode: resp.StatusCode,
+		Header:     header,
+		Body:       string(body),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("recording %s: %w", req.URL, err)
+	}
+	return resp, nil
+}
+
+// saveRecording writes through a temporary file, so that a concurrent
+// replay never reads half a recording.
+func saveRecording(path string, recording Recording) error {
+	data, err := json.MarshalIndent(recording, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".recording-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(append(data, '\n'))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// ReplayTransport answers the requests with the responses a
+// RecordingTransport saved to Dir under the same Name, never reaching the
+// network. Requests it has no recording for fail with ErrNoRecording.
+type ReplayTransport struct {
+	Dir  string
+	Name string
+}
+
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	path, err := recordingPath(t.Dir, t.Name, req)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoRecording
+	}
+	if err != nil {
+		return nil, err
+	}
+	var recording Recording
+	err = json.Unmarshal(data, &recording)
+	if err != nil {
+		return nil, fmt.Errorf("recording %s: %w", path, err)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recording.StatusCode, http.StatusText(recording.StatusCode)),
+		StatusCode:    recording.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        recording.Header,
+		Body:          io.NopCloser(bytes.NewReader([]byte(recording.Body))),
+		ContentLength: int64(len(recording.Body)),
+		Request:       req,
+	}, nil
+}
+
+// recordingCep matches a CEP, with or without its hyphen.
+var recordingCep = regexp.MustCompile(`\b\d{5}-?\d{3}\b`)
+
+// recordingPath is where the response to req is recorded, keyed by the
+// CEP found in its URL or, for SOAP requests, in its body.
+func recordingPath(dir, name string, req *http.Request) (string, error) {
+	match := recordingCep.FindString(req.URL.Path)
+	if match == "" && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return "", err
+		}
+		data, err := io.ReadAll(body)
+		body.Close()
+		if err != nil {
+			return "", err
+		}
+		match = recordingCep.FindString(string(data))
+	}
+	cep, err := Normalize(match)
+	if err != nil {
+		return "", errors.New("no cep to key the recording by")
+	}
+	return filepath.Join(dir, cep, name+".json"), nil
+}
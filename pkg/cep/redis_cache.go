@@ -0,0 +1,132 @@
+package cep
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const redisKeyPrefix = "multi:cep:"
+
+// RedisCache shares cached lookups between instances. Timeouts are kept
+// short so an unreachable Redis degrades into cache misses instead of
+// eating into the lookup deadline.
+type RedisCache struct {
+	client *redis.Client
+	ttl    time.Duration
+	stale  time.Duration
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+func NewRedisCache(addr string, password string, db int, ttl time.Duration) *RedisCache {
+	client := redis.NewClient(&redis.Options{
+		Addr:         addr,
+		Password:     password,
+		DB:           db,
+		DialTimeout:  200 * time.Millisecond,
+		ReadTimeout:  100 * time.Millisecond,
+		WriteTimeout: 100 * time.Millisecond,
+	})
+	return &RedisCache{client: client, ttl: ttl}
+}
+
+func (c *RedisCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	if c.stale > 0 {
+		value, stale, ok, err := c.getStale(ctx, key)
+		if !ok || stale {
+			c.misses.Add(1)
+			return nil, false, err
+		}
+		c.hits.Add(1)
+		return value, true, nil
+	}
+
+	value, err := c.client.Get(ctx, redisKeyPrefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		c.misses.Add(1)
+		return nil, false, nil
+	}
+	if err != nil {
+		c.misses.Add(1)
+		return nil, false, err
+	}
+	c.hits.Add(1)
+	return value, true, nil
+}
+
+// SetStaleWindow keeps expired entries for window more, for GetStale.
+// It must be called before the cache is used.
+func (c *RedisCache) SetStaleWindow(window time.Duration) {
+	c.stale = window
+}
+
+func (c *RedisCache) GetStale(ctx context.Context, key string) ([]byte, bool, bool, error) {
+	value, stale, ok, err := c.getStale(ctx, key)
+	if !ok {
+		c.misses.Add(1)
+		return nil, false, false, err
+	}
+	c.hits.Add(1)
+	return value, stale, true, nil
+}
+
+// getStale tells stale entries apart by their remaining time to live,
+// which Set extends by the stale window.
+func (c *RedisCache) getStale(ctx context.Context, key string) ([]byte, bool, bool, error) {
+	var get *redis.StringCmd
+	var pttl *redis.DurationCmd
+	_, err := c.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		get = pipe.Get(ctx, redisKeyPrefix+key)
+		pttl = pipe.PTTL(ctx, redisKeyPrefix+key)
+		return nil
+	})
+	if errors.Is(err, redis.Nil) {
+		return nil, false, false, nil
+	}
+	if err != nil {
+		return nil, false, false, err
+	}
+	value, err := get.Bytes()
+	if err != nil {
+		return nil, false, false, err
+	}
+	return value, pttl.Val() < c.stale, true, nil
+}
+
+func (c *RedisCache) Set(ctx context.Context, key string, value []byte) error {
+	return c.client.Set(ctx, redisKeyPrefix+key, value, c.ttl+c.stale).Err()
+}
+
+func (c *RedisCache) Delete(ctx context.Context, key string) error {
+	return c.client.Del(ctx, redisKeyPrefix+key).Err()
+}
+
+// Flush drops the entries of every instance sharing the Redis database,
+// leaving the keys of other applications alone.
+func (c *RedisCache) Flush(ctx context.Context) error {
+	iter := c.client.Scan(ctx, 0, redisKeyPrefix+"*", 1000).Iterator()
+	for iter.Next(ctx) {
+		err := c.client.Del(ctx, iter.Val()).Err()
+		if err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+func (c *RedisCache) Ping(ctx context.Context) error {
+	return c.client.Ping(ctx).Err()
+}
+
+func (c *RedisCache) Stats() CacheStats {
+	return CacheStats{
+		Hits:    c.hits.Load(),
+		Misses:  c.misses.Load(),
+		Entries: -1,
+	}
+}
@@ -0,0 +1,110 @@
+package cep
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+)
+
+// Settings overrides where and how a provider reaches its upstream. Zero
+// values keep the provider's defaults.
+type Settings struct {
+	URL      string
+	Username string
+	Password string
+	// Options are free-form settings for the providers registered outside
+	// this package, such as the table of an internal address database.
+	Options map[string]string
+}
+
+// Factory builds a provider from its settings, sending its requests
+// through client.
+type Factory func(settings Settings, client *http.Client) Provider
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Factory{}
+)
+
+// Register makes a provider available by name to NewProvider. It panics
+// if the name is already taken. Custom providers register from an init
+// function, so that importing their package is enough to enable them by
+// name in the server's providers.
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, ok := registry[name]; ok {
+		panic("cep: provider " + name + " registered twice")
+	}
+	registry[name] = factory
+}
+
+// NewProvider builds the provider registered under name.
+func NewProvider(name string, settings Settings, client *http.Client) (Provider, error) {
+	registryMu.RLock()
+	factory, ok := registry[name]
+	registryMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %q", name)
+	}
+	return factory(settings, client), nil
+}
+
+// ProviderNames returns the names of the registered providers, sorted.
+func ProviderNames() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func init() {
+	Register("viacep", func(s Settings, client *http.Client) Provider {
+		p := NewViaCepProvider()
+		p.Client = client
+		if s.URL != "" {
+			p.BaseURL = s.URL
+		}
+		return p
+	})
+	Register("brasilapi", func(s Settings, client *http.Client) Provider {
+		p := NewBrasilApiProvider()
+		p.Client = client
+		if s.URL != "" {
+			p.BaseURL = s.URL
+		}
+		return p
+	})
+	Register("opencep", func(s Settings, client *http.Client) Provider {
+		p := NewOpenCepProvider()
+		p.Client = client
+		if s.URL != "" {
+			p.BaseURL = s.URL
+		}
+		return p
+	})
+	Register("apicep", func(s Settings, client *http.Client) Provider {
+		p := NewApiCepProvider()
+		p.Client = client
+		if s.URL != "" {
+			p.BaseURL = s.URL
+		}
+		return p
+	})
+	Register("correios", func(s Settings, client *http.Client) Provider {
+		p := NewCorreiosProvider(s.Username, s.Password)
+		p.Client = client
+		if s.URL != "" {
+			p.URL = s.URL
+		}
+		return p
+	})
+}
@@ -0,0 +1,276 @@
+package cep
+
+import (
+	"cmp"
+	"errors"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var ErrInvalidAddress = errors.New("address must name at least the street, the city and the state")
+
+// ParsedAddress is a free-form address split into the parts a street
+// search needs. Its fields keep the spelling of the input.
+type ParsedAddress struct {
+	Street       string `json:"street"`
+	Number       string `json:"number,omitempty"`
+	Neighborhood string `json:"neighborhood,omitempty"`
+	City         string `json:"city"`
+	State        string `json:"state"`
+}
+
+// Candidate is an address matching a free-form one, scored from 0 to 1.
+type Candidate struct {
+	Address
+	Score float64 `json:"score"`
+}
+
+// states maps the states' names, without accents and lowercased, to their
+// UF.
+var states = map[string]string{
+	"acre": "AC", "alagoas": "AL", "amapa": "AP", "amazonas": "AM", "bahia": "BA",
+	"ceara": "CE", "distrito federal": "DF", "espirito santo": "ES", "goias": "GO",
+	"maranhao": "MA", "mato grosso": "MT", "mato grosso do sul": "MS", "minas gerais": "MG",
+	"para": "PA", "paraiba": "PB", "parana": "PR", "pernambuco": "PE", "piaui": "PI",
+	"rio de janeiro": "RJ", "rio grande do norte": "RN", "rio grande do sul": "RS",
+	"rondonia": "RO", "roraima": "RR", "santa catarina": "SC", "sao paulo": "SP",
+	"sergipe": "SE", "tocantins": "TO",
+}
+
+// abbreviations maps the usual abbreviations of addresses to their words.
+var abbreviations = map[string]string{
+	"av": "avenida", "avn": "avenida", "r": "rua", "al": "alameda", "pc": "praca",
+	"pca": "praca", "tv": "travessa", "trav": "travessa", "rod": "rodovia",
+	"est": "estrada", "estr": "estrada", "lg": "largo", "pq": "parque", "jd": "jardim",
+	"jard": "jardim", "vl": "vila", "res": "residencial", "cj": "conjunto", "conj": "conjunto",
+	"dr": "doutor", "prof": "professor", "eng": "engenheiro", "gen": "general",
+	"cel": "coronel", "pres": "presidente", "sen": "senador", "sta": "santa", "sto": "santo",
+	"mal": "marechal", "cap": "capitao", "des": "desembargador", "ten": "tenente",
+}
+
+// streetTypes are the words a street name starts with, left out of the
+// search term to match however the upstream spells them.
+var streetTypes = []string{
+	"avenida", "rua", "alameda", "praca", "travessa", "rodovia", "estrada", "largo",
+	"viela", "passagem", "beco", "ladeira", "via", "servidao",
+}
+
+// numberWords introduce the number of an address, as in "n 100".
+var numberWords = []string{"n", "no", "nº", "num", "numero"}
+
+// NormalizeAddressText drops the accents, punctuation and case of text and
+// expands its abbreviations, so that "Av. São João" and "avenida sao joao"
+// compare equal.
+func NormalizeAddressText(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(SanitizeSearchTerm(text)), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, word := range words {
+		if expanded, ok := abbreviations[word]; ok {
+			words[i] = expanded
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+var addressSeparators = regexp.MustCompile(`\s*(?:[,;/|]|\s-\s)\s*`)
+
+// ParseAddress splits a free-form address such as "Av. Paulista, 1000 -
+// Bela Vista, São Paulo - SP": the street comes first, then its number and
+// neighborhood, then the city and, last, the state, as a UF or a name.
+func ParseAddress(raw string) (ParsedAddress, error) {
+	var segments []string
+	for _, segment := range addressSeparators.Split(raw, -1) {
+		if segment = strings.Trim(segment, " .-"); segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	if len(segments) < 2 {
+		return ParsedAddress{}, ErrInvalidAddress
+	}
+
+	var parsed ParsedAddress
+	last := segments[len(segments)-1]
+	if state, ok := stateOf(last); ok {
+		parsed.State = state
+		segments = segments[:len(segments)-1]
+	} else if i := strings.LastIndexByte(last, ' '); i > 0 {
+		// "São Paulo SP"
+		if state, ok := stateOf(last[i+1:]); ok {
+			parsed.State = state
+			segments[len(segments)-1] = strings.TrimSpace(last[:i])
+		}
+	}
+	if parsed.State == "" || len(segments) < 2 {
+		return ParsedAddress{}, ErrInvalidAddress
+	}
+	parsed.City = segments[len(segments)-1]
+
+	parsed.Street, parsed.Number = splitNumber(segments[0])
+	for _, segment := range segments[1 : len(segments)-1] {
+		if number := strings.TrimSpace(trimNumberWord(segment)); parsed.Number == "" && isNumber(number) {
+			parsed.Number = number
+			continue
+		}
+		if parsed.Neighborhood == "" {
+			parsed.Neighborhood = segment
+		}
+	}
+	if parsed.Street == "" {
+		return ParsedAddress{}, ErrInvalidAddress
+	}
+	return parsed, nil
+}
+
+func stateOf(segment string) (string, bool) {
+	normalized := NormalizeAddressText(segment)
+	if len(normalized) == 2 {
+		uf := strings.ToUpper(normalized)
+		for _, state := range states {
+			if state == uf {
+				return uf, true
+			}
+		}
+		return "", false
+	}
+	state, ok := states[normalized]
+	return state, ok
+}
+
+// splitNumber splits the trailing number off a street, as in "Rua Augusta
+// 1500" or "Rua Augusta, nº 1500".
+func splitNumber(street string) (string, string) {
+	i := strings.LastIndexByte(street, ' ')
+	if i < 0 || !isNumber(street[i+1:]) {
+		return street, ""
+	}
+	return strings.TrimSpace(trimNumberWord(street[:i])), street[i+1:]
+}
+
+// trimNumberWord drops the "nº" or "número" before a number.
+func trimNumberWord(text string) string {
+	i := strings.LastIndexByte(text, ' ')
+	word := NormalizeAddressText(text[i+1:])
+	if slices.Contains(numberWords, word) {
+		return text[:max(i, 0)]
+	}
+	if j := strings.IndexByte(text, ' '); j > 0 {
+		word := NormalizeAddressText(text[:j])
+		if slices.Contains(numberWords, word) {
+			return text[j+1:]
+		}
+	}
+	return text
+}
+
+func isNumber(text string) bool {
+	if text == "" {
+		return false
+	}
+	for _, c := range text {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// SearchTerm is the street name to search the upstreams for: the street
+// without its type, which upstreams spell in different ways, unless that
+// leaves too short a name.
+func (p ParsedAddress) SearchTerm() string {
+	words := strings.Fields(NormalizeAddressText(p.Street))
+	if len(words) > 1 && slices.Contains(streetTypes, words[0]) && len(strings.Join(words[1:], " ")) >= 3 {
+		words = words[1:]
+	}
+	return strings.Join(words, " ")
+}
+
+// Rank scores the candidates of a street search against parsed, best
+// first. The street similarity weighs most; the neighborhood and whether
+// the number falls in the candidate's range, as in "de 612 a 1510 - lado
+// par", count when the address has them.
+func Rank(parsed ParsedAddress, addresses []Address) []Candidate {
+	street := NormalizeAddressText(parsed.Street)
+	neighborhood := NormalizeAddressText(parsed.Neighborhood)
+	number, _ := strconv.Atoi(parsed.Number)
+
+	candidates := make([]Candidate, 0, len(addresses))
+	for _, address := range addresses {
+		score, weight := 0.6*similarity(street, NormalizeAddressText(address.Street)), 0.6
+		if neighborhood != "" {
+			score += 0.2 * similarity(neighborhood, NormalizeAddressText(address.Neighborhood))
+			weight += 0.2
+		}
+		if parsed.Number != "" {
+			score += 0.2 * numberFit(number, address.Complement)
+			weight += 0.2
+		}
+		candidates = append(candidates, Candidate{Address: address, Score: math.Round(score/weight*1000) / 1000})
+	}
+	slices.SortStableFunc(candidates, func(a, b Candidate) int {
+		return cmp.Or(cmp.Compare(b.Score, a.Score), cmp.Compare(a.Cep, b.Cep))
+	})
+	return candidates
+}
+
+// similarity is the Dice coefficient of the character bigrams of a and b,
+// 1 when they are equal and 0 when they share none.
+func similarity(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+	bigrams := func(s string) map[string]int {
+		counts := map[string]int{}
+		runes := []rune(s)
+		for i := 0; i+1 < len(runes); i++ {
+			counts[string(runes[i:i+2])]++
+		}
+		return counts
+	}
+	countsA, countsB := bigrams(a), bigrams(b)
+	total, shared := 0, 0
+	for bigram, n := range countsA {
+		total += n
+		shared += min(n, countsB[bigram])
+	}
+	for _, n := range countsB {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+	return 2 * float64(shared) / float64(total)
+}
+
+var (
+	complementFrom = regexp.MustCompile(`\bde (\d+)`)
+	complementTo   = regexp.MustCompile(`\b(?:a|ate) (\d+)`)
+)
+
+// numberFit tells whether number falls in the range of a complement such
+// as "de 612 a 1510 - lado par": 1 when it does, 0 when it does not and
+// 0.5 when the complement gives no range.
+func numberFit(number int, complement string) float64 {
+	complement = NormalizeAddressText(complement)
+	from, to := 0, math.MaxInt
+	if match := complementFrom.FindStringSubmatch(complement); match != nil {
+		from, _ = strconv.Atoi(match[1])
+	}
+	if match := complementTo.FindStringSubmatch(complement); match != nil {
+		to, _ = strconv.Atoi(match[1])
+	}
+	even := strings.Contains(complement, "lado par")
+	odd := strings.Contains(complement, "lado impar")
+	if from == 0 && to == math.MaxInt && !even && !odd {
+		return 0.5
+	}
+	if number < from || number > to || even && number%2 != 0 || odd && number%2 == 0 {
+		return 0
+	}
+	return 1
+}
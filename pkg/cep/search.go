@@ -0,0 +1,39 @@
+package cep
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+var ErrInvalidSearch = errors.New("uf must have 2 letters, and city and street at least 3 characters")
+
+// Searcher is implemented by the providers that can list the CEPs of a
+// street, given its state (UF) and city.
+type Searcher interface {
+	Search(ctx context.Context, state, city, street string) ([]Address, error)
+}
+
+// ValidateSearch checks the lengths the upstreams require before a search
+// is sent to them.
+func ValidateSearch(state, city, street string) error {
+	if len([]rune(state)) != 2 || len([]rune(city)) < 3 || len([]rune(street)) < 3 {
+		return ErrInvalidSearch
+	}
+	return nil
+}
+
+// SanitizeSearchTerm drops the accents of term and collapses its spaces,
+// so "  São   Paulo " is searched as "Sao Paulo".
+func SanitizeSearchTerm(term string) string {
+	stripped, _, err := transform.String(transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC), term)
+	if err != nil {
+		stripped = term
+	}
+	return strings.Join(strings.Fields(stripped), " ")
+}
@@ -0,0 +1,284 @@
+package cep
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrNoQuorum = errors.New("providers did not reach a quorum")
+
+// ErrEmptyAnswer is the failure of a provider that answered neither an
+// address nor an error, so that no strategy picks an empty winner.
+var ErrEmptyAnswer = errors.New("provider answered no address")
+
+// Strategy decides which provider answer is returned for a lookup.
+type Strategy func(ctx context.Context, providers []Provider, cep string) (Result, error)
+
+// Strategies returns the selectable strategies by name. quorum is the
+// number of providers that must agree for the quorum strategy, hedgeDelay
+// how long the hedged strategy waits on a provider before the next.
+func Strategies(quorum int, hedgeDelay time.Duration) map[string]Strategy {
+	return map[string]Strategy{
+		"fastest":     Race,
+		"first-valid": FirstValid,
+		"priority":    Priority,
+		"quorum":      Quorum(quorum),
+		"hedged":      Hedged(hedgeDelay),
+	}
+}
+
+// Result is the answer of one provider, or its failure.
+type Result struct {
+	Provider string
+	Address  *Address
+	Err      error
+}
+
+// LookupResult looks cep up with provider. An answer without an address
+// nor an error fails with ErrEmptyAnswer.
+func LookupResult(ctx context.Context, provider Provider, cep string) Result {
+	address, err := provider.Lookup(ctx, cep)
+	if address == nil && err == nil {
+		err = ErrEmptyAnswer
+	}
+	return Result{Provider: provider.Name(), Address: address, Err: err}
+}
+
+// Race queries every provider concurrently and returns whichever answers
+// first, the address or that the CEP does not exist. Providers failing
+// otherwise are passed over for the next one to answer, and only once all
+// of them failed is the lookup a failure. The losers are cancelled as
+// soon as the winner arrives.
+func Race(ctx context.Context, providers []Provider, cep string) (Result, error) {
+	raceCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ch := fanOut(raceCtx, providers, cep)
+	failures := make([]Result, 0, len(providers))
+	for range providers {
+		select {
+		case result := <-ch:
+			if result.Err == nil || errors.Is(result.Err, ErrNotFound) {
+				return result.Result, nil
+			}
+			failures = append(failures, result.Result)
+		case <-ctx.Done():
+			return Result{}, ctx.Err()
+		}
+	}
+	return combineFailures(failures), nil
+}
+
+type indexedResult struct {
+	Index int
+	Result
+}
+
+// fanOut starts a lookup on every provider and streams back the results
+// tagged with the provider's position.
+func fanOut(ctx context.Context, providers []Provider, cep string) <-chan indexedResult {
+	// buffered so the goroutines can always deliver and exit
+	ch := make(chan indexedResult, len(providers))
+	for i, provider := range providers {
+		go lookupInto(ctx, ch, i, provider, cep)
+	}
+	return ch
+}
+
+func lookupInto(ctx context.Context, ch chan<- indexedResult, i int, provider Provider, cep string) {
+	ch <- indexedResult{Index: i, Result: LookupResult(ctx, provider, cep)}
+}
+
+// FirstValid returns the first provider that actually found the address,
+// skipping those that failed.
+func FirstValid(ctx context.Context, providers []Provider, cep string) (Result, error) {
+	raceCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ch := fanOut(raceCtx, providers, cep)
+	failures := make([]Result, 0, len(providers))
+	for range providers {
+		select {
+		case result := <-ch:
+			if result.Err == nil {
+				return result.Result, nil
+			}
+			failures = append(failures, result.Result)
+		case <-ctx.Done():
+			return Result{}, ctx.Err()
+		}
+	}
+	return combineFailures(failures), nil
+}
+
+// Priority returns the answer of the first provider in configuration order
+// that succeeds. All providers are queried at once, so falling back to a
+// lower priority provider does not cost another round trip. When ctx is
+// done before a higher priority provider answered, the best address
+// already in wins over the timeout.
+func Priority(ctx context.Context, providers []Provider, cep string) (Result, error) {
+	raceCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ch := fanOut(raceCtx, providers, cep)
+	results := make([]*Result, len(providers))
+	next := 0
+	for range providers {
+		select {
+		case result := <-ch:
+			results[result.Index] = &result.Result
+		case <-ctx.Done():
+			for _, result := range results[next:] {
+				if result != nil && result.Err == nil {
+					return *result, nil
+				}
+			}
+			return Result{}, ctx.Err()
+		}
+
+		for next < len(results) && results[next] != nil {
+			if results[next].Err == nil {
+				return *results[next], nil
+			}
+			next++
+		}
+	}
+
+	failures := make([]Result, 0, len(results))
+	for _, result := range results {
+		failures = append(failures, *result)
+	}
+	return combineFailures(failures), nil
+}
+
+// Hedged queries the providers in configuration order, starting the next
+// one only once those already started have not answered within delay, or
+// have all failed. The first provider that found the address wins, so a
+// healthy primary answers alone while a slow one is still covered.
+func Hedged(delay time.Duration) Strategy {
+	return func(ctx context.Context, providers []Provider, cep string) (Result, error) {
+		raceCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		// buffered so the goroutines can always deliver and exit
+		ch := make(chan indexedResult, len(providers))
+		started := 0
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		hedge := func() {
+			if started < len(providers) {
+				go lookupInto(raceCtx, ch, started, providers[started], cep)
+				started++
+				timer.Reset(delay)
+			}
+		}
+		hedge()
+
+		failures := make([]Result, 0, len(providers))
+		for len(failures) < len(providers) {
+			select {
+			case result := <-ch:
+				if result.Err == nil {
+					return result.Result, nil
+				}
+				failures = append(failures, result.Result)
+				if len(failures) == started {
+					hedge()
+				}
+			case <-timer.C:
+				hedge()
+			case <-ctx.Done():
+				return Result{}, ctx.Err()
+			}
+		}
+		return combineFailures(failures), nil
+	}
+}
+
+// Quorum returns as soon as n providers agree on the same answer, which
+// may also be that the CEP does not exist.
+func Quorum(n int) Strategy {
+	return func(ctx context.Context, providers []Provider, cep string) (Result, error) {
+		raceCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		ch := fanOut(raceCtx, providers, cep)
+		votes := make(map[string]int)
+		failures := make([]Result, 0, len(providers))
+		for range providers {
+			select {
+			case result := <-ch:
+				key, ok := agreementKey(result.Result)
+				if !ok {
+					failures = append(failures, result.Result)
+					continue
+				}
+				votes[key]++
+				if votes[key] >= n {
+					return result.Result, nil
+				}
+			case <-ctx.Done():
+				return Result{}, ctx.Err()
+			}
+		}
+
+		err := ErrNoQuorum
+		if len(failures) > 0 {
+			err = fmt.Errorf("%w: %w", ErrNoQuorum, combineFailures(failures).Err)
+		}
+		return Result{Err: err}, nil
+	}
+}
+
+// agreementKey identifies equivalent answers. Provider errors other than
+// not found never count towards a quorum.
+func agreementKey(result Result) (string, bool) {
+	if errors.Is(result.Err, ErrNotFound) {
+		return "not found", true
+	}
+	if result.Err != nil {
+		return "", false
+	}
+	address := result.Address
+	return strings.ToLower(strings.Join([]string{address.Cep, address.State, address.City, address.Neighborhood, address.Street}, "|")), true
+}
+
+// combineFailures reduces failed results to a single one: not found wins
+// since a provider gave a definitive answer, otherwise every error is kept
+// as ProviderFailures.
+func combineFailures(failures []Result) Result {
+	if len(failures) == 0 {
+		return Result{Err: errors.New("no providers enabled")}
+	}
+
+	errs := make(ProviderFailures, 0, len(failures))
+	for _, failure := range failures {
+		if errors.Is(failure.Err, ErrNotFound) {
+			return failure
+		}
+		errs = append(errs, &ProviderError{Provider: failure.Provider, Err: failure.Err})
+	}
+	return Result{Err: errs}
+}
+
+// ProviderFailures is the failure of every provider a lookup asked.
+type ProviderFailures []*ProviderError
+
+func (f ProviderFailures) Error() string {
+	messages := make([]string, len(f))
+	for i, failure := range f {
+		messages[i] = failure.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+func (f ProviderFailures) Unwrap() []error {
+	errs := make([]error, len(f))
+	for i, failure := range f {
+		errs[i] = failure
+	}
+	return errs
+}
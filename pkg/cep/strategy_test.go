@@ -0,0 +1,139 @@
+package cep
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeProvider answers after delay with address or err.
+type fakeProvider struct {
+	name    string
+	delay   time.Duration
+	address *Address
+	err     error
+	calls   atomic.Int32
+}
+
+func (p *fakeProvider) Name() string { return p.name }
+
+func (p *fakeProvider) Lookup(ctx context.Context, cep string) (*Address, error) {
+	p.calls.Add(1)
+	select {
+	case <-time.After(p.delay):
+		return p.address, p.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func found(name string, delay time.Duration, city string) *fakeProvider {
+	return &fakeProvider{name: name, delay: delay, address: &Address{Cep: "01001000", City: city, Provider: name}}
+}
+
+func failing(name string, delay time.Duration, err error) *fakeProvider {
+	return &fakeProvider{name: name, delay: delay, err: err}
+}
+
+var errUpstream = errors.New("upstream down")
+
+func TestStrategies(t *testing.T) {
+	const ms = time.Millisecond
+	tests := []struct {
+		name      string
+		strategy  Strategy
+		providers []*fakeProvider
+		winner    string // provider of the answer, empty when it failed
+		err       error  // of the result
+		notCalled []int  // providers never started
+	}{
+		{"race takes the fastest", Race,
+			[]*fakeProvider{found("slow", 40*ms, "São Paulo"), found("fast", ms, "São Paulo")}, "fast", nil, nil},
+		{"race passes over failures", Race,
+			[]*fakeProvider{failing("fast", ms, errUpstream), found("slow", 20*ms, "São Paulo")}, "slow", nil, nil},
+		{"race ends on not found", Race,
+			[]*fakeProvider{failing("fast", ms, ErrNotFound), found("slow", 20*ms, "São Paulo")}, "fast", ErrNotFound, nil},
+		{"race fails once all do", Race,
+			[]*fakeProvider{failing("a", ms, errUpstream), failing("b", ms, errUpstream)}, "", errUpstream, nil},
+		{"first valid skips not found", FirstValid,
+			[]*fakeProvider{failing("fast", ms, ErrNotFound), found("slow", 20*ms, "São Paulo")}, "slow", nil, nil},
+		{"first valid answers not found when all do", FirstValid,
+			[]*fakeProvider{failing("a", ms, ErrNotFound), failing("b", 5*ms, errUpstream)}, "a", ErrNotFound, nil},
+		{"priority waits on the first provider", Priority,
+			[]*fakeProvider{found("primary", 20*ms, "São Paulo"), found("secondary", ms, "São Paulo")}, "primary", nil, nil},
+		{"priority falls back", Priority,
+			[]*fakeProvider{failing("primary", ms, errUpstream), found("secondary", 10*ms, "São Paulo")}, "secondary", nil, nil},
+		{"quorum of agreeing providers", Quorum(2),
+			[]*fakeProvider{found("a", ms, "São Paulo"), found("b", 10*ms, "Campinas"), found("c", 20*ms, "são paulo")}, "c", nil, nil},
+		{"quorum not reached", Quorum(2),
+			[]*fakeProvider{found("a", ms, "São Paulo"), found("b", ms, "Campinas")}, "", ErrNoQuorum, nil},
+		{"quorum on not found", Quorum(2),
+			[]*fakeProvider{failing("a", ms, ErrNotFound), failing("b", ms, ErrNotFound)}, "", ErrNotFound, nil},
+		{"hedged answers alone when quick", Hedged(30 * ms),
+			[]*fakeProvider{found("primary", ms, "São Paulo"), found("secondary", ms, "São Paulo")}, "primary", nil, []int{1}},
+		{"hedged covers a slow primary", Hedged(5 * ms),
+			[]*fakeProvider{found("primary", 200*ms, "São Paulo"), found("secondary", ms, "São Paulo")}, "secondary", nil, nil},
+		{"hedged moves on at once on failure", Hedged(time.Hour),
+			[]*fakeProvider{failing("primary", ms, errUpstream), found("secondary", ms, "São Paulo")}, "secondary", nil, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			providers := make([]Provider, len(test.providers))
+			for i, provider := range test.providers {
+				providers[i] = provider
+			}
+			result, err := test.strategy(context.Background(), providers, "01001000")
+			if err != nil {
+				t.Fatalf("strategy failed: %v", err)
+			}
+			if !errors.Is(result.Err, test.err) || (test.err == nil && result.Err != nil) {
+				t.Fatalf("got error %v, want %v", result.Err, test.err)
+			}
+			if test.winner != "" && result.Provider != test.winner {
+				t.Errorf("answered by %q, want %q", result.Provider, test.winner)
+			}
+			for _, i := range test.notCalled {
+				if calls := test.providers[i].calls.Load(); calls != 0 {
+					t.Errorf("%s called %d times, want 0", test.providers[i].name, calls)
+				}
+			}
+		})
+	}
+}
+
+func TestStrategiesFailuresNameEveryProvider(t *testing.T) {
+	providers := []Provider{failing("a", 0, errUpstream), failing("b", 0, errUpstream)}
+	result, _ := Race(context.Background(), providers, "01001000")
+	var failures ProviderFailures
+	if !errors.As(result.Err, &failures) || len(failures) != 2 {
+		t.Fatalf("got %v, want the failures of both providers", result.Err)
+	}
+	for i, name := range []string{"a", "b"} {
+		found := false
+		for _, failure := range failures {
+			found = found || failure.Provider == name
+		}
+		if !found {
+			t.Errorf("failure %d: provider %s missing from %v", i, name, failures)
+		}
+	}
+}
+
+func TestStrategiesStopAtTheDeadline(t *testing.T) {
+	strategies := Strategies(2, 10*time.Millisecond)
+	for name, strategy := range strategies {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		providers := []Provider{found("a", time.Second, "São Paulo"), found("b", time.Second, "São Paulo")}
+		start := time.Now()
+		_, err := strategy(ctx, providers, "01001000")
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s: got %v, want the deadline", name, err)
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("%s: returned after %s, past the deadline", name, elapsed)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package cep
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type ViaCep struct {
+	Cep         string `json:"cep"`
+	Logradouro  string `json:"logradouro"`
+	Complemento string `json:"complemento"`
+	Bairro      string `json:"bairro"`
+	Localidade  string `json:"localidade"`
+	Uf          string `json:"uf"`
+	Unidade     string `json:"unidade"`
+	Ibge        string `json:"ibge"`
+	Gia         string `json:"gia"`
+	Ddd         string `json:"ddd"`
+	Siafi       string `json:"siafi"`
+}
+
+// viaCepError captures the error marker ViaCep sends with HTTP 200 for
+// unknown CEPs, which has been served both as a boolean and as "true".
+type viaCepError struct {
+	Erro interface{} `json:"erro"`
+}
+
+func (e viaCepError) notFound() bool {
+	return e.Erro == true || e.Erro == "true"
+}
+
+type ViaCepProvider struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+func NewViaCepProvider() *ViaCepProvider {
+	return &ViaCepProvider{BaseURL: "http://viacep.com.br/ws/", Client: http.DefaultClient}
+}
+
+func (p *ViaCepProvider) Name() string {
+	return "ViaCep"
+}
+
+func (p *ViaCepProvider) Lookup(ctx context.Context, cep string) (*Address, error) {
+	viaCep, err := p.fetch(ctx, cep)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Address{
+		Cep:          normalizedOr(viaCep.Cep, cep),
+		State:        viaCep.Uf,
+		City:         viaCep.Localidade,
+		Neighborhood: viaCep.Bairro,
+		Street:       viaCep.Logradouro,
+		Complement:   viaCep.Complemento,
+		Ibge:         viaCep.Ibge,
+		Ddd:          viaCep.Ddd,
+		Provider:     p.Name(),
+	}, nil
+}
+
+// Search lists the CEPs ViaCep knows for street, which may be partial.
+func (p *ViaCepProvider) Search(ctx context.Context, state, city, street string) ([]Address, error) {
+	state = strings.ToUpper(SanitizeSearchTerm(state))
+	city, street = SanitizeSearchTerm(city), SanitizeSearchTerm(street)
+	err := ValidateSearch(state, city, street)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []ViaCep
+	status, err := fetchJSON(ctx, p.Client, p.BaseURL+url.PathEscape(state)+"/"+url.PathEscape(city)+"/"+url.PathEscape(street)+"/json/", &results)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusBadRequest {
+		return nil, ErrInvalidSearch
+	}
+	if status != http.StatusOK {
+		return nil, &StatusError{Provider: "viacep", StatusCode: status}
+	}
+
+	addresses := make([]Address, 0, len(results))
+	for _, viaCep := range results {
+		addresses = append(addresses, Address{
+			Cep:          normalizedOr(viaCep.Cep, viaCep.Cep),
+			State:        viaCep.Uf,
+			City:         viaCep.Localidade,
+			Neighborhood: viaCep.Bairro,
+			Street:       viaCep.Logradouro,
+			Complement:   viaCep.Complemento,
+			Ibge:         viaCep.Ibge,
+			Ddd:          viaCep.Ddd,
+			Provider:     p.Name(),
+		})
+	}
+	return addresses, nil
+}
+
+func (p *ViaCepProvider) fetch(ctx context.Context, cep string) (*ViaCep, error) {
+	// the error marker comes in place of the address, so both are
+	// decoded in a single pass
+	var response struct {
+		ViaCep
+		viaCepError
+	}
+	status, err := fetchJSON(ctx, p.Client, p.BaseURL+cep+"/json/", &response)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, &StatusError{Provider: "viacep", StatusCode: status}
+	}
+	if response.notFound() {
+		return nil, ErrNotFound
+	}
+
+	return &response.ViaCep, nil
+}
@@ -0,0 +1,98 @@
+package cep
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var ErrInvalidPostalCode = errors.New("invalid postal code: expected a two letter country and up to 10 letters, digits, spaces or hyphens")
+
+// NormalizePostalCode validates a country and one of its postal codes and
+// returns them as the COUNTRY/CODE key ZippopotamProvider looks up.
+func NormalizePostalCode(country string, code string) (string, error) {
+	country = strings.ToUpper(strings.TrimSpace(country))
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if len(country) != 2 || !isLetters(country) {
+		return "", ErrInvalidPostalCode
+	}
+	if code == "" || len(code) > 10 {
+		return "", ErrInvalidPostalCode
+	}
+	for _, r := range code {
+		if !(r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == ' ' || r == '-') {
+			return "", ErrInvalidPostalCode
+		}
+	}
+	return country + "/" + code, nil
+}
+
+func isLetters(s string) bool {
+	for _, r := range s {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+type Zippopotam struct {
+	PostCode            string `json:"post code"`
+	CountryAbbreviation string `json:"country abbreviation"`
+	Places              []struct {
+		PlaceName         string `json:"place name"`
+		StateAbbreviation string `json:"state abbreviation"`
+		Longitude         string `json:"longitude"`
+		Latitude          string `json:"latitude"`
+	} `json:"places"`
+}
+
+// ZippopotamProvider looks up postal codes of about 60 countries in
+// Zippopotam.us. Unlike the CEP providers its Lookup takes the
+// COUNTRY/CODE keys built by NormalizePostalCode.
+type ZippopotamProvider struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+func NewZippopotamProvider() *ZippopotamProvider {
+	return &ZippopotamProvider{BaseURL: "https://api.zippopotam.us/", Client: http.DefaultClient}
+}
+
+func (p *ZippopotamProvider) Name() string {
+	return "Zippopotam"
+}
+
+func (p *ZippopotamProvider) Lookup(ctx context.Context, key string) (*Address, error) {
+	country, code, _ := strings.Cut(key, "/")
+	var zippopotam Zippopotam
+	status, err := fetchJSON(ctx, p.Client, p.BaseURL+strings.ToLower(country)+"/"+url.PathEscape(code), &zippopotam)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if status != http.StatusOK {
+		return nil, &StatusError{Provider: "zippopotam", StatusCode: status}
+	}
+
+	// unknown codes may also come back as an empty object
+	if len(zippopotam.Places) == 0 {
+		return nil, ErrNotFound
+	}
+
+	// a code covering several places is answered with the first one
+	place := zippopotam.Places[0]
+	address := &Address{
+		Cep:      zippopotam.PostCode,
+		Country:  zippopotam.CountryAbbreviation,
+		State:    place.StateAbbreviation,
+		City:     place.PlaceName,
+		Provider: p.Name(),
+	}
+	address.Location, _ = ParseLocation(place.Latitude, place.Longitude)
+	return address, nil
+}
@@ -0,0 +1,119 @@
+package cep
+
+import (
+	"compress/gzip"
+	_ "embed"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// zonesDataset classifies the state capitals as capital, the states of
+// the North region as remote and the rest of the country as interior.
+//
+//go:embed zones.csv
+var zonesDataset string
+
+// ZoneRange puts the CEPs from Start to End inclusive in Zone.
+type ZoneRange struct {
+	Start string `json:"start" yaml:"start"`
+	End   string `json:"end" yaml:"end"`
+	Zone  string `json:"zone" yaml:"zone"`
+}
+
+// ZoneTable classifies CEPs into shipping zones by their range. Ranges may
+// nest, as a capital inside its state: a CEP gets the zone of the
+// narrowest range holding it, the last one listed among equals.
+type ZoneTable struct {
+	ranges []ZoneRange
+}
+
+// NewZoneTable loads the embedded zones.
+func NewZoneTable() *ZoneTable {
+	t, err := LoadZones(strings.NewReader(zonesDataset))
+	if err != nil {
+		panic("cep: embedded zones: " + err.Error())
+	}
+	return t
+}
+
+// OpenZones loads a zones file, gunzipping it when its name ends in .gz.
+func OpenZones(path string) (*ZoneTable, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return LoadZones(r)
+}
+
+// LoadZones reads a CSV of start,end,zone rows, the first row being a
+// header.
+func LoadZones(r io.Reader) (*ZoneTable, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 3
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) < 2 {
+		return nil, fmt.Errorf("zones have no ranges")
+	}
+
+	table := &ZoneTable{}
+	for i, record := range records[1:] {
+		err := table.Add(ZoneRange{Start: record[0], End: record[1], Zone: record[2]})
+		if err != nil {
+			return nil, fmt.Errorf("zones line %d: %w", i+2, err)
+		}
+	}
+	return table, nil
+}
+
+// Add appends r to the table, normalizing its CEPs, so that it overrides
+// the ranges of the same span added before.
+func (t *ZoneTable) Add(r ZoneRange) error {
+	start, errStart := Normalize(r.Start)
+	end, errEnd := Normalize(r.End)
+	if errStart != nil || errEnd != nil || start > end {
+		return fmt.Errorf("invalid range %q-%q", r.Start, r.End)
+	}
+	zone := strings.TrimSpace(r.Zone)
+	if zone == "" {
+		return fmt.Errorf("range %q-%q has no zone", r.Start, r.End)
+	}
+	t.ranges = append(t.ranges, ZoneRange{Start: start, End: end, Zone: zone})
+	return nil
+}
+
+// Classify returns the zone of a normalized cep and the range it falls
+// in, or false when no range holds it.
+func (t *ZoneTable) Classify(cep string) (ZoneRange, bool) {
+	var best *ZoneRange
+	for i := range t.ranges {
+		r := &t.ranges[i]
+		// fixed width CEPs compare as strings
+		if cep < r.Start || cep > r.End {
+			continue
+		}
+		if best == nil || r.End <= best.End && r.Start >= best.Start {
+			best = r
+		}
+	}
+	if best == nil {
+		return ZoneRange{}, false
+	}
+	return *best, true
+}
@@ -0,0 +1,290 @@
+// Package client is a typed client of the multi HTTP API, for Go services
+// calling a deployed instance instead of embedding package cep.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/liberopassadorneto/multi/pkg/cep"
+)
+
+// Address is the normalized address answered by the API.
+type Address = cep.Address
+
+// API is the part of the HTTP API covered by Client. Code depending on it
+// can be tested against a Mock.
+type API interface {
+	Lookup(ctx context.Context, cep string) (*Address, error)
+	BatchLookup(ctx context.Context, ceps []string) ([]BatchItem, error)
+	Search(ctx context.Context, query SearchQuery) (*SearchResult, error)
+}
+
+// BatchItem is the answer for one CEP of a batch, with either its address
+// or the error a single lookup would have failed with.
+type BatchItem struct {
+	Input   string   `json:"input"`
+	Cep     string   `json:"cep,omitempty"`
+	Address *Address `json:"address,omitempty"`
+	Error   string   `json:"error,omitempty"`
+	Code    string   `json:"code,omitempty"`
+	Status  int      `json:"status"`
+}
+
+// SearchQuery looks up the CEPs of a street. Page counts from 1; zero
+// values leave the server defaults.
+type SearchQuery struct {
+	UF      string
+	City    string
+	Street  string
+	Page    int
+	PerPage int
+}
+
+type SearchResult struct {
+	Results []Address `json:"results"`
+	Total   int       `json:"total"`
+	Page    int       `json:"page"`
+	PerPage int       `json:"per_page"`
+}
+
+// Error is an error response of the API. It matches cep.ErrNotFound and
+// cep.ErrInvalid with errors.Is, like the errors of package cep.
+type Error struct {
+	Status    int    `json:"-"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("multi: %d %s: %s", e.Status, e.Code, e.Message)
+}
+
+func (e *Error) Is(target error) bool {
+	switch target {
+	case cep.ErrNotFound:
+		return e.Code == "NOT_FOUND"
+	case cep.ErrInvalid:
+		return e.Code == "INVALID_CEP"
+	}
+	return false
+}
+
+// Client calls the API of one multi instance. It is safe for concurrent
+// use.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	timeout    time.Duration
+	retries    int
+	apiKey     string
+	strategy   string
+}
+
+var _ API = (*Client)(nil)
+
+type Option func(*Client)
+
+// WithBaseURL points the client to an instance. The default is
+// http://localhost:8080.
+func WithBaseURL(baseURL string) Option {
+	return func(c *Client) {
+		c.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// WithTimeout bounds every attempt of a call. The default is five
+// seconds.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// WithRetries retries a call up to retries more times on connection
+// errors and on 429, 502, 503 and 504 answers. The default is 2.
+func WithRetries(retries int) Option {
+	return func(c *Client) {
+		c.retries = retries
+	}
+}
+
+// WithAPIKey sends key in X-API-Key, for instances requiring one.
+func WithAPIKey(key string) Option {
+	return func(c *Client) {
+		c.apiKey = key
+	}
+}
+
+// WithStrategy asks for the named strategy instead of the instance's
+// default one.
+func WithStrategy(strategy string) Option {
+	return func(c *Client) {
+		c.strategy = strategy
+	}
+}
+
+// WithHTTPClient sends the requests through client instead of
+// http.DefaultClient.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = client
+	}
+}
+
+func New(opts ...Option) *Client {
+	c := &Client{
+		baseURL:    "http://localhost:8080",
+		httpClient: http.DefaultClient,
+		timeout:    5 * time.Second,
+		retries:    2,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Lookup resolves a CEP. A CEP the providers do not know fails with an
+// error matching cep.ErrNotFound.
+func (c *Client) Lookup(ctx context.Context, raw string) (*Address, error) {
+	query := url.Values{"cep": {raw}}
+	c.setStrategy(query)
+	var address Address
+	err := c.do(ctx, http.MethodGet, "/", query, nil, &address)
+	if err != nil {
+		return nil, err
+	}
+	return &address, nil
+}
+
+// BatchLookup resolves many CEPs, answering with one item per input in the
+// same order. Only the batch itself failing is an error: failed CEPs carry
+// theirs in their item.
+func (c *Client) BatchLookup(ctx context.Context, ceps []string) ([]BatchItem, error) {
+	query := url.Values{}
+	c.setStrategy(query)
+	var items []BatchItem
+	err := c.do(ctx, http.MethodPost, "/batch", query, ceps, &items)
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// Search lists the CEPs of a street.
+func (c *Client) Search(ctx context.Context, q SearchQuery) (*SearchResult, error) {
+	query := url.Values{"uf": {q.UF}, "city": {q.City}, "street": {q.Street}}
+	if q.Page > 0 {
+		query.Set("page", strconv.Itoa(q.Page))
+	}
+	if q.PerPage > 0 {
+		query.Set("per_page", strconv.Itoa(q.PerPage))
+	}
+	var result SearchResult
+	err := c.do(ctx, http.MethodGet, "/search", query, nil, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *Client) setStrategy(query url.Values) {
+	if c.strategy != "" {
+		query.Set("strategy", c.strategy)
+	}
+}
+
+// do sends a request, retrying the transient failures with a doubling
+// delay, and decodes the answer into out.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in, out any) error {
+	var body []byte
+	if in != nil {
+		var err error
+		body, err = json.Marshal(in)
+		if err != nil {
+			return err
+		}
+	}
+
+	delay := 100 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		err := c.attempt(ctx, method, path+"?"+query.Encode(), body, out)
+		if err == nil || attempt >= c.retries || !retryable(ctx, err) {
+			return err
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+		delay *= 2
+	}
+}
+
+func (c *Client) attempt(ctx context.Context, method, path string, body []byte, out any) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &Error{Status: resp.StatusCode}
+		var envelope struct {
+			Error *Error `json:"error"`
+		}
+		envelope.Error = apiErr
+		if json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&envelope) != nil || apiErr.Code == "" {
+			apiErr.Code, apiErr.Message = "", http.StatusText(resp.StatusCode)
+		}
+		return apiErr
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// retryable reports whether err is worth another attempt: the statuses
+// of an overloaded server, connection errors and the timeout of the
+// attempt itself, but never an answer that did not decode or a ctx the
+// caller gave up on.
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.Status {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded)
+}
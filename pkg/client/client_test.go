@@ -0,0 +1,59 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClientRetries(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		cancel  bool // by the caller, before the first answer
+		calls   int32
+	}{
+		{"answer", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"cep":"01001000"}`))
+		}, false, 1},
+		{"overloaded", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}, false, 3},
+		{"rejected", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+		}, false, 1},
+		{"undecodable answer", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"cep":`))
+		}, false, 1},
+		{"attempt timed out", func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		}, false, 3},
+		{"cancelled by the caller", func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		}, true, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				test.handler(w, r)
+			}))
+			defer server.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if test.cancel {
+				time.AfterFunc(20*time.Millisecond, cancel)
+			}
+			client := New(WithBaseURL(server.URL), WithRetries(2), WithTimeout(50*time.Millisecond))
+			client.Lookup(ctx, "01001000")
+			if got := calls.Load(); got != test.calls {
+				t.Errorf("server called %d times, want %d", got, test.calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package client
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotMocked is returned by the Mock methods whose function is not set.
+var ErrNotMocked = errors.New("client: method not mocked")
+
+// Mock is an API answering through its functions, for testing code that
+// depends on API without a running instance.
+type Mock struct {
+	LookupFunc      func(ctx context.Context, cep string) (*Address, error)
+	BatchLookupFunc func(ctx context.Context, ceps []string) ([]BatchItem, error)
+	SearchFunc      func(ctx context.Context, query SearchQuery) (*SearchResult, error)
+}
+
+var _ API = (*Mock)(nil)
+
+func (m *Mock) Lookup(ctx context.Context, cep string) (*Address, error) {
+	if m.LookupFunc == nil {
+		return nil, ErrNotMocked
+	}
+	return m.LookupFunc(ctx, cep)
+}
+
+func (m *Mock) BatchLookup(ctx context.Context, ceps []string) ([]BatchItem, error) {
+	if m.BatchLookupFunc == nil {
+		return nil, ErrNotMocked
+	}
+	return m.BatchLookupFunc(ctx, ceps)
+}
+
+func (m *Mock) Search(ctx context.Context, query SearchQuery) (*SearchResult, error) {
+	if m.SearchFunc == nil {
+		return nil, ErrNotMocked
+	}
+	return m.SearchFunc(ctx, query)
+}
+
+// StaticMock is a Mock answering every lookup from addresses, keyed by
+// normalized CEP, and failing the others as not found.
+func StaticMock(addresses map[string]*Address) *Mock {
+	lookup := func(_ context.Context, raw string) (*Address, error) {
+		address, ok := addresses[raw]
+		if !ok {
+			return nil, &Error{Status: 404, Code: "NOT_FOUND", Message: "cep not found"}
+		}
+		return address, nil
+	}
+	return &Mock{
+		LookupFunc: lookup,
+		BatchLookupFunc: func(ctx context.Context, ceps []string) ([]BatchItem, error) {
+			items := make([]BatchItem, len(ceps))
+			for i, raw := range ceps {
+				items[i] = BatchItem{Input: raw, Cep: raw, Status: 200}
+				address, err := lookup(ctx, raw)
+				if err != nil {
+					items[i].Error, items[i].Code, items[i].Status = err.Error(), "NOT_FOUND", 404
+				}
+				items[i].Address = address
+			}
+			return items, nil
+		},
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// ErrOverloaded is returned when every outbound slot is taken and the
+// queue waiting for one is full, or the wait for a slot timed out.
+var ErrOverloaded = errors.New("too many lookups in flight, try again later")
+
+// outbound caps the provider calls in flight across every lookup, nil when
+// they are not capped.
+var outbound *workerPool
+
+var (
+	outboundRejections = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "multi",
+		Name:      "outbound_rejections_total",
+		Help:      "Lookups and provider calls turned away because every outbound slot was taken.",
+	})
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "multi",
+		Name:      "outbound_in_flight",
+		Help:      "Provider calls holding an outbound slot.",
+	}, func() float64 { return float64(outbound.inFlight()) })
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "multi",
+		Name:      "outbound_queued",
+		Help:      "Provider calls waiting for an outbound slot.",
+	}, func() float64 { return float64(outbound.waiting()) })
+)
+
+// workerPool is a semaphore of slots. Past them, up to maxQueue callers
+// wait for one at most queueTimeout; the rest are turned away at once.
+type workerPool struct {
+	slots        chan struct{}
+	maxQueue     int64
+	queueTimeout time.Duration
+	queued       atomic.Int64
+}
+
+func setupOutbound(cfg OutboundConfig) {
+	outboundBudget = cfg.Budget
+	outbound = nil
+	if cfg.MaxInFlight == 0 {
+		return
+	}
+	outbound = &workerPool{
+		slots:        make(chan struct{}, cfg.MaxInFlight),
+		maxQueue:     int64(cfg.MaxQueue),
+		queueTimeout: cfg.QueueTimeout,
+	}
+}
+
+// acquire takes a slot, returning the function giving it back.
+func (p *workerPool) acquire(ctx context.Context) (func(), error) {
+	select {
+	case p.slots <- struct{}{}:
+		return p.release, nil
+	default:
+	}
+
+	if p.queued.Add(1) > p.maxQueue {
+		p.queued.Add(-1)
+		outboundRejections.Inc()
+		return nil, ErrOverloaded
+	}
+	defer p.queued.Add(-1)
+
+	timer := time.NewTimer(p.queueTimeout)
+	defer timer.Stop()
+	select {
+	case p.slots <- struct{}{}:
+		return p.release, nil
+	case <-timer.C:
+		outboundRejections.Inc()
+		return nil, ErrOverloaded
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (p *workerPool) release() {
+	<-p.slots
+}
+
+// saturated reports whether a call would be turned away right now, so a
+// lookup can fail before starting its race.
+func (p *workerPool) saturated() bool {
+	return p != nil && len(p.slots) == cap(p.slots) && p.queued.Load() >= p.maxQueue
+}
+
+func (p *workerPool) inFlight() int {
+	if p == nil {
+		return 0
+	}
+	return len(p.slots)
+}
+
+func (p *workerPool) waiting() int64 {
+	if p == nil {
+		return 0
+	}
+	return p.queued.Load()
+}
+
+// pooledProvider holds an outbound slot for the whole of each lookup,
+// retries included.
+type pooledProvider struct {
+	Provider
+	pool *workerPool
+}
+
+func (p *pooledProvider) Lookup(ctx context.Context, cep string) (*Address, error) {
+	release, err := p.pool.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return p.Provider.Lookup(ctx, cep)
+}
@@ -0,0 +1,175 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: proto/cep/v1/cep.proto
+
+package cepv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	CepService_Lookup_FullMethodName      = "/multi.cep.v1.CepService/Lookup"
+	CepService_BatchLookup_FullMethodName = "/multi.cep.v1.CepService/BatchLookup"
+)
+
+// CepServiceClient is the client API for CepService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// CepService resolves CEPs through the same providers and strategies as
+// the HTTP API.
+type CepServiceClient interface {
+	// Lookup resolves a single CEP.
+	Lookup(ctx context.Context, in *LookupRequest, opts ...grpc.CallOption) (*AddressResponse, error)
+	// BatchLookup resolves many CEPs, streaming each result as soon as it
+	// is ready rather than in input order.
+	BatchLookup(ctx context.Context, in *BatchLookupRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[BatchLookupResponse], error)
+}
+
+type cepServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewCepServiceClient(cc grpc.ClientConnInterface) CepServiceClient {
+	return &cepServiceClient{cc}
+}
+
+func (c *cepServiceClient) Lookup(ctx context.Context, in *LookupRequest, opts ...grpc.CallOption) (*AddressResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AddressResponse)
+	err := c.cc.Invoke(ctx, CepService_Lookup_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *cepServiceClient) BatchLookup(ctx context.Context, in *BatchLookupRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[BatchLookupResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &CepService_ServiceDesc.Streams[0], CepService_BatchLookup_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[BatchLookupRequest, BatchLookupResponse]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type CepService_BatchLookupClient = grpc.ServerStreamingClient[BatchLookupResponse]
+
+// CepServiceServer is the server API for CepService service.
+// All implementations must embed UnimplementedCepServiceServer
+// for forward compatibility.
+//
+// CepService resolves CEPs through the same providers and strategies as
+// the HTTP API.
+type CepServiceServer interface {
+	// Lookup resolves a single CEP.
+	Lookup(context.Context, *LookupRequest) (*AddressResponse, error)
+	// BatchLookup resolves many CEPs, streaming each result as soon as it
+	// is ready rather than in input order.
+	BatchLookup(*BatchLookupRequest, grpc.ServerStreamingServer[BatchLookupResponse]) error
+	mustEmbedUnimplementedCepServiceServer()
+}
+
+// UnimplementedCepServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedCepServiceServer struct{}
+
+func (UnimplementedCepServiceServer) Lookup(context.Context, *LookupRequest) (*AddressResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Lookup not implemented")
+}
+func (UnimplementedCepServiceServer) BatchLookup(*BatchLookupRequest, grpc.ServerStreamingServer[BatchLookupResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method BatchLookup not implemented")
+}
+func (UnimplementedCepServiceServer) mustEmbedUnimplementedCepServiceServer() {}
+func (UnimplementedCepServiceServer) testEmbeddedByValue()                    {}
+
+// UnsafeCepServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to CepServiceServer will
+// result in compilation errors.
+type UnsafeCepServiceServer interface {
+	mustEmbedUnimplementedCepServiceServer()
+}
+
+func RegisterCepServiceServer(s grpc.ServiceRegistrar, srv CepServiceServer) {
+	// If the following call pancis, it indicates UnimplementedCepServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&CepService_ServiceDesc, srv)
+}
+
+func _CepService_Lookup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LookupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CepServiceServer).Lookup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CepService_Lookup_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CepServiceServer).Lookup(ctx, req.(*LookupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CepService_BatchLookup_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(BatchLookupRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CepServiceServer).BatchLookup(m, &grpc.GenericServerStream[BatchLookupRequest, BatchLookupResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type CepService_BatchLookupServer = grpc.ServerStreamingServer[BatchLookupResponse]
+
+// CepService_ServiceDesc is the grpc.ServiceDesc for CepService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var CepService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "multi.cep.v1.CepService",
+	HandlerType: (*CepServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Lookup",
+			Handler:    _CepService_Lookup_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "BatchLookup",
+			Handler:       _CepService_BatchLookup_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "proto/cep/v1/cep.proto",
+}
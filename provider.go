@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// timeoutProvider gives a provider its own deadline, shorter than the
+// lookup's, so a slow upstream gives up before the whole race does. In
+// adaptive mode the deadline follows the provider's rolling latency
+// percentile, capped by the static timeout when there is one.
+type timeoutProvider struct {
+	Provider
+	timeout   time.Duration
+	adaptive  *AdaptiveTimeoutConfig
+	latencies *LatencyWindow
+}
+
+func (p *timeoutProvider) Lookup(ctx context.Context, cep string) (*Address, error) {
+	if deadline := p.deadline(); deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, deadline)
+		defer cancel()
+	}
+
+	start := time.Now()
+	address, err := p.Provider.Lookup(ctx, cep)
+	// only answers are recorded: a timed out call would just echo the
+	// deadline back into the percentile
+	if p.latencies != nil && (err == nil || errors.Is(err, ErrCepNotFound)) {
+		p.latencies.Record(time.Since(start))
+	}
+	return address, err
+}
+
+func (p *timeoutProvider) deadline() time.Duration {
+	if p.adaptive == nil || p.latencies.Len() < p.adaptive.MinSamples {
+		return p.timeout
+	}
+
+	percentile, _ := p.latencies.Percentile(p.adaptive.Percentile)
+	deadline := max(time.Duration(float64(percentile)*p.adaptive.Multiplier), p.adaptive.Min)
+	if p.timeout > 0 {
+		deadline = min(deadline, p.timeout)
+	}
+	return deadline
+}
+
+// activeProviders leaves out the providers that failed their health
+// checks, whose breaker is open or whose rate limit is exhausted, so they
+// sit out this race instead of delaying it. It reserves nothing: the
+// strategies may not start every provider, so the half-open probe and the
+// rate limit token are only taken by the providers called.
+func activeProviders(providers []Provider) []Provider {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	active := make([]Provider, 0, len(providers))
+	for _, provider := range providers {
+		if dailyCapReached(provider.Name()) {
+			continue
+		}
+		if health, ok := providerHealth[provider.Name()]; ok && !health.Healthy() {
+			continue
+		}
+		if breaker, ok := breakers[provider.Name()]; ok && !breaker.Ready() {
+			continue
+		}
+		if limiter, ok := limiters[provider.Name()]; ok && limiter.Tokens() < 1 {
+			continue
+		}
+		active = append(active, provider)
+	}
+	return active
+}
+
+// replaced holds the registries NewProviders last replaced, for
+// wrapProvider to keep the breaker, rate limiter, health and stats of the
+// providers whose settings for them did not change: a reload or an admin
+// change must not close a breaker tripped by hand or refill a burst.
+var replaced struct {
+	breakers map[string]*CircuitBreaker
+	limiters map[string]*rate.Limiter
+	health   map[string]*ProviderHealth
+	stats    map[string]*ProviderStats
+}
+
+// NewProviders builds the enabled providers in priority order, with a
+// health check, a circuit breaker and a rate limiter registered for each
+// of them when enabled. In mock mode they answer from fixtures instead.
+func NewProviders(cfg Config, client *http.Client) []Provider {
+	replaced.breakers, replaced.limiters = breakers, limiters
+	replaced.health, replaced.stats = providerHealth, providerStats
+	breakers = map[string]*CircuitBreaker{}
+	limiters = map[string]*rate.Limiter{}
+	providerStats = map[string]*ProviderStats{}
+	providerHealth = map[string]*ProviderHealth{}
+	providerWeights = map[string]float64{}
+	providerCaps = map[string]int{}
+	searchers = nil
+	providers := make([]Provider, 0, len(cfg.Providers))
+	for _, name := range cfg.Providers {
+		providerClient := recordingClient(cfg.Recording, name, providerClient(client, cfg.Provider(name)))
+		provider, err := newProvider(name, cfg.Provider(name), providerClient)
+		if err != nil {
+			// Validate rejects unknown providers, so this is a bug
+			panic(err)
+		}
+		if mockFixtures != nil {
+			provider = mockProvider(cfg, name, provider)
+		}
+		if searcher, ok := provider.(Searcher); ok {
+			searchers = append(searchers, searcher)
+		}
+		providers = append(providers, wrapProvider(cfg, name, provider))
+	}
+	return providers
+}
+
+// wrapProvider adds the daily cap, rate limit, retries, timeouts, health
+// check, breaker, tracing, metrics and outbound slot the
+// configuration asks for around provider.
+func wrapProvider(cfg Config, name string, provider Provider) Provider {
+	settings := cfg.Provider(name)
+	// innermost, so that every retry and hedge is counted
+	providerCaps[provider.Name()] = settings.DailyCap
+	provider = &cappedProvider{Provider: provider, dailyCap: settings.DailyCap}
+	if limit := cfg.ProviderRateLimit(name); limit.RPS > 0 {
+		// inside the retries and the probes, so that every call to the
+		// upstream takes a token, and inside the breaker, so that a refused
+		// call gives its probe back
+		limiter, ok := replaced.limiters[provider.Name()]
+		if !ok || limiter.Limit() != rate.Limit(limit.RPS) || limiter.Burst() != limit.Burst {
+			limiter = rate.NewLimiter(rate.Limit(limit.RPS), limit.Burst)
+		}
+		limiters[provider.Name()] = limiter
+		provider = &limitedProvider{Provider: provider, limiter: limiter}
+	}
+	if retry := cfg.ProviderRetry(name); retry.Attempts > 0 {
+		provider = &retryProvider{Provider: provider, retry: retry}
+	}
+	if cfg.AdaptiveTimeout.Enabled {
+		provider = &timeoutProvider{
+			Provider:  provider,
+			timeout:   settings.Timeout,
+			adaptive:  &cfg.AdaptiveTimeout,
+			latencies: NewLatencyWindow(cfg.AdaptiveTimeout.Window),
+		}
+	} else if settings.Timeout > 0 {
+		provider = &timeoutProvider{Provider: provider, timeout: settings.Timeout}
+	}
+	if h := cfg.HealthCheck; h.Enabled {
+		// probes go around the breaker so that both judge the
+		// provider independently
+		health := NewProviderHealth(provider, h.UnhealthyThreshold, h.HealthyThreshold)
+		if old, ok := replaced.health[provider.Name()]; ok && old.unhealthyAfter == health.unhealthyAfter && old.healthyAfter == health.healthyAfter {
+			health.inherit(old)
+		}
+		providerHealth[provider.Name()] = health
+	}
+	if b := cfg.CircuitBreaker; b.Enabled {
+		breaker, ok := replaced.breakers[provider.Name()]
+		if !ok || breaker.threshold != b.FailureThreshold || breaker.openFor != b.OpenDuration || breaker.probes != b.HalfOpenProbes {
+			breaker = NewCircuitBreaker(b.FailureThreshold, b.OpenDuration, b.HalfOpenProbes)
+		}
+		breakers[provider.Name()] = breaker
+		provider = &breakerProvider{Provider: provider, breaker: breaker}
+	}
+	providerWeights[provider.Name()] = settings.Weight
+	stats, ok := replaced.stats[provider.Name()]
+	if !ok {
+		stats = NewProviderStats(statsWindow)
+	}
+	providerStats[provider.Name()] = stats
+	provider = &metricsProvider{Provider: &tracingProvider{Provider: provider}, stats: stats}
+	if outbound != nil {
+		// outside the metrics, so that calls turned away do not count
+		// against the provider
+		provider = &pooledProvider{Provider: provider, pool: outbound}
+	}
+	return provider
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// ErrDailyCapReached is returned for the calls to a provider past its
+// daily cap. Like ErrBudgetExhausted, the call is never made, so the
+// breakers, health checks and provider stats ignore it.
+var ErrDailyCapReached = errors.New("daily cap of the provider reached")
+
+var (
+	providerCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "multi",
+		Name:      "provider_calls_total",
+		Help:      "Calls made to each provider, retries, hedges and health checks included.",
+	}, []string{"provider"})
+	providerCapRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "multi",
+		Name:      "provider_daily_cap_rejections_total",
+		Help:      "Calls to each provider refused past its daily cap.",
+	}, []string{"provider"})
+)
+
+// providerCaps are the daily caps of the providers by name, registered
+// along with their breakers.
+var providerCaps = map[string]int{}
+
+// ProviderUsage is the calls made to a provider on a day.
+type ProviderUsage struct {
+	Provider string `json:"provider"`
+	Day      string `json:"day"`
+	Calls    int    `json:"calls"`
+	// DailyCap is 0, and Remaining nil, when the calls are unlimited.
+	DailyCap  int  `json:"daily_cap"`
+	Remaining *int `json:"remaining,omitempty"`
+	Capped    bool `json:"capped"`
+}
+
+// calls counts the calls to the providers on the current day: totals with
+// those already in the history, pending those not written to it yet.
+var calls = struct {
+	mu      sync.Mutex
+	day     string
+	totals  map[string]int
+	pending map[string]int
+}{totals: map[string]int{}, pending: map[string]int{}}
+
+func usageDay(t time.Time) string {
+	return t.UTC().Format(time.DateOnly)
+}
+
+// setupProviderUsage reads the calls of the current day from the history,
+// so that the daily caps survive restarts and hold across the instances
+// sharing it, and keeps them in step with the history until ctx is done.
+func setupProviderUsage(ctx context.Context) {
+	calls.mu.Lock()
+	calls.day = usageDay(time.Now())
+	calls.mu.Unlock()
+	if history == nil {
+		return
+	}
+	err := refreshProviderCalls(ctx)
+	if err != nil {
+		slog.Error("error reading provider usage", "error", err)
+	}
+	go func() {
+		ticker := time.NewTicker(usageFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				flushProviderCalls()
+			}
+		}
+	}()
+}
+
+// rollCallsDay starts counting a new day when the current one is over,
+// writing what is pending of the last one first. calls.mu must be held.
+func rollCallsDay() {
+	day := usageDay(time.Now())
+	if day == calls.day {
+		return
+	}
+	if history != nil {
+		go addProviderCalls(calls.day, calls.pending)
+	}
+	calls.day, calls.totals, calls.pending = day, map[string]int{}, map[string]int{}
+}
+
+// takeCall counts a call to the provider named name, reporting false
+// instead when it would go past dailyCap.
+func takeCall(name string, dailyCap int) bool {
+	calls.mu.Lock()
+	defer calls.mu.Unlock()
+	rollCallsDay()
+	if dailyCap > 0 && calls.totals[name] >= dailyCap {
+		return false
+	}
+	calls.totals[name]++
+	calls.pending[name]++
+	return true
+}
+
+// giveBackCall uncounts a call taken but never made.
+func giveBackCall(name string) {
+	calls.mu.Lock()
+	defer calls.mu.Unlock()
+	if calls.pending[name] > 0 {
+		calls.totals[name]--
+		calls.pending[name]--
+	}
+}
+
+// dailyCapReached reports whether the provider named name used up its
+// cap for the day, leaving it out of the races. reloadMu must be held.
+func dailyCapReached(name string) bool {
+	dailyCap := providerCaps[name]
+	if dailyCap <= 0 {
+		return false
+	}
+	calls.mu.Lock()
+	defer calls.mu.Unlock()
+	rollCallsDay()
+	return calls.totals[name] >= dailyCap
+}
+
+// cappedProvider counts the calls to a provider, every one of them the
+// innermost wrapper sees, and refuses those past its daily cap.
+type cappedProvider struct {
+	Provider
+	dailyCap int
+}
+
+func (p *cappedProvider) Lookup(ctx context.Context, cep string) (*Address, error) {
+	if !takeCall(p.Name(), p.dailyCap) {
+		providerCapRejections.WithLabelValues(p.Name()).Inc()
+		return nil, fmt.Errorf("%s: %w", p.Name(), ErrDailyCapReached)
+	}
+	address, err := p.Provider.Lookup(ctx, cep)
+	if errors.Is(err, ErrBudgetExhausted) {
+		giveBackCall(p.Name())
+		return address, err
+	}
+	providerCalls.WithLabelValues(p.Name()).Inc()
+	return address, err
+}
+
+// flushProviderCalls writes the pending calls to the history, then reads
+// back the totals, which the other instances sharing it add to.
+func flushProviderCalls() {
+	if history == nil {
+		return
+	}
+	calls.mu.Lock()
+	day, pending := calls.day, calls.pending
+	calls.pending = map[string]int{}
+	calls.mu.Unlock()
+
+	addProviderCalls(day, pending)
+	err := refreshProviderCalls(context.Background())
+	if err != nil {
+		slog.Error("error reading provider usage", "error", err)
+	}
+}
+
+func addProviderCalls(day string, pending map[string]int) {
+	for name, count := range pending {
+		err := history.AddProviderCalls(context.Background(), name, day, count)
+		if err != nil {
+			slog.Error("error writing provider usage", "provider", name, "error", err)
+		}
+	}
+}
+
+// refreshProviderCalls sets the totals to the calls in the history plus
+// what is still pending.
+func refreshProviderCalls(ctx context.Context) error {
+	calls.mu.Lock()
+	day := calls.day
+	calls.mu.Unlock()
+	stored, err := history.ProviderCalls(ctx, day)
+	if err != nil {
+		return err
+	}
+
+	calls.mu.Lock()
+	defer calls.mu.Unlock()
+	if day != calls.day {
+		return nil
+	}
+	totals := maps.Clone(calls.pending)
+	for name, count := range stored {
+		totals[name] += count
+	}
+	calls.totals = totals
+	return nil
+}
+
+// providerUsage returns the calls to the providers on day, every enabled
+// provider included, with their remaining cap.
+func providerUsage(ctx context.Context, day string) ([]ProviderUsage, error) {
+	counts := map[string]int{}
+	calls.mu.Lock()
+	current := day == calls.day
+	if current {
+		counts = maps.Clone(calls.totals)
+	}
+	calls.mu.Unlock()
+	if !current && history != nil {
+		var err error
+		counts, err = history.ProviderCalls(ctx, day)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	reloadMu.RLock()
+	caps := maps.Clone(providerCaps)
+	reloadMu.RUnlock()
+	for name := range caps {
+		if _, ok := counts[name]; !ok {
+			counts[name] = 0
+		}
+	}
+	records := make([]ProviderUsage, 0, len(counts))
+	for _, name := range slices.Sorted(maps.Keys(counts)) {
+		record := ProviderUsage{Provider: name, Day: day, Calls: counts[name], DailyCap: caps[name]}
+		if record.DailyCap > 0 {
+			remaining := max(record.DailyCap-record.Calls, 0)
+			record.Remaining, record.Capped = &remaining, remaining == 0
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// ProviderUsageHandler serves GET /providers/usage, the calls made to
+// every provider on ?day=, today in UTC by default.
+func ProviderUsageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSONError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed")
+		return
+	}
+	day := usageDay(time.Now())
+	if raw := r.URL.Query().Get("day"); raw != "" {
+		parsed, err := time.Parse(time.DateOnly, raw)
+		if err != nil {
+			writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, "day must be formatted as 2006-01-02")
+			return
+		}
+		day = usageDay(parsed)
+	}
+
+	records, err := providerUsage(r.Context(), day)
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadGateway, CodeUpstreamFailure, err.Error())
+		return
+	}
+	writeJSON(w, r, http.StatusOK, records)
+}
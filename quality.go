@@ -0,0 +1,260 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// QualitySample is a comparison of every provider's answer for a CEP,
+// with the fields the ones that found it disagreed on.
+type QualitySample struct {
+	Cep           string        `json:"cep"`
+	Providers     []string      `json:"providers"`
+	Discrepancies []Discrepancy `json:"discrepancies"`
+	At            time.Time     `json:"at"`
+}
+
+// QualityReport summarizes the samples of a time window: how often each
+// field, pair of providers and CEP prefix diverged, most divergent first.
+type QualityReport struct {
+	From      time.Time          `json:"from"`
+	To        time.Time          `json:"to"`
+	Samples   int                `json:"samples"`
+	Divergent int                `json:"divergent"`
+	Fields    []FieldDivergence  `json:"fields"`
+	Pairs     []PairDivergence   `json:"pairs"`
+	Prefixes  []PrefixDivergence `json:"prefixes"`
+}
+
+// FieldDivergence counts the samples disagreeing on a field.
+type FieldDivergence struct {
+	Field     string  `json:"field"`
+	Divergent int     `json:"divergent"`
+	Rate      float64 `json:"rate"`
+}
+
+// PairDivergence compares two providers over the samples both found,
+// counting the fields on which they gave different values.
+type PairDivergence struct {
+	Providers [2]string      `json:"providers"`
+	Samples   int            `json:"samples"`
+	Divergent int            `json:"divergent"`
+	Rate      float64        `json:"rate"`
+	Fields    map[string]int `json:"fields"`
+}
+
+type PrefixDivergence struct {
+	Prefix    string  `json:"prefix"`
+	Samples   int     `json:"samples"`
+	Divergent int     `json:"divergent"`
+	Rate      float64 `json:"rate"`
+}
+
+// qualitySampleRate is the share of the lookups compared in the background.
+var qualitySampleRate float64
+
+// qualityChecks bounds the comparisons running at once; lookups sampled
+// past it are not compared.
+var qualityChecks = make(chan struct{}, 4)
+
+func setupQuality(cfg QualityConfig) {
+	qualitySampleRate = cfg.SampleRate
+	if qualitySampleRate > 0 {
+		slog.Info("quality sampling enabled", "sample_rate", qualitySampleRate)
+	}
+}
+
+// sampleQuality compares every active provider's answer for cep in the
+// background, for the configured share of the lookups, and records the
+// fields they disagree on to the history.
+func sampleQuality(ctx context.Context, cep string) {
+	if history == nil || qualitySampleRate == 0 || rand.Float64() >= qualitySampleRate {
+		return
+	}
+	select {
+	case qualityChecks <- struct{}{}:
+	default:
+		return
+	}
+
+	go func() {
+		defer func() { <-qualityChecks }()
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), lookupTimeout())
+		defer cancel()
+		defer context.AfterFunc(lookupsCtx, cancel)()
+
+		sample := newQualitySample(cep, All(ctx, activeProviders(currentProviders()), cep))
+		// there is nothing to compare a single answer with
+		if len(sample.Providers) < 2 {
+			return
+		}
+		err := history.RecordSample(ctx, sample)
+		if err != nil {
+			slog.ErrorContext(ctx, "error recording quality sample", "error", err)
+		}
+	}()
+}
+
+func newQualitySample(cep string, results []Result) QualitySample {
+	sample := QualitySample{Cep: cep, Discrepancies: FindDiscrepancies(results), At: time.Now()}
+	for _, result := range results {
+		if result.Err == nil && result.Address != nil {
+			sample.Providers = append(sample.Providers, result.Provider)
+		}
+	}
+	return sample
+}
+
+// qualityReport aggregates samples, grouping the CEPs by their first
+// prefixLen digits.
+type qualityReport struct {
+	report    QualityReport
+	prefixLen int
+	fields    map[string]int
+	pairs     map[[2]string]*PairDivergence
+	prefixes  map[string]*PrefixDivergence
+}
+
+func newQualityReport(from, to time.Time, prefixLen int) *qualityReport {
+	return &qualityReport{
+		report:    QualityReport{From: from, To: to},
+		prefixLen: prefixLen,
+		fields:    map[string]int{},
+		pairs:     map[[2]string]*PairDivergence{},
+		prefixes:  map[string]*PrefixDivergence{},
+	}
+}
+
+func (q *qualityReport) add(sample QualitySample) error {
+	divergent := len(sample.Discrepancies) > 0
+	q.report.Samples++
+	prefix := q.prefixes[sample.Cep[:q.prefixLen]]
+	if prefix == nil {
+		prefix = &PrefixDivergence{Prefix: sample.Cep[:q.prefixLen]}
+		q.prefixes[prefix.Prefix] = prefix
+	}
+	prefix.Samples++
+	if divergent {
+		q.report.Divergent++
+		prefix.Divergent++
+	}
+	for _, discrepancy := range sample.Discrepancies {
+		q.fields[discrepancy.Field]++
+	}
+
+	providers := slices.Sorted(slices.Values(sample.Providers))
+	for i, a := range providers {
+		for _, b := range providers[i+1:] {
+			key := [2]string{a, b}
+			pair := q.pairs[key]
+			if pair == nil {
+				pair = &PairDivergence{Providers: key, Fields: map[string]int{}}
+				q.pairs[key] = pair
+			}
+			pair.Samples++
+			diverged := false
+			for _, discrepancy := range sample.Discrepancies {
+				va, okA := discrepancy.Values[a]
+				vb, okB := discrepancy.Values[b]
+				if okA && okB && va != vb {
+					pair.Fields[discrepancy.Field]++
+					diverged = true
+				}
+			}
+			if diverged {
+				pair.Divergent++
+			}
+		}
+	}
+	return nil
+}
+
+// result sorts the report, keeping the limit most divergent prefixes.
+func (q *qualityReport) result(limit int) *QualityReport {
+	report := q.report
+	report.Fields = make([]FieldDivergence, 0, len(q.fields))
+	for field, divergent := range q.fields {
+		report.Fields = append(report.Fields, FieldDivergence{Field: field, Divergent: divergent, Rate: divergenceRate(divergent, report.Samples)})
+	}
+	slices.SortFunc(report.Fields, func(a, b FieldDivergence) int {
+		return cmp.Or(cmp.Compare(b.Divergent, a.Divergent), cmp.Compare(a.Field, b.Field))
+	})
+
+	report.Pairs = make([]PairDivergence, 0, len(q.pairs))
+	for _, pair := range q.pairs {
+		pair.Rate = divergenceRate(pair.Divergent, pair.Samples)
+		report.Pairs = append(report.Pairs, *pair)
+	}
+	slices.SortFunc(report.Pairs, func(a, b PairDivergence) int {
+		return cmp.Or(cmp.Compare(b.Rate, a.Rate), cmp.Compare(a.Providers[0], b.Providers[0]), cmp.Compare(a.Providers[1], b.Providers[1]))
+	})
+
+	report.Prefixes = make([]PrefixDivergence, 0, len(q.prefixes))
+	for _, prefix := range q.prefixes {
+		if prefix.Divergent == 0 {
+			continue
+		}
+		prefix.Rate = divergenceRate(prefix.Divergent, prefix.Samples)
+		report.Prefixes = append(report.Prefixes, *prefix)
+	}
+	slices.SortFunc(report.Prefixes, func(a, b PrefixDivergence) int {
+		return cmp.Or(cmp.Compare(b.Divergent, a.Divergent), cmp.Compare(b.Rate, a.Rate), cmp.Compare(a.Prefix, b.Prefix))
+	})
+	report.Prefixes = report.Prefixes[:min(limit, len(report.Prefixes))]
+	return &report
+}
+
+func divergenceRate(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
+}
+
+const (
+	defaultQualityPrefix = 3
+	defaultQualityLimit  = 20
+	maxQualityLimit      = 500
+)
+
+// QualityReportHandler serves GET /quality/report over a time window, with
+// the CEPs grouped by their first ?prefix= digits (3 by default) and the
+// ?limit= most divergent prefixes listed.
+func QualityReportHandler(w http.ResponseWriter, r *http.Request) {
+	if history == nil {
+		writeJSONError(w, r, http.StatusServiceUnavailable, CodeUnavailable, "history is not enabled")
+		return
+	}
+
+	queryParams := r.URL.Query()
+	from, to, err := timeWindow(queryParams)
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	prefixLen, err := intParam(queryParams.Get("prefix"), defaultQualityPrefix)
+	if err != nil || prefixLen < 1 || prefixLen > 8 {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, "prefix must be between 1 and 8")
+		return
+	}
+	limit, err := intParam(queryParams.Get("limit"), defaultQualityLimit)
+	if err != nil || limit < 1 || limit > maxQualityLimit {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, "limit must be between 1 and "+strconv.Itoa(maxQualityLimit))
+		return
+	}
+
+	report := newQualityReport(from, to, prefixLen)
+	err = history.Samples(r.Context(), from, to, report.add)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error reading quality samples", "error", err)
+		writeJSONError(w, r, http.StatusInternalServerError, CodeUnavailable, "error reading quality samples")
+		return
+	}
+	writeJSON(w, r, http.StatusOK, report.result(limit))
+}
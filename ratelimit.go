@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// limiters holds the outbound token bucket of each rate limited provider.
+var limiters = map[string]*rate.Limiter{}
+
+// limitedProvider takes a token from its limiter before each lookup,
+// refusing the lookups past the provider's rate limit.
+type limitedProvider struct {
+	Provider
+	limiter *rate.Limiter
+}
+
+func (p *limitedProvider) Lookup(ctx context.Context, cep string) (*Address, error) {
+	if !p.limiter.Allow() {
+		return nil, fmt.Errorf("%s: %w: rate limit exhausted", p.Name(), ErrHeldBack)
+	}
+	return p.Provider.Lookup(ctx, cep)
+}
+
+// waitingProvider throttles a provider by waiting for its limiter before
+// each lookup, for background work that can afford to be slow.
+type waitingProvider struct {
+	Provider
+	limiter *rate.Limiter
+}
+
+func (p *waitingProvider) Lookup(ctx context.Context, cep string) (*Address, error) {
+	err := p.limiter.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return p.Provider.Lookup(ctx, cep)
+}
+
+// throttled wraps every provider with its own limiter allowing rps lookups
+// per second. A non-positive rps leaves the providers unthrottled.
+func throttled(providers []Provider, rps float64, burst int) []Provider {
+	if rps <= 0 {
+		return providers
+	}
+	wrapped := make([]Provider, 0, len(providers))
+	for _, provider := range providers {
+		wrapped = append(wrapped, &waitingProvider{Provider: provider, limiter: rate.NewLimiter(rate.Limit(rps), burst)})
+	}
+	return wrapped
+}
+
+// clientLimits holds the inbound token bucket of each client, nil when
+// clients are not limited. A reload swaps it for a new one.
+var clientLimits atomic.Pointer[clientLimiter]
+
+type clientLimiter struct {
+	rps    rate.Limit
+	burst  int
+	bypass []netip.Prefix
+
+	mu      sync.Mutex
+	buckets map[string]*clientBucket
+}
+
+type clientBucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func setupClientRateLimit(cfg ClientRateLimitConfig) {
+	if cfg.RPS <= 0 {
+		clientLimits.Store(nil)
+		return
+	}
+	// already validated with the config
+	bypass, _ := parseNetworks(cfg.Bypass)
+	limits := &clientLimiter{
+		rps:     rate.Limit(cfg.RPS),
+		burst:   cfg.Burst,
+		bypass:  bypass,
+		buckets: make(map[string]*clientBucket),
+	}
+	clientLimits.Store(limits)
+	go limits.sweep(time.Minute)
+}
+
+// parseNetworks parses a list of IPs and CIDRs, an IP being the network
+// of that single address.
+func parseNetworks(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		if addr, err := netip.ParseAddr(value); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("%q is neither an IP nor a CIDR", value)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func (l *clientLimiter) bypassed(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range l.bypass {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// bucket returns the limiter of client, creating it on its first request.
+func (l *clientLimiter) bucket(client string, now time.Time) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &clientBucket{limiter: rate.NewLimiter(l.rps, l.burst)}
+		l.buckets[client] = b
+	}
+	b.lastSeen = now
+	return b.limiter
+}
+
+// sweep forgets, every interval, the clients idle for long enough that
+// their bucket is full again, so a new one makes no difference. It stops
+// once a reload has replaced l.
+func (l *clientLimiter) sweep(interval time.Duration) {
+	refill := time.Duration(float64(l.burst) / float64(l.rps) * float64(time.Second))
+	idle := max(interval, refill)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if clientLimits.Load() != l {
+			return
+		}
+		l.mu.Lock()
+		for client, b := range l.buckets {
+			if now.Sub(b.lastSeen) > idle {
+				delete(l.buckets, client)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// rateLimited answers 429 to the clients over the client rate limit, and
+// reports their allowance to every client in the X-RateLimit-* headers.
+// Clients sending a known API key are limited by key, the others by IP.
+func rateLimited(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r)
+		limits := clientLimits.Load()
+		if limits == nil || limits.bypassed(ip) {
+			next(w, r)
+			return
+		}
+		client := "ip:" + ip
+		if key, ok := apiKeys[r.Header.Get("X-API-Key")]; ok {
+			client = "key:" + key.name
+		}
+
+		now := time.Now()
+		limiter := limits.bucket(client, now)
+		reservation := limiter.ReserveN(now, 1)
+		delay := reservation.DelayFrom(now)
+		if delay > 0 {
+			reservation.CancelAt(now)
+		}
+		tokens := limiter.TokensAt(now)
+		refill := time.Duration((float64(limits.burst) - tokens) / float64(limits.rps) * float64(time.Second))
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limits.burst))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(max(0, int(tokens))))
+		w.Header().Set("X-RateLimit-Reset", seconds(refill))
+		if delay > 0 {
+			w.Header().Set("Retry-After", seconds(delay))
+			writeJSONError(w, r, http.StatusTooManyRequests, CodeRateLimited, "too many requests")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// seconds formats d as the whole seconds of Retry-After, rounding up.
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
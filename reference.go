@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/liberopassadorneto/multi/pkg/cep"
+)
+
+// The reference modules serve BrasilAPI's other datasets, each enabled on
+// its own; their providers are nil when disabled.
+var (
+	bankProvider *cep.BankProvider
+	fipeProvider *cep.FipeProvider
+	// referenceCache is kept apart from the CEP cache, so that the
+	// reference datasets do not evict addresses
+	referenceCache *cep.MemoryCache
+)
+
+func setupReference(cfg Config, client *http.Client) {
+	bankProvider, fipeProvider = nil, nil
+	if m := cfg.Modules.Banks; m.Enabled {
+		bankProvider = cep.NewBankProvider()
+		bankProvider.Client = client
+		if m.URL != "" {
+			bankProvider.BaseURL = m.URL
+		}
+	}
+	if m := cfg.Modules.Fipe; m.Enabled {
+		fipeProvider = cep.NewFipeProvider()
+		fipeProvider.Client = client
+		if m.URL != "" {
+			fipeProvider.BaseURL = m.URL
+		}
+	}
+	referenceCache = cep.NewMemoryCache(cfg.Cache.Size, cfg.Cache.TTL)
+}
+
+// cachedReference answers key from the reference cache or, on a miss,
+// from fetch bounded by the configured timeout, timed under provider in
+// the provider metrics like the CEP lookups.
+func cachedReference[T any](ctx context.Context, key, provider string, fetch func(context.Context) (T, error)) (T, error) {
+	var value T
+	if body, ok, _ := referenceCache.Get(ctx, key); ok && json.Unmarshal(body, &value) == nil {
+		return value, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, lookupTimeout())
+	defer cancel()
+
+	start := time.Now()
+	value, err := fetch(ctx)
+	providerDuration.WithLabelValues(provider, outcome(err)).Observe(time.Since(start).Seconds())
+	if err != nil {
+		if ctx.Err() != nil {
+			return value, ErrTimeout
+		}
+		slog.WarnContext(ctx, provider+" lookup failed", "error", err)
+		return value, err
+	}
+
+	if body, err := json.Marshal(value); err == nil {
+		_ = referenceCache.Set(ctx, key, body)
+	}
+	return value, nil
+}
+
+// BanksHandler serves GET /banks, listing every bank, and GET
+// /banks/{code}.
+func BanksHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSONError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed")
+		return
+	}
+	if bankProvider == nil {
+		writeJSONError(w, r, http.StatusServiceUnavailable, CodeUnavailable, "the banks module is not enabled")
+		return
+	}
+
+	raw := r.PathValue("code")
+	if raw == "" {
+		banks, err := cachedReference(r.Context(), "banks", "brasilapi_banks", bankProvider.List)
+		if err != nil {
+			writeLookupError(w, r, err)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, banks)
+		return
+	}
+
+	code, err := cep.NormalizeBankCode(raw)
+	if err != nil {
+		writeLookupError(w, r, err)
+		return
+	}
+	bank, err := cachedReference(r.Context(), "banks:"+code, "brasilapi_banks", func(ctx context.Context) (*cep.Bank, error) {
+		return bankProvider.Lookup(ctx, code)
+	})
+	if err != nil {
+		writeLookupError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, bank)
+}
+
+// FipeHandler serves GET /fipe/brands/{vehicle_type} and GET
+// /fipe/prices/{fipe_code}.
+func FipeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSONError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed")
+		return
+	}
+	if fipeProvider == nil {
+		writeJSONError(w, r, http.StatusServiceUnavailable, CodeUnavailable, "the fipe module is not enabled")
+		return
+	}
+
+	resource, raw := r.PathValue("resource"), r.PathValue("value")
+	switch resource {
+	case "brands":
+		vehicleType, err := cep.NormalizeVehicleType(raw)
+		if err != nil {
+			writeLookupError(w, r, err)
+			return
+		}
+		brands, err := cachedReference(r.Context(), "fipe:brands:"+vehicleType, "brasilapi_fipe", func(ctx context.Context) ([]cep.FipeBrand, error) {
+			return fipeProvider.Brands(ctx, vehicleType)
+		})
+		if err != nil {
+			writeLookupError(w, r, err)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, brands)
+	case "prices":
+		code, err := cep.NormalizeFipeCode(raw)
+		if err != nil {
+			writeLookupError(w, r, err)
+			return
+		}
+		prices, err := cachedReference(r.Context(), "fipe:prices:"+code, "brasilapi_fipe", func(ctx context.Context) ([]cep.FipePrice, error) {
+			return fipeProvider.Prices(ctx, code)
+		})
+		if err != nil {
+			writeLookupError(w, r, err)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, prices)
+	default:
+		writeJSONError(w, r, http.StatusNotFound, CodeNotFound, "not found")
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// reloadMu guards what a config reload swaps: the providers, with the
+// health checks, breakers, limiters, stats and searchers registered for
+// them, and the reloadable fields of config. Readers hold it only to pick
+// them up, so lookups in flight finish on the providers they started with.
+var reloadMu sync.RWMutex
+
+// outboundClient is the client the providers are built with, kept to
+// rebuild them on reload.
+var outboundClient *http.Client
+
+func currentProviders() []Provider {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	return providers
+}
+
+func currentInternational() []Provider {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	return international
+}
+
+// lookupTimeout is the deadline of a lookup, which a reload may change.
+func lookupTimeout() time.Duration {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	return config.Timeout
+}
+
+// maxLookupTimeout caps the deadline a request may ask for.
+func maxLookupTimeout() time.Duration {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	return config.MaxTimeout
+}
+
+// applyReloadable copies the settings a reload applies from loaded. Any
+// other change needs a restart.
+func (c *Config) applyReloadable(loaded Config) {
+	c.Timeout = loaded.Timeout
+	c.MaxTimeout = loaded.MaxTimeout
+	c.LogLevel = loaded.LogLevel
+	c.Providers = loaded.Providers
+	c.Canary = loaded.Canary
+	c.ProviderSettings = loaded.ProviderSettings
+	c.AdaptiveTimeout = loaded.AdaptiveTimeout
+	c.Retry = loaded.Retry
+	c.CircuitBreaker = loaded.CircuitBreaker
+	c.RateLimit = loaded.RateLimit
+	c.ClientRateLimit = loaded.ClientRateLimit
+}
+
+// watchConfig reloads the configuration on SIGHUP and, every interval,
+// when its file has changed, until ctx is done.
+func watchConfig(ctx context.Context, flags *ConfigFlags, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	path := flags.Path()
+	var tick <-chan time.Time
+	if interval > 0 && path != "" {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	modified := modTime(path)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			slog.Info("reloading config on SIGHUP")
+		case <-tick:
+			// a file being written is caught again on the next tick,
+			// once its modification time settles
+			m := modTime(path)
+			if m.Equal(modified) {
+				continue
+			}
+			modified = m
+			slog.Info("reloading config on file change", "path", path)
+		}
+		reload(flags)
+	}
+}
+
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// reload applies the reloadable settings of the configuration as it is
+// now. An invalid configuration is logged and leaves the running one in
+// place.
+func reload(flags *ConfigFlags) {
+	loaded, err := flags.Load()
+	if err != nil {
+		slog.Error("config reload failed, keeping the current config", "error", err)
+		return
+	}
+	if restartNeeded(currentConfig(), loaded) {
+		slog.Warn("config reload skipped settings that need a restart")
+	}
+	applyConfig(loaded)
+	slog.Info("config reloaded", "providers", loaded.Providers, "timeout", loaded.Timeout, "log_level", loaded.LogLevel)
+}
+
+func currentConfig() Config {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	return config
+}
+
+// restartNeeded reports whether loaded changes settings that only apply
+// on a restart. The configs are compared as YAML, where empty and missing
+// lists are the same.
+func restartNeeded(current, loaded Config) bool {
+	current.applyReloadable(loaded)
+	a, errA := yaml.Marshal(current)
+	b, errB := yaml.Marshal(loaded)
+	return errA != nil || errB != nil || !bytes.Equal(a, b)
+}
+
+// applyConfig puts the reloadable settings of a validated cfg in effect,
+// rebuilding the providers from them.
+func applyConfig(cfg Config) {
+	reloadMu.Lock()
+	config.applyReloadable(cfg)
+	providers = NewProviders(config, outboundClient)
+	international = NewInternationalProviders(config, outboundClient)
+	canaries = NewCanaryProviders(config, outboundClient)
+	canarySampleRate = config.Canary.SampleRate
+	reloadMu.Unlock()
+
+	logLevel.Set(parseLevel(cfg.LogLevel))
+	setupClientRateLimit(cfg.ClientRateLimit)
+	jobs.SetProviders(throttled(currentProviders(), config.Jobs.ProviderRPS, config.Jobs.ProviderBurst))
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/liberopassadorneto/multi/pkg/cep"
+)
+
+const (
+	defaultResolveLimit = 10
+	maxResolveLimit     = 50
+)
+
+type ResolveRequest struct {
+	Address string `json:"address"`
+}
+
+// ResolveResponse is the parsed address and the CEPs matching it, best
+// first.
+type ResolveResponse struct {
+	Query      cep.ParsedAddress `json:"query"`
+	Candidates []cep.Candidate   `json:"candidates"`
+}
+
+// ResolveHandler serves POST /resolve, turning a free-form address into
+// the ?limit= CEPs of the street search best matching it.
+func ResolveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSONError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	limit, err := intParam(r.URL.Query().Get("limit"), defaultResolveLimit)
+	if err != nil || limit < 1 || limit > maxResolveLimit {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, "limit must be between 1 and "+strconv.Itoa(maxResolveLimit))
+		return
+	}
+	var req ResolveRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, "body must be a JSON object with address")
+		return
+	}
+	parsed, err := cep.ParseAddress(req.Address)
+	if err != nil {
+		writeLookupError(w, r, err)
+		return
+	}
+
+	addresses, err := Search(r.Context(), parsed.State, parsed.City, parsed.SearchTerm())
+	if err != nil {
+		writeLookupError(w, r, err)
+		return
+	}
+	candidates := cep.Rank(parsed, addresses)
+	writeJSON(w, r, http.StatusOK, ResolveResponse{Query: parsed, Candidates: candidates[:min(limit, len(candidates))]})
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+)
+
+// ErrorCode identifies the kind of failure in an error response, so that
+// clients need not parse the message.
+type ErrorCode string
+
+const (
+	CodeInvalidRequest   ErrorCode = "INVALID_REQUEST"
+	CodeInvalidCep       ErrorCode = "INVALID_CEP"
+	CodeUFMismatch       ErrorCode = "UF_MISMATCH"
+	CodeNoCoordinates    ErrorCode = "NO_COORDINATES"
+	CodeUnauthorized     ErrorCode = "UNAUTHORIZED"
+	CodeNotFound         ErrorCode = "NOT_FOUND"
+	CodeMethodNotAllowed ErrorCode = "METHOD_NOT_ALLOWED"
+	CodeConflict         ErrorCode = "CONFLICT"
+	CodeTooLarge         ErrorCode = "PAYLOAD_TOO_LARGE"
+	CodeRateLimited      ErrorCode = "RATE_LIMITED"
+	CodeQuotaExceeded    ErrorCode = "QUOTA_EXCEEDED"
+	CodeTimeout          ErrorCode = "TIMEOUT"
+	CodeUpstreamFailure  ErrorCode = "UPSTREAM_FAILURE"
+	CodeUnavailable      ErrorCode = "UNAVAILABLE"
+	CodeBudgetExhausted  ErrorCode = "BUDGET_EXHAUSTED"
+	CodeInternal         ErrorCode = "INTERNAL_ERROR"
+)
+
+// ErrorResponse is the envelope of every error answered by the handlers.
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+type ErrorBody struct {
+	Code      ErrorCode `json:"code"`
+	Message   string    `json:"message"`
+	RequestID string    `json:"request_id,omitempty"`
+	// Mismatch details an UF_MISMATCH error.
+	Mismatch *UFMismatch `json:"mismatch,omitempty"`
+	// Failures details the providers behind an UPSTREAM_FAILURE.
+	Failures []ProviderFailure `json:"failures,omitempty"`
+}
+
+// ProviderFailure is how a provider failed a lookup.
+type ProviderFailure struct {
+	Provider string `json:"provider"`
+	Error    string `json:"error"`
+	// Status is the HTTP status the provider answered, if it answered.
+	Status int `json:"status,omitempty"`
+}
+
+// providerFailures details the failures of the providers err combines,
+// nil for the other errors.
+func providerFailures(err error) []ProviderFailure {
+	var failures ProviderFailures
+	if !errors.As(err, &failures) {
+		return nil
+	}
+	details := make([]ProviderFailure, len(failures))
+	for i, failure := range failures {
+		details[i] = ProviderFailure{Provider: failure.Provider, Error: failure.Err.Error()}
+		var statusErr *StatusError
+		if errors.As(failure.Err, &statusErr) {
+			details[i].Status = statusErr.StatusCode
+		}
+	}
+	return details
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	var body bytes.Buffer
+	err := json.NewEncoder(&body).Encode(v)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error encoding response", "error", err)
+	}
+	writeBody(w, r, status, body.Bytes())
+}
+
+// writeBody answers body, encoded whole so that it can be signed.
+func writeBody(w http.ResponseWriter, r *http.Request, status int, body []byte) {
+	if signingSecret != "" {
+		w.Header().Set("X-Signature", sign(signingSecret, body))
+	}
+	if budgetExhausted(r.Context()) {
+		w.Header().Set("Warning", `199 multi "outbound budget exhausted, the response may be partial"`)
+	}
+	w.WriteHeader(status)
+	_, err := w.Write(body)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error writing response", "error", err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, r *http.Request, status int, code ErrorCode, message string) {
+	writeJSON(w, r, status, ErrorResponse{Error: ErrorBody{
+		Code:      code,
+		Message:   message,
+		RequestID: requestID(r.Context()),
+	}})
+}
+
+// writeLookupError answers with the status and code of a Lookup error.
+func writeLookupError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, ErrOverloaded) {
+		w.Header().Set("Retry-After", "1")
+	}
+	var mismatch *UFMismatch
+	errors.As(err, &mismatch)
+	writeJSON(w, r, lookupStatus(err), ErrorResponse{Error: ErrorBody{
+		Code:      lookupCode(err),
+		Message:   err.Error(),
+		RequestID: requestID(r.Context()),
+		Mismatch:  mismatch,
+		Failures:  providerFailures(err),
+	}})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/url"
+	"slices"
+	"time"
+)
+
+// retryProvider retries transient failures of a provider with exponential
+// backoff and jitter. It never waits past the context deadline: when the
+// next delay would not fit, the last error is returned right away, as it
+// is when the rate limit or the daily cap refuses a retry.
+type retryProvider struct {
+	Provider
+	retry RetryConfig
+}
+
+func (p *retryProvider) Lookup(ctx context.Context, cep string) (*Address, error) {
+	address, err := p.Provider.Lookup(ctx, cep)
+	for attempt := 0; attempt < p.retry.Attempts && p.retryable(ctx, err); attempt++ {
+		delay := p.delay(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return nil, err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+
+		retried, retryErr := p.Provider.Lookup(ctx, cep)
+		if notCalled(retryErr) {
+			return nil, err
+		}
+		address, err = retried, retryErr
+	}
+	return address, err
+}
+
+// retryable reports whether err is worth another attempt: connection
+// failures and the configured status codes, as long as ctx is still alive.
+func (p *retryProvider) retryable(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil || notCalled(err) {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return slices.Contains(p.retry.Statuses, statusErr.StatusCode)
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// delay returns BaseDelay doubled per attempt, capped by MaxDelay, then
+// randomized by up to Jitter of itself in either direction.
+func (p *retryProvider) delay(attempt int) time.Duration {
+	delay := p.retry.BaseDelay << attempt
+	if p.retry.MaxDelay > 0 && (delay > p.retry.MaxDelay || delay <= 0) {
+		delay = p.retry.MaxDelay
+	}
+	if p.retry.Jitter > 0 {
+		delay += time.Duration((rand.Float64()*2 - 1) * p.retry.Jitter * float64(delay))
+	}
+	return delay
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// failingProvider fails every lookup as a connection error would.
+type failingProvider struct {
+	calls atomic.Int32
+}
+
+func (p *failingProvider) Name() string { return "failing" }
+
+func (p *failingProvider) Lookup(ctx context.Context, cep string) (*Address, error) {
+	p.calls.Add(1)
+	return nil, &url.Error{Op: "Get", URL: "http://upstream", Err: errors.New("connection refused")}
+}
+
+func TestRetriesTakeRateLimitTokens(t *testing.T) {
+	upstream := &failingProvider{}
+	// two tokens, not refilling, for a first attempt and three retries
+	limiter := rate.NewLimiter(rate.Every(time.Hour), 2)
+	provider := &retryProvider{
+		Provider: &limitedProvider{Provider: upstream, limiter: limiter},
+		retry:    RetryConfig{Attempts: 3, BaseDelay: time.Millisecond},
+	}
+
+	_, err := provider.Lookup(context.Background(), "01001000")
+	if calls := upstream.calls.Load(); calls != 2 {
+		t.Errorf("upstream called %d times, want 2", calls)
+	}
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) || errors.Is(err, ErrHeldBack) {
+		t.Errorf("got %v, want the failure of the last call made", err)
+	}
+
+	_, err = provider.Lookup(context.Background(), "01001000")
+	if !errors.Is(err, ErrHeldBack) {
+		t.Errorf("got %v once the tokens ran out, want ErrHeldBack", err)
+	}
+	if calls := upstream.calls.Load(); calls != 2 {
+		t.Errorf("upstream called %d times past the limit, want 2", calls)
+	}
+}
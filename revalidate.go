@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/time/rate"
+)
+
+var addressChanges = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: "multi",
+	Name:      "revalidation_changes_total",
+	Help:      "Addresses the re-validation found changed against the history.",
+})
+
+// AddressChange is an address the re-validation found changed against the
+// one last stored for its CEP. Current is nil when the providers no longer
+// find the CEP, and Previous when they find it again after that.
+type AddressChange struct {
+	Cep      string        `json:"cep"`
+	Fields   []FieldChange `json:"fields"`
+	Previous *Address      `json:"previous"`
+	Current  *Address      `json:"current"`
+	At       time.Time     `json:"at"`
+}
+
+// FieldChange is a field of an address whose value changed.
+type FieldChange struct {
+	Field    string `json:"field"`
+	Previous string `json:"previous"`
+	Current  string `json:"current"`
+}
+
+// changedFields lists the fields of current that differ from previous,
+// spelling aside, as a street renamed or a neighborhood added. Fields
+// current leaves empty are not changes: the providers leave out what
+// they do not know.
+func changedFields(previous, current *Address) []FieldChange {
+	fields := []struct {
+		name  string
+		value func(*Address) string
+	}{
+		{"state", func(a *Address) string { return a.State }},
+		{"city", func(a *Address) string { return a.City }},
+		{"neighborhood", func(a *Address) string { return a.Neighborhood }},
+		{"street", func(a *Address) string { return a.Street }},
+		{"ibge", func(a *Address) string { return a.Ibge }},
+		{"ddd", func(a *Address) string { return a.Ddd }},
+	}
+
+	changes := []FieldChange{}
+	for _, field := range fields {
+		before, after := field.value(previous), field.value(current)
+		if after != "" && NormalizeAddressText(before) != NormalizeAddressText(after) {
+			changes = append(changes, FieldChange{Field: field.name, Previous: before, Current: after})
+		}
+	}
+	return changes
+}
+
+// runRevalidation looks the CEPs of cfg up again on every tick until ctx
+// is done.
+func runRevalidation(ctx context.Context, cfg RevalidationConfig) {
+	if cfg.Interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			revalidate(ctx, cfg)
+		}
+	}
+}
+
+// revalidate races the CEPs of cfg, at most cfg.RPS a second, comparing
+// each answer with the address last stored for the CEP. Like the warm-up,
+// its lookups skip the cache, which they refresh, and leave the history
+// of the lookups alone; the changes go to the change log.
+func revalidate(ctx context.Context, cfg RevalidationConfig) {
+	start := time.Now()
+	ceps, err := warmupCeps(ctx, WarmupConfig{Ceps: cfg.Ceps, File: cfg.File, Top: cfg.Top, Window: cfg.Window})
+	if err != nil {
+		slog.Error("error listing the ceps to revalidate", "error", err)
+	}
+
+	limiter := rate.NewLimiter(rate.Limit(cfg.RPS), 1)
+	var changed, unknown, failed int
+	for _, cep := range ceps {
+		previous, known, err := storedAddress(ctx, cep)
+		if err != nil {
+			slog.Error("error reading the stored address", "cep", cep, "error", err)
+			failed++
+			continue
+		}
+		if !known {
+			unknown++
+			continue
+		}
+		if limiter.Wait(ctx) != nil {
+			return
+		}
+
+		result := <-racing(lookupsCtx, currentProviders(), cep, defaultStrategy)
+		if result.Err != nil && !errors.Is(result.Err, ErrCepNotFound) {
+			slog.Debug("revalidation lookup failed", "cep", cep, "error", result.Err)
+			failed++
+			continue
+		}
+		current, _ := result.Val.(*Address)
+
+		change := AddressChange{Cep: cep, Fields: []FieldChange{}, Previous: previous, Current: current, At: time.Now()}
+		switch {
+		case previous == nil && current == nil:
+			continue
+		case previous != nil && current != nil:
+			change.Fields = changedFields(previous, current)
+			if len(change.Fields) == 0 {
+				continue
+			}
+		}
+		changed++
+		recordChange(ctx, cfg, change)
+	}
+	slog.Info("revalidation done", "ceps", len(ceps), "changed", changed, "never_found", unknown,
+		"failed", failed, "duration", time.Since(start).Round(time.Millisecond))
+}
+
+// storedAddress returns the address last stored for cep: that of its
+// newest change, or of its newest lookup found, whichever is the latest.
+// A nil address is a CEP that stopped being found; known is false for
+// those never found.
+func storedAddress(ctx context.Context, cep string) (*Address, bool, error) {
+	var address *Address
+	var at time.Time
+	known := false
+	err := history.Changes(ctx, cep, time.Time{}, time.Now(), func(change AddressChange) error {
+		address, at, known = change.Current, change.At, true
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	records, err := history.Query(ctx, cep, storedLookups)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, record := range records {
+		if record.Result == HistoryFound && record.Address != nil {
+			if record.At.After(at) {
+				address, known = record.Address, true
+			}
+			break
+		}
+	}
+	return address, known, nil
+}
+
+// storedLookups are the newest lookups of a CEP searched for its last
+// address found.
+const storedLookups = 20
+
+// recordChange adds change to the change log and sends it to the webhook
+// and the event bus of cfg.
+func recordChange(ctx context.Context, cfg RevalidationConfig, change AddressChange) {
+	addressChanges.Inc()
+	slog.Info("address changed", "cep", change.Cep, "fields", len(change.Fields), "found", change.Current != nil)
+	err := history.RecordChange(ctx, change)
+	if err != nil {
+		slog.Error("error recording address change", "cep", change.Cep, "error", err)
+	}
+	if cfg.WebhookURL != "" {
+		deliverWebhook(ctx, revalidationClient, cfg.WebhookURL, change)
+	}
+	if cfg.Events {
+		publishChange(ctx, change)
+	}
+}
+
+// ChangeLog are the address changes of a time window, oldest first.
+type ChangeLog struct {
+	From    time.Time       `json:"from"`
+	To      time.Time       `json:"to"`
+	Changes []AddressChange `json:"changes"`
+}
+
+// ChangesHandler serves GET /changes, the change log of a time window,
+// narrowed to a CEP with ?cep=.
+func ChangesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSONError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed")
+		return
+	}
+	if history == nil {
+		writeJSONError(w, r, http.StatusServiceUnavailable, CodeUnavailable, "history is not enabled")
+		return
+	}
+
+	queryParams := r.URL.Query()
+	from, to, err := timeWindow(queryParams)
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	var cep string
+	if raw := queryParams.Get("cep"); raw != "" {
+		cep, err = NormalizeCep(raw)
+		if err != nil {
+			writeLookupError(w, r, err)
+			return
+		}
+	}
+
+	log := ChangeLog{From: from, To: to, Changes: []AddressChange{}}
+	err = history.Changes(r.Context(), cep, from, to, func(change AddressChange) error {
+		log.Changes = append(log.Changes, change)
+		return nil
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error reading address changes", "error", err)
+		writeJSONError(w, r, http.StatusInternalServerError, CodeUnavailable, "error reading address changes")
+		return
+	}
+	writeJSON(w, r, http.StatusOK, log)
+}
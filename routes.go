@@ -0,0 +1,296 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/liberopassadorneto/multi/pkg/cep"
+)
+
+// Route is a handler of the HTTP API together with the description of the
+// operations it serves, from which /openapi.json is generated. Keeping
+// both in one place keeps the spec in sync with what is served.
+type Route struct {
+	// Pattern is registered with the mux; Label names the route in the
+	// metrics and traces.
+	Pattern string
+	Label   string
+	Handler http.HandlerFunc
+	// Middleware wraps Handler, inside apiMiddleware unless Public.
+	Middleware []middleware
+	// Public routes skip the API keys, the client rate limit and CORS.
+	Public     bool
+	Operations []Operation
+}
+
+// Operation documents a method on a path. Body and Response are values of
+// the types exchanged, described through their JSON fields.
+type Operation struct {
+	Method      string
+	Path        string
+	Summary     string
+	Params      []Param
+	Body        any
+	BodyType    string // defaults to application/json
+	Status      int    // of a success, defaults to 200
+	Response    any
+	ContentType string // of Response, defaults to application/json
+	// Formats is set on the operations also answering XML, CSV and
+	// protobuf.
+	Formats bool
+}
+
+type Param struct {
+	Name        string
+	In          string // query or path
+	Description string
+	Required    bool
+	Type        string // defaults to string
+	Enum        []string
+}
+
+var (
+	strategyParam = Param{Name: "strategy", In: "query", Description: "Strategy picking the answer, the configured one when empty"}
+	timeoutParam  = Param{Name: "timeout_ms", In: "query", Description: "Deadline of the lookup in milliseconds, clamped to the configured maximum", Type: "integer"}
+	formatParam   = Param{Name: "format", In: "query", Description: "Response format, overriding Accept", Enum: []string{"json", "xml", "csv", "geojson", "protobuf"}}
+	fieldsParam   = Param{Name: "fields", In: "query", Description: "Comma separated fields of the addresses to answer with, in JSON and CSV"}
+	langParam     = Param{Name: "lang", In: "query", Description: "Language of the address keys in JSON and CSV, overriding Accept-Language", Enum: []string{"en", "pt"}}
+	jobIDParam    = Param{Name: "id", In: "path", Required: true}
+	cepPathParam  = Param{Name: "cep", In: "path", Description: "CEP as 00000000 or 00000-000", Required: true}
+)
+
+// routes is the HTTP API. It must be called once the handlers' settings
+// are loaded, as some handlers are built from them.
+func routes() []Route {
+	apiRoutes := []Route{
+		{Pattern: "/{$}", Label: "/", Handler: FetchBothHandler, Operations: []Operation{{
+			Method: http.MethodGet, Path: "/", Summary: "Look up a CEP through the providers race",
+			Params: []Param{
+				{Name: "cep", In: "query", Description: "CEP as 00000000 or 00000-000", Required: true},
+				strategyParam,
+				{Name: "mode", In: "query", Description: "all answers with every provider's result and their discrepancies instead", Enum: []string{"race", "all"}},
+				{Name: "enrich", In: "query", Description: "Comma separated enrichments: ibge, geo"},
+				{Name: "debug", In: "query", Description: "Answer in JSON with a meta block: the winner, the duration and every provider attempt", Type: "boolean"},
+				{Name: "expect_uf", In: "query", Description: "State the CEP is expected in; a CEP of another state is answered 422 UF_MISMATCH"},
+				{Name: "expect_uf_mode", In: "query", Description: "flag answers a mismatching CEP anyway, with its state in X-UF-Mismatch", Enum: []string{"reject", "flag"}},
+				timeoutParam, formatParam, fieldsParam, langParam,
+			},
+			Response: &Address{}, Formats: true,
+		}}},
+		{Pattern: "/v1/cep/{cep}", Label: "/v1/cep/{cep}", Handler: VersionedHandler("v1"), Operations: []Operation{{
+			Method: http.MethodGet, Path: "/v1/cep/{cep}", Summary: "Look up a CEP, answered in the v1 schema",
+			Params:   []Param{cepPathParam, strategyParam, timeoutParam, formatParam, fieldsParam, langParam},
+			Response: &Address{}, Formats: true,
+		}}},
+		{Pattern: "/v2/cep/{cep}", Label: "/v2/cep/{cep}", Handler: VersionedHandler("v2"), Operations: []Operation{{
+			Method: http.MethodGet, Path: "/v2/cep/{cep}", Summary: "Look up a CEP, answered in the v2 schema, with flat coordinates",
+			Params:   []Param{cepPathParam, strategyParam, timeoutParam, formatParam, fieldsParam, langParam},
+			Response: &AddressV2{}, Formats: true,
+		}}},
+		{Pattern: "/batch", Label: "/batch", Handler: BatchHandler, Operations: []Operation{{
+			Method: http.MethodPost, Path: "/batch", Summary: "Look up many CEPs, answered in input order or as Server-Sent Events",
+			Params: []Param{strategyParam, timeoutParam, formatParam, fieldsParam, langParam},
+			Body:   []string{}, Response: []BatchItem{}, Formats: true,
+		}}},
+		{Pattern: "/jobs", Label: "/jobs", Handler: JobsHandler, Operations: []Operation{{
+			Method: http.MethodPost, Path: "/jobs", Summary: "Start a job resolving the first column of a CSV",
+			Body: "", BodyType: "text/csv", Status: http.StatusAccepted, Response: JobProgress{},
+		}}},
+		{Pattern: "/jobs/", Label: "/jobs/{id}", Handler: JobHandler, Operations: []Operation{
+			{Method: http.MethodGet, Path: "/jobs/{id}", Summary: "Get the progress of a job", Params: []Param{jobIDParam}, Response: JobProgress{}},
+			{Method: http.MethodGet, Path: "/jobs/{id}/result", Summary: "Get the CSV of a done job with the addresses appended", Params: []Param{jobIDParam}, Response: "", ContentType: "text/csv"},
+			{Method: http.MethodGet, Path: "/jobs/{id}/events", Summary: "Stream the progress of a job as Server-Sent Events", Params: []Param{jobIDParam}, Response: "", ContentType: "text/event-stream"},
+		}},
+		{Pattern: "/ddd/{code}", Label: "/ddd/{code}", Handler: DddHandler, Operations: []Operation{{
+			Method: http.MethodGet, Path: "/ddd/{code}", Summary: "List the state and cities of a DDD",
+			Params:   []Param{{Name: "code", In: "path", Description: "Two digit area code", Required: true}},
+			Response: &cep.DddInfo{},
+		}}},
+		{Pattern: "/cnpj/{cnpj}", Label: "/cnpj/{cnpj}", Handler: CnpjHandler, Operations: []Operation{{
+			Method: http.MethodGet, Path: "/cnpj/{cnpj}", Summary: "Get the registration of a company",
+			Params:   []Param{{Name: "cnpj", In: "path", Description: "CNPJ, with or without its punctuation", Required: true}},
+			Response: &cep.Company{},
+		}}},
+		{Pattern: "/banks", Label: "/banks", Handler: BanksHandler, Operations: []Operation{{
+			Method: http.MethodGet, Path: "/banks", Summary: "List the banks, with the banks module enabled",
+			Response: []cep.Bank{},
+		}}},
+		{Pattern: "/banks/{code}", Label: "/banks/{code}", Handler: BanksHandler, Operations: []Operation{{
+			Method: http.MethodGet, Path: "/banks/{code}", Summary: "Get a bank by its COMPE code, with the banks module enabled",
+			Params:   []Param{{Name: "code", In: "path", Description: "One to three digit COMPE code", Required: true}},
+			Response: &cep.Bank{},
+		}}},
+		{Pattern: "/fipe/{resource}/{value}", Label: "/fipe/{resource}", Handler: FipeHandler, Operations: []Operation{
+			{
+				Method: http.MethodGet, Path: "/fipe/brands/{vehicle_type}", Summary: "List the FIPE brands of a vehicle type, with the fipe module enabled",
+				Params:   []Param{{Name: "vehicle_type", In: "path", Description: "cars, motorcycles or trucks", Required: true}},
+				Response: []cep.FipeBrand{},
+			},
+			{
+				Method: http.MethodGet, Path: "/fipe/prices/{fipe_code}", Summary: "List the FIPE prices of a model by model year, with the fipe module enabled",
+				Params:   []Param{{Name: "fipe_code", In: "path", Description: "FIPE code, as in 001004-9", Required: true}},
+				Response: []cep.FipePrice{},
+			},
+		}},
+		{Pattern: "/history", Label: "/history", Handler: HistoryHandler, Operations: []Operation{{
+			Method: http.MethodGet, Path: "/history", Summary: "List the past lookups of a CEP, newest first",
+			Params: []Param{
+				{Name: "cep", In: "query", Required: true},
+				{Name: "limit", In: "query", Type: "integer"},
+			},
+			Response: HistoryResponse{},
+		}}},
+		{Pattern: "/history/export", Label: "/history/export", Handler: HistoryExportHandler, Operations: []Operation{{
+			Method: http.MethodGet, Path: "/history/export", Summary: "Export the lookups of a time window as CSV or JSON lines",
+			Params:   append(windowParams(), Param{Name: "format", In: "query", Enum: []string{"csv", "json"}}),
+			Response: "", ContentType: "text/csv",
+		}}},
+		{Pattern: "/stats", Label: "/stats", Handler: StatsHandler, Operations: []Operation{{
+			Method: http.MethodGet, Path: "/stats", Summary: "Summarize the providers' lookups over a time window",
+			Params: windowParams(), Response: HistoryStats{},
+		}}},
+		{Pattern: "/usage", Label: "/usage", Handler: UsageHandler, Middleware: []middleware{cors}, Public: true, Operations: []Operation{{
+			Method: http.MethodGet, Path: "/usage", Summary: "Report the lookups of the caller's API key in a month and its remaining quota",
+			Params:   []Param{{Name: "month", In: "query", Description: "Month, as in 2026-09; the current one by default"}},
+			Response: KeyUsage{},
+		}}},
+		{Pattern: "/quality/report", Label: "/quality/report", Handler: QualityReportHandler, Operations: []Operation{{
+			Method: http.MethodGet, Path: "/quality/report", Summary: "Summarize the fields, provider pairs and CEP prefixes diverging most in the sampled comparisons",
+			Params: append(windowParams(),
+				Param{Name: "prefix", In: "query", Description: "Digits of the CEP prefixes grouped, 3 by default", Type: "integer"},
+				Param{Name: "limit", In: "query", Description: "Prefixes listed, 20 by default", Type: "integer"},
+			),
+			Response: QualityReport{},
+		}}},
+		{Pattern: "/changes", Label: "/changes", Handler: ChangesHandler, Operations: []Operation{{
+			Method: http.MethodGet, Path: "/changes", Summary: "List the address changes the re-validation found over a time window",
+			Params:   append(windowParams(), Param{Name: "cep", In: "query", Description: "Only the changes of this CEP"}),
+			Response: ChangeLog{},
+		}}},
+		{Pattern: "/canary/report", Label: "/canary/report", Handler: CanaryReportHandler, Operations: []Operation{{
+			Method: http.MethodGet, Path: "/canary/report", Summary: "Compare the accuracy and latency of the canary providers with the race",
+			Response: CanaryReport{},
+		}}},
+		{Pattern: "/autocomplete", Label: "/autocomplete", Handler: AutocompleteHandler, Operations: []Operation{{
+			Method: http.MethodGet, Path: "/autocomplete", Summary: "List the known CEPs starting with a prefix, for type-ahead forms",
+			Params: []Param{
+				{Name: "prefix", In: "query", Description: "1 to 8 leading digits of the CEP", Required: true},
+				{Name: "limit", In: "query", Description: "CEPs listed, 10 by default", Type: "integer"},
+				{Name: "offline", In: "query", Description: "Also list the ranges of the offline dataset holding the prefix", Type: "boolean"},
+			},
+			Response: AutocompleteResponse{},
+		}}},
+		{Pattern: "/lookup", Label: "/lookup", Handler: LookupHandler, Operations: []Operation{{
+			Method: http.MethodGet, Path: "/lookup", Summary: "Look up a postal code of any supported country",
+			Params: []Param{
+				{Name: "country", In: "query", Description: "ISO country code, BR when empty"},
+				{Name: "code", In: "query", Required: true},
+				strategyParam, timeoutParam, formatParam, fieldsParam, langParam,
+			},
+			Response: &Address{}, Formats: true,
+		}}},
+		{Pattern: "/lookup/async", Label: "/lookup/async", Handler: AsyncLookupHandler, Operations: []Operation{{
+			Method: http.MethodPost, Path: "/lookup/async", Summary: "Look up a CEP in the background and POST the result to a callback",
+			Body: AsyncLookupRequest{}, Status: http.StatusAccepted, Response: AsyncLookupResponse{},
+		}}},
+		{Pattern: "/distance", Label: "/distance", Handler: DistanceHandler, Operations: []Operation{{
+			Method: http.MethodGet, Path: "/distance", Summary: "Get the straight-line distance between two CEPs",
+			Params: []Param{
+				{Name: "from", In: "query", Required: true},
+				{Name: "to", In: "query", Required: true},
+			},
+			Response: DistanceResponse{},
+		}}},
+		{Pattern: "/zone", Label: "/zone", Handler: ZoneHandler, Operations: []Operation{{
+			Method: http.MethodGet, Path: "/zone", Summary: "Classify a CEP into its shipping zone, with its address",
+			Params:   []Param{{Name: "cep", In: "query", Description: "CEP as 00000000 or 00000-000", Required: true}},
+			Response: ZoneResponse{},
+		}}},
+		{Pattern: "/search", Label: "/search", Handler: SearchHandler, Operations: []Operation{{
+			Method: http.MethodGet, Path: "/search", Summary: "List the CEPs of a street",
+			Params: []Param{
+				{Name: "uf", In: "query", Required: true},
+				{Name: "city", In: "query", Required: true},
+				{Name: "street", In: "query", Required: true},
+				{Name: "page", In: "query", Type: "integer"},
+				{Name: "per_page", In: "query", Type: "integer"},
+				formatParam, fieldsParam, langParam,
+			},
+			Response: SearchResponse{}, Formats: true,
+		}}},
+		{Pattern: "/resolve", Label: "/resolve", Handler: ResolveHandler, Operations: []Operation{{
+			Method: http.MethodPost, Path: "/resolve", Summary: "Rank the CEPs matching a free-form address",
+			Params: []Param{{Name: "limit", In: "query", Description: "Candidates listed, 10 by default", Type: "integer"}},
+			Body:   ResolveRequest{}, Response: ResolveResponse{},
+		}}},
+		{Pattern: "/ws", Label: "/ws", Handler: WebSocketHandler, Operations: []Operation{{
+			Method: http.MethodGet, Path: "/ws", Summary: "Open a WebSocket exchanging WSRequest and WSResult messages",
+			Status: http.StatusSwitchingProtocols,
+		}}},
+		{Pattern: "/graphql", Label: "/graphql", Handler: GraphQLHandler().ServeHTTP, Operations: []Operation{{
+			Method: http.MethodPost, Path: "/graphql", Summary: "Run a GraphQL query",
+			Body: struct {
+				Query         string         `json:"query"`
+				OperationName string         `json:"operationName,omitempty"`
+				Variables     map[string]any `json:"variables,omitempty"`
+			}{},
+			Response: map[string]any{},
+		}}},
+		{Pattern: "/healthz", Label: "/healthz", Handler: HealthzHandler, Public: true, Operations: []Operation{{
+			Method: http.MethodGet, Path: "/healthz", Summary: "Report that the process is up", Response: HealthStatus{},
+		}}},
+		{Pattern: "/readyz", Label: "/readyz", Handler: ReadyzHandler, Public: true, Operations: []Operation{{
+			Method: http.MethodGet, Path: "/readyz", Summary: "Report whether the cache backend is reachable", Response: HealthStatus{},
+		}}},
+		{Pattern: "/providers/status", Label: "/providers/status", Handler: ProvidersStatusHandler, Public: true, Operations: []Operation{{
+			Method: http.MethodGet, Path: "/providers/status", Summary: "Report the recent success rate, latency and state of every provider",
+			Response: []ProviderStatus{},
+		}}},
+		{Pattern: "/providers/usage", Label: "/providers/usage", Handler: ProviderUsageHandler, Public: true, Operations: []Operation{{
+			Method: http.MethodGet, Path: "/providers/usage", Summary: "Report the calls made to every provider on a day and what is left of their daily caps",
+			Params:   []Param{{Name: "day", In: "query", Description: "Day as 2006-01-02 in UTC, today by default"}},
+			Response: []ProviderUsage{},
+		}}},
+		{Pattern: "/slo", Label: "/slo", Handler: SLOHandler, Public: true, Operations: []Operation{{
+			Method: http.MethodGet, Path: "/slo", Summary: "Report the SLIs and burn rates of the objectives, answering 503 while one is alerting",
+			Response: SLOReport{},
+		}}},
+		{Pattern: "/ui/", Label: "/ui", Handler: UIHandler, Public: true, Operations: []Operation{{
+			Method: http.MethodGet, Path: "/ui/", Summary: "Get the web UI", Response: "", ContentType: "text/html",
+		}}},
+		{Pattern: "/openapi.json", Label: "/openapi.json", Handler: OpenAPIHandler, Public: true, Operations: []Operation{{
+			Method: http.MethodGet, Path: "/openapi.json", Summary: "Get this OpenAPI document", Response: map[string]any{},
+		}}},
+	}
+	if adminToken != "" {
+		apiRoutes = append(apiRoutes, adminRoute())
+	}
+	return apiRoutes
+}
+
+// adminRoute is guarded by the admin token rather than the API keys.
+func adminRoute() Route {
+	provider := Param{Name: "name", In: "path", Description: "Provider as configured, such as viacep", Required: true}
+	cep := Param{Name: "cep", In: "path", Required: true}
+	return Route{Pattern: "/admin/", Label: "/admin", Handler: AdminHandler, Middleware: []middleware{adminOnly}, Public: true, Operations: []Operation{
+		{Method: http.MethodGet, Path: "/admin/config", Summary: "Get the effective configuration, secrets masked", Response: map[string]any{}},
+		{Method: http.MethodPatch, Path: "/admin/config", Summary: "Change the providers, timeouts, retries, breakers, rate limits or log level", Body: map[string]any{}, Response: map[string]any{}},
+		{Method: http.MethodPost, Path: "/admin/providers/{name}/enable", Summary: "Enable a provider, last in priority", Params: []Param{provider}, Response: map[string]any{}},
+		{Method: http.MethodPost, Path: "/admin/providers/{name}/disable", Summary: "Disable a provider", Params: []Param{provider}, Response: map[string]any{}},
+		{Method: http.MethodPost, Path: "/admin/providers/{name}/promote", Summary: "Move a canary into the race, last in priority", Params: []Param{provider}, Response: map[string]any{}},
+		{Method: http.MethodPost, Path: "/admin/breakers/{name}/trip", Summary: "Open the breaker of a provider", Params: []Param{provider}, Response: BreakerStatus{}},
+		{Method: http.MethodPost, Path: "/admin/breakers/{name}/reset", Summary: "Close the breaker of a provider", Params: []Param{provider}, Response: BreakerStatus{}},
+		{Method: http.MethodGet, Path: "/admin/cache", Summary: "Get the cache stats", Response: CacheStats{}},
+		{Method: http.MethodDelete, Path: "/admin/cache", Summary: "Flush the cache", Status: http.StatusNoContent},
+		{Method: http.MethodGet, Path: "/admin/cache/{cep}", Summary: "Get the cached lookup of a CEP", Params: []Param{cep}, Response: AdminCacheEntry{}},
+		{Method: http.MethodDelete, Path: "/admin/cache/{cep}", Summary: "Drop the cached lookup of a CEP", Params: []Param{cep}, Status: http.StatusNoContent},
+		{Method: http.MethodGet, Path: "/admin/usage", Summary: "Report the lookups of every API key in a month", Params: []Param{{Name: "month", In: "query"}}, Response: []KeyUsage{}},
+	}}
+}
+
+func windowParams() []Param {
+	return []Param{
+		{Name: "from", In: "query", Description: "Start of the window, a date or RFC 3339 time"},
+		{Name: "to", In: "query", Description: "End of the window, a date or RFC 3339 time"},
+		{Name: "window", In: "query", Description: "Duration of a window ending now, instead of from and to"},
+	}
+}
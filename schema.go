@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/liberopassadorneto/multi/pkg/cep"
+)
+
+// schemaVersions are the versions of the JSON shape of the addresses, the
+// oldest first. An address is answered in the first unless a client asks
+// for another, so that the shape can evolve without breaking anyone.
+var schemaVersions = []string{"v1", "v2"}
+
+// AddressV2 is the v2 shape of an address: its coordinates are plain
+// latitude and longitude numbers instead of a GeoJSON-like location.
+type AddressV2 struct {
+	Cep          string            `json:"cep"`
+	Country      string            `json:"country,omitempty"`
+	State        string            `json:"state"`
+	City         string            `json:"city"`
+	Neighborhood string            `json:"neighborhood"`
+	Street       string            `json:"street"`
+	Complement   string            `json:"complement,omitempty"`
+	Ibge         string            `json:"ibge,omitempty"`
+	Ddd          string            `json:"ddd,omitempty"`
+	Latitude     *float64          `json:"latitude,omitempty"`
+	Longitude    *float64          `json:"longitude,omitempty"`
+	Municipality *cep.Municipality `json:"municipality,omitempty"`
+	Provider     string            `json:"provider"`
+	Source       string            `json:"source,omitempty"`
+	Stale        bool              `json:"stale,omitempty"`
+}
+
+func newAddressV2(a *Address) *AddressV2 {
+	v2 := &AddressV2{
+		Cep: a.Cep, Country: a.Country, State: a.State, City: a.City, Neighborhood: a.Neighborhood,
+		Street: a.Street, Complement: a.Complement, Ibge: a.Ibge, Ddd: a.Ddd,
+		Municipality: a.Municipality, Provider: a.Provider, Source: a.Source, Stale: a.Stale,
+	}
+	if a.Location != nil {
+		latitude, longitude := a.Location.Coordinates.Latitude, a.Location.Coordinates.Longitude
+		v2.Latitude, v2.Longitude = &latitude, &longitude
+	}
+	return v2
+}
+
+// schemaAddress is address in the shape of schema, for JSON encoding.
+func schemaAddress(address *Address, schema string) any {
+	if schema == "v2" {
+		return newAddressV2(address)
+	}
+	return address
+}
+
+// addressV2Fields are the JSON names of the fields of AddressV2, which
+// ?fields= picks from in v2.
+var addressV2Fields = jsonFields[AddressV2]()
+
+type schemaKey struct{}
+
+// responseSchema picks the shape of the JSON addresses answered to r: the
+// version of the path of /v1/cep/{cep} and /v2/cep/{cep}, else the profile
+// of the JSON media type of Accept, else v1. The profile is the version,
+// as in application/json; profile=v2, or a URI ending in it.
+func responseSchema(r *http.Request) (string, error) {
+	if schema, ok := r.Context().Value(schemaKey{}).(string); ok {
+		return schema, nil
+	}
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil || formats[mediaType] != "json" {
+			continue
+		}
+		profile, ok := params["profile"]
+		if !ok {
+			continue
+		}
+		schema := profile
+		if uri, err := url.Parse(profile); err == nil && uri.Path != "" {
+			schema = path.Base(uri.Path)
+		}
+		if !slices.Contains(schemaVersions, schema) {
+			return "", fmt.Errorf("unknown profile %q, expected one of %s", profile, strings.Join(schemaVersions, ", "))
+		}
+		return schema, nil
+	}
+	return schemaVersions[0], nil
+}
+
+// VersionedHandler serves GET /{schema}/cep/{cep}, the lookup of /?cep=
+// with its JSON addresses pinned to schema whatever the Accept.
+func VersionedHandler(schema string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			writeJSONError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed")
+			return
+		}
+		r = r.Clone(context.WithValue(r.Context(), schemaKey{}, schema))
+		queryParams := r.URL.Query()
+		queryParams.Set("cep", r.PathValue("cep"))
+		r.URL.RawQuery = queryParams.Encode()
+		FetchBothHandler(w, r)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultSearchPerPage = 20
+	maxSearchPerPage     = 100
+)
+
+// searchers are the enabled providers that support reverse lookups, in
+// priority order.
+var searchers []Searcher
+
+// Search lists the addresses of a street through the first searcher that
+// answers within the configured timeout, falling back to the next one
+// when a searcher fails.
+func Search(ctx context.Context, state, city, street string) ([]Address, error) {
+	reloadMu.RLock()
+	searchers := searchers
+	reloadMu.RUnlock()
+	if len(searchers) == 0 {
+		return nil, ErrNoProviders
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, lookupTimeout())
+	defer cancel()
+
+	var errs []error
+	for _, searcher := range searchers {
+		addresses, err := searcher.Search(ctx, state, city, street)
+		if err == nil {
+			return addresses, nil
+		}
+		if errors.Is(err, ErrInvalidSearch) {
+			return nil, err
+		}
+		if ctx.Err() != nil {
+			return nil, ErrTimeout
+		}
+		slog.WarnContext(ctx, "search failed", "error", err)
+		errs = append(errs, err)
+	}
+	return nil, &ProviderError{Err: errors.Join(errs...)}
+}
+
+type SearchResponse struct {
+	XMLName xml.Name  `json:"-" xml:"search"`
+	Results []Address `json:"results" xml:"results>address"`
+	Total   int       `json:"total" xml:"total,attr"`
+	Page    int       `json:"page" xml:"page,attr"`
+	PerPage int       `json:"per_page" xml:"per_page,attr"`
+}
+
+// SearchHandler serves GET /search?uf=&city=&street=, paginated by page
+// (from 1) and per_page.
+func SearchHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	page, err := intParam(query.Get("page"), 1)
+	if err != nil || page < 1 {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, "page must be a positive integer")
+		return
+	}
+	perPage, err := intParam(query.Get("per_page"), defaultSearchPerPage)
+	if err != nil || perPage < 1 || perPage > maxSearchPerPage {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, "per_page must be between 1 and "+strconv.Itoa(maxSearchPerPage))
+		return
+	}
+
+	format, err := responseFormat(r)
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	if err := checkResponseShape(r, format); err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	if format == "protobuf" {
+		writeJSONError(w, r, http.StatusNotAcceptable, CodeInvalidRequest, "protobuf is only available for lookups and batches")
+		return
+	}
+
+	addresses, err := Search(r.Context(), query.Get("uf"), query.Get("city"), query.Get("street"))
+	if err != nil {
+		writeLookupError(w, r, err)
+		return
+	}
+
+	start := min((page-1)*perPage, len(addresses))
+	end := min(start+perPage, len(addresses))
+	writeFormatted(w, r, http.StatusOK, format, SearchResponse{
+		Results: addresses[start:end],
+		Total:   len(addresses),
+		Page:    page,
+		PerPage: perPage,
+	})
+}
+
+// intParam parses an optional integer query parameter.
+func intParam(value string, fallback int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(value)
+}
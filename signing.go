@@ -0,0 +1,19 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// signingSecret signs the response bodies and webhook payloads in
+// X-Signature when set.
+var signingSecret string
+
+// sign is the hex HMAC-SHA256 of body under secret, as "sha256=<hex>" so
+// that receivers can tell it apart from other schemes.
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
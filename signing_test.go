@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/hmac"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSign(t *testing.T) {
+	tests := []struct {
+		secret string
+		body   string
+		want   string
+	}{
+		// RFC 4231, test case 2
+		{"Jefe", "what do ya want for nothing?", "sha256=5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"},
+		{"", "", "sha256=b613679a0814d9ec772f95d778c35fc5ff1697c493715653c6c712144292c5ad"},
+	}
+	for _, test := range tests {
+		if got := sign(test.secret, []byte(test.body)); got != test.want {
+			t.Errorf("sign(%q, %q) = %s, want %s", test.secret, test.body, got, test.want)
+		}
+	}
+
+	body := []byte(`{"cep":"01001000"}`)
+	if hmac.Equal([]byte(sign("secret", body)), []byte(sign("other", body))) {
+		t.Error("different secrets gave the same signature")
+	}
+	if hmac.Equal([]byte(sign("secret", body)), []byte(sign("secret", []byte(`{"cep":"01001001"}`)))) {
+		t.Error("different bodies gave the same signature")
+	}
+}
+
+func TestWriteBodySignature(t *testing.T) {
+	t.Cleanup(func() { signingSecret = "" })
+	body := []byte(`{"cep":"01001000"}` + "\n")
+	for _, secret := range []string{"", "secret"} {
+		signingSecret = secret
+		w := httptest.NewRecorder()
+		writeBody(w, httptest.NewRequest(http.MethodGet, "/", nil), http.StatusOK, body)
+
+		got := w.Header().Get("X-Signature")
+		switch {
+		case secret == "" && got != "":
+			t.Errorf("signed with no secret: %s", got)
+		case secret != "" && got != sign(secret, w.Body.Bytes()):
+			t.Errorf("X-Signature %q does not match the body sent", got)
+		}
+	}
+}
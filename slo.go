@@ -0,0 +1,251 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// sloBucket is the span of time whose requests an objective counts
+// together; the windows are read to the bucket.
+const sloBucket = time.Minute
+
+// slos tracks the objectives by route label, set once at startup.
+var slos struct {
+	trackers  []*sloTracker
+	byRoute   map[string]*sloTracker
+	windows   []time.Duration
+	alertRate float64
+}
+
+// setupSLO tracks the objectives of cfg, whose routes must be among
+// routes.
+func setupSLO(cfg SLOConfig, routes []Route) {
+	labels := map[string]bool{"unmatched": true}
+	for _, route := range routes {
+		labels[route.Label] = true
+	}
+	slos.byRoute = map[string]*sloTracker{}
+	slos.windows, slos.alertRate = cfg.Windows, cfg.AlertBurnRate
+	longest := slices.Max(cfg.Windows)
+	for _, objective := range cfg.Objectives {
+		if !labels[objective.Route] {
+			fatal("error tracking slo", fmt.Errorf("no route %q", objective.Route))
+		}
+		tracker := &sloTracker{objective: objective, buckets: make([]sloCounts, longest/sloBucket+1)}
+		slos.trackers = append(slos.trackers, tracker)
+		slos.byRoute[objective.Route] = tracker
+	}
+}
+
+// envSLOObjectives reads route=target@latency pairs, falling back when
+// any of them is invalid.
+func envSLOObjectives(name string, fallback []SLOObjective) []SLOObjective {
+	value := envString(name, "")
+	if value == "" {
+		return fallback
+	}
+	var objectives []SLOObjective
+	for _, pair := range splitList(value) {
+		route, promise, _ := strings.Cut(pair, "=")
+		target, latency, _ := strings.Cut(promise, "@")
+		objective := SLOObjective{Route: strings.TrimSpace(route)}
+		var errTarget, errLatency error
+		objective.Target, errTarget = strconv.ParseFloat(strings.TrimSpace(target), 64)
+		objective.Latency, errLatency = time.ParseDuration(strings.TrimSpace(latency))
+		if err := errors.Join(errTarget, errLatency); err != nil {
+			slog.Warn("invalid environment variable, using the fallback", "name", name, "value", value, "error", err)
+			return fallback
+		}
+		objectives = append(objectives, objective)
+	}
+	return objectives
+}
+
+// recordSLO counts a request to route against its objective, if it has
+// one: it is bad when it failed with a 5xx or took over the latency.
+func recordSLO(route string, status int, latency time.Duration) {
+	if tracker, ok := slos.byRoute[route]; ok {
+		tracker.record(status >= 500 || latency > tracker.objective.Latency)
+	}
+}
+
+// sloTracker counts the requests of an objective, and the bad ones, in a
+// ring of buckets covering the longest window.
+type sloTracker struct {
+	objective SLOObjective
+	mu        sync.Mutex
+	buckets   []sloCounts
+}
+
+type sloCounts struct {
+	// bucket is the number of the bucket since the epoch
+	bucket   int64
+	requests int
+	bad      int
+}
+
+func currentSLOBucket() int64 {
+	return time.Now().UnixNano() / int64(sloBucket)
+}
+
+func (t *sloTracker) record(bad bool) {
+	bucket := currentSLOBucket()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	counts := &t.buckets[bucket%int64(len(t.buckets))]
+	if counts.bucket != bucket {
+		*counts = sloCounts{bucket: bucket}
+	}
+	counts.requests++
+	if bad {
+		counts.bad++
+	}
+}
+
+// window sums the requests of the last window, the current bucket
+// included.
+func (t *sloTracker) window(window time.Duration) (requests, bad int) {
+	last := currentSLOBucket()
+	first := last - int64(window/sloBucket) + 1
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, counts := range t.buckets {
+		if counts.bucket >= first && counts.bucket <= last {
+			requests += counts.requests
+			bad += counts.bad
+		}
+	}
+	return requests, bad
+}
+
+// SLOReport tells how every objective fares over the windows.
+type SLOReport struct {
+	AlertBurnRate float64     `json:"alert_burn_rate"`
+	Objectives    []SLOStatus `json:"objectives"`
+}
+
+// SLOStatus is an objective of Target of the requests to Route answered
+// under LatencyMs without a 5xx. It is Alerting when every window burns
+// its error budget at AlertBurnRate or faster.
+type SLOStatus struct {
+	Route     string      `json:"route"`
+	Target    float64     `json:"target"`
+	LatencyMs float64     `json:"latency_ms"`
+	Alerting  bool        `json:"alerting"`
+	Windows   []SLOWindow `json:"windows"`
+}
+
+// SLOWindow is an objective over a window: SLI is the share of good
+// requests, and BurnRate how fast the bad ones spend the error budget,
+// 1 spending it exactly over the objective's period.
+type SLOWindow struct {
+	Window   string   `json:"window"`
+	Requests int      `json:"requests"`
+	Bad      int      `json:"bad"`
+	SLI      *float64 `json:"sli,omitempty"`
+	BurnRate float64  `json:"burn_rate"`
+}
+
+func (t *sloTracker) status() SLOStatus {
+	status := SLOStatus{
+		Route:     t.objective.Route,
+		Target:    t.objective.Target,
+		LatencyMs: float64(t.objective.Latency) / float64(time.Millisecond),
+		Alerting:  true,
+		Windows:   make([]SLOWindow, 0, len(slos.windows)),
+	}
+	for _, window := range slos.windows {
+		requests, bad := t.window(window)
+		result := SLOWindow{Window: windowName(window), Requests: requests, Bad: bad}
+		if requests > 0 {
+			sli := 1 - float64(bad)/float64(requests)
+			result.SLI = &sli
+			result.BurnRate = math.Round(float64(bad)/float64(requests)/(1-t.objective.Target)*1e4) / 1e4
+		}
+		status.Alerting = status.Alerting && result.BurnRate >= slos.alertRate
+		status.Windows = append(status.Windows, result)
+	}
+	return status
+}
+
+// windowName spells window without its zero units, 1h rather than 1h0m0s.
+func windowName(window time.Duration) string {
+	name := window.String()
+	if strings.HasSuffix(name, "m0s") {
+		name = strings.TrimSuffix(name, "0s")
+	}
+	if strings.HasSuffix(name, "h0m") {
+		name = strings.TrimSuffix(name, "0m")
+	}
+	return name
+}
+
+// SLOHandler serves GET /slo, how every objective fares, answering 503
+// while one is alerting so that a plain uptime check can page on it.
+func SLOHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSONError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed")
+		return
+	}
+	report := SLOReport{AlertBurnRate: slos.alertRate, Objectives: make([]SLOStatus, 0, len(slos.trackers))}
+	code := http.StatusOK
+	for _, tracker := range slos.trackers {
+		status := tracker.status()
+		if status.Alerting {
+			code = http.StatusServiceUnavailable
+		}
+		report.Objectives = append(report.Objectives, status)
+	}
+	writeJSON(w, r, code, report)
+}
+
+var (
+	sloBurnRateDesc = prometheus.NewDesc(
+		"multi_slo_burn_rate",
+		"How fast each objective spends its error budget over each window, 1 spending it exactly over its period.",
+		[]string{"route", "window"}, nil,
+	)
+	sloAlertingDesc = prometheus.NewDesc(
+		"multi_slo_alerting",
+		"1 while every window of the objective burns at the alert burn rate or faster.",
+		[]string{"route"}, nil,
+	)
+)
+
+// sloCollector reads the objectives at scrape time, since their windows
+// slide by the passing of time.
+type sloCollector struct{}
+
+func (sloCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- sloBurnRateDesc
+	ch <- sloAlertingDesc
+}
+
+func (sloCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, tracker := range slos.trackers {
+		status := tracker.status()
+		for _, window := range status.Windows {
+			ch <- prometheus.MustNewConstMetric(sloBurnRateDesc, prometheus.GaugeValue, window.BurnRate, status.Route, window.Window)
+		}
+		alerting := 0.0
+		if status.Alerting {
+			alerting = 1
+		}
+		ch <- prometheus.MustNewConstMetric(sloAlertingDesc, prometheus.GaugeValue, alerting, status.Route)
+	}
+}
+
+func init() {
+	prometheus.MustRegister(sloCollector{})
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// eventStream writes Server-Sent Events, flushing each one so it reaches
+// the client right away.
+type eventStream struct {
+	w          http.ResponseWriter
+	controller *http.ResponseController
+}
+
+func newEventStream(w http.ResponseWriter) *eventStream {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	return &eventStream{w: w, controller: http.NewResponseController(w)}
+}
+
+// acceptsEventStream reports whether the client asked for Server-Sent
+// Events.
+func acceptsEventStream(r *http.Request) bool {
+	return r.Header.Get("Accept") == "text/event-stream"
+}
+
+// Send writes an event of the given type with v as JSON data. An empty id
+// leaves the event without one.
+func (s *eventStream) Send(id string, event string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		_, err = fmt.Fprintf(s.w, "id: %s\n", id)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, data)
+	if err != nil {
+		return err
+	}
+	return s.controller.Flush()
+}
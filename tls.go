@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// serverTLS is the TLS configuration of the HTTP and gRPC servers, nil
+// when they serve plain text.
+var serverTLS *tls.Config
+
+var (
+	tlsVersions = map[string]uint16{"1.2": tls.VersionTLS12, "1.3": tls.VersionTLS13}
+	clientAuths = map[string]tls.ClientAuthType{
+		"none":    tls.NoClientCert,
+		"request": tls.VerifyClientCertIfGiven,
+		"require": tls.RequireAndVerifyClientCert,
+	}
+)
+
+// setupTLS loads the server certificate when one is configured and, with a
+// reload interval, keeps it in sync with its files until ctx is done.
+func setupTLS(ctx context.Context, cfg TLSConfig) {
+	serverTLS = nil
+	if cfg.CertFile == "" {
+		return
+	}
+	tlsConfig, certs, err := newServerTLS(cfg)
+	if err != nil {
+		fatal("error loading tls certificate", err)
+	}
+	serverTLS = tlsConfig
+	if cfg.ReloadInterval > 0 {
+		go certs.watch(ctx, cfg.ReloadInterval)
+	}
+	slog.Info("tls enabled", "cert_file", cfg.CertFile, "client_auth", cfg.ClientAuth)
+}
+
+func newServerTLS(cfg TLSConfig) (*tls.Config, *certReloader, error) {
+	certs := &certReloader{certFile: cfg.CertFile, keyFile: cfg.KeyFile}
+	err := certs.reload()
+	if err != nil {
+		return nil, nil, err
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:     tlsVersions[cfg.MinVersion],
+		GetCertificate: certs.certificate,
+		ClientAuth:     clientAuths[cfg.ClientAuth],
+	}
+	if cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("%s: no PEM certificate found", cfg.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+	}
+	return tlsConfig, certs, nil
+}
+
+// certReloader serves the certificate last loaded from its files, so a
+// rotated certificate is picked up without a restart. Handshakes in
+// flight keep the one they started with.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	modified time.Time
+}
+
+func (c *certReloader) certificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+func (c *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	modified, err := c.lastModified()
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.cert, c.modified = &cert, modified
+	c.mu.Unlock()
+	return nil
+}
+
+// lastModified is the latest modification time of the certificate and
+// key files.
+func (c *certReloader) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// watch reloads the certificate every interval its files have changed. A
+// pair failing to load, such as one caught halfway through its rotation,
+// keeps the previous certificate until the next check.
+func (c *certReloader) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		modified, err := c.lastModified()
+		c.mu.RLock()
+		changed := err == nil && !modified.Equal(c.modified)
+		c.mu.RUnlock()
+		if !changed {
+			continue
+		}
+		err = c.reload()
+		if err != nil {
+			slog.Warn("error reloading tls certificate, keeping the previous one", "cert_file", c.certFile, "error", err)
+			continue
+		}
+		slog.Info("tls certificate reloaded", "cert_file", c.certFile)
+	}
+}
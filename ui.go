@@ -0,0 +1,35 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// uiFiles is the web UI, a page calling the API from the browser.
+//
+//go:embed ui
+var uiFiles embed.FS
+
+var uiServer = func() http.Handler {
+	files, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		panic("embedded ui: " + err.Error())
+	}
+	return http.StripPrefix("/ui/", http.FileServerFS(files))
+}()
+
+// UIHandler serves the web UI under /ui/: a CEP lookup with its provider
+// attempts and their latency, every provider's answer side by side, the
+// lookup of the CEPs of a file and the status of the providers. It is
+// public, as the API it calls asks for the key typed in it.
+func UIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeJSONError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed")
+		return
+	}
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; frame-ancestors 'none'")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	uiServer.ServeHTTP(w, r)
+}
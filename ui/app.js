@@ -0,0 +1,224 @@
+"use strict";
+
+// The UI only calls the API of the server it is served by, sending the API
+// key typed in, kept in the browser.
+const batchSize = 100;
+const addressFields = ["cep", "state", "city", "neighborhood", "street", "complement", "ibge", "ddd", "provider"];
+
+const apiKey = document.getElementById("api-key");
+apiKey.value = localStorage.getItem("multi.apiKey") || "";
+apiKey.addEventListener("change", () => localStorage.setItem("multi.apiKey", apiKey.value));
+
+async function api(path, options = {}) {
+  const headers = { Accept: "application/json", ...options.headers };
+  if (apiKey.value) {
+    headers["X-API-Key"] = apiKey.value;
+  }
+  const start = performance.now();
+  const response = await fetch(path, { ...options, headers });
+  const elapsed = performance.now() - start;
+  const body = await response.json().catch(() => null);
+  if (!response.ok) {
+    const message = body && body.error ? `${body.error.code}: ${body.error.message}` : `${response.status} ${response.statusText}`;
+    throw new Error(message);
+  }
+  return { body, elapsed, response };
+}
+
+function el(tag, text, className) {
+  const node = document.createElement(tag);
+  if (text !== undefined && text !== null) {
+    node.textContent = text;
+  }
+  if (className) {
+    node.className = className;
+  }
+  return node;
+}
+
+function row(cells, tag = "td") {
+  const tr = document.createElement("tr");
+  for (const cell of cells) {
+    tr.append(cell instanceof Node ? cell : el(tag, cell));
+  }
+  return tr;
+}
+
+function ms(value) {
+  return value === undefined || value === null ? "" : `${value.toFixed(1)} ms`;
+}
+
+function showError(section, err) {
+  const error = section.querySelector(".error");
+  error.textContent = err ? err.message : "";
+  error.hidden = !err;
+  if (err) {
+    section.querySelector(".result")?.setAttribute("hidden", "");
+  }
+}
+
+for (const button of document.querySelectorAll("nav button")) {
+  button.addEventListener("click", () => {
+    for (const other of document.querySelectorAll("nav button, .tab")) {
+      other.classList.remove("active");
+    }
+    button.classList.add("active");
+    document.getElementById(button.dataset.tab).classList.add("active");
+    if (button.dataset.tab === "providers") {
+      loadProviders();
+    }
+  });
+}
+
+function cepParam(form) {
+  return new URLSearchParams({ cep: form.elements.cep.value.trim() });
+}
+
+// Lookup: the answer of the race with its meta block, every provider
+// called and how long each took.
+const lookup = document.getElementById("lookup");
+lookup.querySelector("form").addEventListener("submit", async (event) => {
+  event.preventDefault();
+  const form = event.target;
+  const params = cepParam(form);
+  params.set("debug", "true");
+  if (form.elements.strategy.value) {
+    params.set("strategy", form.elements.strategy.value);
+  }
+  try {
+    const { body, elapsed } = await api(`/?${params}`);
+    showError(lookup, null);
+    renderLookup(body, elapsed);
+  } catch (err) {
+    showError(lookup, err);
+  }
+});
+
+function renderLookup(address, elapsed) {
+  const { meta } = address;
+  const result = lookup.querySelector(".result");
+  result.querySelector(".summary").textContent =
+    `Answered by ${meta.provider} (${meta.strategy}) in ${ms(meta.duration_ms)}, ${ms(elapsed)} round trip` +
+    (meta.cached ? ", from the cache" : "") + (meta.stale ? ", stale" : "");
+
+  const table = result.querySelector(".address");
+  table.replaceChildren(...addressFields.filter((field) => address[field]).map((field) => row([el("th", field), address[field]])));
+  if (address.location) {
+    const { latitude, longitude } = address.location.coordinates;
+    table.append(row([el("th", "location"), `${latitude}, ${longitude}`]));
+  }
+
+  const slowest = Math.max(1, ...meta.attempts.map((attempt) => attempt.duration_ms));
+  const bar = (attempt) => {
+    const td = el("td");
+    const div = el("div", null, "bar");
+    div.style.width = `${(attempt.duration_ms / slowest) * 12}rem`;
+    td.append(div);
+    return td;
+  };
+  result.querySelector(".attempts tbody").replaceChildren(...meta.attempts.map((attempt) => row([
+    attempt.provider,
+    el("td", attempt.http_status ? `${attempt.status} (${attempt.http_status})` : attempt.status, attempt.status === "success" ? "ok" : "failed"),
+    ms(attempt.duration_ms),
+    bar(attempt),
+    attempt.error || "",
+  ])));
+  result.hidden = false;
+}
+
+// Compare: every provider's answer side by side, the fields they
+// disagree on highlighted.
+const compare = document.getElementById("compare");
+compare.querySelector("form").addEventListener("submit", async (event) => {
+  event.preventDefault();
+  const params = cepParam(event.target);
+  params.set("mode", "all");
+  try {
+    const { body, elapsed } = await api(`/?${params}`);
+    showError(compare, null);
+    renderComparison(body, elapsed);
+  } catch (err) {
+    showError(compare, err);
+  }
+});
+
+function renderComparison({ results, discrepancies }, elapsed) {
+  const result = compare.querySelector(".result");
+  const disagreeing = new Set(discrepancies.map((discrepancy) => discrepancy.field));
+  result.querySelector(".summary").textContent =
+    `${results.filter((answer) => answer.address).length} of ${results.length} providers answered in ${ms(elapsed)}, ` +
+    (disagreeing.size ? `disagreeing on ${[...disagreeing].join(", ")}` : "agreeing on every field");
+
+  const table = result.querySelector(".comparison");
+  table.replaceChildren(row(["", ...results.map((answer) => answer.provider)], "th"));
+  for (const field of addressFields.filter((field) => field !== "provider")) {
+    const cells = results.map((answer) => el("td", answer.address ? answer.address[field] || "" : "", disagreeing.has(field) ? "diff" : ""));
+    table.append(row([el("th", field), ...cells]));
+  }
+  table.append(row([el("th", "error"), ...results.map((answer) => el("td", answer.error || "", "failed"))]));
+  result.hidden = false;
+}
+
+// Batch: the CEPs of a file, sent to POST /batch in chunks of its default
+// maximum.
+const batch = document.getElementById("batch");
+batch.querySelector("form").addEventListener("submit", async (event) => {
+  event.preventDefault();
+  const text = await event.target.elements.file.files[0].text();
+  const ceps = text.split(/\r?\n/)
+    .map((line) => line.split(/[,;\t]/)[0].replace(/"/g, "").trim())
+    .filter((cep, i) => cep && !(i === 0 && !/\d/.test(cep)));
+  const result = batch.querySelector(".result");
+  const tbody = result.querySelector("tbody");
+  tbody.replaceChildren();
+  showError(batch, null);
+  result.hidden = false;
+
+  let done = 0;
+  let failed = 0;
+  const start = performance.now();
+  try {
+    for (let i = 0; i < ceps.length; i += batchSize) {
+      const { body } = await api("/batch", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify(ceps.slice(i, i + batchSize)),
+      });
+      for (const item of body) {
+        const address = item.address || {};
+        failed += item.error ? 1 : 0;
+        tbody.append(row([item.input, el("td", item.status, item.error ? "failed" : "ok"), address.state, address.city,
+          address.neighborhood, address.street, address.provider, item.error || ""]));
+      }
+      done += body.length;
+      result.querySelector(".summary").textContent =
+        `${done} of ${ceps.length} CEPs looked up in ${ms(performance.now() - start)}, ${failed} failed`;
+    }
+  } catch (err) {
+    showError(batch, err);
+    result.hidden = done === 0;
+  }
+});
+
+// Providers: the recent success rate and latency of each, with its health
+// check and breaker.
+const providers = document.getElementById("providers");
+document.getElementById("providers-refresh").addEventListener("click", loadProviders);
+
+async function loadProviders() {
+  try {
+    const { body } = await api("/providers/status");
+    showError(providers, null);
+    providers.querySelector("tbody").replaceChildren(...body.map((status) => row([
+      status.name,
+      status.samples,
+      status.success_rate === undefined ? "" : `${(status.success_rate * 100).toFixed(1)}%`,
+      ms(status.latency_p50_ms),
+      ms(status.latency_p95_ms),
+      status.health_check ? el("td", status.health_check.healthy ? "healthy" : "unhealthy", status.health_check.healthy ? "ok" : "failed") : "",
+      status.breaker ? status.breaker.state : "",
+    ])));
+  } catch (err) {
+    showError(providers, err);
+  }
+}
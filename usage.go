@@ -0,0 +1,307 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// usageFlushInterval is how often the usage counted in memory is added to
+// the history, and the usage of the other instances sharing it read back.
+const usageFlushInterval = 10 * time.Second
+
+type apiKeyNameKey struct{}
+
+// withAPIKeyName stores in ctx the name of the API key a request came
+// with, whose usage its lookups count towards.
+func withAPIKeyName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, apiKeyNameKey{}, name)
+}
+
+func apiKeyName(ctx context.Context) string {
+	name, _ := ctx.Value(apiKeyNameKey{}).(string)
+	return name
+}
+
+// KeyUsage is the usage of an API key in a month.
+type KeyUsage struct {
+	Key   string `json:"key"`
+	Month string `json:"month"`
+	// Lookups are the CEPs looked up, of which Cached were answered
+	// from the cache.
+	Lookups       int     `json:"lookups"`
+	Cached        int     `json:"cached"`
+	CacheHitRatio float64 `json:"cache_hit_ratio"`
+	// MonthlyQuota is 0, and Remaining nil, when the lookups are unlimited.
+	MonthlyQuota int  `json:"monthly_quota"`
+	Remaining    *int `json:"remaining,omitempty"`
+}
+
+type usageCount struct {
+	lookups, cached int
+}
+
+// usage counts the lookups of the API keys in the current month: totals
+// with those already in the history, pending those not written to it yet.
+var usage = struct {
+	mu      sync.Mutex
+	month   string
+	totals  map[string]usageCount
+	pending map[string]usageCount
+}{totals: map[string]usageCount{}, pending: map[string]usageCount{}}
+
+func usageMonth(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+// setupUsage reads the usage of the current month from the history, so
+// that the monthly quotas survive restarts, and keeps it in step with the
+// history until ctx is done.
+func setupUsage(ctx context.Context) {
+	if apiKeys == nil {
+		return
+	}
+	usage.mu.Lock()
+	usage.month = usageMonth(time.Now())
+	usage.mu.Unlock()
+	if history == nil {
+		return
+	}
+	err := refreshUsage(ctx)
+	if err != nil {
+		slog.Error("error reading api key usage", "error", err)
+	}
+	go func() {
+		ticker := time.NewTicker(usageFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				flushUsage()
+			}
+		}
+	}()
+}
+
+// countUsage counts a lookup answered, found or not, towards the usage of
+// the API key of ctx.
+func countUsage(ctx context.Context, info LookupInfo, err error) {
+	name := apiKeyName(ctx)
+	if name == "" || (err != nil && !errors.Is(err, ErrCepNotFound)) {
+		return
+	}
+	count := usageCount{lookups: 1}
+	if info.Cached {
+		count.cached = 1
+	}
+
+	usage.mu.Lock()
+	defer usage.mu.Unlock()
+	if month := usageMonth(time.Now()); month != usage.month {
+		// the last month's pending usage is lost with the totals unless
+		// written first
+		if history != nil {
+			go addUsage(usage.month, usage.pending)
+		}
+		usage.month, usage.totals, usage.pending = month, map[string]usageCount{}, map[string]usageCount{}
+	}
+	usage.totals[name] = usage.totals[name].add(count)
+	usage.pending[name] = usage.pending[name].add(count)
+}
+
+func (c usageCount) add(other usageCount) usageCount {
+	return usageCount{lookups: c.lookups + other.lookups, cached: c.cached + other.cached}
+}
+
+// monthlyUsage returns the lookups of the API key named name in the
+// month of now.
+func monthlyUsage(name string, now time.Time) int {
+	usage.mu.Lock()
+	defer usage.mu.Unlock()
+	if usageMonth(now) != usage.month {
+		return 0
+	}
+	return usage.totals[name].lookups
+}
+
+// flushUsage writes the pending usage to the history, then reads back the
+// totals, which the other instances sharing it add to.
+func flushUsage() {
+	if history == nil {
+		return
+	}
+	usage.mu.Lock()
+	month, pending := usage.month, usage.pending
+	usage.pending = map[string]usageCount{}
+	usage.mu.Unlock()
+
+	addUsage(month, pending)
+	err := refreshUsage(context.Background())
+	if err != nil {
+		slog.Error("error reading api key usage", "error", err)
+	}
+}
+
+func addUsage(month string, pending map[string]usageCount) {
+	for name, count := range pending {
+		err := history.AddUsage(context.Background(), name, month, count.lookups, count.cached)
+		if err != nil {
+			slog.Error("error writing api key usage", "api_key", name, "error", err)
+		}
+	}
+}
+
+// refreshUsage sets the totals to the usage in the history plus what is
+// still pending.
+func refreshUsage(ctx context.Context) error {
+	usage.mu.Lock()
+	month := usage.month
+	usage.mu.Unlock()
+	stored, err := history.Usage(ctx, month)
+	if err != nil {
+		return err
+	}
+
+	usage.mu.Lock()
+	defer usage.mu.Unlock()
+	if month != usage.month {
+		return nil
+	}
+	totals := maps.Clone(usage.pending)
+	for _, record := range stored {
+		totals[record.Key] = totals[record.Key].add(usageCount{lookups: record.Lookups, cached: record.Cached})
+	}
+	usage.totals = totals
+	return nil
+}
+
+// keyUsage returns the usage of the API keys in month, every configured
+// key included, with their remaining quota.
+func keyUsage(ctx context.Context, month string) ([]KeyUsage, error) {
+	counts := map[string]usageCount{}
+	usage.mu.Lock()
+	current := month == usage.month
+	if current {
+		counts = maps.Clone(usage.totals)
+	}
+	usage.mu.Unlock()
+	if !current && history != nil {
+		stored, err := history.Usage(ctx, month)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range stored {
+			counts[record.Key] = usageCount{lookups: record.Lookups, cached: record.Cached}
+		}
+	}
+
+	quotas := map[string]int{}
+	for _, key := range apiKeys {
+		quotas[key.name] = key.monthlyQuota
+		if _, ok := counts[key.name]; !ok {
+			counts[key.name] = usageCount{}
+		}
+	}
+	records := make([]KeyUsage, 0, len(counts))
+	for _, name := range slices.Sorted(maps.Keys(counts)) {
+		records = append(records, newKeyUsage(name, month, counts[name], quotas[name]))
+	}
+	return records, nil
+}
+
+func newKeyUsage(name, month string, count usageCount, quota int) KeyUsage {
+	record := KeyUsage{Key: name, Month: month, Lookups: count.lookups, Cached: count.cached, MonthlyQuota: quota}
+	if count.lookups > 0 {
+		record.CacheHitRatio = float64(count.cached) / float64(count.lookups)
+	}
+	if quota > 0 {
+		remaining := max(quota-count.lookups, 0)
+		record.Remaining = &remaining
+	}
+	return record
+}
+
+// usageMonthParam parses ?month=2006-01, the current month when missing.
+func usageMonthParam(r *http.Request) (string, error) {
+	raw := r.URL.Query().Get("month")
+	if raw == "" {
+		return usageMonth(time.Now()), nil
+	}
+	month, err := time.Parse("2006-01", raw)
+	if err != nil {
+		return "", errors.New("month must be formatted as 2006-01")
+	}
+	return usageMonth(month), nil
+}
+
+// UsageHandler serves GET /usage, the usage of the caller's API key in
+// ?month=, the current one by default. It checks the key itself rather
+// than through authenticated, so that a key over its quotas can still see
+// its usage.
+func UsageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSONError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed")
+		return
+	}
+	if apiKeys == nil {
+		writeJSONError(w, r, http.StatusNotFound, CodeNotFound, "usage is only tracked for api keys")
+		return
+	}
+	value := r.Header.Get("X-API-Key")
+	if value == "" {
+		writeJSONError(w, r, http.StatusUnauthorized, CodeUnauthorized, errMissingAPIKey.Error())
+		return
+	}
+	key, ok := apiKeys[value]
+	if !ok {
+		writeJSONError(w, r, http.StatusUnauthorized, CodeUnauthorized, errInvalidAPIKey.Error())
+		return
+	}
+	name := key.name
+	month, err := usageMonthParam(r)
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	records, err := keyUsage(r.Context(), month)
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadGateway, CodeUpstreamFailure, err.Error())
+		return
+	}
+	i := slices.IndexFunc(records, func(record KeyUsage) bool { return record.Key == name })
+	writeJSON(w, r, http.StatusOK, records[i])
+}
+
+// adminUsage answers the usage of every API key in ?month=, the current
+// one by default, most used first.
+func adminUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSONError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed")
+		return
+	}
+	month, err := usageMonthParam(r)
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	records, err := keyUsage(r.Context(), month)
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadGateway, CodeUpstreamFailure, err.Error())
+		return
+	}
+	slices.SortStableFunc(records, func(a, b KeyUsage) int {
+		return cmp.Compare(b.Lookups, a.Lookups)
+	})
+	writeJSON(w, r, http.StatusOK, records)
+}
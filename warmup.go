@@ -0,0 +1,130 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// runWarmup preloads the cache with the CEPs of cfg at startup and, with
+// an interval, again on every tick until ctx is done.
+func runWarmup(ctx context.Context, cfg WarmupConfig) {
+	if !cfg.enabled() {
+		return
+	}
+	warmup(ctx, cfg)
+	if cfg.Interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			warmup(ctx, cfg)
+		}
+	}
+}
+
+// warmup looks up the CEPs of cfg missing from the cache, or stale in it,
+// at most cfg.RPS a second so that it does not spike the upstreams it is
+// meant to spare. Its lookups go straight to the race, leaving the
+// history and its top CEPs alone.
+func warmup(ctx context.Context, cfg WarmupConfig) {
+	start := time.Now()
+	ceps, err := warmupCeps(ctx, cfg)
+	if err != nil {
+		slog.Error("error listing the ceps to warm up", "error", err)
+	}
+
+	limiter := rate.NewLimiter(rate.Limit(cfg.RPS), 1)
+	var loaded, cached, failed int
+	for _, cep := range ceps {
+		if _, info, ok := cachedAddress(ctx, cep); ok && !info.Stale {
+			cached++
+			continue
+		}
+		if limiter.Wait(ctx) != nil {
+			return
+		}
+		result := <-racing(lookupsCtx, currentProviders(), cep, defaultStrategy)
+		if result.Err != nil {
+			slog.Debug("warm-up lookup failed", "cep", cep, "error", result.Err)
+			failed++
+			continue
+		}
+		loaded++
+	}
+	slog.Info("cache warm-up done", "ceps", len(ceps), "loaded", loaded, "already_cached", cached,
+		"failed", failed, "duration", time.Since(start).Round(time.Millisecond))
+}
+
+// warmupCeps lists the CEPs of cfg, normalized and without duplicates:
+// the configured ones, then those of the file, then the most looked up
+// in the history, most popular first.
+func warmupCeps(ctx context.Context, cfg WarmupConfig) ([]string, error) {
+	raw := slices.Clone(cfg.Ceps)
+	var errs []error
+	if cfg.File != "" {
+		lines, err := readCepFile(cfg.File)
+		errs = append(errs, err)
+		raw = append(raw, lines...)
+	}
+	if cfg.Top > 0 && history != nil {
+		top, err := topCeps(ctx, history, time.Now().Add(-cfg.Window), cfg.Top)
+		errs = append(errs, err)
+		raw = append(raw, top...)
+	}
+
+	ceps := make([]string, 0, len(raw))
+	seen := map[string]bool{}
+	for _, value := range raw {
+		cep, err := NormalizeCep(value)
+		if err != nil {
+			slog.Warn("skipping invalid cep in the list", "cep", value)
+			continue
+		}
+		if !seen[cep] {
+			seen[cep] = true
+			ceps = append(ceps, cep)
+		}
+	}
+	return ceps, errors.Join(errs...)
+}
+
+func readCepFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return readLines(file)
+}
+
+// topCeps returns the n CEPs found the most often in the history since
+// from, most popular first.
+func topCeps(ctx context.Context, store HistoryStore, from time.Time, n int) ([]string, error) {
+	counts := map[string]int{}
+	err := store.Export(ctx, from, time.Now(), func(record HistoryRecord) error {
+		if record.Result == HistoryFound {
+			counts[record.Cep]++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	ceps := slices.SortedFunc(maps.Keys(counts), func(a, b string) int {
+		return cmp.Or(cmp.Compare(counts[b], counts[a]), cmp.Compare(a, b))
+	})
+	return ceps[:min(n, len(ceps))], nil
+}
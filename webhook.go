@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// maxPendingWebhooks bounds the async lookups being resolved or delivered
+// at once, past which new ones are refused.
+const maxPendingWebhooks = 1000
+
+// asyncMaxBody bounds the body of POST /lookup/async.
+const asyncMaxBody = 64 << 10
+
+var (
+	webhookConfig WebhookConfig
+	// webhookClient delivers the callbacks the API clients ask for, and
+	// revalidationClient those to the URL of the configuration. Neither
+	// follows redirects.
+	webhookClient      *http.Client
+	revalidationClient *http.Client
+	webhookPending     = make(chan struct{}, maxPendingWebhooks)
+)
+
+// errCallbackAddress is returned for the callbacks to an address that is
+// not public.
+var errCallbackAddress = errors.New("callback address is not public")
+
+func setupWebhook(cfg WebhookConfig, client *http.Client) {
+	webhookConfig = cfg
+	webhookClient = callbackClient(client, cfg.AllowedHosts)
+	revalidationClient = &http.Client{Transport: client.Transport, CheckRedirect: noRedirects}
+}
+
+func noRedirects(*http.Request, []*http.Request) error {
+	return http.ErrUseLastResponse
+}
+
+// callbackClient is client connecting only to public addresses, checked
+// as they are dialed so that a DNS answer cannot slip a private one in,
+// unless the host is one of allowed. Any API client could otherwise have
+// the server POST to the services next to it, such as the cloud metadata
+// at 169.254.169.254. It connects directly, as a proxy would dial on its
+// behalf past the check.
+func callbackClient(client *http.Client, allowed []string) *http.Client {
+	transport, ok := client.Transport.(*http.Transport)
+	if !ok {
+		transport = http.DefaultTransport.(*http.Transport)
+	}
+	transport = transport.Clone()
+	transport.Proxy = nil
+	public := &net.Dialer{Control: func(_, address string, _ syscall.RawConn) error {
+		addrPort, err := netip.ParseAddrPort(address)
+		if err != nil || !publicAddress(addrPort.Addr()) {
+			return fmt.Errorf("%w: %s", errCallbackAddress, address)
+		}
+		return nil
+	}}
+	var dialer net.Dialer
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, _, _ := net.SplitHostPort(address)
+		if slices.Contains(allowed, strings.ToLower(host)) {
+			return dialer.DialContext(ctx, network, address)
+		}
+		return public.DialContext(ctx, network, address)
+	}
+	return &http.Client{Transport: transport, CheckRedirect: noRedirects}
+}
+
+// sharedAddressSpace is the carrier-grade NAT range, private in practice.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicAddress reports whether addr is neither loopback, private,
+// link-local, multicast nor unspecified.
+func publicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
+
+type AsyncLookupRequest struct {
+	Cep         string `json:"cep"`
+	CallbackURL string `json:"callback_url"`
+	Strategy    string `json:"strategy,omitempty"`
+}
+
+type AsyncLookupResponse struct {
+	ID  string `json:"id"`
+	Cep string `json:"cep"`
+}
+
+// WebhookPayload is POSTed to the callback URL once the lookup is done,
+// with either the address or the error the lookup would have answered.
+type WebhookPayload struct {
+	ID      string     `json:"id"`
+	Cep     string     `json:"cep"`
+	Status  int        `json:"status"`
+	Address *Address   `json:"address,omitempty"`
+	Error   *ErrorBody `json:"error,omitempty"`
+}
+
+// AsyncLookupHandler serves POST /lookup/async, answering 202 right away
+// and delivering the result to the callback URL in the background.
+func AsyncLookupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSONError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req AsyncLookupRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, asyncMaxBody)).Decode(&req)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		writeJSONError(w, r, http.StatusRequestEntityTooLarge, CodeTooLarge, fmt.Sprintf("body must be at most %d bytes", asyncMaxBody))
+		return
+	}
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, "body must be a JSON object with cep and callback_url")
+		return
+	}
+	cep, err := NormalizeCep(req.Cep)
+	if err != nil {
+		writeJSONError(w, r, http.StatusUnprocessableEntity, CodeInvalidCep, err.Error())
+		return
+	}
+	err = validateCallback(req.CallbackURL)
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	strategy, err := strategyFor(req.Strategy)
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	select {
+	case webhookPending <- struct{}{}:
+	default:
+		writeJSONError(w, r, http.StatusServiceUnavailable, CodeUnavailable, "too many async lookups pending")
+		return
+	}
+
+	// the request ID is kept so the callback can be traced back to it,
+	// and the API key so that the lookup counts towards its usage
+	id := requestID(r.Context())
+	ctx := withAPIKeyName(withRequestID(lookupsCtx, id), apiKeyName(r.Context()))
+	go func() {
+		defer func() { <-webhookPending }()
+		payload := WebhookPayload{ID: id, Cep: cep, Status: http.StatusOK}
+		address, _, err := Lookup(ctx, cep, strategy)
+		if err != nil {
+			payload.Status = lookupStatus(err)
+			payload.Error = &ErrorBody{Code: lookupCode(err), Message: err.Error(), RequestID: id}
+		}
+		payload.Address = address
+		deliverWebhook(ctx, webhookClient, req.CallbackURL, payload)
+	}()
+
+	writeJSON(w, r, http.StatusAccepted, AsyncLookupResponse{ID: id, Cep: cep})
+}
+
+func validateCallback(raw string) error {
+	callback, err := url.Parse(raw)
+	if err != nil || callback.Host == "" || (callback.Scheme != "http" && callback.Scheme != "https") {
+		return errors.New("callback_url must be an absolute http or https URL")
+	}
+	host := strings.ToLower(callback.Hostname())
+	allowed := slices.Contains(webhookConfig.AllowedHosts, host)
+	if len(webhookConfig.AllowedHosts) > 0 && !allowed {
+		return fmt.Errorf("callback host %q is not allowed", callback.Hostname())
+	}
+	// the names are only resolved, and checked, as the callback is made
+	if addr, err := netip.ParseAddr(host); err == nil && !allowed && !publicAddress(addr) {
+		return fmt.Errorf("callback host %q is not a public address", callback.Hostname())
+	}
+	return nil
+}
+
+// deliverWebhook POSTs payload to callback with client, retrying connection
+// errors and 5xx answers with a doubling delay up to the configured
+// attempts.
+func deliverWebhook(ctx context.Context, client *http.Client, callback string, payload any) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		slog.ErrorContext(ctx, "error encoding webhook", "error", err)
+		return
+	}
+
+	delay := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		err = postWebhook(ctx, client, callback, body)
+		if err == nil {
+			slog.InfoContext(ctx, "webhook delivered", "callback", callback, "attempt", attempt)
+			return
+		}
+		var statusErr *StatusError
+		retryable := (!errors.As(err, &statusErr) || statusErr.StatusCode >= 500) && !errors.Is(err, errCallbackAddress)
+		if !retryable || attempt >= webhookConfig.Attempts {
+			slog.WarnContext(ctx, "webhook delivery failed", "callback", callback, "attempt", attempt, "error", err)
+			return
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		delay *= 2
+	}
+}
+
+func postWebhook(ctx context.Context, client *http.Client, callback string, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, webhookConfig.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callback, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if webhookConfig.Secret != "" {
+		req.Header.Set("X-Multi-Signature", sign(webhookConfig.Secret, body))
+	}
+	if signingSecret != "" {
+		req.Header.Set("X-Signature", sign(signingSecret, body))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &StatusError{Provider: "webhook", StatusCode: resp.StatusCode}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestPublicAddress(t *testing.T) {
+	tests := []struct {
+		addr   string
+		public bool
+	}{
+		{"8.8.8.8", true},
+		{"2001:4860:4860::8888", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"fd00::1", false},
+		{"100.64.0.1", false},
+		{"100.127.255.254", false},
+		{"0.0.0.0", false},
+		{"224.0.0.1", false},
+	}
+	for _, test := range tests {
+		if got := publicAddress(netip.MustParseAddr(test.addr)); got != test.public {
+			t.Errorf("publicAddress(%s) = %v, want %v", test.addr, got, test.public)
+		}
+	}
+}
+
+func TestValidateCallback(t *testing.T) {
+	saved := webhookConfig
+	t.Cleanup(func() { webhookConfig = saved })
+
+	tests := []struct {
+		callback string
+		allowed  []string
+		ok       bool
+	}{
+		{"https://hooks.example.com/multi", nil, true},
+		{"http://203.0.113.10:8080/", nil, true},
+		{"ftp://hooks.example.com/", nil, false},
+		{"/relative", nil, false},
+		{"http://169.254.169.254/latest/meta-data/", nil, false},
+		{"http://10.0.0.1/", nil, false},
+		{"http://100.64.0.1/", nil, false},
+		{"http://127.0.0.1/", nil, false},
+		{"http://[::1]/", nil, false},
+		{"http://[::ffff:127.0.0.1]/", nil, false},
+		{"http://127.0.0.1/", []string{"127.0.0.1"}, true},
+		{"http://[::1]/", []string{"::1"}, true},
+		{"https://other.example.com/", []string{"hooks.example.com"}, false},
+		{"https://HOOKS.example.com/", []string{"hooks.example.com"}, true},
+	}
+	for _, test := range tests {
+		webhookConfig = WebhookConfig{AllowedHosts: test.allowed}
+		err := validateCallback(test.callback)
+		if (err == nil) != test.ok {
+			t.Errorf("validateCallback(%q) with allowed hosts %v: got %v, want ok=%v", test.callback, test.allowed, err, test.ok)
+		}
+	}
+}
+
+// TestCallbackClientDial checks the addresses as they are dialed, where a
+// host name resolving to loopback is caught too.
+func TestCallbackClientDial(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	port := server.URL[strings.LastIndex(server.URL, ":")+1:]
+
+	tests := []struct {
+		name     string
+		callback string
+		allowed  []string
+		status   int
+	}{
+		{"loopback", server.URL, nil, 0},
+		{"name resolving to loopback", "http://localhost:" + port, nil, 0},
+		{"loopback allowed", server.URL, []string{"127.0.0.1"}, http.StatusNoContent},
+		{"name allowed", "http://localhost:" + port, []string{"localhost"}, http.StatusNoContent},
+		{"other host allowed", server.URL, []string{"hooks.example.com"}, 0},
+		{"redirect not followed", server.URL + "/redirect", []string{"127.0.0.1"}, http.StatusFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := callbackClient(server.Client(), test.allowed)
+			resp, err := client.Post(test.callback, "application/json", strings.NewReader("{}"))
+			if test.status == 0 {
+				if !errors.Is(err, errCallbackAddress) {
+					t.Fatalf("got %v, want errCallbackAddress", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != test.status {
+				t.Errorf("got status %d, want %d", resp.StatusCode, test.status)
+			}
+		})
+	}
+}
+
+func TestCallbackClientDialIPv6Loopback(t *testing.T) {
+	listener, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skip("no IPv6 loopback:", err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	_, err = callbackClient(server.Client(), nil).Post(server.URL, "application/json", strings.NewReader("{}"))
+	if !errors.Is(err, errCallbackAddress) {
+		t.Errorf("got %v, want errCallbackAddress", err)
+	}
+	resp, err := callbackClient(server.Client(), []string{"::1"}).Post(server.URL, "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatalf("allowed ::1: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestAsyncLookupBodyLimit(t *testing.T) {
+	body := `{"cep": "01001000", "callback_url": "https://hooks.example.com/` + strings.Repeat("a", asyncMaxBody) + `"}`
+	recorder := httptest.NewRecorder()
+	AsyncLookupHandler(recorder, httptest.NewRequest(http.MethodPost, "/lookup/async", strings.NewReader(body)))
+	if recorder.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("got status %d, want %d", recorder.Code, http.StatusRequestEntityTooLarge)
+	}
+}
+
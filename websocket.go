@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+)
+
+var webSocketConfig WebSocketConfig
+
+// WSRequest is a lookup sent over /ws. ID is echoed in the result so the
+// client can match the answers, which come in completion order.
+type WSRequest struct {
+	ID       string `json:"id,omitempty"`
+	Cep      string `json:"cep"`
+	Strategy string `json:"strategy,omitempty"`
+}
+
+type WSResult struct {
+	ID string `json:"id,omitempty"`
+	BatchItem
+}
+
+// WebSocketHandler serves /ws, where every message is a WSRequest answered
+// with a WSResult. Up to the configured concurrency lookups run at once
+// per connection; past that, reading waits for one of them to finish.
+func WebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		// Accept has already answered the failed handshake
+		slog.WarnContext(r.Context(), "websocket handshake failed", "error", err)
+		return
+	}
+	conn.SetReadLimit(webSocketConfig.MaxMessage)
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	// hijacked connections outlive server.Shutdown, so drop them with
+	// the lookups in flight
+	stop := context.AfterFunc(lookupsCtx, func() {
+		conn.Close(websocket.StatusGoingAway, "server shutting down")
+	})
+	defer stop()
+
+	sem := make(chan struct{}, webSocketConfig.Concurrency)
+	for {
+		var req WSRequest
+		err := wsjson.Read(ctx, conn, &req)
+		if err != nil {
+			// anything but the client closing, such as a message
+			// that is not JSON, ends the connection
+			if websocket.CloseStatus(err) == -1 && ctx.Err() == nil {
+				slog.DebugContext(ctx, "websocket read failed", "error", err)
+				conn.Close(websocket.StatusUnsupportedData, "messages must be JSON lookups")
+			}
+			break
+		}
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		go func() {
+			defer func() { <-sem }()
+			result := lookupWS(ctx, req)
+			err := wsjson.Write(ctx, conn, result)
+			if err != nil {
+				cancel()
+			}
+		}()
+	}
+
+	// let the lookups still running finish writing
+	for range cap(sem) {
+		sem <- struct{}{}
+	}
+	conn.Close(websocket.StatusNormalClosure, "")
+}
+
+func lookupWS(ctx context.Context, req WSRequest) WSResult {
+	result := WSResult{ID: req.ID, BatchItem: BatchItem{Input: req.Cep}}
+	strategy, err := strategyFor(req.Strategy)
+	if err != nil {
+		result.Error, result.Status, result.Code = err.Error(), http.StatusBadRequest, CodeInvalidRequest
+		return result
+	}
+
+	cep, err := NormalizeCep(req.Cep)
+	if err == nil {
+		result.Cep = cep
+		result.Address, _, err = Lookup(ctx, cep, strategy)
+	}
+	if err != nil {
+		result.Error, result.Status, result.Code = err.Error(), lookupStatus(err), lookupCode(err)
+		return result
+	}
+	result.Status = http.StatusOK
+	return result
+}
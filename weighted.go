@@ -0,0 +1,138 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"maps"
+	"math/rand"
+	"slices"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// adaptiveMinSamples is how many lookups a provider needs in its stats
+// window before the adaptive strategy trusts them.
+const adaptiveMinSamples = 10
+
+// providerWeights holds the configured routing weight of each provider.
+var providerWeights = map[string]float64{}
+
+var providerSelections = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "multi",
+	Name:      "provider_selections_total",
+	Help:      "Providers picked by the weighted and adaptive strategies, by provider and strategy.",
+}, []string{"provider", "strategy"})
+
+// allStrategies are the strategies of the cep package plus the routing
+// ones, which need the providers' weights and stats.
+func allStrategies(cfg Config) map[string]Strategy {
+	all := Strategies(cfg.Quorum, cfg.HedgeDelay)
+	maps.Copy(all, map[string]Strategy{
+		"quorum":   clampedQuorum(cfg.Quorum),
+		"weighted": routed("weighted", weightedScores),
+		"adaptive": routed("adaptive", adaptiveScores),
+	})
+	return all
+}
+
+// clampedQuorum is the quorum strategy asking n providers to agree, or
+// all the enabled ones when fewer are: the quorum is only bound to the
+// providers when it is the configured strategy, yet a request may still
+// ask for it.
+func clampedQuorum(n int) Strategy {
+	return func(ctx context.Context, providers []Provider, cep string) (Result, error) {
+		return Quorum(max(min(n, len(currentProviders())), 1))(ctx, providers, cep)
+	}
+}
+
+// routed asks one provider at a time instead of racing them, picking each
+// at random in proportion to its score. A provider failing hands over to
+// another pick among the rest; not found is an answer and ends the lookup.
+func routed(name string, scores func([]Provider) []float64) Strategy {
+	return func(ctx context.Context, providers []Provider, cep string) (Result, error) {
+		providers = slices.Clone(providers)
+		reloadMu.RLock()
+		weights := scores(providers)
+		reloadMu.RUnlock()
+
+		var failures ProviderFailures
+		for len(providers) > 0 {
+			i := pick(weights)
+			provider := providers[i]
+			providers, weights = slices.Delete(providers, i, i+1), slices.Delete(weights, i, i+1)
+			providerSelections.WithLabelValues(provider.Name(), name).Inc()
+
+			result := LookupResult(ctx, provider, cep)
+			if ctx.Err() != nil {
+				return Result{}, ctx.Err()
+			}
+			if result.Err == nil || errors.Is(result.Err, ErrCepNotFound) {
+				return result, nil
+			}
+			failures = append(failures, &ProviderError{Provider: result.Provider, Err: result.Err})
+		}
+		return Result{Err: failures}, nil
+	}
+}
+
+// pick returns an index at random, in proportion to weights.
+func pick(weights []float64) int {
+	var total float64
+	for _, weight := range weights {
+		total += weight
+	}
+	r := rand.Float64() * total
+	for i, weight := range weights {
+		r -= weight
+		if r < 0 {
+			return i
+		}
+	}
+	return len(weights) - 1
+}
+
+func weightedScores(providers []Provider) []float64 {
+	scores := make([]float64, len(providers))
+	for i, provider := range providers {
+		scores[i] = cmp.Or(providerWeights[provider.Name()], 1)
+	}
+	return scores
+}
+
+// adaptiveScores favors the providers answering most often and fastest:
+// their recent success rate over their median latency, times their weight.
+// Providers without enough samples score as the best one, so that they get
+// tried, and failing ones keep a small share so that their recovery shows.
+func adaptiveScores(providers []Provider) []float64 {
+	scores := make([]float64, len(providers))
+	best := 0.0
+	for i, provider := range providers {
+		scores[i] = -1
+		stats, ok := providerStats[provider.Name()]
+		if !ok {
+			continue
+		}
+		rate, samples := stats.SuccessRate()
+		if samples < adaptiveMinSamples {
+			continue
+		}
+		// a provider that has not answered in the window is scored as
+		// if it took a second
+		latency := time.Second
+		if p50, ok := stats.latencies.Percentile(0.5); ok {
+			latency = max(p50, time.Millisecond)
+		}
+		scores[i] = max(rate, 0.05) / latency.Seconds()
+		best = max(best, scores[i])
+	}
+	for i, provider := range providers {
+		if scores[i] < 0 {
+			scores[i] = cmp.Or(best, 1)
+		}
+		scores[i] *= cmp.Or(providerWeights[provider.Name()], 1)
+	}
+	return scores
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestRoutedSpendsOnlyThePickedProvider(t *testing.T) {
+	first := &stubProvider{name: "first"}
+	second := &stubProvider{name: "second"}
+	b := map[string]*CircuitBreaker{}
+	l := map[string]*rate.Limiter{}
+	var providers []Provider
+	for _, stub := range []*stubProvider{first, second} {
+		// a single probe and a single token, neither refilling
+		b[stub.name] = halfOpenBreaker()
+		l[stub.name] = rate.NewLimiter(rate.Every(time.Hour), 1)
+		limited := &limitedProvider{Provider: stub, limiter: l[stub.name]}
+		providers = append(providers, &breakerProvider{Provider: limited, breaker: b[stub.name]})
+	}
+	withProviderState(t, b, l)
+	strategy := routed("weighted", weightedScores)
+
+	// the first lookup spends the token of the provider it picks, so the
+	// second one can only pick the other
+	for i := range 2 {
+		active := activeProviders(providers)
+		if len(active) != 2-i {
+			t.Fatalf("lookup %d: %d active providers, want %d", i, len(active), 2-i)
+		}
+		result, err := strategy(context.Background(), active, "01001000")
+		if err != nil || result.Err != nil {
+			t.Fatalf("lookup %d: %v, %v", i, err, result.Err)
+		}
+	}
+	for _, stub := range []*stubProvider{first, second} {
+		if calls := stub.calls.Load(); calls != 1 {
+			t.Errorf("%s called %d times, want 1", stub.name, calls)
+		}
+		if state := b[stub.name].Status().State; state != BreakerClosed {
+			t.Errorf("breaker of %s is %s, want closed after its probe succeeded", stub.name, state)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/liberopassadorneto/multi/pkg/cep"
+)
+
+// zones classifies the CEPs of GET /zone, nil unless enabled.
+var zones *cep.ZoneTable
+
+// defaultZone is the zone of the CEPs no range holds, none when empty.
+var defaultZone string
+
+func setupZones(cfg ZonesConfig) {
+	zones, defaultZone = nil, cfg.Default
+	if !cfg.Enabled {
+		return
+	}
+	table := cep.NewZoneTable()
+	if cfg.File != "" {
+		var err error
+		table, err = cep.OpenZones(cfg.File)
+		if err != nil {
+			fatal("error loading zones", err)
+		}
+	}
+	for _, r := range cfg.Ranges {
+		// Validate has checked them
+		_ = table.Add(r)
+	}
+	zones = table
+}
+
+// ZoneResponse is the shipping zone of a CEP with its address. Range is
+// the range of the zone the CEP fell in, nil for the default zone.
+type ZoneResponse struct {
+	Cep     string     `json:"cep"`
+	Zone    string     `json:"zone"`
+	Range   *ZoneRange `json:"range,omitempty"`
+	Address *Address   `json:"address"`
+}
+
+// ZoneHandler serves GET /zone?cep=, the shipping zone of a CEP alongside
+// its address, so that checkouts can pick their shipping rules in one
+// call.
+func ZoneHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSONError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed")
+		return
+	}
+	if zones == nil {
+		writeJSONError(w, r, http.StatusServiceUnavailable, CodeUnavailable, "zones are not enabled")
+		return
+	}
+	raw := r.URL.Query().Get("cep")
+	if raw == "" {
+		writeJSONError(w, r, http.StatusBadRequest, CodeInvalidRequest, "missing 'cep' query parameter")
+		return
+	}
+	cep, err := NormalizeCep(raw)
+	if err != nil {
+		writeLookupError(w, r, err)
+		return
+	}
+
+	response := ZoneResponse{Cep: cep, Zone: defaultZone}
+	if zoneRange, ok := zones.Classify(cep); ok {
+		response.Zone, response.Range = zoneRange.Zone, &zoneRange
+	}
+	if response.Zone == "" {
+		writeJSONError(w, r, http.StatusNotFound, CodeNotFound, "no shipping zone holds the cep")
+		return
+	}
+	address, _, err := Lookup(r.Context(), cep, defaultStrategy)
+	if err != nil {
+		writeLookupError(w, r, err)
+		return
+	}
+	response.Address = address
+	writeJSON(w, r, http.StatusOK, response)
+}